@@ -0,0 +1,187 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WindowsInstancePhase describes where a WindowsInstance is in its configuration lifecycle
+// +kubebuilder:validation:Enum=Pending;Configuring;Configured;Failed
+type WindowsInstancePhase string
+
+const (
+	// WindowsInstancePending indicates that the instance has not been processed yet
+	WindowsInstancePending WindowsInstancePhase = "Pending"
+	// WindowsInstanceConfiguring indicates that the instance is being configured into a Node
+	WindowsInstanceConfiguring WindowsInstancePhase = "Configuring"
+	// WindowsInstanceConfigured indicates that the instance has been configured into a Node by the current WMCO version
+	WindowsInstanceConfigured WindowsInstancePhase = "Configured"
+	// WindowsInstanceFailed indicates that the last attempt to configure the instance failed
+	WindowsInstanceFailed WindowsInstancePhase = "Failed"
+)
+
+const (
+	// ConditionReady is the condition type indicating that the instance has been configured as a Node
+	ConditionReady = "Ready"
+)
+
+// WindowsInstanceSpec describes an existing Windows instance that should be configured into a Node
+type WindowsInstanceSpec struct {
+	// Address is the network address used to access the instance. This can be a DNS name or an IPv4 address.
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+	// Username is the name of the administrator user used to SSH into the instance. Instances accessed over WinRM
+	// must map the client certificate to this user.
+	// +kubebuilder:validation:MinLength=1
+	Username string `json:"username"`
+	// Hostname, if set, is the hostname the instance will be renamed to before joining the cluster
+	// +optional
+	Hostname string `json:"hostname,omitempty"`
+	// Labels are applied to the Node associated with the instance
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// Taints are applied to the Node associated with the instance
+	// +optional
+	Taints []core.Taint `json:"taints,omitempty"`
+	// PrivateKeySecretRef references a Secret in the operator namespace holding the private key used to access the
+	// instance, under the private-key.pem key. If not set, the operator's cloud-private-key Secret is used.
+	// +optional
+	PrivateKeySecretRef *core.LocalObjectReference `json:"privateKeySecretRef,omitempty"`
+	// Transport is the protocol used to access the instance. SSH authenticates with the private key, while WinRM uses
+	// HTTPS and authenticates with the client certificate held by the winrm-client-certificate Secret in the operator
+	// namespace. Defaults to SSH.
+	// +kubebuilder:validation:Enum=SSH;WinRM
+	// +optional
+	Transport string `json:"transport,omitempty"`
+	// SSHHostKey is the public host key the instance must present when accessed over SSH, in authorized_keys format,
+	// such as the contents of C:\ProgramData\ssh\ssh_host_ed25519_key.pub on the instance. If not set, the host key
+	// presented by the first connection is trusted and recorded on the associated Node. Connections to an instance
+	// presenting any other host key are refused.
+	// +optional
+	SSHHostKey string `json:"sshHostKey,omitempty"`
+	// JumpHosts are the SSH hosts connections to the instance are tunneled through, in order, for instances which
+	// cannot be reached directly from the operator. If neither jumpHosts nor proxyURL is set, the route given by the
+	// windows-operator-config ConfigMap is used.
+	// +optional
+	JumpHosts []JumpHost `json:"jumpHosts,omitempty"`
+	// ProxyURL is the URL of an HTTP CONNECT or SOCKS5 proxy, such as socks5://proxy.example.com:1080, through which
+	// connections to the instance, or to its first jump host, are made. The URL must not contain credentials, which
+	// are given through proxyCredentialsSecretRef instead.
+	// +kubebuilder:validation:Pattern=`^(http|socks5)://[^@]+$`
+	// +optional
+	ProxyURL string `json:"proxyURL,omitempty"`
+	// ProxyCredentialsSecretRef references a Secret in the operator namespace holding the credentials used to
+	// authenticate against the proxy, under the username and password keys. If not set, the proxy is used without
+	// authentication.
+	// +optional
+	ProxyCredentialsSecretRef *core.LocalObjectReference `json:"proxyCredentialsSecretRef,omitempty"`
+}
+
+// JumpHost describes an SSH host which connections to an instance are tunneled through
+type JumpHost struct {
+	// Address is the address of the jump host, optionally followed by a port. Port 22 is used if no port is given.
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+	// Username is the name of the user used to SSH into the jump host
+	// +kubebuilder:validation:MinLength=1
+	Username string `json:"username"`
+	// PrivateKeySecretRef references a Secret in the operator namespace holding the private key used to access the
+	// jump host, under the private-key.pem key. If not set, the private key used to access the instance is used.
+	// +optional
+	PrivateKeySecretRef *core.LocalObjectReference `json:"privateKeySecretRef,omitempty"`
+	// SSHHostKey is the public host key the jump host must present, in authorized_keys format
+	// +kubebuilder:validation:MinLength=1
+	SSHHostKey string `json:"sshHostKey"`
+}
+
+// ConfigurationProgress describes the progress of configuring an instance into a Node
+type ConfigurationProgress struct {
+	// Step is the last configuration step the instance reached
+	// +optional
+	Step string `json:"step,omitempty"`
+	// Attempts is the number of configuration attempts made since the instance was last successfully configured,
+	// including the successful attempt
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+	// LastError is the error encountered during the last failed configuration attempt
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+	// LastAttemptTime is the time the last configuration attempt started
+	// +optional
+	LastAttemptTime *meta.Time `json:"lastAttemptTime,omitempty"`
+	// LastStepTime is the time the last configuration step was reached
+	// +optional
+	LastStepTime *meta.Time `json:"lastStepTime,omitempty"`
+	// LastConfiguredTime is the time the instance was last successfully configured
+	// +optional
+	LastConfiguredTime *meta.Time `json:"lastConfiguredTime,omitempty"`
+}
+
+// WindowsInstanceStatus is the observed state of a WindowsInstance
+type WindowsInstanceStatus struct {
+	// Phase is the point in its configuration lifecycle the instance has reached
+	// +optional
+	Phase WindowsInstancePhase `json:"phase,omitempty"`
+	// NodeName is the name of the Node associated with the instance
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// ConfiguredVersion is the WMCO version that last successfully configured the instance
+	// +optional
+	ConfiguredVersion string `json:"configuredVersion,omitempty"`
+	// ConfigurationProgress describes the progress of the last configuration attempt
+	ConfigurationProgress `json:",inline"`
+	// Conditions represent the latest available observations of the instance's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []meta.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:shortName=wi
+//+kubebuilder:printcolumn:name="Address",type=string,JSONPath=`.spec.address`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.status.nodeName`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.configuredVersion`
+//+kubebuilder:printcolumn:name="Step",type=string,JSONPath=`.status.step`,priority=1
+//+kubebuilder:printcolumn:name="Attempts",type=integer,JSONPath=`.status.attempts`,priority=1
+
+// WindowsInstance describes an existing Windows instance which should be configured into a Node.
+// This is the typed replacement of the entries within the windows-instances ConfigMap.
+type WindowsInstance struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WindowsInstanceSpec   `json:"spec,omitempty"`
+	Status WindowsInstanceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WindowsInstanceList contains a list of WindowsInstance
+type WindowsInstanceList struct {
+	meta.TypeMeta `json:",inline"`
+	meta.ListMeta `json:"metadata,omitempty"`
+	Items         []WindowsInstance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WindowsInstance{}, &WindowsInstanceList{})
+}
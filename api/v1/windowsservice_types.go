@@ -0,0 +1,138 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionAccepted is the condition type indicating that the service has been merged into the services
+	// ConfigMap, and will be managed on every Windows node
+	ConditionAccepted = "Accepted"
+)
+
+// WindowsServiceSpec describes a Windows service which should run on every Windows node
+type WindowsServiceSpec struct {
+	// Command is the command the service runs, including the path of its executable and its arguments. Any variable
+	// given by nodeVariablesInCommand or powershellPreScripts is replaced by its value on each node.
+	// +kubebuilder:validation:MinLength=1
+	Command string `json:"command"`
+	// NodeVariablesInCommand are the variables of the command whose values are read from the Node object
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	NodeVariablesInCommand []NodeCommandVariable `json:"nodeVariablesInCommand,omitempty"`
+	// PowershellPreScripts are PowerShell scripts run on the node, in order, before the service is started. The
+	// output of a script can be used as the value of a variable of the command.
+	// +optional
+	PowershellPreScripts []PowershellPreScript `json:"powershellPreScripts,omitempty"`
+	// Dependencies are the names of the Windows services which must be running before the service is started. These
+	// can be services of the operator, other WindowsServices or services installed on the nodes.
+	// +optional
+	Dependencies []string `json:"dependencies,omitempty"`
+	// Priority orders the creation of the WindowsServices, from the lowest priority. WindowsServices are always
+	// created after the services of the operator. A WindowsService can only depend on WindowsServices with a lower or
+	// equal priority.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+	// RunAs is the account the service logs on as. It can be a built-in service account, NT AUTHORITY\LocalService
+	// or NT AUTHORITY\NetworkService, the virtual account of the service, NT SERVICE\<name>, or a group managed
+	// service account, DOMAIN\<account>$. Accounts requiring a password are not supported. Defaults to LocalSystem.
+	// +optional
+	RunAs string `json:"runAs,omitempty"`
+	// Limits are the resources the processes of the service can use on each node, enforced through a Windows job
+	// object. The resources of the service are not limited if unset.
+	// +optional
+	Limits *WindowsServiceLimits `json:"limits,omitempty"`
+}
+
+// WindowsServiceLimits describes the resources the processes of a Windows service can use
+type WindowsServiceLimits struct {
+	// CPUPercent is the maximum CPU usage of the processes, as a percentage of the CPU capacity of the node
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	CPUPercent int32 `json:"cpuPercent,omitempty"`
+	// Memory is the maximum memory committed by the processes, such as 512Mi
+	// +optional
+	Memory *resource.Quantity `json:"memory,omitempty"`
+}
+
+// NodeCommandVariable describes a variable of a service command whose value is read from the Node object
+type NodeCommandVariable struct {
+	// Name is the variable as it appears in the command
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// NodeObjectJSONPath is the JSON path of a string field of the Node object, such as {.metadata.name}
+	// +kubebuilder:validation:MinLength=1
+	NodeObjectJSONPath string `json:"nodeObjectJsonPath"`
+}
+
+// PowershellPreScript describes a PowerShell script run on the node before a service is started
+type PowershellPreScript struct {
+	// Path is the PowerShell script to run, such as the location of a script file on the node, followed by its
+	// arguments
+	// +kubebuilder:validation:MinLength=1
+	Path string `json:"path"`
+	// VariableName, if set, is a variable of the command which is replaced by the output of the script
+	// +optional
+	VariableName string `json:"variableName,omitempty"`
+}
+
+// WindowsServiceStatus is the observed state of a WindowsService
+type WindowsServiceStatus struct {
+	// Conditions represent the latest available observations of the service's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []meta.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:shortName=wsvc
+//+kubebuilder:printcolumn:name="Command",type=string,JSONPath=`.spec.command`
+//+kubebuilder:printcolumn:name="Priority",type=integer,JSONPath=`.spec.priority`
+//+kubebuilder:printcolumn:name="Accepted",type=string,JSONPath=`.status.conditions[?(@.type=="Accepted")].status`
+
+// WindowsService describes a user-defined Windows service which should run on every Windows node. The name of the
+// Windows service is the name of the WindowsService. Like the services of the operator, it is created, updated and
+// removed on each node by the Windows Instance Config Daemon.
+type WindowsService struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WindowsServiceSpec   `json:"spec,omitempty"`
+	Status WindowsServiceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WindowsServiceList contains a list of WindowsService
+type WindowsServiceList struct {
+	meta.TypeMeta `json:",inline"`
+	meta.ListMeta `json:"metadata,omitempty"`
+	Items         []WindowsService `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WindowsService{}, &WindowsServiceList{})
+}
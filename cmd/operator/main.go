@@ -20,14 +20,18 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
 	"github.com/openshift/windows-machine-config-operator/controllers"
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 	"github.com/openshift/windows-machine-config-operator/version"
@@ -56,6 +60,7 @@ func init() {
 	utilruntime.Must(operators.AddToScheme(scheme))
 	utilruntime.Must(mcfg.Install(scheme))
 	utilruntime.Must(openshiftconfig.AddToScheme(scheme))
+	utilruntime.Must(wmcov1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -184,8 +189,21 @@ func main() {
 	}
 	setupLog.Info("operator", "namespace", watchNamespace)
 
+	// The manager's cached client cannot be used before the manager is started
+	directClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+	if err := secrets.EnsureUsernameEncryptionKey(ctx, watchNamespace, directClient); err != nil {
+		setupLog.Error(err, "unable to ensure username encryption key exists")
+		os.Exit(1)
+	}
+
 	// Setup all Controllers
-	winMachineReconciler, err := controllers.NewWindowsMachineReconciler(mgr, clusterConfig, watchNamespace)
+	keyRotationLocks := &controllers.KeyRotationLocks{}
+	winMachineReconciler, err := controllers.NewWindowsMachineReconciler(mgr, clusterConfig, watchNamespace,
+		keyRotationLocks)
 	if err != nil {
 		setupLog.Error(err, "unable to create Windows Machine reconciler")
 		os.Exit(1)
@@ -215,7 +233,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	secretReconciler, err := controllers.NewSecretReconciler(mgr, clusterConfig, watchNamespace)
+	secretReconciler, err := controllers.NewSecretReconciler(mgr, clusterConfig, watchNamespace, keyRotationLocks)
 	if err != nil {
 		setupLog.Error(err, "unable to create Secret reconciler")
 		os.Exit(1)
@@ -275,6 +293,10 @@ func main() {
 		setupLog.Error(err, "error setting up metrics")
 		os.Exit(1)
 	}
+	if err := ctrlmetrics.Registry.Register(metrics.NewWindowsNodesCollector(mgr.GetClient())); err != nil {
+		setupLog.Error(err, "error registering Windows nodes metrics collector")
+		os.Exit(1)
+	}
 
 	// Create the singleton Windows services ConfigMap
 	if err := configMapReconciler.EnsureServicesConfigMapExists(); err != nil {
@@ -68,6 +68,7 @@ func NewCertificateSigningRequestsReconciler(mgr manager.Manager, clusterConfig
 			k8sclientset:       clientset,
 			clusterServiceCIDR: clusterConfig.Network().GetServiceCIDR(),
 			watchNamespace:     watchNamespace,
+			controllerName:     CSRController,
 			recorder:           mgr.GetEventRecorderFor(CSRController),
 		},
 	}, nil
@@ -19,10 +19,13 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	config "github.com/openshift/api/config/v1"
 	core "k8s.io/api/core/v1"
@@ -40,19 +43,21 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
 	"github.com/openshift/windows-machine-config-operator/pkg/certificates"
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/condition"
-	"github.com/openshift/windows-machine-config-operator/pkg/crypto"
 	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/patch"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/services"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 	"github.com/openshift/windows-machine-config-operator/pkg/wiparser"
 	"github.com/openshift/windows-machine-config-operator/version"
 )
@@ -61,6 +66,7 @@ import (
 //+kubebuilder:rbac:groups="",resources=configmaps/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups="",resources=configmaps/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=delete;get;list;patch;watch
+//+kubebuilder:rbac:groups="",resources=nodes/status,verbs=patch
 //+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
 //+kubebuilder:rbac:groups="rbac.authorization.k8s.io",resources=rolebindings,verbs=get;create;delete
 //+kubebuilder:rbac:groups="rbac.authorization.k8s.io",resources=clusterrolebindings,verbs=get;create;delete
@@ -70,6 +76,12 @@ const (
 	BYOHLabel = "windowsmachineconfig.openshift.io/byoh"
 	// UsernameAnnotation is a node annotation that contains the username used to log into the Windows instance
 	UsernameAnnotation = "windowsmachineconfig.openshift.io/username"
+	// PrivateKeySecretAnnotation is a node annotation that contains the name of the secret holding the private key used
+	// to access the Windows instance, if it differs from the operator's private key secret
+	PrivateKeySecretAnnotation = "windowsmachineconfig.openshift.io/private-key-secret"
+	// TransportAnnotation is a node annotation that contains the protocol used to access the Windows instance, if it
+	// is not SSH. As a MachineSet annotation, it selects the protocol used to access the instances of the MachineSet.
+	TransportAnnotation = "windowsmachineconfig.openshift.io/transport"
 	// ConfigMapController is the name of this controller in logs and other outputs.
 	ConfigMapController = "configmap"
 	// wicdRBACResourceName is the name of the resources associated with WICD's RBAC permissions
@@ -83,6 +95,12 @@ type ConfigMapReconciler struct {
 	instanceReconciler
 	servicesManifest *servicescm.Data
 	proxyEnabled     bool
+	// argsFromIgnition are the kubelet arguments given by the ignition file
+	argsFromIgnition map[string]string
+	// vxlanPort is the VXLAN port used by hybrid-overlay
+	vxlanPort string
+	// payloadFiles are the path and checksum of each payload file once copied to a Windows instance
+	payloadFiles []servicescm.FileInfo
 }
 
 // NewConfigMapReconciler returns a pointer to a ConfigMapReconciler
@@ -111,26 +129,69 @@ func NewConfigMapReconciler(mgr manager.Manager, clusterConfig cluster.Config, w
 	if err != nil {
 		return nil, err
 	}
-	svcData, err := services.GenerateManifest(argsFromIgnition, clusterConfig.Network().VXLANPort(),
-		clusterConfig.Platform(), ctrl.Log.V(1).Enabled())
+	platform := clusterConfig.Platform()
+	payloadFiles, err := windows.FileManifest(&platform)
 	if err != nil {
-		return nil, fmt.Errorf("error generating expected Windows service state: %w", err)
+		return nil, fmt.Errorf("error determining payload file checksums: %w", err)
 	}
 
-	return &ConfigMapReconciler{
+	r := &ConfigMapReconciler{
 		instanceReconciler: instanceReconciler{
 			client:               mgr.GetClient(),
 			k8sclientset:         clientset,
 			clusterServiceCIDR:   clusterConfig.Network().GetServiceCIDR(),
 			log:                  ctrl.Log.WithName("controllers").WithName(ConfigMapController),
 			watchNamespace:       watchNamespace,
+			controllerName:       ConfigMapController,
 			recorder:             mgr.GetEventRecorderFor(ConfigMapController),
 			prometheusNodeConfig: pc,
-			platform:             clusterConfig.Platform(),
+			platform:             platform,
 		},
-		servicesManifest: svcData,
 		proxyEnabled:     proxyEnabled,
-	}, nil
+		argsFromIgnition: argsFromIgnition,
+		vxlanPort:        clusterConfig.Network().VXLANPort(),
+		payloadFiles:     payloadFiles,
+	}
+	// The cache is not yet started, so the operator configuration is read from the API server. Failing to read the
+	// operator configuration must not prevent the operator from starting, so the defaults are used until it can be read.
+	operatorConfig, err := operatorconfig.Get(context.TODO(), directClient, watchNamespace)
+	if err != nil {
+		r.log.Error(err, "using the default operator configuration to generate the expected Windows service state")
+		operatorConfig = operatorconfig.Default()
+	}
+	windowsServices, err := listWindowsServices(context.TODO(), directClient, watchNamespace)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.generateServicesManifest(operatorConfig, windowsServices); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// generateServicesManifest sets the expected state of the services ConfigMap, given the operator configuration and
+// the user-defined WindowsServices. Returns the reason each WindowsService which was left out was rejected, by name.
+func (r *ConfigMapReconciler) generateServicesManifest(operatorConfig *operatorconfig.Config,
+	windowsServices []wmcov1.WindowsService) (map[string]error, error) {
+	userServices, rejected := services.UserDefinedServices(windowsServices)
+	svcData, err := services.GenerateManifest(r.argsFromIgnition, r.vxlanPort, r.platform, ctrl.Log.V(1).Enabled(),
+		operatorConfig.WindowsExporter, userServices, r.payloadFiles, operatorConfig.FileDriftRemediation,
+		operatorConfig.MaintenanceWindow)
+	if err != nil && len(userServices) > 0 {
+		// User-defined services which cannot be managed together, such as services depending on each other in a cycle,
+		// must not prevent the services of WMCO from being managed
+		for _, svc := range userServices {
+			rejected[svc.Name] = err
+		}
+		svcData, err = services.GenerateManifest(r.argsFromIgnition, r.vxlanPort, r.platform,
+			ctrl.Log.V(1).Enabled(), operatorConfig.WindowsExporter, nil, r.payloadFiles,
+			operatorConfig.FileDriftRemediation, operatorConfig.MaintenanceWindow)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error generating expected Windows service state: %w", err)
+	}
+	r.servicesManifest = svcData
+	return rejected, nil
 }
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -164,6 +225,24 @@ func (r *ConfigMapReconciler) Reconcile(ctx context.Context,
 	// 2. windows-services, describing expected configuration of WMCO-managed services on all Windows instances
 	// 3. kube-apiserver-to-kubelet-client-ca, contains the CA for the kubelet to recognize the kube-apiserver client cert
 	// 4. trusted-ca, where CNO will publish user-provided certs when there is an active cluster-wide proxy
+	if req.NamespacedName.Name == servicescm.Name {
+		// The expected services depend on the operator configuration and the WindowsServices, which may have changed
+		operatorConfig, err := operatorconfig.Get(ctx, r.client, r.watchNamespace)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		windowsServices, err := listWindowsServices(ctx, r.client, r.watchNamespace)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		rejected, err := r.generateServicesManifest(operatorConfig, windowsServices)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.updateWindowsServiceStatuses(ctx, windowsServices, rejected); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 	configMap := &core.ConfigMap{}
 	if err := r.client.Get(ctx, req.NamespacedName, configMap); err != nil {
 		if !k8sapierrors.IsNotFound(err) {
@@ -188,7 +267,7 @@ func (r *ConfigMapReconciler) Reconcile(ctx context.Context,
 	case servicescm.Name:
 		return ctrl.Result{}, r.reconcileServices(ctx, configMap)
 	case wiparser.InstanceConfigMap:
-		return ctrl.Result{}, r.reconcileNodes(ctx, configMap)
+		return r.reconcileNodes(ctx, configMap)
 	case certificates.ProxyCertsConfigMap:
 		return ctrl.Result{}, r.reconcileProxyCerts(ctx, configMap)
 	default:
@@ -261,71 +340,179 @@ func isTiedToRelevantVersion(v string, versions map[string]struct{}) bool {
 	return false
 }
 
-// reconcileNodes corrects the discrepancy between the "expected" instances, and the "actual" Node list
-func (r *ConfigMapReconciler) reconcileNodes(ctx context.Context, windowsInstances *core.ConfigMap) error {
+// reconcileNodes corrects the discrepancy between the "expected" instances, and the "actual" Node list. The entries
+// of the given instances ConfigMap are migrated into WindowsInstances, which describe the expected instances. The
+// request is requeued for the next maintenance window if any upgrades were deferred until then.
+func (r *ConfigMapReconciler) reconcileNodes(ctx context.Context, windowsInstancesCM *core.ConfigMap) (ctrl.Result,
+	error) {
+	if err := r.migrateInstancesConfigMap(ctx, windowsInstancesCM); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to migrate %s entries to WindowsInstances: %w",
+			wiparser.InstanceConfigMap, err)
+	}
+
 	// Get the current list of Windows BYOH Nodes
 	nodes := &core.NodeList{}
 	err := r.client.List(ctx, nodes, client.MatchingLabels{BYOHLabel: "true", core.LabelOSStable: "windows"})
 	if err != nil {
-		return fmt.Errorf("error listing nodes: %w", err)
+		return ctrl.Result{}, fmt.Errorf("error listing nodes: %w", err)
 	}
 
 	// Get the list of instances that are expected to be Nodes
-	instances, err := wiparser.Parse(windowsInstances.Data, nodes)
+	windowsInstances := &wmcov1.WindowsInstanceList{}
+	if err := r.client.List(ctx, windowsInstances, client.InNamespace(r.watchNamespace)); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error listing WindowsInstances: %w", err)
+	}
+	instances, err := wiparser.ParseWindowsInstances(windowsInstances.Items, nodes)
 	if err != nil {
-		return fmt.Errorf("unable to parse instances from ConfigMap: %w", err)
+		return ctrl.Result{}, fmt.Errorf("unable to parse instances from WindowsInstances: %w", err)
 	}
 
-	r.log.Info("processing", "instances in", wiparser.InstanceConfigMap)
+	r.log.Info("processing", "instances in", "WindowsInstances")
 	// For each instance, ensure that it is configured into a node
-	if err := r.ensureInstancesAreUpToDate(instances); err != nil {
-		r.recorder.Eventf(windowsInstances, core.EventTypeWarning, "InstanceSetupFailure", err.Error())
-		return err
+	requeueAfter, err := r.ensureInstancesAreUpToDate(ctx, windowsInstances.Items, nodes)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
-	// Ensure that only instances currently specified by the ConfigMap are joined to the cluster as nodes
+	// Ensure that only instances currently specified by WindowsInstances are joined to the cluster as nodes
 	if err = r.deconfigureInstances(instances, nodes); err != nil {
-		return fmt.Errorf("error removing undesired nodes from cluster: %w", err)
+		return ctrl.Result{}, fmt.Errorf("error removing undesired nodes from cluster: %w", err)
 	}
 
 	// Once all the proper Nodes are in the cluster, configure the prometheus endpoints.
 	if err := r.prometheusNodeConfig.Configure(); err != nil {
-		return fmt.Errorf("unable to configure Prometheus: %w", err)
+		return ctrl.Result{}, fmt.Errorf("unable to configure Prometheus: %w", err)
 	}
-	return nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-// ensureInstancesAreUpToDate configures all instances that require configuration
-func (r *ConfigMapReconciler) ensureInstancesAreUpToDate(instances []*instance.Info) error {
-	// Get private key to encrypt instance usernames
-	privateKeyBytes, err := secrets.GetPrivateKey(kubeTypes.NamespacedName{Namespace: r.watchNamespace,
-		Name: secrets.PrivateKeySecret}, r.client)
+// ensureInstancesAreUpToDate configures all instances described by the given WindowsInstances that require
+// configuration. The nodes parameter should be a list of all Windows BYOH nodes. If any upgrades were deferred until
+// the next maintenance window, the time until the upgrades should be retried is returned.
+func (r *ConfigMapReconciler) ensureInstancesAreUpToDate(ctx context.Context,
+	windowsInstances []wmcov1.WindowsInstance, nodes *core.NodeList) (time.Duration, error) {
+	operatorConfig, err := operatorconfig.Get(ctx, r.client, r.watchNamespace)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	windowsInstances := &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: wiparser.InstanceConfigMap,
-		Namespace: r.watchNamespace}}
-	for _, instanceInfo := range instances {
-		// When platform type is none or Nutanix, kubelet will pick a random interface to use for the Node's IP. In that case we
-		// should override that with the IP that the user is providing via the ConfigMap.
-		instanceInfo.SetNodeIP = r.platform == config.NonePlatformType || r.platform == config.NutanixPlatformType
-		encryptedUsername, err := crypto.EncryptToJSONString(instanceInfo.Username, privateKeyBytes)
-		if err != nil {
-			return fmt.Errorf("unable to encrypt username for instance %s: %w", instanceInfo.Address, err)
+	// Report all newly added instances as pending, so that each instance has a status while waiting to be configured
+	for i := range windowsInstances {
+		if windowsInstances[i].Status.Phase != "" || !windowsInstances[i].GetDeletionTimestamp().IsZero() {
+			continue
 		}
-		err = r.ensureInstanceIsUpToDate(instanceInfo, map[string]string{BYOHLabel: "true", nodeconfig.WorkerLabel: ""},
-			map[string]string{UsernameAnnotation: encryptedUsername})
-		if err != nil {
-			// It is better to return early like this, instead of trying to configure as many instances as possible in a
-			// single reconcile call, as it simplifies error collection. The order the map is read from is
-			// psuedo-random, so the configuration effort for configurable hosts will not be blocked by a specific host
-			// that has issues with configuration.
-			return fmt.Errorf("error configuring host with address %s: %w", instanceInfo.Address, err)
+		if err := r.setWindowsInstancePhase(ctx, &windowsInstances[i], wmcov1.WindowsInstancePending, "",
+			nil); err != nil {
+			return 0, err
+		}
+	}
+	// Deferred upgrades of removed instances are forgotten
+	current := make(map[client.ObjectKey]struct{})
+	for i := range windowsInstances {
+		if windowsInstances[i].GetDeletionTimestamp().IsZero() {
+			current[client.ObjectKeyFromObject(&windowsInstances[i])] = struct{}{}
 		}
-		r.recorder.Eventf(windowsInstances, core.EventTypeNormal, "InstanceSetup",
+	}
+	r.pruneUpgradeDeferrals(current)
+	pool := newBYOHUpgradePool(operatorConfig.Upgrade, operatorConfig.MaintenanceWindow)
+	var requeueLock sync.Mutex
+	var requeueAfter time.Duration
+	// Instances are configured concurrently, and errors are collected rather than returned early, so that a host
+	// with issues does not delay the configuration of other hosts
+	err = runConcurrently(len(windowsInstances), operatorConfig.MaxConcurrentInstanceConfigurations,
+		func(i int) error {
+			if !windowsInstances[i].GetDeletionTimestamp().IsZero() {
+				return nil
+			}
+			err := r.ensureWindowsInstanceIsUpToDate(ctx, &windowsInstances[i], nodes, pool)
+			var deferredErr *upgradeDeferredError
+			if errors.As(err, &deferredErr) && deferredErr.requeueAfter() > 0 {
+				// The upgrade is retried once the next maintenance window starts, rather than treated as a failure
+				untilWindow := deferredErr.requeueAfter()
+				requeueLock.Lock()
+				if requeueAfter == 0 || untilWindow < requeueAfter {
+					requeueAfter = untilWindow
+				}
+				requeueLock.Unlock()
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error configuring host with address %s: %w", windowsInstances[i].Spec.Address,
+					err)
+			}
+			return nil
+		})
+	return requeueAfter, err
+}
+
+// ensureWindowsInstanceIsUpToDate configures the instance described by the given WindowsInstance, if required, and
+// reflects the outcome in the WindowsInstance's status. Upgrades of the instance are subject to the given pool's policy.
+func (r *ConfigMapReconciler) ensureWindowsInstanceIsUpToDate(ctx context.Context,
+	windowsInstance *wmcov1.WindowsInstance, nodes *core.NodeList, pool *upgradePool) error {
+	instanceInfo, err := wiparser.FromWindowsInstance(windowsInstance, nodes)
+	if err != nil {
+		return r.markWindowsInstanceFailed(ctx, windowsInstance, err)
+	}
+	// When platform type is none or Nutanix, kubelet will pick a random interface to use for the Node's IP. In that
+	// case we should override that with the IP that the user is providing via the WindowsInstance.
+	instanceInfo.SetNodeIP = r.platform == config.NonePlatformType || r.platform == config.NutanixPlatformType
+
+	encryptedUsername, err := r.encryptUsername(instanceInfo.Username)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt username for instance %s: %w", instanceInfo.Address, err)
+	}
+
+	labelsToApply := map[string]string{BYOHLabel: "true", nodeconfig.WorkerLabel: ""}
+	for key, value := range windowsInstance.Spec.Labels {
+		labelsToApply[key] = value
+	}
+	annotationsToApply := map[string]string{UsernameAnnotation: encryptedUsername}
+	if instanceInfo.PrivateKeySecret != "" {
+		annotationsToApply[PrivateKeySecretAnnotation] = instanceInfo.PrivateKeySecret
+	}
+	if instanceInfo.UsesWinRM() {
+		annotationsToApply[TransportAnnotation] = string(instanceInfo.Transport)
+	}
+
+	upToDate := instanceInfo.UpToDate()
+	if instanceInfo.UpgradeRequired() {
+		if err := r.admitUpgrade(ctx, instanceInfo.Node, pool); err != nil {
+			var deferredErr *upgradeDeferredError
+			if !errors.As(err, &deferredErr) {
+				return r.markWindowsInstanceFailed(ctx, windowsInstance, err)
+			}
+			if r.upgradeDeferralChanged(windowsInstance, deferredErr) {
+				r.recorder.Eventf(windowsInstance, core.EventTypeNormal, "UpgradeDeferred",
+					"Upgrade of instance with address %s deferred: %v", instanceInfo.Address, err)
+			}
+			if deferredErr.paused {
+				return nil
+			}
+			return err
+		}
+		r.clearUpgradeDeferral(client.ObjectKeyFromObject(windowsInstance))
+	}
+	if !upToDate {
+		if err := r.setWindowsInstancePhase(ctx, windowsInstance, wmcov1.WindowsInstanceConfiguring,
+			windowsInstance.Status.NodeName, nil); err != nil {
+			return err
+		}
+	}
+	err = r.ensureInstanceIsUpToDate(instanceInfo, pool, labelsToApply, annotationsToApply, func(step nodeconfig.Step) {
+		r.recordWindowsInstanceStep(ctx, windowsInstance, step)
+	})
+	if err != nil {
+		return r.markWindowsInstanceFailed(ctx, windowsInstance, err)
+	}
+
+	node, err := r.syncNodeMetadata(ctx, instanceInfo, windowsInstance)
+	if err != nil {
+		return r.markWindowsInstanceFailed(ctx, windowsInstance, err)
+	}
+	if !upToDate {
+		r.recorder.Eventf(windowsInstance, core.EventTypeNormal, "InstanceSetup",
 			"Configured instance with address %s as a worker node", instanceInfo.Address)
 	}
-	return nil
+	return r.setWindowsInstancePhase(ctx, windowsInstance, wmcov1.WindowsInstanceConfigured, node.GetName(), nil)
 }
 
 // deconfigureInstances removes all BYOH nodes that are not specified in the given instances slice, and
@@ -408,10 +595,22 @@ func (r *ConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			return r.isValidConfigMap(e.Object)
 		},
 	}
+	// Changes to the operator configuration, such as resuming upgrades, may affect how instances are configured
+	operatorConfigPredicate := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		return o.GetNamespace() == r.watchNamespace && o.GetName() == operatorconfig.ConfigMapName
+	})
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&core.ConfigMap{}, builder.WithPredicates(configMapPredicate)).
+		Watches(&wmcov1.WindowsInstance{}, handler.EnqueueRequestsFromMapFunc(r.mapToInstancesConfigMap),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Watches(&core.Node{}, handler.EnqueueRequestsFromMapFunc(r.mapToInstancesConfigMap),
 			builder.WithPredicates(outdatedWindowsNodePredicate(true))).
+		Watches(&core.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.mapToInstancesConfigMap),
+			builder.WithPredicates(operatorConfigPredicate)).
+		Watches(&core.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.mapToServicesConfigMap),
+			builder.WithPredicates(operatorConfigPredicate)).
+		Watches(&wmcov1.WindowsService{}, handler.EnqueueRequestsFromMapFunc(r.mapToServicesConfigMap),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Watches(&core.Node{}, handler.EnqueueRequestsFromMapFunc(r.mapToServicesConfigMap),
 			builder.WithPredicates(windowsNodeVersionChangePredicate())).
 		Complete(r)
@@ -523,8 +722,12 @@ func (r *ConfigMapReconciler) ensureTrustedCABundleInNode(ctx context.Context, n
 	if err != nil {
 		return err
 	}
+	instanceSigner, err := r.instanceSigner(winInstance)
+	if err != nil {
+		return err
+	}
 	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-		winInstance, r.signer, nil, nil, r.platform)
+		winInstance, instanceSigner, nil, nil, r.platform)
 	if err != nil {
 		return fmt.Errorf("failed to create new nodeconfig: %w", err)
 	}
@@ -65,6 +65,7 @@ func NewControllerConfigReconciler(mgr manager.Manager, clusterConfig cluster.Co
 			k8sclientset:       clientset,
 			clusterServiceCIDR: clusterConfig.Network().GetServiceCIDR(),
 			watchNamespace:     watchNamespace,
+			controllerName:     ControllerConfigController,
 			recorder:           mgr.GetEventRecorderFor(ControllerConfigController),
 		},
 	}, nil
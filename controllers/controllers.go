@@ -11,12 +11,14 @@ import (
 	"golang.org/x/crypto/ssh"
 	core "k8s.io/api/core/v1"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
 	"github.com/openshift/windows-machine-config-operator/pkg/condition"
 	"github.com/openshift/windows-machine-config-operator/pkg/crypto"
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
@@ -24,15 +26,11 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
+	"github.com/openshift/windows-machine-config-operator/pkg/signer"
+	"github.com/openshift/windows-machine-config-operator/pkg/wiparser"
 	"github.com/openshift/windows-machine-config-operator/version"
 )
 
-const (
-	// MaxParallelUpgrades is the default maximum allowed number of nodes that can be upgraded in parallel.
-	// It is a positive integer and cannot be used to stop upgrades, only to limit the number of concurrent upgrades.
-	MaxParallelUpgrades = 1
-)
-
 var (
 	// controllerLocker is used to synchronize upgrades between controllers
 	controllerLocker sync.Mutex
@@ -57,12 +55,23 @@ type instanceReconciler struct {
 	recorder record.EventRecorder
 	// platform indicates the cloud on which the cluster is running
 	platform config.PlatformType
+	// controllerName is the name of the controller the reconciler belongs to
+	controllerName string
+	// deferredUpgrades holds the state of each deferred upgrade, keyed by the object whose upgrade was deferred, so
+	// that an event is only recorded when an upgrade becomes deferred, or becomes deferred for a different reason
+	deferredUpgrades sync.Map
+	// keyRotationLocks prevents the key of an instance from being rotated by multiple controllers at the same time. It
+	// is only set for reconcilers which rotate keys.
+	keyRotationLocks *KeyRotationLocks
 }
 
 // ensureInstanceIsUpToDate ensures that the given instance is configured as a node and upgraded to the specifications
-// defined by the current version of WMCO. If labelsToApply/annotationsToApply is not nil, the node will have the
-// specified annotations and/or labels applied to it.
-func (r *instanceReconciler) ensureInstanceIsUpToDate(instanceInfo *instance.Info, labelsToApply, annotationsToApply map[string]string) error {
+// defined by the current version of WMCO. Upgrades are subject to the policy of the given upgrade pool, which must not
+// be nil if the instance requires an upgrade. If labelsToApply/annotationsToApply is not nil, the node will have the
+// specified annotations and/or labels applied to it. If stepRecorder is not nil, it is called as each configuration
+// step is reached.
+func (r *instanceReconciler) ensureInstanceIsUpToDate(instanceInfo *instance.Info, pool *upgradePool, labelsToApply,
+	annotationsToApply map[string]string, stepRecorder func(nodeconfig.Step)) (err error) {
 	if instanceInfo == nil {
 		return fmt.Errorf("instance cannot be nil")
 	}
@@ -74,12 +83,18 @@ func (r *instanceReconciler) ensureInstanceIsUpToDate(instanceInfo *instance.Inf
 			instanceInfo.Node.GetAnnotations()[metadata.VersionAnnotation])
 		return nil
 	}
+	defer func() { r.countInstanceConfiguration(instanceInfo, err) }()
 
+	instanceSigner, err := r.instanceSigner(instanceInfo)
+	if err != nil {
+		return err
+	}
 	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-		instanceInfo, r.signer, labelsToApply, annotationsToApply, r.platform)
+		instanceInfo, instanceSigner, labelsToApply, annotationsToApply, r.platform)
 	if err != nil {
 		return fmt.Errorf("failed to create new nodeconfig: %w", err)
 	}
+	nc.SetStepRecorder(stepRecorder)
 
 	// Check if the instance was configured by a previous version of WMCO and must be deconfigured before being
 	// configured again.
@@ -87,7 +102,7 @@ func (r *instanceReconciler) ensureInstanceIsUpToDate(instanceInfo *instance.Inf
 		// Instance requiring an upgrade indicates that node object is present with the version annotation
 		r.log.Info("instance requires upgrade", "node", instanceInfo.Node.GetName(), "version",
 			instanceInfo.Node.GetAnnotations()[metadata.VersionAnnotation], "expected version", version.Get())
-		if err := markNodeAsUpgrading(context.TODO(), r.client, instanceInfo.Node); err != nil {
+		if err := r.admitUpgrade(context.TODO(), instanceInfo.Node, pool); err != nil {
 			return err
 		}
 		if err := nc.Deconfigure(); err != nil {
@@ -98,7 +113,7 @@ func (r *instanceReconciler) ensureInstanceIsUpToDate(instanceInfo *instance.Inf
 	return nc.Configure()
 }
 
-// instanceFromNode returns an instance object for the given node. Requires a username that can be used to SSH into the
+// instanceFromNode returns an instance object for the given node. Requires a username that can be used to access the
 // instance to be annotated on the node.
 func (r *instanceReconciler) instanceFromNode(node *core.Node) (*instance.Info, error) {
 	usernameAnnotation := node.Annotations[UsernameAnnotation]
@@ -110,18 +125,93 @@ func (r *instanceReconciler) instanceFromNode(node *core.Node) (*instance.Info,
 		return nil, err
 	}
 
-	// Decrypt username annotation to plain text using private key
-	privateKeyBytes, err := secrets.GetPrivateKey(kubeTypes.NamespacedName{Namespace: r.watchNamespace,
-		Name: secrets.PrivateKeySecret}, r.client)
+	username, err := r.decryptUsername(node)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt username annotation for node %s: %w", node.Name, err)
+	}
+
+	instanceInfo, err := instance.NewInfo(addr, username, "", false, node)
 	if err != nil {
 		return nil, err
 	}
-	username, err := crypto.DecryptFromJSONString(usernameAnnotation, privateKeyBytes)
+	instanceInfo.PrivateKeySecret = node.Annotations[PrivateKeySecretAnnotation]
+	instanceInfo.Transport, err = instance.ParseTransport(node.Annotations[TransportAnnotation])
 	if err != nil {
-		return nil, fmt.Errorf("unable to decrypt username annotation for node %s: %w", node.Name, err)
+		return nil, fmt.Errorf("node %s has an invalid %s annotation: %w", node.Name, TransportAnnotation, err)
 	}
+	if node.Labels[BYOHLabel] == "true" {
+		if instanceInfo.SSHRoute, err = r.sshRouteForNode(node); err != nil {
+			return nil, err
+		}
+	}
+	return instanceInfo, nil
+}
 
-	return instance.NewInfo(addr, username, "", false, node)
+// sshRouteForNode returns the SSH route specified by the WindowsInstance associated with the given BYOH node. A direct
+// route is returned if there is no such WindowsInstance, for example because it has been deleted.
+func (r *instanceReconciler) sshRouteForNode(node *core.Node) (instance.SSHRoute, error) {
+	windowsInstances := &wmcov1.WindowsInstanceList{}
+	if err := r.client.List(context.TODO(), windowsInstances, client.InNamespace(r.watchNamespace)); err != nil {
+		return instance.SSHRoute{}, fmt.Errorf("error listing WindowsInstances: %w", err)
+	}
+	windowsInstance, err := wiparser.GetWindowsInstanceForNode(windowsInstances.Items, node)
+	if err != nil {
+		return instance.SSHRoute{}, nil
+	}
+	route, err := wiparser.ToSSHRoute(windowsInstance.Spec.ProxyURL, wiparser.SecretName(
+		windowsInstance.Spec.ProxyCredentialsSecretRef), windowsInstance.Spec.JumpHosts)
+	if err != nil {
+		return instance.SSHRoute{}, fmt.Errorf("WindowsInstance %s has an invalid SSH route: %w",
+			windowsInstance.Name, err)
+	}
+	return route, nil
+}
+
+// encryptUsername returns the given username encrypted with the username encryption key, as stored in the username
+// annotation of nodes
+func (r *instanceReconciler) encryptUsername(username string) (string, error) {
+	key, err := secrets.GetUsernameEncryptionKey(r.watchNamespace, r.client)
+	if err != nil {
+		return "", err
+	}
+	return crypto.EncryptToJSONString(username, key)
+}
+
+// decryptUsername returns the plaintext value of the username annotation of the given node. Annotations applied by
+// previous versions of WMCO are encrypted with the private key used to access the instance instead of the username
+// encryption key, and are decrypted with that private key, or with the previous private key if it has since been
+// rotated.
+func (r *instanceReconciler) decryptUsername(node *core.Node) (string, error) {
+	usernameAnnotation := node.Annotations[UsernameAnnotation]
+	key, err := secrets.GetUsernameEncryptionKey(r.watchNamespace, r.client)
+	if err != nil {
+		return "", err
+	}
+	username, err := crypto.DecryptFromJSONString(usernameAnnotation, key)
+	if err == nil {
+		return username, nil
+	}
+
+	legacyKeySecrets := []string{node.Annotations[PrivateKeySecretAnnotation]}
+	if usesOperatorKey(node) {
+		legacyKeySecrets = []string{secrets.PrivateKeySecret, secrets.PreviousPrivateKeySecret}
+	}
+	for _, keySecret := range legacyKeySecrets {
+		privateKeyBytes, keyErr := secrets.GetPrivateKey(kubeTypes.NamespacedName{Namespace: r.watchNamespace,
+			Name: keySecret}, r.client)
+		if keyErr != nil {
+			continue
+		}
+		if username, keyErr := crypto.DecryptFromJSONString(usernameAnnotation, privateKeyBytes); keyErr == nil {
+			return username, nil
+		}
+	}
+	return "", err
+}
+
+// instanceSigner returns the signer that should be used to access the given instance
+func (r *instanceReconciler) instanceSigner(instanceInfo *instance.Info) (ssh.Signer, error) {
+	return signer.ForInstance(instanceInfo, r.signer, r.watchNamespace, r.client)
 }
 
 // updateKubeletCA updates the kubelet CA in the node, by copying the kubelet CA file content to the Windows instance
@@ -130,8 +220,12 @@ func (r *instanceReconciler) updateKubeletCA(node core.Node, contents []byte) er
 	if err != nil {
 		return fmt.Errorf("error creating instance for node %s: %w", node.Name, err)
 	}
+	instanceSigner, err := r.instanceSigner(winInstance)
+	if err != nil {
+		return err
+	}
 	nodeConfig, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR,
-		r.watchNamespace, winInstance, r.signer, nil, nil, r.platform)
+		r.watchNamespace, winInstance, instanceSigner, nil, nil, r.platform)
 	if err != nil {
 		return fmt.Errorf("error creating nodeConfig for instance %s: %w", winInstance.Address, err)
 	}
@@ -161,8 +255,12 @@ func (r *instanceReconciler) deconfigureInstance(node *core.Node) error {
 		return fmt.Errorf("unable to create instance object from node: %w", err)
 	}
 
+	instanceSigner, err := r.instanceSigner(instance)
+	if err != nil {
+		return err
+	}
 	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-		instance, r.signer, nil, nil, r.platform)
+		instance, instanceSigner, nil, nil, r.platform)
 	if err != nil {
 		return fmt.Errorf("failed to create new nodeconfig: %w", err)
 	}
@@ -170,6 +268,11 @@ func (r *instanceReconciler) deconfigureInstance(node *core.Node) error {
 	if err = nc.Deconfigure(); err != nil {
 		return err
 	}
+	// The payload cache is only kept when an instance is deconfigured to be upgraded. A failure to remove it is not
+	// retried, as the instance has already been deconfigured.
+	if err = nc.RemovePayloadCache(); err != nil {
+		r.log.Error(err, "unable to remove payload cache", "node", instance.Node.GetName())
+	}
 	if err = r.client.Delete(context.TODO(), instance.Node); err != nil {
 		return fmt.Errorf("error deleting node %s: %w", instance.Node.GetName(), err)
 	}
@@ -267,37 +370,29 @@ func markAsFreeOnSuccess(c client.Client, watchNamespace string, recorder record
 	return err
 }
 
-// markNodeAsUpgrading marks the given node as upgrading by adding an annotation to it. If the number of nodes
-// performing upgrades in parallel exceeds the maximum allowed, an error is returned
-func markNodeAsUpgrading(ctx context.Context, c client.Client, currentNode *core.Node) error {
-	controllerLocker.Lock()
-	defer controllerLocker.Unlock()
-	upgradingNodes, err := findUpgradingNodes(ctx, c)
-	if err != nil {
-		return err
-	}
-	// check if current node is already marked as upgrading
-	for _, node := range upgradingNodes.Items {
-		if node.Name == currentNode.Name {
-			// current node is upgrading, continue with it
-			return nil
-		}
+// runConcurrently calls fn for each index in [0, count), running at most maxConcurrent calls at the same time. All
+// calls are made regardless of failures, and the errors returned by them are aggregated.
+func runConcurrently(count, maxConcurrent int, fn func(int) error) error {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
 	}
-	if len(upgradingNodes.Items) >= MaxParallelUpgrades {
-		return fmt.Errorf("cannot mark node %s as upgrading, maximum number of parallel upgrading nodes reached (%d)",
-			currentNode.Name, MaxParallelUpgrades)
+	var wg sync.WaitGroup
+	var errsLock sync.Mutex
+	var errs []error
+	inFlight := make(chan struct{}, maxConcurrent)
+	for i := 0; i < count; i++ {
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			if err := fn(i); err != nil {
+				errsLock.Lock()
+				errs = append(errs, err)
+				errsLock.Unlock()
+			}
+		}(i)
 	}
-	return metadata.ApplyUpgradingLabel(ctx, c, currentNode)
-}
-
-// findUpgradingNodes returns a pointer to the resulting list of Windows nodes that are upgrading  i.e. have the
-// upgrading label set to true
-func findUpgradingNodes(ctx context.Context, c client.Client) (*core.NodeList, error) {
-	// get nodes Windows nodes with upgrading label
-	matchingLabels := client.MatchingLabels{core.LabelOSStable: "windows", metadata.UpgradingLabel: "true"}
-	nodeList := &core.NodeList{}
-	if err := c.List(ctx, nodeList, matchingLabels); err != nil {
-		return nil, fmt.Errorf("error listing Windows nodes with upgrading label: %w", err)
-	}
-	return nodeList, nil
+	wg.Wait()
+	return kerrors.NewAggregate(errs)
 }
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"fmt"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -62,3 +64,70 @@ func TestGetAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestRunConcurrently(t *testing.T) {
+	testCases := []struct {
+		name          string
+		count         int
+		maxConcurrent int
+		failing       map[int]bool
+		expectedErrs  int
+	}{
+		{
+			name:          "no items",
+			count:         0,
+			maxConcurrent: 2,
+		},
+		{
+			name:          "all succeed",
+			count:         10,
+			maxConcurrent: 3,
+		},
+		{
+			name:          "invalid limit treated as sequential",
+			count:         4,
+			maxConcurrent: 0,
+		},
+		{
+			name:          "failures do not stop other calls",
+			count:         10,
+			maxConcurrent: 3,
+			failing:       map[int]bool{0: true, 5: true},
+			expectedErrs:  2,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var calls, inFlight, maxInFlight int32
+			err := runConcurrently(test.count, test.maxConcurrent, func(i int) error {
+				atomic.AddInt32(&calls, 1)
+				current := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				for {
+					observed := atomic.LoadInt32(&maxInFlight)
+					if current <= observed || atomic.CompareAndSwapInt32(&maxInFlight, observed, current) {
+						break
+					}
+				}
+				if test.failing[i] {
+					return fmt.Errorf("item %d failed", i)
+				}
+				return nil
+			})
+			assert.Equal(t, int32(test.count), calls)
+			expectedLimit := test.maxConcurrent
+			if expectedLimit < 1 {
+				expectedLimit = 1
+			}
+			assert.LessOrEqual(t, maxInFlight, int32(expectedLimit))
+			if test.expectedErrs == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			for i := range test.failing {
+				assert.Contains(t, err.Error(), fmt.Sprintf("item %d failed", i))
+			}
+		})
+	}
+}
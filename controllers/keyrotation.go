@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/crypto"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
+	"github.com/openshift/windows-machine-config-operator/pkg/signer"
+	"github.com/openshift/windows-machine-config-operator/pkg/wiparser"
+)
+
+// KeyRotationLocks prevents controllers from rotating the key of the same instance at the same time. A single
+// KeyRotationLocks must be shared by all reconcilers which rotate keys.
+type KeyRotationLocks struct {
+	// locks holds a mutex for each node, by name
+	locks sync.Map
+}
+
+// forNode returns the mutex guarding the key rotation of the instance associated with the given node
+func (l *KeyRotationLocks) forNode(nodeName string) *sync.Mutex {
+	lock, _ := l.locks.LoadOrStore(nodeName, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// remove forgets the mutex of the given node, once the node is gone
+func (l *KeyRotationLocks) remove(nodeName string) {
+	l.locks.Delete(nodeName)
+}
+
+// prune forgets the mutexes of all nodes aside from the given nodes
+func (l *KeyRotationLocks) prune(nodes []core.Node) {
+	current := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		current[node.GetName()] = struct{}{}
+	}
+	l.locks.Range(func(nodeName, _ interface{}) bool {
+		if _, exists := current[nodeName.(string)]; !exists {
+			l.locks.Delete(nodeName)
+		}
+		return true
+	})
+}
+
+// usesOperatorKey returns true if the instance associated with the given node is accessed with the operator's private
+// key, rather than with the private key of its WindowsInstance
+func usesOperatorKey(node *core.Node) bool {
+	return node.Annotations[PrivateKeySecretAnnotation] == ""
+}
+
+// rotateInstanceKey moves the instance associated with the given node from the previous private key to the private
+// key of the given signer. The instance is accessed with the previous key to authorize the new key, and the previous
+// key is only revoked, and the node's public key hash annotation updated, once the instance has been accessed with the
+// new key.
+func (r *instanceReconciler) rotateInstanceKey(ctx context.Context, node *core.Node, keySigner ssh.Signer) error {
+	lock := r.keyRotationLocks.forNode(node.GetName())
+	lock.Lock()
+	defer lock.Unlock()
+
+	expectedPubKeyAnno := nodeconfig.CreatePubKeyHashAnnotation(keySigner.PublicKey())
+	if node.Annotations[nodeconfig.PubKeyHashAnnotation] == expectedPubKeyAnno {
+		return nil
+	}
+	previousSigner, err := signer.Create(kubeTypes.NamespacedName{Namespace: r.watchNamespace,
+		Name: secrets.PreviousPrivateKeySecret}, r.client)
+	if err != nil {
+		return fmt.Errorf("unable to get signer from secret %s: %w", secrets.PreviousPrivateKeySecret, err)
+	}
+	if node.Annotations[nodeconfig.PubKeyHashAnnotation] !=
+		nodeconfig.CreatePubKeyHashAnnotation(previousSigner.PublicKey()) {
+		return fmt.Errorf("node %s was not configured with the private key in secret %s", node.GetName(),
+			secrets.PreviousPrivateKeySecret)
+	}
+	instanceInfo, err := r.instanceFromNode(node)
+	if err != nil {
+		return err
+	}
+
+	// Instances accessed over WinRM are not accessed with the key, so only the annotation needs to be updated
+	if !instanceInfo.UsesWinRM() {
+		previousNC, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
+			instanceInfo, previousSigner, nil, nil, r.platform)
+		if err != nil {
+			return fmt.Errorf("unable to access instance with the previous private key: %w", err)
+		}
+		if err := previousNC.Windows.AuthorizeKey(keySigner.PublicKey()); err != nil {
+			return err
+		}
+		nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
+			instanceInfo, keySigner, nil, nil, r.platform)
+		if err != nil {
+			return fmt.Errorf("unable to access instance with the new private key: %w", err)
+		}
+		if err := nc.Windows.RevokeKey(previousSigner.PublicKey()); err != nil {
+			return err
+		}
+	}
+
+	if err := metadata.ApplyLabelsAndAnnotations(ctx, r.client, *node, nil,
+		map[string]string{nodeconfig.PubKeyHashAnnotation: expectedPubKeyAnno}); err != nil {
+		return fmt.Errorf("error updating annotations on node %s: %w", node.GetName(), err)
+	}
+	r.log.Info("rotated private key in place", "node", node.GetName())
+	return nil
+}
+
+// ensurePreviousPrivateKey ensures the previous private key secret holds a copy of the private key secret, so that the
+// key can be used to rotate instances in place once the private key secret changes
+func (r *instanceReconciler) ensurePreviousPrivateKey(ctx context.Context) error {
+	current := &core.Secret{}
+	if err := r.client.Get(ctx, kubeTypes.NamespacedName{Namespace: r.watchNamespace,
+		Name: secrets.PrivateKeySecret}, current); err != nil {
+		return fmt.Errorf("unable to get secret %s: %w", secrets.PrivateKeySecret, err)
+	}
+	previous := &core.Secret{}
+	err := r.client.Get(ctx, kubeTypes.NamespacedName{Namespace: r.watchNamespace,
+		Name: secrets.PreviousPrivateKeySecret}, previous)
+	if err != nil && !k8sapierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to get secret %s: %w", secrets.PreviousPrivateKeySecret, err)
+	}
+	exists := err == nil
+
+	var annotations map[string]string
+	if passphraseSecret, present := current.GetAnnotations()[secrets.PassphraseSecretAnnotation]; present {
+		annotations = map[string]string{secrets.PassphraseSecretAnnotation: passphraseSecret}
+	}
+	if exists && reflect.DeepEqual(previous.Data, current.Data) &&
+		previous.GetAnnotations()[secrets.PassphraseSecretAnnotation] == annotations[secrets.PassphraseSecretAnnotation] {
+		return nil
+	}
+	previous.SetName(secrets.PreviousPrivateKeySecret)
+	previous.SetNamespace(r.watchNamespace)
+	previous.SetAnnotations(annotations)
+	previous.Data = current.Data
+	if !exists {
+		err = r.client.Create(ctx, previous)
+	} else {
+		err = r.client.Update(ctx, previous)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to store private key in secret %s: %w", secrets.PreviousPrivateKeySecret, err)
+	}
+	return nil
+}
+
+// removePreviousPrivateKey deletes the previous private key secret, which is only kept while keys are rotated in place
+func (r *instanceReconciler) removePreviousPrivateKey(ctx context.Context) error {
+	previous := &core.Secret{}
+	previous.SetName(secrets.PreviousPrivateKeySecret)
+	previous.SetNamespace(r.watchNamespace)
+	if err := r.client.Delete(ctx, previous); err != nil && !k8sapierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete secret %s: %w", secrets.PreviousPrivateKeySecret, err)
+	}
+	return nil
+}
+
+// errUsernameUndecryptable is returned when a username annotation cannot be decrypted with any known key, in which
+// case retrying the migration cannot succeed
+var errUsernameUndecryptable = errors.New("unable to decrypt username annotation with the current or previous keys")
+
+// migrateUsernameAnnotations re-encrypts the username annotations of Windows nodes which are not encrypted with the
+// username encryption key, such as annotations applied by previous versions of WMCO with the private key. Nodes whose
+// annotation cannot be decrypted are reported through an event, and do not cause an error to be returned, as retrying
+// cannot migrate them. Any other failure is returned, so that the migration is retried.
+func (r *instanceReconciler) migrateUsernameAnnotations(ctx context.Context) error {
+	key, err := secrets.GetUsernameEncryptionKey(r.watchNamespace, r.client)
+	if err != nil {
+		return err
+	}
+	nodes := &core.NodeList{}
+	if err := r.client.List(ctx, nodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		return fmt.Errorf("error getting node list: %w", err)
+	}
+	var errs []error
+	for _, node := range nodes.Items {
+		usernameAnnotation := node.Annotations[UsernameAnnotation]
+		if usernameAnnotation == "" {
+			continue
+		}
+		if _, err := crypto.DecryptFromJSONString(usernameAnnotation, key); err == nil {
+			continue
+		}
+		err := r.migrateUsernameAnnotation(ctx, &node, key)
+		if err == nil {
+			continue
+		}
+		r.log.Error(err, "unable to migrate username annotation", "node", node.GetName())
+		if errors.Is(err, errUsernameUndecryptable) {
+			r.recorder.Eventf(&node, core.EventTypeWarning, "UsernameMigrationFailed",
+				"Unable to re-encrypt the username annotation of node %s: %v", node.GetName(), err)
+			continue
+		}
+		errs = append(errs, fmt.Errorf("node %s: %w", node.GetName(), err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to migrate username annotations: %w", kerrors.NewAggregate(errs))
+	}
+	return nil
+}
+
+// migrateUsernameAnnotation re-encrypts the username annotation of the given node with the given key. The username of
+// BYOH nodes whose annotation cannot be decrypted is taken from their WindowsInstance. errUsernameUndecryptable is
+// returned if the username cannot be recovered.
+func (r *instanceReconciler) migrateUsernameAnnotation(ctx context.Context, node *core.Node, key []byte) error {
+	username, err := r.decryptUsername(node)
+	if err != nil && node.Labels[BYOHLabel] == "true" {
+		windowsInstances := &wmcov1.WindowsInstanceList{}
+		if listErr := r.client.List(ctx, windowsInstances, client.InNamespace(r.watchNamespace)); listErr != nil {
+			return fmt.Errorf("error listing WindowsInstances: %w", listErr)
+		}
+		if wiUsername, wiErr := wiparser.GetNodeUsername(windowsInstances.Items, node); wiErr == nil {
+			username, err = wiUsername, nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUsernameUndecryptable, err)
+	}
+	encryptedUsername, err := crypto.EncryptToJSONString(username, key)
+	if err != nil {
+		return fmt.Errorf("error encrypting username: %w", err)
+	}
+	if err := metadata.ApplyLabelsAndAnnotations(ctx, r.client, *node, nil,
+		map[string]string{UsernameAnnotation: encryptedUsername}); err != nil {
+		return fmt.Errorf("error updating annotations: %w", err)
+	}
+	r.log.Info("re-encrypted username annotation", "node", node.GetName())
+	return nil
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/crypto"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
+)
+
+// testNamespace is the operator namespace used by the tests
+const testNamespace = "openshift-windows-machine-config-operator"
+
+// newTestKeySecret returns a private key secret with the given name holding a new key, along with its signer
+func newTestKeySecret(t *testing.T, name string) (*core.Secret, ssh.Signer) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	require.NoError(t, err)
+	keySigner, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+	return &core.Secret{ObjectMeta: meta.ObjectMeta{Name: name, Namespace: testNamespace},
+		Data: map[string][]byte{secrets.PrivateKeySecretKey: pem.EncodeToMemory(block)}}, keySigner
+}
+
+func TestEnsurePreviousPrivateKey(t *testing.T) {
+	current, _ := newTestKeySecret(t, secrets.PrivateKeySecret)
+	current.Annotations = map[string]string{secrets.PassphraseSecretAnnotation: "passphrase", "other": "value"}
+	c := fake.NewClientBuilder().WithObjects(current).Build()
+	r := instanceReconciler{client: c, watchNamespace: testNamespace, log: logr.Discard()}
+
+	require.NoError(t, r.ensurePreviousPrivateKey(context.TODO()))
+	previous := &core.Secret{}
+	require.NoError(t, c.Get(context.TODO(), kubeTypes.NamespacedName{Namespace: testNamespace,
+		Name: secrets.PreviousPrivateKeySecret}, previous))
+	assert.Equal(t, current.Data, previous.Data)
+	assert.Equal(t, map[string]string{secrets.PassphraseSecretAnnotation: "passphrase"}, previous.Annotations)
+
+	// The copy follows changes to the private key secret
+	updated, _ := newTestKeySecret(t, secrets.PrivateKeySecret)
+	current.Data = updated.Data
+	current.Annotations = nil
+	require.NoError(t, c.Update(context.TODO(), current))
+	require.NoError(t, r.ensurePreviousPrivateKey(context.TODO()))
+	require.NoError(t, c.Get(context.TODO(), kubeTypes.NamespacedName{Namespace: testNamespace,
+		Name: secrets.PreviousPrivateKeySecret}, previous))
+	assert.Equal(t, updated.Data, previous.Data)
+	assert.Empty(t, previous.Annotations)
+}
+
+func TestRemovePreviousPrivateKey(t *testing.T) {
+	previous, _ := newTestKeySecret(t, secrets.PreviousPrivateKeySecret)
+	c := fake.NewClientBuilder().WithObjects(previous).Build()
+	r := instanceReconciler{client: c, watchNamespace: testNamespace, log: logr.Discard()}
+
+	require.NoError(t, r.removePreviousPrivateKey(context.TODO()))
+	err := c.Get(context.TODO(), kubeTypes.NamespacedName{Namespace: testNamespace,
+		Name: secrets.PreviousPrivateKeySecret}, &core.Secret{})
+	assert.True(t, k8sapierrors.IsNotFound(err))
+	// Removing the secret once it is gone is not an error
+	require.NoError(t, r.removePreviousPrivateKey(context.TODO()))
+}
+
+func TestKeyRotationLocksPrune(t *testing.T) {
+	locks := &KeyRotationLocks{}
+	removed := locks.forNode("removed")
+	kept := locks.forNode("kept")
+
+	locks.prune([]core.Node{{ObjectMeta: meta.ObjectMeta{Name: "kept"}}})
+	assert.Same(t, kept, locks.forNode("kept"))
+	assert.NotSame(t, removed, locks.forNode("removed"))
+}
+
+func TestRotateInstanceKeyPreconditions(t *testing.T) {
+	previous, previousSigner := newTestKeySecret(t, secrets.PreviousPrivateKeySecret)
+	_, currentSigner := newTestKeySecret(t, secrets.PrivateKeySecret)
+	_, otherSigner := newTestKeySecret(t, "other")
+
+	testCases := []struct {
+		name        string
+		pubKeyHash  string
+		objects     []*core.Secret
+		expectedErr bool
+	}{
+		{
+			name:        "node already uses current key",
+			pubKeyHash:  nodeconfig.CreatePubKeyHashAnnotation(currentSigner.PublicKey()),
+			expectedErr: false,
+		},
+		{
+			name:        "previous key missing",
+			pubKeyHash:  nodeconfig.CreatePubKeyHashAnnotation(previousSigner.PublicKey()),
+			expectedErr: true,
+		},
+		{
+			name:        "node not configured with previous key",
+			pubKeyHash:  nodeconfig.CreatePubKeyHashAnnotation(otherSigner.PublicKey()),
+			objects:     []*core.Secret{previous},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder()
+			for _, obj := range test.objects {
+				builder = builder.WithObjects(obj)
+			}
+			r := instanceReconciler{client: builder.Build(), watchNamespace: testNamespace, log: logr.Discard(),
+				keyRotationLocks: &KeyRotationLocks{}}
+			node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "node",
+				Annotations: map[string]string{nodeconfig.PubKeyHashAnnotation: test.pubKeyHash}}}
+			err := r.rotateInstanceKey(context.TODO(), node, currentSigner)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestMigrateUsernameAnnotations(t *testing.T) {
+	current, _ := newTestKeySecret(t, secrets.PrivateKeySecret)
+	previous, _ := newTestKeySecret(t, secrets.PreviousPrivateKeySecret)
+	other, _ := newTestKeySecret(t, "other")
+	usernameKey := []byte("0123456789abcdef0123456789abcdef")
+	usernameKeySecret := &core.Secret{ObjectMeta: meta.ObjectMeta{Name: secrets.UsernameEncryptionKeySecret,
+		Namespace: testNamespace}, Data: map[string][]byte{secrets.UsernameEncryptionKeySecretKey: usernameKey}}
+
+	newNode := func(name string, key []byte) *core.Node {
+		encrypted, err := crypto.EncryptToJSONString(name+"-user", key)
+		require.NoError(t, err)
+		return &core.Node{ObjectMeta: meta.ObjectMeta{Name: name,
+			Labels:      map[string]string{core.LabelOSStable: "windows"},
+			Annotations: map[string]string{UsernameAnnotation: encrypted}}}
+	}
+	nodes := []*core.Node{
+		newNode("migrated", usernameKey),
+		newNode("current-key", current.Data[secrets.PrivateKeySecretKey]),
+		newNode("previous-key", previous.Data[secrets.PrivateKeySecretKey]),
+		newNode("unknown-key", other.Data[secrets.PrivateKeySecretKey]),
+		newNode("byoh-user", other.Data[secrets.PrivateKeySecretKey]),
+	}
+	// The username of a BYOH node is recovered from its WindowsInstance
+	byohNode := nodes[len(nodes)-1]
+	byohNode.Labels[BYOHLabel] = "true"
+	byohNode.Status.Addresses = []core.NodeAddress{{Type: core.NodeInternalIP, Address: "10.0.0.1"}}
+	windowsInstance := &wmcov1.WindowsInstance{ObjectMeta: meta.ObjectMeta{Name: "instance", Namespace: testNamespace},
+		Spec: wmcov1.WindowsInstanceSpec{Address: "10.0.0.1", Username: "byoh-user-user"}}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, wmcov1.AddToScheme(scheme))
+	builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(current, previous, usernameKeySecret,
+		windowsInstance)
+	for _, node := range nodes {
+		builder = builder.WithObjects(node)
+	}
+	c := builder.Build()
+	recorder := record.NewFakeRecorder(10)
+	r := instanceReconciler{client: c, watchNamespace: testNamespace, log: logr.Discard(), recorder: recorder}
+
+	// A node whose annotation cannot be migrated is reported through an event, without failing the migration
+	require.NoError(t, r.migrateUsernameAnnotations(context.TODO()))
+	require.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	assert.Contains(t, event, "UsernameMigrationFailed")
+	assert.Contains(t, event, "unknown-key")
+
+	for _, node := range nodes {
+		updated := &core.Node{}
+		require.NoError(t, c.Get(context.TODO(), kubeTypes.NamespacedName{Name: node.GetName()}, updated))
+		username, err := crypto.DecryptFromJSONString(updated.Annotations[UsernameAnnotation], usernameKey)
+		if node.GetName() == "unknown-key" {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err, node.GetName())
+		assert.Equal(t, node.GetName()+"-user", username)
+	}
+}
+
+func TestMigrateUsernameAnnotationsTransientFailure(t *testing.T) {
+	previous, _ := newTestKeySecret(t, secrets.PreviousPrivateKeySecret)
+	usernameKeySecret := &core.Secret{ObjectMeta: meta.ObjectMeta{Name: secrets.UsernameEncryptionKeySecret,
+		Namespace: testNamespace},
+		Data: map[string][]byte{secrets.UsernameEncryptionKeySecretKey: []byte("0123456789abcdef0123456789abcdef")}}
+	encrypted, err := crypto.EncryptToJSONString("user", previous.Data[secrets.PrivateKeySecretKey])
+	require.NoError(t, err)
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "node", Labels: map[string]string{core.LabelOSStable: "windows"},
+		Annotations: map[string]string{UsernameAnnotation: encrypted}}}
+
+	c := fake.NewClientBuilder().WithObjects(previous, usernameKeySecret, node).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(context.Context, client.WithWatch, client.Object, client.Patch, ...client.PatchOption) error {
+				return fmt.Errorf("patch failed")
+			},
+		}).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := instanceReconciler{client: c, watchNamespace: testNamespace, log: logr.Discard(), recorder: recorder}
+
+	// A failure which can succeed on retry is returned, so the previous key is kept, rather than reported as an event
+	assert.Error(t, r.migrateUsernameAnnotations(context.TODO()))
+	assert.Empty(t, recorder.Events)
+}
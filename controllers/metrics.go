@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+)
+
+var (
+	// instanceConfigurations counts the attempts of each controller to configure or upgrade each instance, by result
+	instanceConfigurations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wmco_instance_configurations_total",
+		Help: "Number of attempts to configure or upgrade Windows instances",
+	}, []string{"controller", "instance", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(instanceConfigurations)
+}
+
+// countInstanceConfiguration counts an attempt to configure or upgrade the given instance, which failed if the given
+// error is not nil
+func (r *instanceReconciler) countInstanceConfiguration(instanceInfo *instance.Info, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	instanceConfigurations.WithLabelValues(r.controllerName, instanceInfo.Address, result).Inc()
+}
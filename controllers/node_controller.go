@@ -18,7 +18,9 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	core "k8s.io/api/core/v1"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -35,6 +37,7 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/condition"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
 )
@@ -63,7 +66,9 @@ func NewNodeReconciler(mgr manager.Manager, clusterConfig cluster.Config, watchN
 			k8sclientset:       clientset,
 			clusterServiceCIDR: clusterConfig.Network().GetServiceCIDR(),
 			watchNamespace:     watchNamespace,
+			controllerName:     NodeController,
 			recorder:           mgr.GetEventRecorderFor(NodeController),
+			platform:           clusterConfig.Platform(),
 		},
 	}, nil
 }
@@ -93,9 +98,49 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (resul
 		return ctrl.Result{}, err
 	}
 
+	// Populating the payload cache does not disrupt workloads, so it is not deferred until a maintenance window. A
+	// failed re-push does not prevent a requested reboot from being handled, and is retried once it has been.
+	var repushErr error
+	if _, ok := node.GetAnnotations()[metadata.PayloadRepushAnnotation]; ok {
+		if err := r.repushPayload(ctx, node); err != nil {
+			repushErr = fmt.Errorf("payload re-push failed: %w", err)
+		} else {
+			r.recorder.Eventf(node, core.EventTypeNormal, "PayloadRepushed",
+				"Payload files transferred to the payload cache of node %s, so that modified files can be restored",
+				node.GetName())
+		}
+	}
+
 	if _, ok := node.GetAnnotations()[metadata.RebootAnnotation]; ok {
-		// Create a new signer using the private key that the instances will be reconciled with
-		signer, err := signer.Create(types.NamespacedName{Namespace: r.watchNamespace,
+		operatorConfig, err := operatorconfig.Get(ctx, r.client, r.watchNamespace)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if window := operatorConfig.MaintenanceWindow; window != nil && rebootRequiresMaintenanceWindow(node) {
+			now := time.Now()
+			if !window.Active(now) {
+				// The reboot is deferred until the next maintenance window, the reboot annotation remains on the node.
+				// The deferral is only reported when the node enters the deferred state, not on every requeue.
+				nextWindow := window.NextStart(now)
+				if !metadata.IsDisruptionPending(*node, "Reboot", nextWindow) {
+					if err := metadata.ApplyDisruptionPendingAnnotations(ctx, r.client, *node, "Reboot",
+						nextWindow); err != nil {
+						return ctrl.Result{}, err
+					}
+					r.recorder.Eventf(node, core.EventTypeNormal, "RebootDeferred",
+						"Reboot of node %s deferred until the maintenance window starting at %s", node.GetName(),
+						nextWindow.UTC().Format(time.RFC3339))
+				}
+				return ctrl.Result{RequeueAfter: time.Until(nextWindow) + time.Second}, repushErr
+			}
+		}
+		if err := metadata.RemoveDisruptionPendingAnnotations(ctx, r.client, *node); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		// Create a new signer using the private key that the instances will be reconciled with. The signer is local to
+		// this reconcile, as the reconciler is shared by concurrent reconciles.
+		defaultSigner, err := signer.Create(types.NamespacedName{Namespace: r.watchNamespace,
 			Name: secrets.PrivateKeySecret}, r.client)
 		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("unable to create signer from private key secret: %w", err)
@@ -104,17 +149,50 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (resul
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+		instanceSigner, err := signer.ForInstance(instanceInfo, defaultSigner, r.watchNamespace, r.client)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 		nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-			instanceInfo, signer, nil, nil, r.platform)
+			instanceInfo, instanceSigner, nil, nil, r.platform)
 		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("failed to create new nodeconfig: %w", err)
 		}
 
 		if err := nc.SafeReboot(ctx); err != nil {
-			return ctrl.Result{}, fmt.Errorf("full instance reboot failed: %w", err)
+			var drainErr *nodeconfig.DrainError
+			if errors.As(err, &drainErr) {
+				// The reboot is retried on the requeue, as the reboot annotation remains on the node
+				r.recorder.Eventf(node, core.EventTypeWarning, "RebootSkipped",
+					"Reboot of node %s skipped as it could not be drained: %v", node.GetName(), drainErr.Err)
+			}
+			return ctrl.Result{}, errors.Join(fmt.Errorf("full instance reboot failed: %w", err), repushErr)
 		}
 	}
-	return ctrl.Result{}, nil
+	return ctrl.Result{}, repushErr
+}
+
+// repushPayload transfers the payload files missing from the payload cache of the instance of the given node
+func (r *nodeReconciler) repushPayload(ctx context.Context, node *core.Node) error {
+	defaultSigner, err := signer.Create(types.NamespacedName{Namespace: r.watchNamespace,
+		Name: secrets.PrivateKeySecret}, r.client)
+	if err != nil {
+		return fmt.Errorf("unable to create signer from private key secret: %w", err)
+	}
+	instanceInfo, err := r.instanceFromNode(node)
+	if err != nil {
+		return err
+	}
+	instanceSigner, err := signer.ForInstance(instanceInfo, defaultSigner, r.watchNamespace, r.client)
+	if err != nil {
+		return err
+	}
+	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
+		instanceInfo, instanceSigner, nil, nil, r.platform)
+	if err != nil {
+		return fmt.Errorf("failed to create new nodeconfig: %w", err)
+	}
+	return nc.RepushPayload(ctx)
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -138,6 +216,18 @@ func (r *nodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Complete(r)
 }
 
+// rebootRequiresMaintenanceWindow returns true if rebooting the given node would disrupt workloads outside of an
+// operation which has already been admitted. Reboots are not deferred for nodes which are being configured or
+// upgraded, or which have already been cordoned, as such nodes are not running workloads and the operation waits for
+// the reboot to complete.
+func rebootRequiresMaintenanceWindow(node *core.Node) bool {
+	if node.GetLabels()[metadata.UpgradingLabel] == "true" || node.Spec.Unschedulable {
+		return false
+	}
+	version, present := node.GetAnnotations()[metadata.VersionAnnotation]
+	return present && version == node.GetAnnotations()[metadata.DesiredVersionAnnotation]
+}
+
 // isWindowsNode returns true if the given object is a Windows node
 func isWindowsNode(obj runtime.Object) bool {
 	node, ok := obj.(*core.Node)
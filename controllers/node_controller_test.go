@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+)
+
+func TestRebootRequiresMaintenanceWindow(t *testing.T) {
+	configured := map[string]string{metadata.VersionAnnotation: "1.0", metadata.DesiredVersionAnnotation: "1.0"}
+	testCases := []struct {
+		name        string
+		node        core.Node
+		expectedOut bool
+	}{
+		{
+			name:        "configured node",
+			node:        core.Node{ObjectMeta: meta.ObjectMeta{Annotations: configured}},
+			expectedOut: true,
+		},
+		{
+			name: "node being configured",
+			node: core.Node{ObjectMeta: meta.ObjectMeta{
+				Annotations: map[string]string{metadata.DesiredVersionAnnotation: "1.0"}}},
+			expectedOut: false,
+		},
+		{
+			name: "node being upgraded",
+			node: core.Node{ObjectMeta: meta.ObjectMeta{Annotations: configured,
+				Labels: map[string]string{metadata.UpgradingLabel: "true"}}},
+			expectedOut: false,
+		},
+		{
+			name: "cordoned node",
+			node: core.Node{ObjectMeta: meta.ObjectMeta{Annotations: configured},
+				Spec: core.NodeSpec{Unschedulable: true}},
+			expectedOut: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expectedOut, rebootRequiresMaintenanceWindow(&test.node))
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	mapi "github.com/openshift/api/machine/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+)
+
+const (
+	// ConfigurationProgressAnnotation is a Machine annotation containing the JSON encoded configuration progress of the
+	// instance backing the Machine
+	ConfigurationProgressAnnotation = "windowsmachineconfig.openshift.io/configuration-progress"
+)
+
+// startConfigurationAttempt records the start of a new attempt to configure an instance. The attempt counter is reset
+// if the previous attempt was successful.
+func startConfigurationAttempt(progress *wmcov1.ConfigurationProgress) {
+	now := meta.Now()
+	if progress.Step == string(nodeconfig.StepConfigured) {
+		progress.Attempts = 0
+	}
+	progress.Attempts++
+	progress.Step = ""
+	progress.LastAttemptTime = &now
+	progress.LastStepTime = nil
+}
+
+// recordConfigurationStep records that the current configuration attempt has reached the given step
+func recordConfigurationStep(progress *wmcov1.ConfigurationProgress, step nodeconfig.Step) {
+	now := meta.Now()
+	progress.Step = string(step)
+	progress.LastStepTime = &now
+}
+
+// recordConfigurationResult records the outcome of the current configuration attempt. A nil setupErr indicates the
+// instance is configured.
+func recordConfigurationResult(progress *wmcov1.ConfigurationProgress, setupErr error) {
+	if setupErr != nil {
+		progress.LastError = setupErr.Error()
+		return
+	}
+	progress.Step = string(nodeconfig.StepConfigured)
+	progress.LastError = ""
+	// Only the first success following an attempt is recorded, so that instances found to be up to date are not
+	// reported as having been configured again
+	if progress.LastConfiguredTime == nil ||
+		(progress.LastAttemptTime != nil && progress.LastConfiguredTime.Before(progress.LastAttemptTime)) {
+		now := meta.Now()
+		progress.LastConfiguredTime = &now
+	}
+}
+
+// getMachineConfigurationProgress returns the configuration progress recorded on the given Machine. An empty
+// progress is returned if none has been recorded, or if the recorded value cannot be read.
+func getMachineConfigurationProgress(machine *mapi.Machine) wmcov1.ConfigurationProgress {
+	progress := wmcov1.ConfigurationProgress{}
+	value, present := machine.GetAnnotations()[ConfigurationProgressAnnotation]
+	if !present {
+		return progress
+	}
+	if err := json.Unmarshal([]byte(value), &progress); err != nil {
+		return wmcov1.ConfigurationProgress{}
+	}
+	return progress
+}
+
+// setMachineConfigurationProgress records the given configuration progress on the given Machine
+func setMachineConfigurationProgress(ctx context.Context, c client.Client, machine *mapi.Machine,
+	progress wmcov1.ConfigurationProgress) error {
+	value, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("error encoding configuration progress: %w", err)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{ConfigurationProgressAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating patch: %w", err)
+	}
+	if err := c.Patch(ctx, machine, client.RawPatch(kubeTypes.MergePatchType, patch)); err != nil {
+		return fmt.Errorf("error updating configuration progress of Machine %s: %w", machine.GetName(), err)
+	}
+	return nil
+}
+
+// isProgressOnlyUpdate returns true if the given Machines differ only by their configuration progress, such as when
+// WMCO records the progress of a configuration it is performing. Reconciling such updates would restart the
+// configuration each time its progress is recorded.
+func isProgressOnlyUpdate(oldMachine, newMachine client.Object) bool {
+	withoutProgress := func(obj client.Object) client.Object {
+		stripped := obj.DeepCopyObject().(client.Object)
+		annotations := stripped.GetAnnotations()
+		delete(annotations, ConfigurationProgressAnnotation)
+		if len(annotations) == 0 {
+			annotations = nil
+		}
+		stripped.SetAnnotations(annotations)
+		stripped.SetResourceVersion("")
+		stripped.SetManagedFields(nil)
+		return stripped
+	}
+	return equality.Semantic.DeepEqual(withoutProgress(oldMachine), withoutProgress(newMachine))
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	mapi "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+)
+
+func TestConfigurationProgress(t *testing.T) {
+	progress := wmcov1.ConfigurationProgress{}
+
+	// A failed attempt records the step it reached and the error
+	startConfigurationAttempt(&progress)
+	recordConfigurationStep(&progress, nodeconfig.StepBootstrapFiles)
+	recordConfigurationStep(&progress, nodeconfig.StepTLSCerts)
+	recordConfigurationResult(&progress, fmt.Errorf("connection reset"))
+	assert.Equal(t, int32(1), progress.Attempts)
+	assert.Equal(t, string(nodeconfig.StepTLSCerts), progress.Step)
+	assert.Equal(t, "connection reset", progress.LastError)
+	require.NotNil(t, progress.LastAttemptTime)
+	require.NotNil(t, progress.LastStepTime)
+	assert.Nil(t, progress.LastConfiguredTime)
+
+	// A following successful attempt is counted and clears the error
+	startConfigurationAttempt(&progress)
+	assert.Empty(t, progress.Step)
+	assert.Nil(t, progress.LastStepTime)
+	recordConfigurationStep(&progress, nodeconfig.StepConfigured)
+	recordConfigurationResult(&progress, nil)
+	assert.Equal(t, int32(2), progress.Attempts)
+	assert.Equal(t, string(nodeconfig.StepConfigured), progress.Step)
+	assert.Empty(t, progress.LastError)
+	require.NotNil(t, progress.LastConfiguredTime)
+
+	// Recording success without a new attempt does not change when the instance was configured
+	configuredTime := *progress.LastConfiguredTime
+	recordConfigurationResult(&progress, nil)
+	assert.Equal(t, configuredTime, *progress.LastConfiguredTime)
+
+	// The attempt counter resets after a successful attempt
+	startConfigurationAttempt(&progress)
+	assert.Equal(t, int32(1), progress.Attempts)
+}
+
+func TestGetMachineConfigurationProgress(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    wmcov1.ConfigurationProgress
+	}{
+		{
+			name:        "no annotation",
+			annotations: nil,
+			expected:    wmcov1.ConfigurationProgress{},
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{ConfigurationProgressAnnotation: "{"},
+			expected:    wmcov1.ConfigurationProgress{},
+		},
+		{
+			name: "valid annotation",
+			annotations: map[string]string{
+				ConfigurationProgressAnnotation: `{"step":"TLSCerts","attempts":3,"lastError":"timeout"}`,
+			},
+			expected: wmcov1.ConfigurationProgress{Step: "TLSCerts", Attempts: 3, LastError: "timeout"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			machine := &mapi.Machine{ObjectMeta: meta.ObjectMeta{Annotations: test.annotations}}
+			assert.Equal(t, test.expected, getMachineConfigurationProgress(machine))
+		})
+	}
+}
+
+func TestIsProgressOnlyUpdate(t *testing.T) {
+	phase := "Provisioned"
+	oldMachine := &mapi.Machine{ObjectMeta: meta.ObjectMeta{Name: "machine", ResourceVersion: "1",
+		Labels: map[string]string{MachineOSLabel: "Windows"}}}
+
+	progressRecorded := oldMachine.DeepCopy()
+	progressRecorded.ResourceVersion = "2"
+	progressRecorded.Annotations = map[string]string{ConfigurationProgressAnnotation: `{"attempts":1}`}
+	assert.True(t, isProgressOnlyUpdate(oldMachine, progressRecorded))
+
+	progressUpdated := progressRecorded.DeepCopy()
+	progressUpdated.ResourceVersion = "3"
+	progressUpdated.Annotations[ConfigurationProgressAnnotation] = `{"attempts":2}`
+	assert.True(t, isProgressOnlyUpdate(progressRecorded, progressUpdated))
+
+	annotated := progressUpdated.DeepCopy()
+	annotated.Annotations["other"] = "value"
+	assert.False(t, isProgressOnlyUpdate(progressUpdated, annotated))
+
+	provisioned := progressUpdated.DeepCopy()
+	provisioned.Status.Phase = &phase
+	assert.False(t, isProgressOnlyUpdate(progressUpdated, provisioned))
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mapi "github.com/openshift/api/machine/v1beta1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
+)
+
+const (
+	// RemediationAnnotation is a Machine annotation containing the JSON encoded remediation status of the node backed
+	// by the Machine, present while the node is being remediated
+	RemediationAnnotation = "windowsmachineconfig.openshift.io/remediation"
+)
+
+// remediationStatus records the remediation of a node which is not ready
+type remediationStatus struct {
+	// Step is the last remediation step taken
+	Step operatorconfig.RemediationStep `json:"step"`
+	// Time is when the last step was taken
+	Time meta.Time `json:"time"`
+	// Exhausted is true once every step has been taken without bringing the node back
+	Exhausted bool `json:"exhausted,omitempty"`
+}
+
+// nextRemediationStep returns the remediation step to take now for a node which has not been ready since the given
+// time, given the status of its remediation, if any. If no step should be taken now, an empty step is returned along
+// with the time to wait until the next step is due, which is zero if every step has already been taken.
+func nextRemediationStep(policy operatorconfig.RemediationPolicy, notReadySince time.Time, status *remediationStatus,
+	now time.Time) (operatorconfig.RemediationStep, time.Duration) {
+	if len(policy.Steps) == 0 {
+		return "", 0
+	}
+	next := 0
+	dueAt := notReadySince.Add(policy.Timeout)
+	if status != nil {
+		if status.Exhausted {
+			return "", 0
+		}
+		// A step which is no longer part of the policy restarts the remediation from the first step
+		for i, step := range policy.Steps {
+			if step == status.Step {
+				next = i + 1
+				break
+			}
+		}
+		dueAt = status.Time.Add(policy.Timeout)
+	}
+	if wait := dueAt.Sub(now); wait > 0 {
+		return "", wait
+	}
+	if next >= len(policy.Steps) {
+		return "", 0
+	}
+	return policy.Steps[next], 0
+}
+
+// notReadySince returns the time the given node stopped being ready
+func notReadySince(node *core.Node) time.Time {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == core.NodeReady {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return node.GetCreationTimestamp().Time
+}
+
+// remediateMachine takes the next step of the remediation policy for the given node backed by the given Machine, if
+// the node is not ready. Steps are taken one at a time, each being given the policy's timeout to bring the node back,
+// and the remediation stops as soon as the node is ready.
+func (r *WindowsMachineReconciler) remediateMachine(ctx context.Context, machine *mapi.Machine,
+	node *core.Node) (ctrl.Result, error) {
+	status, err := getMachineRemediationStatus(machine)
+	if err != nil {
+		r.log.Info("ignoring invalid remediation status", "machine", machine.GetName(), "error", err)
+	}
+	if nodeutil.IsReady(node) {
+		if status == nil {
+			return ctrl.Result{}, nil
+		}
+		r.recorder.Eventf(machine, core.EventTypeNormal, "RemediationSucceeded",
+			"Node %s is ready following remediation step %s", node.GetName(), status.Step)
+		return ctrl.Result{}, setMachineRemediationStatus(ctx, r.client, machine, nil)
+	}
+	// Nodes which are being upgraded or rebooted by WMCO are expected to be not ready for a while
+	if !machine.GetDeletionTimestamp().IsZero() || node.GetLabels()[metadata.UpgradingLabel] == "true" {
+		return ctrl.Result{}, nil
+	}
+	if _, present := node.GetAnnotations()[metadata.RebootAnnotation]; present {
+		return ctrl.Result{}, nil
+	}
+
+	operatorConfig, err := operatorconfig.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	policy := operatorConfig.Remediation
+	step, wait := nextRemediationStep(policy, notReadySince(node), status, time.Now())
+	if step == "" {
+		if wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+		if status != nil && !status.Exhausted {
+			r.recorder.Eventf(machine, core.EventTypeWarning, "RemediationFailed",
+				"Node %s is still not ready after all remediation steps were taken", node.GetName())
+			status.Exhausted = true
+			return ctrl.Result{}, setMachineRemediationStatus(ctx, r.client, machine, status)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	r.log.Info("remediating node", "node", node.GetName(), "step", step)
+	if step == operatorconfig.RemediationDeleteMachine {
+		deleted, err := r.deleteMachineWithinBudget(ctx, machine)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: !deleted}, nil
+	}
+	// The step is recorded before it is taken, so that a step which fails is not retried before moving on to the next
+	if err := setMachineRemediationStatus(ctx, r.client, machine,
+		&remediationStatus{Step: step, Time: meta.Now()}); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.recorder.Eventf(machine, core.EventTypeNormal, "Remediating",
+		"Node %s has not been ready since %s, taking remediation step %s", node.GetName(),
+		notReadySince(node).UTC().Format(time.RFC3339), step)
+	if err := r.takeRemediationStep(ctx, node, step); err != nil {
+		r.recorder.Eventf(machine, core.EventTypeWarning, "RemediationStepFailed",
+			"Remediation step %s failed for node %s: %v", step, node.GetName(), err)
+	}
+	return ctrl.Result{RequeueAfter: policy.Timeout}, nil
+}
+
+// takeRemediationStep restarts the services of the instance of the given node, or reboots it, as described by the
+// given step
+func (r *WindowsMachineReconciler) takeRemediationStep(ctx context.Context, node *core.Node,
+	step operatorconfig.RemediationStep) error {
+	instanceInfo, err := r.instanceFromNode(node)
+	if err != nil {
+		return err
+	}
+	instanceSigner, err := r.instanceSigner(instanceInfo)
+	if err != nil {
+		return err
+	}
+	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
+		instanceInfo, instanceSigner, nil, nil, r.platform)
+	if err != nil {
+		return fmt.Errorf("failed to create new nodeconfig: %w", err)
+	}
+	switch step {
+	case operatorconfig.RemediationRestartServices:
+		return nc.RestartServices()
+	case operatorconfig.RemediationReboot:
+		return nc.SafeReboot(ctx)
+	default:
+		return fmt.Errorf("unknown remediation step %s", step)
+	}
+}
+
+// getMachineRemediationStatus returns the remediation status recorded on the given Machine, or nil if none has been
+// recorded
+func getMachineRemediationStatus(machine *mapi.Machine) (*remediationStatus, error) {
+	value, present := machine.GetAnnotations()[RemediationAnnotation]
+	if !present {
+		return nil, nil
+	}
+	status := &remediationStatus{}
+	if err := json.Unmarshal([]byte(value), status); err != nil {
+		return nil, fmt.Errorf("error decoding %s annotation: %w", RemediationAnnotation, err)
+	}
+	return status, nil
+}
+
+// setMachineRemediationStatus records the given remediation status on the given Machine, removing the recorded
+// status if nil
+func setMachineRemediationStatus(ctx context.Context, c client.Client, machine *mapi.Machine,
+	status *remediationStatus) error {
+	var value *string
+	if status != nil {
+		encoded, err := json.Marshal(status)
+		if err != nil {
+			return fmt.Errorf("error encoding remediation status: %w", err)
+		}
+		value = new(string)
+		*value = string(encoded)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{RemediationAnnotation: value},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating patch: %w", err)
+	}
+	if err := c.Patch(ctx, machine, client.RawPatch(kubeTypes.MergePatchType, patch)); err != nil {
+		return fmt.Errorf("error updating remediation status of Machine %s: %w", machine.GetName(), err)
+	}
+	return nil
+}
+
+// nodeReadinessChangedPredicate filters for updates of Machine-backed Windows nodes which become ready or not ready
+func nodeReadinessChangedPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, oldOK := e.ObjectOld.(*core.Node)
+			newNode, newOK := e.ObjectNew.(*core.Node)
+			return oldOK && newOK && isValidWindowsNode(newNode, false) &&
+				nodeutil.IsReady(oldNode) != nodeutil.IsReady(newNode)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
+)
+
+func TestNextRemediationStep(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	policy := operatorconfig.RemediationPolicy{Steps: []operatorconfig.RemediationStep{
+		operatorconfig.RemediationRestartServices, operatorconfig.RemediationReboot,
+		operatorconfig.RemediationDeleteMachine}, Timeout: 10 * time.Minute}
+
+	testCases := []struct {
+		name          string
+		policy        operatorconfig.RemediationPolicy
+		notReadySince time.Time
+		status        *remediationStatus
+		expectedStep  operatorconfig.RemediationStep
+		expectedWait  time.Duration
+	}{
+		{
+			name:          "remediation disabled",
+			policy:        operatorconfig.RemediationPolicy{Timeout: 10 * time.Minute},
+			notReadySince: now.Add(-time.Hour),
+		},
+		{
+			name:          "node not ready for less than the timeout",
+			policy:        policy,
+			notReadySince: now.Add(-4 * time.Minute),
+			expectedWait:  6 * time.Minute,
+		},
+		{
+			name:          "first step",
+			policy:        policy,
+			notReadySince: now.Add(-10 * time.Minute),
+			expectedStep:  operatorconfig.RemediationRestartServices,
+		},
+		{
+			name:          "previous step still within its timeout",
+			policy:        policy,
+			notReadySince: now.Add(-time.Hour),
+			status: &remediationStatus{Step: operatorconfig.RemediationRestartServices,
+				Time: meta.NewTime(now.Add(-time.Minute))},
+			expectedWait: 9 * time.Minute,
+		},
+		{
+			name:          "next step",
+			policy:        policy,
+			notReadySince: now.Add(-time.Hour),
+			status: &remediationStatus{Step: operatorconfig.RemediationRestartServices,
+				Time: meta.NewTime(now.Add(-11 * time.Minute))},
+			expectedStep: operatorconfig.RemediationReboot,
+		},
+		{
+			name:          "all steps taken",
+			policy:        policy,
+			notReadySince: now.Add(-time.Hour),
+			status: &remediationStatus{Step: operatorconfig.RemediationDeleteMachine,
+				Time: meta.NewTime(now.Add(-11 * time.Minute))},
+		},
+		{
+			name:          "step removed from policy",
+			policy:        policy,
+			notReadySince: now.Add(-time.Hour),
+			status: &remediationStatus{Step: "Removed",
+				Time: meta.NewTime(now.Add(-11 * time.Minute))},
+			expectedStep: operatorconfig.RemediationRestartServices,
+		},
+		{
+			name:          "remediation exhausted",
+			policy:        policy,
+			notReadySince: now.Add(-time.Hour),
+			status: &remediationStatus{Step: operatorconfig.RemediationReboot,
+				Time: meta.NewTime(now.Add(-11 * time.Minute)), Exhausted: true},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			step, wait := nextRemediationStep(test.policy, test.notReadySince, test.status, now)
+			assert.Equal(t, test.expectedStep, step)
+			assert.Equal(t, test.expectedWait, wait)
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -21,11 +22,12 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/condition"
-	"github.com/openshift/windows-machine-config-operator/pkg/crypto"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
@@ -33,16 +35,17 @@ import (
 )
 
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;patch
-//+kubebuilder:rbac:groups="",resources=secrets,verbs=create;get;list;watch;update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=create;get;list;watch;update;delete
 
 const (
 	// SecretController is the name of this controller in logs and other outputs.
 	SecretController = "secret"
 )
 
-// NewSecretReconciler returns a pointer to a SecretReconciler
-func NewSecretReconciler(mgr manager.Manager, clusterConfig cluster.Config,
-	watchNamespace string) (*SecretReconciler, error) {
+// NewSecretReconciler returns a pointer to a SecretReconciler. The given KeyRotationLocks must be shared with all other
+// reconcilers which rotate keys.
+func NewSecretReconciler(mgr manager.Manager, clusterConfig cluster.Config, watchNamespace string,
+	keyRotationLocks *KeyRotationLocks) (*SecretReconciler, error) {
 	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
 	if err != nil {
 		return nil, fmt.Errorf("error creating kubernetes clientset: %w", err)
@@ -56,8 +59,10 @@ func NewSecretReconciler(mgr manager.Manager, clusterConfig cluster.Config,
 			clusterServiceCIDR: clusterConfig.Network().GetServiceCIDR(),
 			log:                ctrl.Log.WithName("controllers").WithName(SecretController),
 			watchNamespace:     watchNamespace,
+			controllerName:     SecretController,
 			recorder:           mgr.GetEventRecorderFor(SecretController),
 			platform:           clusterConfig.Platform(),
+			keyRotationLocks:   keyRotationLocks,
 		},
 	}
 	return reconciler, nil
@@ -66,7 +71,7 @@ func NewSecretReconciler(mgr manager.Manager, clusterConfig cluster.Config,
 // SetupWithManager sets up a new Secret controller
 func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	// Check that the private key exists, if it doesn't, log a warning
-	_, err := secrets.GetPrivateKey(kubeTypes.NamespacedName{Namespace: r.watchNamespace,
+	_, err := signer.Create(kubeTypes.NamespacedName{Namespace: r.watchNamespace,
 		Name: secrets.PrivateKeySecret}, mgr.GetClient())
 	if err != nil {
 		r.log.Error(err, "Unable to retrieve private key, please ensure it is created")
@@ -82,8 +87,11 @@ func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		UpdateFunc: func(e event.UpdateEvent) bool {
 			// get update event only when secret data is changed
 			if isPrivateKeySecret(e.ObjectNew, r.watchNamespace) {
-				if string(e.ObjectOld.(*core.Secret).Data[secrets.PrivateKeySecretKey]) !=
-					string(e.ObjectNew.(*core.Secret).Data[secrets.PrivateKeySecretKey]) {
+				oldSecret, newSecret := e.ObjectOld.(*core.Secret), e.ObjectNew.(*core.Secret)
+				if string(oldSecret.Data[secrets.PrivateKeySecretKey]) !=
+					string(newSecret.Data[secrets.PrivateKeySecretKey]) ||
+					string(oldSecret.Data[secrets.AgentPublicKeySecretKey]) !=
+						string(newSecret.Data[secrets.AgentPublicKeySecretKey]) {
 					return true
 				}
 			} else if isTlsSecret(e.ObjectNew, r.watchNamespace) {
@@ -188,6 +196,10 @@ func (r *SecretReconciler) reconcileUserDataSecret(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("error generating %s secret: %w", secrets.UserDataSecret, err)
 	}
+	operatorConfig, err := operatorconfig.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		return err
+	}
 	userData := &core.Secret{}
 	// Fetch UserData instance
 	err = r.client.Get(ctx,
@@ -199,18 +211,59 @@ func (r *SecretReconciler) reconcileUserDataSecret(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
-		// Secret created successfully - don't requeue
-		return nil
 	} else if err != nil {
 		r.log.Error(err, "error retrieving the secret", "name", secrets.UserDataSecret)
 		return err
-	} else if string(userData.Data["userData"][:]) == string(validUserData.Data["userData"][:]) {
-		// valid userData secret already exists
-		return nil
-	} else {
+	} else if string(userData.Data["userData"][:]) != string(validUserData.Data["userData"][:]) {
 		// userdata secret data does not match what is expected
-		return r.updateUserData(ctx, keySigner, validUserData)
+		if err := r.updateUserData(ctx, keySigner, validUserData, operatorConfig.SSHKeyRotation); err != nil {
+			return err
+		}
 	}
+
+	if operatorConfig.SSHKeyRotation == operatorconfig.KeyRotationInPlace {
+		if err := r.rotateKeysInPlace(ctx, keySigner); err != nil {
+			return err
+		}
+	}
+	// Username annotations encrypted with the previous private key are migrated before the key is replaced. Annotations
+	// which cannot be decrypted are reported through events, and do not hold back the rotation, while any other failure
+	// keeps the previous key until the migration is retried.
+	if err := r.migrateUsernameAnnotations(ctx); err != nil {
+		return err
+	}
+	if operatorConfig.SSHKeyRotation != operatorconfig.KeyRotationInPlace {
+		// The previous key is only used to rotate keys in place
+		return r.removePreviousPrivateKey(ctx)
+	}
+	// Once all nodes use the current private key, it is kept as the previous key for the next rotation
+	return r.ensurePreviousPrivateKey(ctx)
+}
+
+// rotateKeysInPlace moves the instances of all Windows nodes accessed with the operator's private key to the key of the
+// given signer, without recreating them
+func (r *SecretReconciler) rotateKeysInPlace(ctx context.Context, keySigner ssh.Signer) error {
+	nodes := &core.NodeList{}
+	if err := r.client.List(ctx, nodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		return fmt.Errorf("error getting node list: %w", err)
+	}
+	r.keyRotationLocks.prune(nodes.Items)
+	var errs []error
+	for _, node := range nodes.Items {
+		// Nodes without a public key hash annotation are not yet configured, and will be configured with the new key
+		if !usesOperatorKey(&node) || node.Annotations[nodeconfig.PubKeyHashAnnotation] == "" {
+			continue
+		}
+		if err := r.rotateInstanceKey(ctx, &node, keySigner); err != nil {
+			r.recorder.Eventf(&node, core.EventTypeWarning, "KeyRotationFailed",
+				"Unable to rotate the private key of node %s in place: %v", node.GetName(), err)
+			errs = append(errs, fmt.Errorf("node %s: %w", node.GetName(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to rotate private key in place: %w", kerrors.NewAggregate(errs))
+	}
+	return nil
 }
 
 func (r *SecretReconciler) reconcileTLSSecret(ctx context.Context) error {
@@ -242,8 +295,12 @@ func (r *SecretReconciler) reconcileTLSSecret(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("unable to create instance object from node: %w", err)
 		}
+		instanceSigner, err := r.instanceSigner(winInstance)
+		if err != nil {
+			return err
+		}
 		nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-			winInstance, r.signer, nil, nil, r.platform)
+			winInstance, instanceSigner, nil, nil, r.platform)
 		if err != nil {
 			return fmt.Errorf("failed to create new nodeconfig: %w", err)
 		}
@@ -254,8 +311,27 @@ func (r *SecretReconciler) reconcileTLSSecret(ctx context.Context) error {
 	return nil
 }
 
-// updateUserData updates the userdata secret to the expected state
-func (r *SecretReconciler) updateUserData(ctx context.Context, keySigner ssh.Signer, expected *core.Secret) error {
+// updateUserData updates the userdata secret to the expected state. Unless the private key is rotated in place, the
+// nodes configured with the previous private key are first updated so that they are moved to the new key.
+func (r *SecretReconciler) updateUserData(ctx context.Context, keySigner ssh.Signer, expected *core.Secret,
+	keyRotation operatorconfig.KeyRotationPolicy) error {
+	if keyRotation != operatorconfig.KeyRotationInPlace {
+		if err := r.updateNodesForNewKey(ctx, keySigner); err != nil {
+			return err
+		}
+	}
+
+	// Set userdata to expected value
+	r.log.Info("updating secret", "name", secrets.UserDataSecret)
+	if err := r.client.Update(ctx, expected); err != nil {
+		return fmt.Errorf("error updating secret: %w", err)
+	}
+	return nil
+}
+
+// updateNodesForNewKey updates the annotations of the nodes configured with the previous private key, so that BYOH
+// nodes are accessed with the new key, and the Machines of other nodes are recreated with the new key
+func (r *SecretReconciler) updateNodesForNewKey(ctx context.Context, keySigner ssh.Signer) error {
 	nodes := &core.NodeList{}
 	err := r.client.List(ctx, nodes, client.MatchingLabels{core.LabelOSStable: "windows"})
 	if err != nil {
@@ -264,21 +340,19 @@ func (r *SecretReconciler) updateUserData(ctx context.Context, keySigner ssh.Sig
 
 	// Modify annotations on nodes configured with the previous private key, if it has changed
 	expectedPubKeyAnno := nodeconfig.CreatePubKeyHashAnnotation(keySigner.PublicKey())
-	privateKeyBytes, err := secrets.GetPrivateKey(kubeTypes.NamespacedName{Namespace: r.watchNamespace,
-		Name: secrets.PrivateKeySecret}, r.client)
-	if err != nil {
-		return err
-	}
 	for _, node := range nodes.Items {
 		annotationsToApply := make(map[string]string)
 		if _, present := node.GetLabels()[BYOHLabel]; present {
-			// Since the public key hash and username annotations are both dependent on the private key secret as well
-			// as applied and updated at the same time, checking one is enough to see if the private key is up to date
+			// Nodes accessed with their own private key are not affected by a change to the operator's private key
+			if node.Annotations[PrivateKeySecretAnnotation] != "" {
+				continue
+			}
 			if node.Annotations[nodeconfig.PubKeyHashAnnotation] == expectedPubKeyAnno {
 				continue
 			}
-			// For BYOH nodes, update the username annotation and public key hash annotation using new private key
-			expectedUsernameAnnotation, err := r.getEncryptedUsername(ctx, node, privateKeyBytes)
+			// For BYOH nodes, update the public key hash annotation, and the username annotation in case it was
+			// applied by a previous version of WMCO which encrypted it with the private key
+			expectedUsernameAnnotation, err := r.getEncryptedUsername(ctx, node)
 			if err != nil {
 				return fmt.Errorf("unable to retrieve expected username annotation: %w", err)
 			}
@@ -299,29 +373,22 @@ func (r *SecretReconciler) updateUserData(ctx context.Context, keySigner ssh.Sig
 		}
 		r.log.V(1).Info("patched node object", "node", node.GetName(), "patch", annotationsToApply)
 	}
-
-	// Set userdata to expected value
-	r.log.Info("updating secret", "name", secrets.UserDataSecret)
-	err = r.client.Update(ctx, expected)
-	if err != nil {
-		return fmt.Errorf("error updating secret: %w", err)
-	}
 	return nil
 }
 
-// getEncryptedUsername retrieves the username associated with a given node and ecrypts it using the given key
-func (r *SecretReconciler) getEncryptedUsername(ctx context.Context, node core.Node, key []byte) (string, error) {
-	// The instance ConfigMap is the source of truth linking BYOH nodes to their underlying instances
-	instancesConfigMap := &core.ConfigMap{}
-	if err := r.client.Get(ctx, kubeTypes.NamespacedName{Namespace: r.watchNamespace,
-		Name: wiparser.InstanceConfigMap}, instancesConfigMap); err != nil {
-		return "", fmt.Errorf("unable to get instance configmap: %w", err)
+// getEncryptedUsername retrieves the username associated with a given node and encrypts it using the username
+// encryption key
+func (r *SecretReconciler) getEncryptedUsername(ctx context.Context, node core.Node) (string, error) {
+	// WindowsInstances are the source of truth linking BYOH nodes to their underlying instances
+	windowsInstances := &wmcov1.WindowsInstanceList{}
+	if err := r.client.List(ctx, windowsInstances, client.InNamespace(r.watchNamespace)); err != nil {
+		return "", fmt.Errorf("unable to list WindowsInstances: %w", err)
 	}
-	instanceUsername, err := wiparser.GetNodeUsername(instancesConfigMap.Data, &node)
+	username, err := wiparser.GetNodeUsername(windowsInstances.Items, &node)
 	if err != nil {
 		return "", err
 	}
-	encryptedUsername, err := crypto.EncryptToJSONString(instanceUsername, key)
+	encryptedUsername, err := r.encryptUsername(username)
 	if err != nil {
 		return "", fmt.Errorf("error encrypting node %s username: %w", node.GetName(), err)
 	}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/maintenance"
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
+)
+
+// upgradePool is a group of Windows nodes whose upgrades are limited by a shared upgrade policy. Each MachineSet
+// forms a pool of the Windows nodes it backs, while all BYOH nodes form a single pool.
+type upgradePool struct {
+	// name identifies the pool in logs and events
+	name string
+	// policy is the upgrade policy applied to the nodes in the pool
+	policy operatorconfig.UpgradePolicy
+	// isMember returns true if the given node is part of the pool
+	isMember func(*core.Node) bool
+	// window restricts when nodes in the pool can start upgrading. Nil allows upgrades at any time.
+	window *maintenance.Window
+}
+
+// upgradeDeferredError indicates that an instance cannot be upgraded at this time due to the policy of its pool
+type upgradeDeferredError struct {
+	// paused is true if the upgrade was deferred because upgrades of the pool are paused
+	paused bool
+	// nextWindow is the start of the maintenance window the upgrade was deferred until, if it was deferred due to
+	// being outside of a maintenance window
+	nextWindow time.Time
+	reason     string
+}
+
+// Error returns the reason the upgrade was deferred
+func (e *upgradeDeferredError) Error() string {
+	return e.reason
+}
+
+// requeueAfter returns the time after which the upgrade should be retried, or zero if the upgrade was not deferred until
+// a maintenance window
+func (e *upgradeDeferredError) requeueAfter() time.Duration {
+	if e.nextWindow.IsZero() {
+		return 0
+	}
+	// Requeue slightly after the window starts, so that the window is active when the upgrade is retried
+	return time.Until(e.nextWindow) + time.Second
+}
+
+// state identifies the reason the upgrade was deferred, ignoring details which change while the upgrade remains deferred
+// for the same reason, such as the number of unavailable nodes
+func (e *upgradeDeferredError) state() string {
+	switch {
+	case e.paused:
+		return "paused"
+	case !e.nextWindow.IsZero():
+		return "maintenanceWindow/" + e.nextWindow.UTC().Format(time.RFC3339)
+	default:
+		return "maxUnavailable"
+	}
+}
+
+// upgradeDeferralChanged records that the upgrade of the given object was deferred with the given error, returning true
+// if the upgrade was not already deferred for the same reason
+func (r *instanceReconciler) upgradeDeferralChanged(object client.Object, deferredErr *upgradeDeferredError) bool {
+	previous, loaded := r.deferredUpgrades.Swap(client.ObjectKeyFromObject(object), deferredErr.state())
+	return !loaded || previous != deferredErr.state()
+}
+
+// clearUpgradeDeferral records that the upgrade of the object with the given key is no longer deferred, as it was
+// admitted or the object is gone
+func (r *instanceReconciler) clearUpgradeDeferral(key client.ObjectKey) {
+	r.deferredUpgrades.Delete(key)
+}
+
+// pruneUpgradeDeferrals clears the deferred upgrades of all objects aside from the objects with the given keys
+func (r *instanceReconciler) pruneUpgradeDeferrals(keep map[client.ObjectKey]struct{}) {
+	r.deferredUpgrades.Range(func(key, _ interface{}) bool {
+		if _, kept := keep[key.(client.ObjectKey)]; !kept {
+			r.deferredUpgrades.Delete(key)
+		}
+		return true
+	})
+}
+
+// newBYOHUpgradePool returns the pool containing all BYOH nodes
+func newBYOHUpgradePool(policy operatorconfig.UpgradePolicy, window *maintenance.Window) *upgradePool {
+	return &upgradePool{
+		name:   "BYOH",
+		policy: policy,
+		window: window,
+		isMember: func(node *core.Node) bool {
+			return node.GetLabels()[BYOHLabel] == "true"
+		},
+	}
+}
+
+// admitUpgrade marks the given node as upgrading, if the policy of the given pool allows it. An upgradeDeferredError
+// is returned if the node cannot be upgraded at this time. A node whose upgrade is deferred until the next maintenance
+// window is annotated as having a pending disruption.
+func (r *instanceReconciler) admitUpgrade(ctx context.Context, node *core.Node, pool *upgradePool) error {
+	if pool == nil {
+		return fmt.Errorf("no upgrade pool given for node %s", node.GetName())
+	}
+	controllerLocker.Lock()
+	defer controllerLocker.Unlock()
+	// Nodes are read directly from the API server, as the cache may not yet reflect nodes which were just marked as
+	// upgrading
+	nodes, err := r.k8sclientset.CoreV1().Nodes().List(ctx,
+		meta.ListOptions{LabelSelector: core.LabelOSStable + "=windows"})
+	if err != nil {
+		return fmt.Errorf("error listing Windows nodes: %w", err)
+	}
+	var currentNode *core.Node
+	for i := range nodes.Items {
+		if nodes.Items[i].GetName() == node.GetName() {
+			currentNode = &nodes.Items[i]
+			break
+		}
+	}
+	if currentNode == nil {
+		return fmt.Errorf("unable to find node %s", node.GetName())
+	}
+	if currentNode.GetLabels()[metadata.UpgradingLabel] == "true" {
+		// current node is upgrading, continue with it
+		return nil
+	}
+	if err := checkUpgradePolicy(currentNode, nodes.Items, pool, time.Now()); err != nil {
+		var deferredErr *upgradeDeferredError
+		if errors.As(err, &deferredErr) && !deferredErr.nextWindow.IsZero() {
+			if annotateErr := metadata.ApplyDisruptionPendingAnnotations(ctx, r.client, *currentNode, "Upgrade",
+				deferredErr.nextWindow); annotateErr != nil {
+				return annotateErr
+			}
+		}
+		return err
+	}
+	if err := metadata.RemoveDisruptionPendingAnnotations(ctx, r.client, *currentNode); err != nil {
+		return err
+	}
+	return metadata.ApplyUpgradingLabel(ctx, r.client, currentNode)
+}
+
+// checkUpgradePolicy returns an upgradeDeferredError if upgrading the given node, which is a member of the given pool,
+// would violate the policy of the pool, or if the given time is outside of the pool's maintenance window. The nodes
+// parameter should be a list of all Windows nodes.
+func checkUpgradePolicy(currentNode *core.Node, nodes []core.Node, pool *upgradePool, now time.Time) error {
+	if pool.policy.Paused {
+		return &upgradeDeferredError{paused: true,
+			reason: fmt.Sprintf("upgrades of the %s upgrade pool are paused", pool.name)}
+	}
+	if pool.window != nil && !pool.window.Active(now) {
+		nextWindow := pool.window.NextStart(now)
+		return &upgradeDeferredError{nextWindow: nextWindow,
+			reason: fmt.Sprintf("node %s is outside of the maintenance window %s, next window starts at %s",
+				currentNode.GetName(), pool.window, nextWindow.UTC().Format(time.RFC3339))}
+	}
+	// A node which is already unavailable does not reduce the capacity of the pool by being upgraded
+	if !nodeutil.IsReady(currentNode) {
+		return nil
+	}
+	poolSize := 0
+	unavailable := 0
+	for i := range nodes {
+		if !pool.isMember(&nodes[i]) {
+			continue
+		}
+		poolSize++
+		if nodes[i].GetName() == currentNode.GetName() {
+			continue
+		}
+		if nodes[i].GetLabels()[metadata.UpgradingLabel] == "true" || !nodeutil.IsReady(&nodes[i]) {
+			unavailable++
+		}
+	}
+	maxUnavailable, err := pool.policy.MaxUnavailableCount(poolSize)
+	if err != nil {
+		return fmt.Errorf("invalid upgrade policy for the %s upgrade pool: %w", pool.name, err)
+	}
+	if unavailable >= maxUnavailable {
+		return &upgradeDeferredError{reason: fmt.Sprintf("cannot mark node %s as upgrading, %d of %d nodes in the "+
+			"%s upgrade pool are unavailable, maximum unavailable is %d", currentNode.GetName(), unavailable, poolSize,
+			pool.name, maxUnavailable)}
+	}
+	return nil
+}
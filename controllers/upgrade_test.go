@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/maintenance"
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
+)
+
+// newTestNode returns a BYOH node with the given name, readiness and upgrading state
+func newTestNode(name string, ready, upgrading bool) core.Node {
+	node := core.Node{ObjectMeta: meta.ObjectMeta{Name: name, Labels: map[string]string{BYOHLabel: "true"}}}
+	if upgrading {
+		node.Labels[metadata.UpgradingLabel] = "true"
+	}
+	status := core.ConditionFalse
+	if ready {
+		status = core.ConditionTrue
+	}
+	node.Status.Conditions = []core.NodeCondition{{Type: core.NodeReady, Status: status}}
+	return node
+}
+
+func TestCheckUpgradePolicy(t *testing.T) {
+	machineNode := newTestNode("machine", false, true)
+	delete(machineNode.Labels, BYOHLabel)
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	activeWindow, err := maintenance.NewWindow("0 11 * * *", "UTC", 4*time.Hour)
+	require.NoError(t, err)
+	inactiveWindow, err := maintenance.NewWindow("0 2 * * *", "UTC", 4*time.Hour)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name             string
+		policy           operatorconfig.UpgradePolicy
+		window           *maintenance.Window
+		current          core.Node
+		nodes            []core.Node
+		expectDeferred   bool
+		expectPaused     bool
+		expectNextWindow time.Time
+	}{
+		{
+			name:    "no other nodes unavailable",
+			policy:  operatorconfig.UpgradePolicy{MaxUnavailable: intstr.FromInt32(1)},
+			current: newTestNode("a", true, false),
+			nodes: []core.Node{newTestNode("a", true, false), newTestNode("b", true, false),
+				machineNode},
+			expectDeferred: false,
+		},
+		{
+			name:    "another node upgrading",
+			policy:  operatorconfig.UpgradePolicy{MaxUnavailable: intstr.FromInt32(1)},
+			current: newTestNode("a", true, false),
+			nodes: []core.Node{newTestNode("a", true, false), newTestNode("b", true, true),
+				newTestNode("c", true, false)},
+			expectDeferred: true,
+		},
+		{
+			name:    "another node not ready",
+			policy:  operatorconfig.UpgradePolicy{MaxUnavailable: intstr.FromInt32(1)},
+			current: newTestNode("a", true, false),
+			nodes: []core.Node{newTestNode("a", true, false), newTestNode("b", false, false),
+				newTestNode("c", true, false)},
+			expectDeferred: true,
+		},
+		{
+			name:    "percentage allows more unavailable nodes",
+			policy:  operatorconfig.UpgradePolicy{MaxUnavailable: intstr.FromString("50%")},
+			current: newTestNode("a", true, false),
+			nodes: []core.Node{newTestNode("a", true, false), newTestNode("b", true, true),
+				newTestNode("c", true, false), newTestNode("d", true, false)},
+			expectDeferred: false,
+		},
+		{
+			name:           "nodes outside of the pool are ignored",
+			policy:         operatorconfig.UpgradePolicy{MaxUnavailable: intstr.FromInt32(1)},
+			current:        newTestNode("a", true, false),
+			nodes:          []core.Node{newTestNode("a", true, false), machineNode},
+			expectDeferred: false,
+		},
+		{
+			name:    "unavailable node can always be upgraded",
+			policy:  operatorconfig.UpgradePolicy{MaxUnavailable: intstr.FromInt32(1)},
+			current: newTestNode("a", false, false),
+			nodes: []core.Node{newTestNode("a", false, false), newTestNode("b", true, true),
+				newTestNode("c", true, false)},
+			expectDeferred: false,
+		},
+		{
+			name:           "paused",
+			policy:         operatorconfig.UpgradePolicy{MaxUnavailable: intstr.FromInt32(1), Paused: true},
+			current:        newTestNode("a", true, false),
+			nodes:          []core.Node{newTestNode("a", true, false)},
+			expectDeferred: true,
+			expectPaused:   true,
+		},
+		{
+			name:           "within maintenance window",
+			policy:         operatorconfig.UpgradePolicy{MaxUnavailable: intstr.FromInt32(1)},
+			window:         activeWindow,
+			current:        newTestNode("a", true, false),
+			nodes:          []core.Node{newTestNode("a", true, false)},
+			expectDeferred: false,
+		},
+		{
+			name:             "outside of maintenance window",
+			policy:           operatorconfig.UpgradePolicy{MaxUnavailable: intstr.FromInt32(1)},
+			window:           inactiveWindow,
+			current:          newTestNode("a", false, false),
+			nodes:            []core.Node{newTestNode("a", false, false)},
+			expectDeferred:   true,
+			expectNextWindow: time.Date(2024, 1, 11, 2, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkUpgradePolicy(&test.current, test.nodes, newBYOHUpgradePool(test.policy, test.window), now)
+			if !test.expectDeferred {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			deferredErr, ok := err.(*upgradeDeferredError)
+			require.True(t, ok)
+			assert.Equal(t, test.expectPaused, deferredErr.paused)
+			assert.True(t, test.expectNextWindow.Equal(deferredErr.nextWindow))
+		})
+	}
+}
+
+func TestUpgradeDeferralChanged(t *testing.T) {
+	r := &instanceReconciler{}
+	node := newTestNode("a", true, false)
+	other := newTestNode("b", true, false)
+
+	paused := &upgradeDeferredError{paused: true, reason: "paused"}
+	assert.True(t, r.upgradeDeferralChanged(&node, paused))
+	assert.False(t, r.upgradeDeferralChanged(&node, paused))
+	// The upgrade remains deferred for the same reason while the number of unavailable nodes changes
+	assert.True(t, r.upgradeDeferralChanged(&node, &upgradeDeferredError{reason: "1 of 2 nodes are unavailable"}))
+	assert.False(t, r.upgradeDeferralChanged(&node, &upgradeDeferredError{reason: "2 of 3 nodes are unavailable"}))
+	// Once the upgrade is admitted, deferring it again is a change
+	r.clearUpgradeDeferral(client.ObjectKeyFromObject(&node))
+	assert.True(t, r.upgradeDeferralChanged(&node, paused))
+
+	// Pruning forgets the deferred upgrades of all objects which are not kept
+	assert.True(t, r.upgradeDeferralChanged(&other, paused))
+	r.pruneUpgradeDeferrals(map[client.ObjectKey]struct{}{client.ObjectKeyFromObject(&other): {}})
+	assert.True(t, r.upgradeDeferralChanged(&node, paused))
+	assert.False(t, r.upgradeDeferralChanged(&other, paused))
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	cloudnodeutil "k8s.io/cloud-provider/node/helpers"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+	"github.com/openshift/windows-machine-config-operator/pkg/wiparser"
+	"github.com/openshift/windows-machine-config-operator/version"
+)
+
+//+kubebuilder:rbac:groups=windowsmachineconfig.openshift.io,resources=windowsinstances,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=windowsmachineconfig.openshift.io,resources=windowsinstances/status,verbs=get;update;patch
+
+// migrateInstancesConfigMap ensures that there is a WindowsInstance for each entry in the given instances ConfigMap,
+// and that WindowsInstances created from entries which have since been removed are deleted. WindowsInstances which are
+// not managed by the ConfigMap take precedence over an entry with the same address.
+func (r *ConfigMapReconciler) migrateInstancesConfigMap(ctx context.Context, windowsInstancesCM *core.ConfigMap) error {
+	desired, err := wiparser.ToWindowsInstances(windowsInstancesCM.Data, r.watchNamespace)
+	if err != nil {
+		return err
+	}
+
+	managed := &wmcov1.WindowsInstanceList{}
+	if err := r.client.List(ctx, managed, client.InNamespace(r.watchNamespace),
+		client.HasLabels{wiparser.ConfigMapManagedLabel}); err != nil {
+		return fmt.Errorf("error listing WindowsInstances: %w", err)
+	}
+	for i := range managed.Items {
+		if hasWindowsInstanceNamed(desired, managed.Items[i].GetName()) {
+			continue
+		}
+		// The entry was removed from the ConfigMap, deleting the WindowsInstance results in the instance being
+		// deconfigured
+		if err := r.client.Delete(ctx, &managed.Items[i]); err != nil && !k8sapierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting WindowsInstance %s: %w", managed.Items[i].GetName(), err)
+		}
+		r.log.Info("Deleted resource", "WindowsInstance", managed.Items[i].GetName())
+	}
+
+	for i := range desired {
+		existing := &wmcov1.WindowsInstance{}
+		err := r.client.Get(ctx, kubeTypes.NamespacedName{Namespace: r.watchNamespace, Name: desired[i].GetName()},
+			existing)
+		if err != nil {
+			if !k8sapierrors.IsNotFound(err) {
+				return fmt.Errorf("error getting WindowsInstance %s: %w", desired[i].GetName(), err)
+			}
+			if err := r.client.Create(ctx, &desired[i]); err != nil {
+				return fmt.Errorf("error creating WindowsInstance %s: %w", desired[i].GetName(), err)
+			}
+			r.log.Info("Created resource from ConfigMap entry", "WindowsInstance", desired[i].GetName())
+			continue
+		}
+		if _, managed := existing.GetLabels()[wiparser.ConfigMapManagedLabel]; !managed {
+			r.log.V(1).Info("WindowsInstance is not managed by ConfigMap, ignoring entry", "WindowsInstance",
+				existing.GetName(), "ConfigMap", wiparser.InstanceConfigMap)
+			continue
+		}
+		if existing.Spec.Address == desired[i].Spec.Address && existing.Spec.Username == desired[i].Spec.Username {
+			continue
+		}
+		existing.Spec.Address = desired[i].Spec.Address
+		existing.Spec.Username = desired[i].Spec.Username
+		if err := r.client.Update(ctx, existing); err != nil {
+			return fmt.Errorf("error updating WindowsInstance %s: %w", existing.GetName(), err)
+		}
+		r.log.Info("Updated resource from ConfigMap entry", "WindowsInstance", existing.GetName())
+	}
+	return nil
+}
+
+// hasWindowsInstanceNamed returns true if the given slice contains a WindowsInstance with the given name
+func hasWindowsInstanceNamed(windowsInstances []wmcov1.WindowsInstance, name string) bool {
+	for _, windowsInstance := range windowsInstances {
+		if windowsInstance.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// syncNodeMetadata ensures the Node associated with the given instance has the labels and taints specified by the
+// given WindowsInstance, returning the Node
+func (r *ConfigMapReconciler) syncNodeMetadata(ctx context.Context, instanceInfo *instance.Info,
+	windowsInstance *wmcov1.WindowsInstance) (*core.Node, error) {
+	nodes := &core.NodeList{}
+	if err := r.client.List(ctx, nodes, client.MatchingLabels{BYOHLabel: "true",
+		core.LabelOSStable: "windows"}); err != nil {
+		return nil, fmt.Errorf("error listing nodes: %w", err)
+	}
+	node := nodeutil.FindByAddress(instanceInfo.IPv4Address, nodes)
+	if node == nil {
+		return nil, fmt.Errorf("unable to find node associated with instance %s", instanceInfo.Address)
+	}
+	if len(windowsInstance.Spec.Labels) > 0 {
+		if err := metadata.ApplyLabelsAndAnnotations(ctx, r.client, *node, windowsInstance.Spec.Labels,
+			nil); err != nil {
+			return nil, fmt.Errorf("error applying labels to node %s: %w", node.GetName(), err)
+		}
+	}
+	if len(windowsInstance.Spec.Taints) > 0 {
+		taints := make([]*core.Taint, 0, len(windowsInstance.Spec.Taints))
+		for i := range windowsInstance.Spec.Taints {
+			taints = append(taints, &windowsInstance.Spec.Taints[i])
+		}
+		if err := cloudnodeutil.AddOrUpdateTaintOnNode(r.k8sclientset, node.GetName(), taints...); err != nil {
+			return nil, fmt.Errorf("error applying taints to node %s: %w", node.GetName(), err)
+		}
+	}
+	return node, nil
+}
+
+// markWindowsInstanceFailed records the given configuration error within the status of the given WindowsInstance and
+// emits an event, returning the configuration error
+func (r *ConfigMapReconciler) markWindowsInstanceFailed(ctx context.Context, windowsInstance *wmcov1.WindowsInstance,
+	setupErr error) error {
+	reason := "InstanceSetupFailure"
+	var mismatchErr *windows.HostKeyMismatchErr
+	if errors.As(setupErr, &mismatchErr) {
+		// The instance is not configured until the mismatch is resolved by an administrator
+		reason = "HostKeyMismatch"
+	}
+	r.recorder.Eventf(windowsInstance, core.EventTypeWarning, reason, setupErr.Error())
+	if err := r.setWindowsInstancePhase(ctx, windowsInstance, wmcov1.WindowsInstanceFailed,
+		windowsInstance.Status.NodeName, setupErr); err != nil {
+		r.log.Error(err, "unable to update status", "WindowsInstance", windowsInstance.GetName())
+	}
+	return setupErr
+}
+
+// setWindowsInstancePhase patches the status of the given WindowsInstance to reflect the given phase. setupErr should
+// be non-nil only when the phase is WindowsInstanceFailed.
+func (r *ConfigMapReconciler) setWindowsInstancePhase(ctx context.Context, windowsInstance *wmcov1.WindowsInstance,
+	phase wmcov1.WindowsInstancePhase, nodeName string, setupErr error) error {
+	return r.patchWindowsInstanceStatus(ctx, windowsInstance, func(status *wmcov1.WindowsInstanceStatus) {
+		setWindowsInstanceStatus(status, phase, nodeName, windowsInstance.GetGeneration(), setupErr)
+	})
+}
+
+// recordWindowsInstanceStep patches the status of the given WindowsInstance to reflect that the given configuration
+// step was reached. Errors are logged rather than returned, as they should not interrupt the configuration.
+func (r *ConfigMapReconciler) recordWindowsInstanceStep(ctx context.Context, windowsInstance *wmcov1.WindowsInstance,
+	step nodeconfig.Step) {
+	if err := r.patchWindowsInstanceStatus(ctx, windowsInstance, func(status *wmcov1.WindowsInstanceStatus) {
+		recordConfigurationStep(&status.ConfigurationProgress, step)
+	}); err != nil {
+		r.log.Error(err, "unable to record configuration step", "WindowsInstance", windowsInstance.GetName(),
+			"step", step)
+	}
+}
+
+// patchWindowsInstanceStatus applies the given mutation to the status of the given WindowsInstance and patches it
+func (r *ConfigMapReconciler) patchWindowsInstanceStatus(ctx context.Context, windowsInstance *wmcov1.WindowsInstance,
+	mutate func(*wmcov1.WindowsInstanceStatus)) error {
+	patch := client.MergeFrom(windowsInstance.DeepCopy())
+	mutate(&windowsInstance.Status)
+	if err := r.client.Status().Patch(ctx, windowsInstance, patch); err != nil {
+		return fmt.Errorf("error updating status of WindowsInstance %s: %w", windowsInstance.GetName(), err)
+	}
+	return nil
+}
+
+// setWindowsInstanceStatus sets the fields of the given status to reflect the given phase. Moving to the
+// WindowsInstanceConfiguring phase starts a new configuration attempt.
+func setWindowsInstanceStatus(status *wmcov1.WindowsInstanceStatus, phase wmcov1.WindowsInstancePhase,
+	nodeName string, generation int64, setupErr error) {
+	status.Phase = phase
+	status.NodeName = nodeName
+	readyCondition := meta.Condition{
+		Type:               wmcov1.ConditionReady,
+		Status:             meta.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             string(phase),
+	}
+	switch phase {
+	case wmcov1.WindowsInstanceConfigured:
+		status.ConfiguredVersion = version.Get()
+		recordConfigurationResult(&status.ConfigurationProgress, nil)
+		readyCondition.Status = meta.ConditionTrue
+		readyCondition.Message = "Instance is configured as a Node"
+	case wmcov1.WindowsInstanceFailed:
+		if setupErr != nil {
+			recordConfigurationResult(&status.ConfigurationProgress, setupErr)
+		}
+		readyCondition.Message = status.LastError
+		if status.Step != "" {
+			readyCondition.Message = fmt.Sprintf("failed at step %s: %s", status.Step, status.LastError)
+		}
+	case wmcov1.WindowsInstanceConfiguring:
+		startConfigurationAttempt(&status.ConfigurationProgress)
+		readyCondition.Message = "Instance is being configured"
+	default:
+		readyCondition.Message = "Instance has not been processed"
+	}
+	apimeta.SetStatusCondition(&status.Conditions, readyCondition)
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+	"github.com/openshift/windows-machine-config-operator/version"
+)
+
+func TestSetWindowsInstanceStatus(t *testing.T) {
+	testCases := []struct {
+		name              string
+		initial           wmcov1.WindowsInstanceStatus
+		phase             wmcov1.WindowsInstancePhase
+		nodeName          string
+		setupErr          error
+		expectedReady     meta.ConditionStatus
+		expectedVersion   string
+		expectedLastError string
+	}{
+		{
+			name:          "configuring",
+			initial:       wmcov1.WindowsInstanceStatus{},
+			phase:         wmcov1.WindowsInstanceConfiguring,
+			expectedReady: meta.ConditionFalse,
+		},
+		{
+			name:              "failed",
+			initial:           wmcov1.WindowsInstanceStatus{ConfiguredVersion: "old"},
+			phase:             wmcov1.WindowsInstanceFailed,
+			nodeName:          "node",
+			setupErr:          fmt.Errorf("ssh failure"),
+			expectedReady:     meta.ConditionFalse,
+			expectedVersion:   "old",
+			expectedLastError: "ssh failure",
+		},
+		{
+			name: "configured after failure",
+			initial: wmcov1.WindowsInstanceStatus{
+				ConfigurationProgress: wmcov1.ConfigurationProgress{LastError: "ssh failure"}},
+			phase:           wmcov1.WindowsInstanceConfigured,
+			nodeName:        "node",
+			expectedReady:   meta.ConditionTrue,
+			expectedVersion: version.Get(),
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			status := test.initial
+			setWindowsInstanceStatus(&status, test.phase, test.nodeName, 2, test.setupErr)
+			assert.Equal(t, test.phase, status.Phase)
+			assert.Equal(t, test.nodeName, status.NodeName)
+			assert.Equal(t, test.expectedVersion, status.ConfiguredVersion)
+			assert.Equal(t, test.expectedLastError, status.LastError)
+			ready := apimeta.FindStatusCondition(status.Conditions, wmcov1.ConditionReady)
+			require.NotNil(t, ready)
+			assert.Equal(t, test.expectedReady, ready.Status)
+			assert.Equal(t, int64(2), ready.ObservedGeneration)
+		})
+	}
+}
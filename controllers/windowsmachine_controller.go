@@ -25,13 +25,15 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/condition"
-	"github.com/openshift/windows-machine-config-operator/pkg/crypto"
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
 	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
@@ -39,16 +41,13 @@ import (
 )
 
 //+kubebuilder:rbac:groups=config.openshift.io,resources=clusteroperators,verbs=get;list;watch
-//+kubebuilder:rbac:groups=machine.openshift.io,resources=machines,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=machine.openshift.io,resources=machines,verbs=get;list;watch;delete;patch
 //+kubebuilder:rbac:groups=machine.openshift.io,resources=machinesets,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;patch;watch
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;
 //+kubebuilder:rbac:groups="",resources=events,verbs=*
 
 const (
-	// maxUnhealthyCount is the maximum number of nodes that are not ready to serve at a given time.
-	// TODO: https://issues.redhat.com/browse/WINC-524
-	maxUnhealthyCount = 1
 	// MachineOSLabel is the label used to identify the Windows Machines.
 	MachineOSLabel = "machine.openshift.io/os-id"
 	// WindowsMachineController is the name of this controller in logs and other outputs.
@@ -64,8 +63,10 @@ type WindowsMachineReconciler struct {
 	machineClient *mclient.MachineV1beta1Client
 }
 
-// NewWindowsMachineReconciler returns a pointer to a WindowsMachineReconciler
-func NewWindowsMachineReconciler(mgr manager.Manager, clusterConfig cluster.Config, watchNamespace string) (*WindowsMachineReconciler, error) {
+// NewWindowsMachineReconciler returns a pointer to a WindowsMachineReconciler. The given KeyRotationLocks must be shared
+// with all other reconcilers which rotate keys.
+func NewWindowsMachineReconciler(mgr manager.Manager, clusterConfig cluster.Config, watchNamespace string,
+	keyRotationLocks *KeyRotationLocks) (*WindowsMachineReconciler, error) {
 	// The client provided by the GetClient() method of the manager is a split client that will always hit the API
 	// server when writing. When reading, the client will either use a cache populated by the informers backing the
 	// controllers, or in certain cases read directly from the API server. It will read from the server both for
@@ -94,10 +95,12 @@ func NewWindowsMachineReconciler(mgr manager.Manager, clusterConfig cluster.Conf
 			log:                  ctrl.Log.WithName("controller").WithName(WindowsMachineController),
 			k8sclientset:         clientset,
 			clusterServiceCIDR:   clusterConfig.Network().GetServiceCIDR(),
+			controllerName:       WindowsMachineController,
 			recorder:             mgr.GetEventRecorderFor(WindowsMachineController),
 			watchNamespace:       watchNamespace,
 			prometheusNodeConfig: pc,
 			platform:             clusterConfig.Platform(),
+			keyRotationLocks:     keyRotationLocks,
 		},
 		machineClient: machineClient,
 	}, nil
@@ -113,7 +116,8 @@ func (r *WindowsMachineReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			return r.isValidMachine(e.Object) && isWindowsMachine(e.Object.GetLabels())
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
-			return r.isValidMachine(e.ObjectNew) && isWindowsMachine(e.ObjectNew.GetLabels())
+			return r.isValidMachine(e.ObjectNew) && isWindowsMachine(e.ObjectNew.GetLabels()) &&
+				!isProgressOnlyUpdate(e.ObjectOld, e.ObjectNew)
 		},
 		GenericFunc: func(e event.GenericEvent) bool {
 			return r.isValidMachine(e.Object) && isWindowsMachine(e.Object.GetLabels())
@@ -125,13 +129,55 @@ func (r *WindowsMachineReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		},
 	}
 
+	// Changes to the upgrade policy, such as resuming upgrades, allow deferred upgrades to proceed
+	operatorConfigPredicate := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		return o.GetNamespace() == r.watchNamespace && o.GetName() == operatorconfig.ConfigMapName
+	})
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&mapi.Machine{}, builder.WithPredicates(machinePredicate)).
 		Watches(&core.Node{}, handler.EnqueueRequestsFromMapFunc(r.mapNodeToMachine),
-			builder.WithPredicates(outdatedWindowsNodePredicate(false))).
+			builder.WithPredicates(predicate.Or(outdatedWindowsNodePredicate(false),
+				nodeReadinessChangedPredicate()))).
+		Watches(&core.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.mapToMachines),
+			builder.WithPredicates(operatorConfigPredicate)).
+		Watches(&mapi.MachineSet{}, handler.EnqueueRequestsFromMapFunc(r.mapMachineSetToMachines),
+			builder.WithPredicates(predicate.AnnotationChangedPredicate{})).
 		Complete(r)
 }
 
+// mapToMachines fulfills the MapFn type, while always returning requests to all Windows Machines
+func (r *WindowsMachineReconciler) mapToMachines(_ context.Context, _ client.Object) []reconcile.Request {
+	return r.windowsMachineRequests("")
+}
+
+// mapMachineSetToMachines maps the given MachineSet to the Windows Machines it owns
+func (r *WindowsMachineReconciler) mapMachineSetToMachines(_ context.Context, object client.Object) []reconcile.Request {
+	return r.windowsMachineRequests(object.GetName())
+}
+
+// windowsMachineRequests returns requests to the Windows Machines owned by the MachineSet with the given name, or to
+// all Windows Machines if no name is given
+func (r *WindowsMachineReconciler) windowsMachineRequests(machineSetName string) []reconcile.Request {
+	machines, err := r.machineClient.Machines(cluster.MachineAPINamespace).List(context.TODO(),
+		meta.ListOptions{LabelSelector: MachineOSLabel + "=Windows," + IgnoreLabel + "!=true"})
+	if err != nil {
+		r.log.Error(err, "could not get a list of machines")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, machine := range machines.Items {
+		if machineSetName != "" &&
+			(len(machine.OwnerReferences) == 0 || machine.OwnerReferences[0].Name != machineSetName) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: machine.GetNamespace(), Name: machine.GetName()},
+		})
+	}
+	return requests
+}
+
 // mapNodeToMachine maps the given Windows node to its associated Machine
 func (r *WindowsMachineReconciler) mapNodeToMachine(_ context.Context, object client.Object) []reconcile.Request {
 	if !isWindowsNode(object) {
@@ -243,6 +289,7 @@ func (r *WindowsMachineReconciler) Reconcile(ctx context.Context,
 			// In the case the machine was deleted, ensure that the metrics subsets are configured properly, so that
 			// the current Windows nodes are properly reflected there.
 			log.V(1).Info("not found")
+			r.clearUpgradeDeferral(request.NamespacedName)
 			return ctrl.Result{}, r.prometheusNodeConfig.Configure()
 		}
 		// Error reading the object - requeue the request.
@@ -254,6 +301,8 @@ func (r *WindowsMachineReconciler) Reconcile(ctx context.Context,
 	runningPhase := "Running"
 	// node is the Node object associated with the machine being reconciled, if any exists
 	var node *core.Node
+	// pool is the upgrade pool of the machine, set only if the associated node must be upgraded
+	var pool *upgradePool
 	if machine.Status.Phase == nil {
 		// This condition should never be true as machine objects without a phase will be filtered out via the predicate functions
 		return ctrl.Result{}, fmt.Errorf("could not get the phase associated with machine %s", machine.Name)
@@ -273,6 +322,8 @@ func (r *WindowsMachineReconciler) Reconcile(ctx context.Context,
 			// Do not requeue if associated node cannot be found (i.e. deleted) for a running machine
 			if k8sapierrors.IsNotFound(err) {
 				log.Info("the node associated with this machine does not exist, no-op", "name", machine.GetName())
+				r.clearUpgradeDeferral(request.NamespacedName)
+				r.keyRotationLocks.remove(machine.Status.NodeRef.Name)
 				return ctrl.Result{}, nil
 			}
 			return ctrl.Result{}, fmt.Errorf("could not get node associated with machine %s: %w", machine.GetName(),
@@ -283,19 +334,19 @@ func (r *WindowsMachineReconciler) Reconcile(ctx context.Context,
 			// If the private key used to configure the machine is out of date, the machine should be deleted
 			if node.Annotations[nodeconfig.PubKeyHashAnnotation] !=
 				nodeconfig.CreatePubKeyHashAnnotation(r.signer.PublicKey()) {
-				log.Info("deleting machine")
-				deletionAllowed, err := r.isAllowedDeletion(machine)
+				rotated, err := r.rotateKeyInPlace(ctx, machine, node)
 				if err != nil {
-					return ctrl.Result{}, fmt.Errorf("unable to determine if Machine can be deleted: %w", err)
+					return ctrl.Result{}, err
+				}
+				if rotated {
+					return ctrl.Result{}, nil
 				}
-				if !deletionAllowed {
-					log.Info("machine deletion restricted", "maxUnhealthyCount", maxUnhealthyCount)
-					r.recorder.Eventf(machine, core.EventTypeWarning, "MachineDeletionRestricted",
-						"Machine %v deletion restricted as the maximum unhealthy machines can`t exceed %v count",
-						machine.Name, maxUnhealthyCount)
-					return ctrl.Result{Requeue: true}, nil
+				log.Info("deleting machine")
+				deleted, err := r.deleteMachineWithinBudget(ctx, machine)
+				if err != nil {
+					return ctrl.Result{}, err
 				}
-				return ctrl.Result{}, r.deleteMachine(machine)
+				return ctrl.Result{Requeue: !deleted}, nil
 			}
 			if node.Annotations[metadata.VersionAnnotation] == version.Get() {
 				// version annotation exists with a valid value, node is fully configured.
@@ -304,8 +355,34 @@ func (r *WindowsMachineReconciler) Reconcile(ctx context.Context,
 				if err := r.prometheusNodeConfig.Configure(); err != nil {
 					return ctrl.Result{}, fmt.Errorf("unable to configure Prometheus: %w", err)
 				}
-				return ctrl.Result{}, nil
+				return r.remediateMachine(ctx, machine, node)
 			}
+			// The node was configured by a previous version of WMCO. Upgrading it is subject to the upgrade policy of
+			// the pool the Machine belongs to.
+			pool, err = r.machineUpgradePool(ctx, machine)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.admitUpgrade(ctx, node, pool); err != nil {
+				var deferredErr *upgradeDeferredError
+				if !errors.As(err, &deferredErr) {
+					return ctrl.Result{}, err
+				}
+				if r.upgradeDeferralChanged(machine, deferredErr) {
+					r.recorder.Eventf(machine, core.EventTypeNormal, "UpgradeDeferred",
+						"Machine %s upgrade deferred: %v", machine.Name, err)
+				}
+				if deferredErr.paused {
+					// The Machine is reconciled again once upgrades are resumed, through the watches on the operator
+					// config ConfigMap and on MachineSets
+					return ctrl.Result{}, nil
+				}
+				if requeueAfter := deferredErr.requeueAfter(); requeueAfter > 0 {
+					return ctrl.Result{RequeueAfter: requeueAfter}, nil
+				}
+				return ctrl.Result{}, err
+			}
+			r.clearUpgradeDeferral(client.ObjectKeyFromObject(machine))
 		}
 	} else if *machine.Status.Phase != provisionedPhase {
 		log.V(1).Info("machine not provisioned", "phase", *machine.Status.Phase)
@@ -342,20 +419,45 @@ func (r *WindowsMachineReconciler) Reconcile(ctx context.Context,
 		return ctrl.Result{}, fmt.Errorf("unable to get instance ID from provider ID for machine %s", machine.Name)
 	}
 
-	log.Info("processing", "address", ipAddress)
+	transport, err := r.machineTransport(ctx, machine)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("processing", "address", ipAddress, "transport", transport)
+	// Record the progress of the configuration on the Machine, so that it is visible without the operator logs
+	progress := getMachineConfigurationProgress(machine)
+	startConfigurationAttempt(&progress)
+	r.recordMachineProgress(ctx, machine, progress)
 	// Configure the Machine as an up-to-date Windows Worker node
-	if err := r.configureMachine(ipAddress, instanceID, machine.Name, node); err != nil {
+	err = r.configureMachine(ipAddress, instanceID, machine.Name, node, transport, pool, func(step nodeconfig.Step) {
+		recordConfigurationStep(&progress, step)
+		r.recordMachineProgress(ctx, machine, progress)
+	})
+	recordConfigurationResult(&progress, err)
+	r.recordMachineProgress(ctx, machine, progress)
+	if err != nil {
 		var authErr *windows.AuthErr
-		if errors.As(err, &authErr) {
+		if errors.As(err, &authErr) && transport == instance.TransportSSH {
 			// SSH authentication errors with the Machine are non recoverable, stemming from a mismatch with the
 			// userdata used to provision the machine and the current private key secret. The machine must be deleted and
-			// re-provisioned.
+			// re-provisioned. WinRM authentication errors stem from the certificate mapping of the image instead, which
+			// re-provisioning would not correct.
 			r.recorder.Eventf(machine, core.EventTypeWarning, "MachineSetupFailure",
 				"Machine %s authentication failure", machine.Name)
 			return ctrl.Result{}, r.deleteMachine(machine)
 		}
+		var mismatchErr *windows.HostKeyMismatchErr
+		if errors.As(err, &mismatchErr) {
+			// The connection may be intercepted, the Machine is neither configured nor remediated until the mismatch
+			// is resolved by an administrator
+			r.recorder.Eventf(machine, core.EventTypeWarning, "HostKeyMismatch",
+				"Machine %s presented an unexpected SSH host key, refusing to configure it: %v", machine.Name, err)
+			return ctrl.Result{}, err
+		}
 		r.recorder.Eventf(machine, core.EventTypeWarning, "MachineSetupFailure",
-			"Machine %s configuration failure", machine.Name)
+			"Machine %s configuration failure at step %q after %d attempt(s): %v", machine.Name, progress.Step,
+			progress.Attempts, err)
 		return ctrl.Result{}, err
 	}
 	r.recorder.Eventf(machine, core.EventTypeNormal, "MachineSetup",
@@ -395,8 +497,20 @@ func (r *WindowsMachineReconciler) getDefaultUsername() string {
 	return "Administrator"
 }
 
-// configureMachine configures the given Windows VM, adding it as a node object to the cluster or upgrading it in place.
-func (r *WindowsMachineReconciler) configureMachine(ipAddress, instanceID, machineName string, node *core.Node) error {
+// recordMachineProgress records the given configuration progress on the given Machine. Errors are logged rather than
+// returned, as they should not interrupt the configuration.
+func (r *WindowsMachineReconciler) recordMachineProgress(ctx context.Context, machine *mapi.Machine,
+	progress wmcov1.ConfigurationProgress) {
+	if err := setMachineConfigurationProgress(ctx, r.client, machine, progress); err != nil {
+		r.log.Error(err, "unable to record configuration progress", "machine", machine.GetName())
+	}
+}
+
+// configureMachine configures the given Windows VM, adding it as a node object to the cluster or upgrading it in place
+// subject to the policy of the given upgrade pool. If stepRecorder is not nil, it is called as each configuration step
+// is reached.
+func (r *WindowsMachineReconciler) configureMachine(ipAddress, instanceID, machineName string, node *core.Node,
+	transport instance.Transport, pool *upgradePool, stepRecorder func(nodeconfig.Step)) error {
 	// The name of the Machine must be the same as the hostname of the associated VM. This is currently not true in the
 	// case of vSphere VMs provisioned by MAPI. In case of Linux, ignition was handling it. As we don't have an
 	// equivalent of ignition in Windows, WMCO must correct this by changing the VM's hostname.
@@ -412,25 +526,99 @@ func (r *WindowsMachineReconciler) configureMachine(ipAddress, instanceID, machi
 	if err != nil {
 		return err
 	}
-	// Get private key to encrypt instance usernames
-	privateKeyBytes, err := secrets.GetPrivateKey(kubeTypes.NamespacedName{Namespace: r.watchNamespace,
-		Name: secrets.PrivateKeySecret}, r.client)
-	if err != nil {
-		return err
-	}
-	encryptedUsername, err := crypto.EncryptToJSONString(username, privateKeyBytes)
+	encryptedUsername, err := r.encryptUsername(username)
 	if err != nil {
 		return fmt.Errorf("unable to encrypt username for instance %s: %w", instanceInfo.Address, err)
 	}
 
-	if err := r.ensureInstanceIsUpToDate(instanceInfo, nil,
-		map[string]string{UsernameAnnotation: encryptedUsername}); err != nil {
+	instanceInfo.Transport = transport
+	annotationsToApply := map[string]string{UsernameAnnotation: encryptedUsername}
+	if instanceInfo.UsesWinRM() {
+		annotationsToApply[TransportAnnotation] = string(instanceInfo.Transport)
+	}
+
+	if err := r.ensureInstanceIsUpToDate(instanceInfo, pool, nil, annotationsToApply, stepRecorder); err != nil {
 		return fmt.Errorf("unable to configure instance %s: %w", instanceID, err)
 	}
 
 	return nil
 }
 
+// machineTransport returns the protocol used to access the instance of the given Machine, as selected by the
+// annotations of its MachineSet. Instances of Machines without a MachineSet are accessed over SSH.
+func (r *WindowsMachineReconciler) machineTransport(ctx context.Context,
+	machine *mapi.Machine) (instance.Transport, error) {
+	if len(machine.OwnerReferences) == 0 {
+		return instance.TransportSSH, nil
+	}
+	machineSetName := machine.OwnerReferences[0].Name
+	machineSet, err := r.machineClient.MachineSets(cluster.MachineAPINamespace).Get(ctx, machineSetName,
+		meta.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("cannot get MachineSet %s: %w", machineSetName, err)
+	}
+	transport, err := instance.ParseTransport(machineSet.GetAnnotations()[TransportAnnotation])
+	if err != nil {
+		return "", fmt.Errorf("invalid %s annotation on MachineSet %s: %w", TransportAnnotation, machineSetName, err)
+	}
+	return transport, nil
+}
+
+// machineUpgradePool returns the upgrade pool of the given Machine, made up of the nodes backed by the Machines of its
+// MachineSet. The policy of the pool is the operator's upgrade policy, overridden by any MachineSet annotations.
+func (r *WindowsMachineReconciler) machineUpgradePool(ctx context.Context,
+	machine *mapi.Machine) (*upgradePool, error) {
+	operatorConfig, err := operatorconfig.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(machine.OwnerReferences) == 0 {
+		// A Machine without a MachineSet forms a pool of its own
+		nodeName := ""
+		if machine.Status.NodeRef != nil {
+			nodeName = machine.Status.NodeRef.Name
+		}
+		return &upgradePool{
+			name:   "Machine/" + machine.GetName(),
+			policy: operatorConfig.Upgrade,
+			isMember: func(node *core.Node) bool {
+				return node.GetName() == nodeName
+			},
+			window: operatorConfig.MaintenanceWindow,
+		}, nil
+	}
+	machineSetName := machine.OwnerReferences[0].Name
+	machineSet, err := r.machineClient.MachineSets(cluster.MachineAPINamespace).Get(ctx, machineSetName,
+		meta.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get MachineSet %s: %w", machineSetName, err)
+	}
+	policy, err := operatorConfig.Upgrade.WithOverrides(machineSet.GetAnnotations())
+	if err != nil {
+		return nil, fmt.Errorf("invalid upgrade policy for MachineSet %s: %w", machineSetName, err)
+	}
+	machines, err := r.machineClient.Machines(cluster.MachineAPINamespace).List(ctx,
+		meta.ListOptions{LabelSelector: MachineOSLabel + "=Windows"})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list Machines: %w", err)
+	}
+	nodeNames := make(map[string]struct{})
+	for _, ma := range machines.Items {
+		if len(ma.OwnerReferences) != 0 && ma.OwnerReferences[0].Name == machineSetName && ma.Status.NodeRef != nil {
+			nodeNames[ma.Status.NodeRef.Name] = struct{}{}
+		}
+	}
+	return &upgradePool{
+		name:   "MachineSet/" + machineSetName,
+		policy: policy,
+		isMember: func(node *core.Node) bool {
+			_, present := nodeNames[node.GetName()]
+			return present
+		},
+		window: operatorConfig.MaintenanceWindow,
+	}, nil
+}
+
 // validateUserData validates the userData secret. It returns error if the secret doesn`t contain the expected public
 // key bytes.
 func (r *WindowsMachineReconciler) validateUserData() error {
@@ -456,48 +644,113 @@ func (r *WindowsMachineReconciler) validateUserData() error {
 	return nil
 }
 
-// isAllowedDeletion determines if the number of machines after deletion of the given machine doesn`t fall below the
-// minHealthyCount
-func (r *WindowsMachineReconciler) isAllowedDeletion(machine *mapi.Machine) (bool, error) {
+// rotateKeyInPlace moves the instance of the given Machine to the current private key in place, if the key rotation
+// policy allows it. Returns true if the key was rotated, and false if the Machine should instead be recreated.
+func (r *WindowsMachineReconciler) rotateKeyInPlace(ctx context.Context, machine *mapi.Machine,
+	node *core.Node) (bool, error) {
+	// The annotation is cleared when the Machine must be recreated with the new key
+	if node.Annotations[nodeconfig.PubKeyHashAnnotation] == "" {
+		return false, nil
+	}
+	operatorConfig, err := operatorconfig.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		return false, err
+	}
+	if operatorConfig.SSHKeyRotation != operatorconfig.KeyRotationInPlace {
+		return false, nil
+	}
+	if err := r.rotateInstanceKey(ctx, node, r.signer); err != nil {
+		r.recorder.Eventf(machine, core.EventTypeWarning, "KeyRotationFailed",
+			"Unable to rotate the private key of Machine %s in place: %v", machine.Name, err)
+		return false, fmt.Errorf("unable to rotate private key of Machine %s in place: %w", machine.Name, err)
+	}
+	return true, nil
+}
+
+// deleteMachineWithinBudget deletes the given Machine if the Machine deletion budget of its MachineSet allows it.
+// Returns false, after emitting an event, if the deletion is restricted by the budget.
+func (r *WindowsMachineReconciler) deleteMachineWithinBudget(ctx context.Context, machine *mapi.Machine) (bool, error) {
+	deletionAllowed, maxUnhealthy, err := r.isAllowedDeletion(ctx, machine)
+	if err != nil {
+		return false, fmt.Errorf("unable to determine if Machine can be deleted: %w", err)
+	}
+	if !deletionAllowed {
+		r.log.Info("machine deletion restricted", "machine", machine.GetName(), "maxUnhealthy", maxUnhealthy)
+		r.recorder.Eventf(machine, core.EventTypeWarning, "MachineDeletionRestricted",
+			"Machine %v deletion restricted as the maximum unhealthy machines can`t exceed %v count",
+			machine.Name, maxUnhealthy)
+		return false, nil
+	}
+	return true, r.deleteMachine(machine)
+}
+
+// isAllowedDeletion determines if the number of unhealthy machines in the MachineSet of the given machine is within
+// the MachineSet's deletion budget, so that the given machine can be deleted. The maximum number of unhealthy
+// machines of the MachineSet is returned along with the decision.
+func (r *WindowsMachineReconciler) isAllowedDeletion(ctx context.Context, machine *mapi.Machine) (bool, int, error) {
 	if len(machine.OwnerReferences) == 0 {
-		return false, fmt.Errorf("machine has no owner reference")
+		return false, 0, fmt.Errorf("machine has no owner reference")
 	}
 	machinesetName := machine.OwnerReferences[0].Name
 
-	machines, err := r.machineClient.Machines(cluster.MachineAPINamespace).List(context.TODO(),
+	machines, err := r.machineClient.Machines(cluster.MachineAPINamespace).List(ctx,
 		meta.ListOptions{LabelSelector: MachineOSLabel + "=Windows"})
 	if err != nil {
-		return false, fmt.Errorf("cannot list Machines: %w", err)
+		return false, 0, fmt.Errorf("cannot list Machines: %w", err)
 	}
 
 	// get Windows MachineSet
-	windowsMachineSet, err := r.machineClient.MachineSets(cluster.MachineAPINamespace).Get(context.TODO(),
+	windowsMachineSet, err := r.machineClient.MachineSets(cluster.MachineAPINamespace).Get(ctx,
 		machinesetName, meta.GetOptions{})
 	if err != nil {
-		return false, fmt.Errorf("cannot get MachineSet: %w", err)
+		return false, 0, fmt.Errorf("cannot get MachineSet: %w", err)
 	}
-
-	// Allow deletion if there is only one machine in the Windows MachineSet
-	totalWindowsMachineCount := *windowsMachineSet.Spec.Replicas
-	if maxUnhealthyCount == totalWindowsMachineCount {
-		return true, nil
+	operatorConfig, err := operatorconfig.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		return false, 0, err
+	}
+	policy, err := operatorConfig.MachineDeletion.WithOverrides(windowsMachineSet.GetAnnotations())
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid Machine deletion budget for MachineSet %s: %w", machinesetName, err)
+	}
+	totalWindowsMachineCount := 0
+	if windowsMachineSet.Spec.Replicas != nil {
+		totalWindowsMachineCount = int(*windowsMachineSet.Spec.Replicas)
+	}
+	maxUnhealthy, err := policy.MaxUnhealthyCount(totalWindowsMachineCount)
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid Machine deletion budget for MachineSet %s: %w", machinesetName, err)
 	}
 
 	totalHealthy := 0
 	for _, ma := range machines.Items {
 		// Increment the count if the machine is identified as healthy and is a part of given Windows MachineSet and
 		// on which deletion is not already initiated.
-		if len(machine.OwnerReferences) != 0 && ma.OwnerReferences[0].Name == machinesetName &&
+		if len(ma.OwnerReferences) != 0 && ma.OwnerReferences[0].Name == machinesetName &&
 			r.isWindowsMachineHealthy(&ma) && ma.DeletionTimestamp.IsZero() {
 			totalHealthy += 1
 		}
 	}
-
-	unhealthyMachineCount := totalWindowsMachineCount - int32(totalHealthy)
 	r.log.Info("unhealthy machine count for machineset", "name", machinesetName, "total", totalWindowsMachineCount,
-		"unhealthy", unhealthyMachineCount)
+		"unhealthy", totalWindowsMachineCount-totalHealthy)
 
-	return unhealthyMachineCount < maxUnhealthyCount, nil
+	return withinDeletionBudget(totalWindowsMachineCount, totalHealthy, maxUnhealthy,
+		r.isWindowsMachineHealthy(machine)), maxUnhealthy, nil
+}
+
+// withinDeletionBudget returns true if a Machine, which is healthy or not as given, can be deleted from a MachineSet
+// with the given number of replicas and healthy Machines without exceeding the given maximum number of unhealthy
+// Machines. Deleting a Machine which is already unhealthy does not increase the number of unhealthy Machines. Deletion
+// is always allowed if the maximum covers every replica.
+func withinDeletionBudget(replicas, healthy, maxUnhealthy int, machineHealthy bool) bool {
+	if maxUnhealthy >= replicas {
+		return true
+	}
+	otherUnhealthy := replicas - healthy
+	if !machineHealthy {
+		otherUnhealthy--
+	}
+	return otherUnhealthy < maxUnhealthy
 }
 
 // isWindowsMachineHealthy determines if the given Machine object is healthy. A Windows machine is considered
@@ -505,9 +758,9 @@ func (r *WindowsMachineReconciler) isAllowedDeletion(machine *mapi.Machine) (boo
 // 1. Machine is not in a 'Running' phase
 // 2. Machine is not associated with a Node object
 // 3. Associated Node object doesn't have a Version annotation
+// 4. Associated Node object is not ready
 func (r *WindowsMachineReconciler) isWindowsMachineHealthy(machine *mapi.Machine) bool {
-	if (machine.Status.Phase == nil || *machine.Status.Phase != "Running") &&
-		machine.Status.NodeRef == nil {
+	if machine.Status.Phase == nil || *machine.Status.Phase != "Running" || machine.Status.NodeRef == nil {
 		return false
 	}
 
@@ -521,7 +774,7 @@ func (r *WindowsMachineReconciler) isWindowsMachineHealthy(machine *mapi.Machine
 		return false
 	}
 
-	return true
+	return nodeutil.IsReady(node)
 }
 
 // getInternalIPAddress returns the internal IP address of the Machine
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	mapi "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -63,3 +64,61 @@ func TestIsValidMachine(t *testing.T) {
 	}
 
 }
+
+func TestWithinDeletionBudget(t *testing.T) {
+	testCases := []struct {
+		name           string
+		replicas       int
+		healthy        int
+		maxUnhealthy   int
+		machineHealthy bool
+		expected       bool
+	}{
+		{
+			name:           "single replica",
+			replicas:       1,
+			healthy:        1,
+			maxUnhealthy:   1,
+			machineHealthy: true,
+			expected:       true,
+		},
+		{
+			name:           "all healthy",
+			replicas:       3,
+			healthy:        3,
+			maxUnhealthy:   1,
+			machineHealthy: true,
+			expected:       true,
+		},
+		{
+			name:           "another machine unhealthy",
+			replicas:       3,
+			healthy:        2,
+			maxUnhealthy:   1,
+			machineHealthy: true,
+			expected:       false,
+		},
+		{
+			name:           "only the deleted machine unhealthy",
+			replicas:       3,
+			healthy:        2,
+			maxUnhealthy:   1,
+			machineHealthy: false,
+			expected:       true,
+		},
+		{
+			name:           "larger budget",
+			replicas:       5,
+			healthy:        4,
+			maxUnhealthy:   2,
+			machineHealthy: true,
+			expected:       true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, withinDeletionBudget(test.replicas, test.healthy, test.maxUnhealthy,
+				test.machineHealthy))
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+)
+
+//+kubebuilder:rbac:groups=windowsmachineconfig.openshift.io,resources=windowsservices,verbs=get;list;watch
+//+kubebuilder:rbac:groups=windowsmachineconfig.openshift.io,resources=windowsservices/status,verbs=get;update;patch
+
+// listWindowsServices returns the WindowsServices in the given namespace, read through the given client
+func listWindowsServices(ctx context.Context, c client.Reader, namespace string) ([]wmcov1.WindowsService, error) {
+	windowsServices := &wmcov1.WindowsServiceList{}
+	if err := c.List(ctx, windowsServices, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("error listing WindowsServices: %w", err)
+	}
+	return windowsServices.Items, nil
+}
+
+// updateWindowsServiceStatuses sets the Accepted condition of each of the given WindowsServices, given the reason
+// each rejected WindowsService was left out of the services ConfigMap, by name. An event is generated for each
+// WindowsService which becomes rejected.
+func (r *ConfigMapReconciler) updateWindowsServiceStatuses(ctx context.Context,
+	windowsServices []wmcov1.WindowsService, rejected map[string]error) error {
+	for i := range windowsServices {
+		windowsService := &windowsServices[i]
+		rejectErr := rejected[windowsService.GetName()]
+		patch := client.MergeFrom(windowsService.DeepCopy())
+		if !setWindowsServiceAccepted(&windowsService.Status, windowsService.GetGeneration(), rejectErr) {
+			continue
+		}
+		if err := r.client.Status().Patch(ctx, windowsService, patch); err != nil {
+			return fmt.Errorf("error updating status of WindowsService %s: %w", windowsService.GetName(), err)
+		}
+		if rejectErr != nil {
+			r.recorder.Eventf(windowsService, core.EventTypeWarning, "WindowsServiceRejected",
+				"WindowsService %s will not be managed on Windows nodes: %v", windowsService.GetName(), rejectErr)
+		}
+	}
+	return nil
+}
+
+// setWindowsServiceAccepted sets the Accepted condition of the given status, which is false if the given error is not
+// nil. Returns true if the condition changed.
+func setWindowsServiceAccepted(status *wmcov1.WindowsServiceStatus, generation int64, rejectErr error) bool {
+	acceptedCondition := meta.Condition{
+		Type:               wmcov1.ConditionAccepted,
+		Status:             meta.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             "Merged",
+		Message:            "Service is managed on Windows nodes",
+	}
+	if rejectErr != nil {
+		acceptedCondition.Status = meta.ConditionFalse
+		acceptedCondition.Reason = "Invalid"
+		acceptedCondition.Message = rejectErr.Error()
+	}
+	return apimeta.SetStatusCondition(&status.Conditions, acceptedCondition)
+}
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+)
+
+func TestSetWindowsServiceAccepted(t *testing.T) {
+	status := &wmcov1.WindowsServiceStatus{}
+
+	assert.True(t, setWindowsServiceAccepted(status, 1, nil))
+	condition := apimeta.FindStatusCondition(status.Conditions, wmcov1.ConditionAccepted)
+	require.NotNil(t, condition)
+	assert.Equal(t, meta.ConditionTrue, condition.Status)
+	assert.Equal(t, int64(1), condition.ObservedGeneration)
+
+	// Setting the same condition again is not a change
+	assert.False(t, setWindowsServiceAccepted(status, 1, nil))
+
+	assert.True(t, setWindowsServiceAccepted(status, 2, fmt.Errorf("dependency cycle")))
+	condition = apimeta.FindStatusCondition(status.Conditions, wmcov1.ConditionAccepted)
+	require.NotNil(t, condition)
+	assert.Equal(t, meta.ConditionFalse, condition.Status)
+	assert.Equal(t, "dependency cycle", condition.Message)
+	assert.Equal(t, int64(2), condition.ObservedGeneration)
+}
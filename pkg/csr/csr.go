@@ -9,10 +9,13 @@ package csr
 
 import (
 	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net"
+	"net/url"
 	"reflect"
 	"strings"
 
@@ -30,6 +33,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
@@ -100,6 +104,21 @@ func NewApprover(client client.Client, clientSet *kubernetes.Clientset, csr *cer
 		watchNamespace}, nil
 }
 
+// invalidCSRErr is returned when the CSR of a BYOH Windows instance fails validation, which retrying cannot change
+type invalidCSRErr struct {
+	err error
+}
+
+// Error returns the reason the CSR is invalid
+func (e *invalidCSRErr) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the reason the CSR is invalid
+func (e *invalidCSRErr) Unwrap() error {
+	return e.err
+}
+
 // Approve determines if a CSR should be approved by WMCO, and if so, approves it by updating its status. This function
 // is a NOOP if the CSR should not be approved.
 func (a *Approver) Approve() error {
@@ -108,6 +127,9 @@ func (a *Approver) Approve() error {
 	}
 
 	validForApproval, err := a.validateCSRContents()
+	if decision := validationDecision(validForApproval, err); decision != "" {
+		decisions.WithLabelValues(decision).Inc()
+	}
 	if err != nil {
 		return fmt.Errorf("error determining if CSR %s should be approved: %w", a.csr.Name, err)
 	}
@@ -128,13 +150,30 @@ func (a *Approver) Approve() error {
 		// have to return err itself here (not wrapped inside another error) so it can be identified as a conflict
 		return err
 	}
+	decisions.WithLabelValues(decisionApproved).Inc()
 	a.log.Info("CSR approved", "CSR", a.csr.Name)
 	return nil
 }
 
+// validationDecision returns the decision label counting a CSR validated with the given result, or an empty string if
+// the validation did not reach a decision, as it failed with an error which may not occur when it is retried
+func validationDecision(validForApproval bool, validationErr error) string {
+	var invalidErr *invalidCSRErr
+	switch {
+	case errors.As(validationErr, &invalidErr):
+		return decisionRejected
+	case validationErr != nil:
+		return ""
+	case !validForApproval:
+		return decisionIgnored
+	}
+	return ""
+}
+
 // validateCSRContents returns true if the CSR request contents are valid.
 // If the CSR is not from a BYOH Windows instance, it returns false with no error.
-// If there is an error during validation, it returns false with the error.
+// If there is an error during validation, it returns false with the error. The error is an invalidCSRErr if the CSR is
+// from a BYOH Windows instance but its contents are invalid.
 func (a *Approver) validateCSRContents() (bool, error) {
 	parsedCSR, err := ParseCSR(a.csr.Spec.Request)
 	if err != nil {
@@ -171,11 +210,13 @@ func (a *Approver) validateCSRContents() (bool, error) {
 		if err != nil && !apierrors.IsNotFound(err) {
 			return false, fmt.Errorf("unable to get node %s: %w", nodeName, err)
 		} else if err == nil {
-			return false, fmt.Errorf("%s node already exists, cannot validate CSR: %s", nodeName, a.csr.Name)
+			return false, &invalidCSRErr{fmt.Errorf("%s node already exists, cannot validate CSR: %s", nodeName,
+				a.csr.Name)}
 		}
 	} else {
 		if err := a.validateKubeletServingCSR(parsedCSR); err != nil {
-			return false, fmt.Errorf("unable to validate kubelet serving CSR: %s: %w", a.csr.Name, err)
+			return false, &invalidCSRErr{fmt.Errorf("unable to validate kubelet serving CSR: %s: %w", a.csr.Name,
+				err)}
 		}
 	}
 	return true, nil
@@ -211,7 +252,7 @@ func (a *Approver) validateWithHostName(nodeName string, windowsInstances []*ins
 		return false, fmt.Errorf("unable to create signer from private key secret: %w", err)
 	}
 	// check if the node name matches any of the instances host names
-	hasEntry, err := matchesHostname(nodeName, windowsInstances, instanceSigner)
+	hasEntry, err := a.matchesHostname(nodeName, windowsInstances, instanceSigner)
 	if err != nil {
 		return false, fmt.Errorf("unable to map node name to the host names of Windows instances: %w", err)
 	}
@@ -225,8 +266,8 @@ func (a *Approver) validateWithHostName(nodeName string, windowsInstances []*ins
 		a.recorder.Eventf(a.csr, core.EventTypeWarning, "NodeNameValidationFailed",
 			"node name %s does not comply with naming rules defined in RFC1123: "+
 				"Requirements for internet hosts", nodeName)
-		return false, fmt.Errorf("node name %s should comply with naming rules defined in RFC1123: "+
-			"Requirements for internet hosts", nodeName)
+		return false, &invalidCSRErr{fmt.Errorf("node name %s should comply with naming rules defined in RFC1123: "+
+			"Requirements for internet hosts", nodeName)}
 	}
 	return true, nil
 }
@@ -300,10 +341,33 @@ func hasUsages(csr *certificates.CertificateSigningRequest, usages []certificate
 
 // matchesHostname returns true if given node name matches with host name of any of the instances present
 // in the given instance list
-func matchesHostname(nodeName string, windowsInstances []*instance.Info,
-	instanceSigner ssh.Signer) (bool, error) {
+func (a *Approver) matchesHostname(nodeName string, windowsInstances []*instance.Info,
+	defaultSigner ssh.Signer) (bool, error) {
+	operatorConfig, err := operatorconfig.Get(context.TODO(), a.client, a.namespace)
+	if err != nil {
+		return false, err
+	}
 	for _, instanceInfo := range windowsInstances {
-		hostName, err := findHostName(instanceInfo, instanceSigner)
+		instanceSigner, err := signer.ForInstance(instanceInfo, defaultSigner, a.namespace, a.client)
+		if err != nil {
+			return false, err
+		}
+		sshRoute, err := windows.NewSSHRoute(instanceInfo, operatorConfig.SSHRoute, instanceSigner,
+			func(secretName string) (ssh.Signer, error) {
+				return signer.Create(kubeTypes.NamespacedName{Namespace: a.namespace, Name: secretName}, a.client)
+			},
+			func(secretName string) (*url.Userinfo, error) {
+				return secrets.GetProxyCredentials(kubeTypes.NamespacedName{Namespace: a.namespace, Name: secretName},
+					a.client)
+			})
+		if err != nil {
+			return false, err
+		}
+		winrmTLSConfig, err := secrets.WinRMTLSConfigForInstance(instanceInfo, a.namespace, a.client)
+		if err != nil {
+			return false, err
+		}
+		hostName, err := findHostName(instanceInfo, instanceSigner, sshRoute, winrmTLSConfig)
 		if err != nil {
 			return false, fmt.Errorf("unable to find host name for instance with address %s: %w",
 				instanceInfo.Address, err)
@@ -317,9 +381,10 @@ func matchesHostname(nodeName string, windowsInstances []*instance.Info,
 }
 
 // findHostName returns the actual host name of the instance by running the 'hostname' command
-func findHostName(instanceInfo *instance.Info, instanceSigner ssh.Signer) (string, error) {
+func findHostName(instanceInfo *instance.Info, instanceSigner ssh.Signer, sshRoute *windows.SSHRoute,
+	winrmTLSConfig *tls.Config) (string, error) {
 	// We don't need to pass most args here as we just need to be able to run commands on the instance.
-	win, err := windows.New("", instanceInfo, instanceSigner, nil)
+	win, err := windows.New("", instanceInfo, instanceSigner, sshRoute, winrmTLSConfig, nil, 0)
 	if err != nil {
 		return "", fmt.Errorf("error instantiating Windows instance: %w", err)
 	}
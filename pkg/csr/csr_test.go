@@ -1,6 +1,7 @@
 package csr
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -169,3 +170,38 @@ func TestAddressesEqual(t *testing.T) {
 		})
 	}
 }
+
+func TestValidationDecision(t *testing.T) {
+	testCases := []struct {
+		name             string
+		validForApproval bool
+		validationErr    error
+		expected         string
+	}{
+		{
+			name:             "valid CSR",
+			validForApproval: true,
+			expected:         "",
+		},
+		{
+			name:     "CSR not from a Windows instance",
+			expected: decisionIgnored,
+		},
+		{
+			name: "invalid CSR from a Windows instance",
+			validationErr: fmt.Errorf("error validating node name: %w",
+				&invalidCSRErr{fmt.Errorf("node name is not RFC1123 compliant")}),
+			expected: decisionRejected,
+		},
+		{
+			name:          "retryable error",
+			validationErr: fmt.Errorf("unable to retrieve Windows instances"),
+			expected:      "",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, validationDecision(test.validForApproval, test.validationErr))
+		})
+	}
+}
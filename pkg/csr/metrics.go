@@ -0,0 +1,28 @@
+package csr
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// decisionApproved is the decision label of the CSRs approved by WMCO
+	decisionApproved = "approved"
+	// decisionRejected is the decision label of the CSRs of Windows instances WMCO did not approve, as they failed
+	// validation
+	decisionRejected = "rejected"
+	// decisionIgnored is the decision label of the CSRs which do not match any Windows instance, such as those of Linux
+	// nodes
+	decisionIgnored = "ignored"
+)
+
+// decisions counts the pending CSRs approved by WMCO, those it refused to approve and those it left to other
+// approvers. CSRs whose validation failed with an error which may not occur on retry are not counted.
+var decisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "wmco_csr_decisions_total",
+	Help: "Number of pending certificate signing requests approved, rejected or ignored by WMCO",
+}, []string{"decision"})
+
+func init() {
+	metrics.Registry.MustRegister(decisions)
+}
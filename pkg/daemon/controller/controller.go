@@ -31,6 +31,7 @@ import (
 	"golang.org/x/sys/windows/svc/mgr"
 	core "k8s.io/api/core/v1"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -53,6 +54,7 @@ import (
 
 	"github.com/openshift/windows-machine-config-operator/pkg/daemon/certs"
 	"github.com/openshift/windows-machine-config-operator/pkg/daemon/envvar"
+	"github.com/openshift/windows-machine-config-operator/pkg/daemon/jobobject"
 	"github.com/openshift/windows-machine-config-operator/pkg/daemon/manager"
 	"github.com/openshift/windows-machine-config-operator/pkg/daemon/powershell"
 	"github.com/openshift/windows-machine-config-operator/pkg/daemon/winsvc"
@@ -60,10 +62,22 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+	"github.com/openshift/windows-machine-config-operator/version"
 )
 
-// WICDController is the name of the WICD controller in logs and other outputs
-const WICDController = "WICD"
+const (
+	// WICDController is the name of the WICD controller in logs and other outputs
+	WICDController = "WICD"
+	// ServicesCrashLoopingCondition is a Node condition which is true while Windows services of the node are failing
+	// repeatedly
+	ServicesCrashLoopingCondition core.NodeConditionType = "WindowsServicesCrashLooping"
+	// ServicesUnhealthyCondition is a Node condition which is true while health probes of Windows services of the node
+	// are failing
+	ServicesUnhealthyCondition core.NodeConditionType = "WindowsServicesUnhealthy"
+	// FilesModifiedCondition is a Node condition which is true while files copied to the node by WMCO have been
+	// modified and not restored
+	FilesModifiedCondition core.NodeConditionType = "WindowsFilesModified"
+)
 
 // Options contains a list of options available when creating a new ServiceController
 type Options struct {
@@ -71,6 +85,7 @@ type Options struct {
 	Client    client.Client
 	Mgr       manager.Manager
 	cmdRunner powershell.CommandRunner
+	limiter   jobobject.Limiter
 	caBundle  string
 	recorder  record.EventRecorder
 }
@@ -102,6 +117,9 @@ func setDefaults(o Options) (Options, error) {
 	if o.cmdRunner == nil {
 		o.cmdRunner = powershell.NewCommandRunner()
 	}
+	if o.limiter == nil {
+		o.limiter = jobobject.NewLimiter()
+	}
 	return o, nil
 }
 
@@ -116,6 +134,14 @@ type ServiceController struct {
 	caBundle       string
 	// recorder to generate events
 	recorder record.EventRecorder
+	// crashLoops tracks the failures of the services managed by WICD
+	crashLoops *crashLoopDetector
+	// health runs the health probes of the services managed by WICD
+	health *healthProber
+	// limiter enforces the resource limits of the services managed by WICD
+	limiter jobobject.Limiter
+	// files verifies the files copied to the instance by WMCO
+	files *fileVerifier
 }
 
 // Bootstrap starts all Windows services marked as necessary for node bootstrapping as defined in the given data
@@ -189,7 +215,9 @@ func NewServiceController(ctx context.Context, nodeName, watchNamespace string,
 		return nil, err
 	}
 	return &ServiceController{client: o.Client, Manager: o.Mgr, ctx: ctx, nodeName: nodeName, psCmdRunner: o.cmdRunner,
-		watchNamespace: watchNamespace, caBundle: o.caBundle, recorder: o.recorder}, nil
+		limiter: o.limiter, watchNamespace: watchNamespace, caBundle: o.caBundle, recorder: o.recorder,
+		crashLoops: newCrashLoopDetector(), health: newHealthProber(ctx, healthMetricsPath),
+		files: newFileVerifier(windows.PayloadCacheDir, fileDriftMetricsPath)}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -225,6 +253,8 @@ func (sc *ServiceController) SetupWithManager(ctx context.Context, mgr ctrl.Mana
 	// This value is based on CVO's resync period
 	reconcilePeriod := 2 * time.Minute
 	eventChan := newPeriodicEventGenerator(ctx, reconcilePeriod)
+	// Failed services are noticed well before the next periodic reconcile
+	failureChan := sc.newServiceFailureEventGenerator(ctx, serviceStatePollPeriod)
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&core.Node{}, builder.WithPredicates(nodePredicate)).
@@ -232,6 +262,10 @@ func (sc *ServiceController) SetupWithManager(ctx context.Context, mgr ctrl.Mana
 			builder.WithPredicates(cmPredicate)).
 		WatchesRawSource(source.Channel(eventChan, handler.EnqueueRequestsFromMapFunc(sc.mapToCurrentNode),
 			source.WithPredicates[client.Object](rebootPredicate))).
+		WatchesRawSource(source.Channel(failureChan, handler.EnqueueRequestsFromMapFunc(sc.mapToCurrentNode),
+			source.WithPredicates[client.Object](rebootPredicate))).
+		WatchesRawSource(source.Channel(sc.health.events(), handler.EnqueueRequestsFromMapFunc(sc.mapToCurrentNode),
+			source.WithPredicates[client.Object](rebootPredicate))).
 		Complete(sc)
 }
 
@@ -281,8 +315,30 @@ func (sc *ServiceController) Reconcile(_ context.Context, req ctrl.Request) (res
 		klog.Info("waiting for reboot")
 		return ctrl.Result{}, nil
 	}
-	// Reconcile state of Windows services with the ConfigMap data
-	if err = sc.reconcileServices(cmData.Services); err != nil {
+	// Files are only verified once they have been copied by the WMCO version the ConfigMap data was generated by
+	if desiredVersion == version.Get() {
+		if err := sc.reconcileFiles(&node, cmData); err != nil {
+			klog.Errorf("unable to reconcile files copied by WMCO: %v", err)
+		}
+		if err := sc.reportFileDrift(&node); err != nil {
+			klog.Errorf("unable to report modified files: %v", err)
+		}
+	}
+	// Reconcile state of Windows services with the ConfigMap data. Services which keep failing or are unhealthy are
+	// reported whether or not they could be started.
+	sc.health.update(cmData.Services)
+	err = sc.reconcileServices(cmData.Services)
+	if reportErr := sc.reportCrashLoops(&node); reportErr != nil {
+		klog.Errorf("unable to report crash looping services: %v", reportErr)
+	}
+	if reportErr := sc.reportHealth(&node); reportErr != nil {
+		klog.Errorf("unable to report unhealthy services: %v", reportErr)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// Services no longer described by the ConfigMap, such as removed user-defined services, are removed
+	if err = sc.removeUnexpectedServices(cmData.Services); err != nil {
 		return ctrl.Result{}, err
 	}
 
@@ -293,7 +349,8 @@ func (sc *ServiceController) Reconcile(_ context.Context, req ctrl.Request) (res
 	if err = metadata.ApplyVersionAnnotation(sc.ctx, sc.client, node, desiredVersion); err != nil {
 		return ctrl.Result{}, fmt.Errorf("error updating version annotation on node %s: %w", sc.nodeName, err)
 	}
-	return ctrl.Result{}, nil
+	// Services left stopped due to their backoff are started once it expires
+	return ctrl.Result{RequeueAfter: sc.crashLoops.nextStartDelay()}, nil
 }
 
 // reconcileEnvVarsAndCerts ensures environment variables and certificates exist as expected, or are safely rectified.
@@ -381,8 +438,93 @@ func (sc *ServiceController) reconcileServices(services []servicescm.Service) er
 	return nil
 }
 
-// reconcileService ensures the given service is running and configured according to the expected definition given
+// removeUnexpectedServices removes the OpenShift managed services which are not among the given services. WICD is
+// managed by WMCO rather than through the services ConfigMap, and so is never removed.
+func (sc *ServiceController) removeUnexpectedServices(services []servicescm.Service) error {
+	existingSvcs, err := sc.GetServices()
+	if err != nil {
+		return fmt.Errorf("could not determine existing Windows services: %w", err)
+	}
+	expected := map[string]struct{}{windows.WicdServiceName: {}}
+	for _, service := range services {
+		expected[service.Name] = struct{}{}
+	}
+	for name := range existingSvcs {
+		if _, present := expected[name]; present {
+			continue
+		}
+		managed, err := sc.isManagedService(name)
+		if err != nil {
+			return err
+		}
+		if !managed {
+			continue
+		}
+		klog.Infof("removing service %s", name)
+		if err := sc.DeleteService(name); err != nil {
+			return err
+		}
+		sc.crashLoops.forget(name)
+		sc.limiter.Release(name)
+	}
+	return nil
+}
+
+// isManagedService returns true if the service of the given name is described as OpenShift managed
+func (sc *ServiceController) isManagedService(name string) (bool, error) {
+	service, err := sc.OpenService(name)
+	if err != nil {
+		return false, fmt.Errorf("error opening service %s: %w", name, err)
+	}
+	defer service.Close()
+	config, err := service.Config()
+	if err != nil {
+		return false, fmt.Errorf("error getting config of service %s: %w", name, err)
+	}
+	return config.Description == fmt.Sprintf("%s %s", windows.ManagedTag, name), nil
+}
+
+// stopService stops the given service, which stops the services depending on it as well. The dependent services which
+// were running are recorded as stopped by WICD, so that they are not seen as having failed.
+func (sc *ServiceController) stopService(service winsvc.Service, name string) error {
+	running := sc.crashLoops.runningServices()
+	if err := sc.EnsureServiceState(service, svc.Stopped); err != nil {
+		return err
+	}
+	for _, dependent := range running {
+		if dependent == name {
+			continue
+		}
+		if status, err := sc.serviceStatus(dependent); err == nil && status.State != svc.Running {
+			sc.crashLoops.stopped(dependent)
+		}
+	}
+	return nil
+}
+
+// reconcileService ensures the given service is running and configured according to the expected definition given.
+// A running service whose health probe failed persistently is stopped, and is handled as a failed service. A service
+// which failed is only started once its backoff expires.
 func (sc *ServiceController) reconcileService(service winsvc.Service, expected servicescm.Service) error {
+	status, err := service.Query()
+	if err != nil {
+		return fmt.Errorf("error querying service %s: %w", expected.Name, err)
+	}
+	if sc.crashLoops.observe(expected.Name, status) {
+		klog.Infof("service %s failed", expected.Name)
+	}
+	if status.State == svc.Running && sc.health.isUnhealthy(expected.Name) {
+		klog.Infof("restarting service %s as its health probe is failing", expected.Name)
+		if err := sc.stopService(service, expected.Name); err != nil {
+			return err
+		}
+		sc.health.restarted(expected.Name)
+		status, err = service.Query()
+		if err != nil {
+			return fmt.Errorf("error querying service %s: %w", expected.Name, err)
+		}
+		sc.crashLoops.observe(expected.Name, status)
+	}
 	config, err := service.Config()
 	if err != nil {
 		return err
@@ -409,19 +551,84 @@ func (sc *ServiceController) reconcileService(service winsvc.Service, expected s
 		updateRequired = true
 	}
 
+	if !accountsEquivalent(config.ServiceStartName, expected.Account()) {
+		// The accounts services can log on as do not require a password
+		config.ServiceStartName = expected.Account()
+		config.Password = ""
+		updateRequired = true
+	}
+
 	if updateRequired {
 		klog.Infof("updating service %s", expected.Name)
 		// Always ensure the service isn't running before updating its config, just to be safe
-		if err := sc.EnsureServiceState(service, svc.Stopped); err != nil {
+		if err := sc.stopService(service, expected.Name); err != nil {
 			return err
 		}
+		sc.crashLoops.stopped(expected.Name)
 		err = service.UpdateConfig(config)
 		if err != nil {
 			return fmt.Errorf("error updating service config: %w", err)
 		}
 	}
+	if err := reconcileRecoveryPolicy(service, expected.Recovery); err != nil {
+		return fmt.Errorf("error updating recovery actions of service %s: %w", expected.Name, err)
+	}
+	if status.State != svc.Running {
+		if delay := sc.crashLoops.delayStart(expected.Name); delay > 0 {
+			klog.Infof("delaying start of service %s by %s", expected.Name, delay.Round(time.Second))
+			return nil
+		}
+	}
 	// always ensure service is started
-	return sc.EnsureServiceState(service, svc.Running)
+	if err := sc.EnsureServiceState(service, svc.Running); err != nil {
+		return err
+	}
+	if status.State != svc.Running {
+		// A service started by WICD is given time to become healthy
+		sc.health.reset(expected.Name)
+	}
+	status, err = service.Query()
+	if err != nil {
+		return fmt.Errorf("error querying service %s: %w", expected.Name, err)
+	}
+	sc.crashLoops.started(expected.Name, status)
+	if err := sc.limiter.Apply(expected.Name, status.ProcessId, expected.Limits); err != nil {
+		return fmt.Errorf("error limiting resources of service %s: %w", expected.Name, err)
+	}
+	return nil
+}
+
+// reconcileRecoveryPolicy ensures the service control manager reacts to the given service failing as described by
+// the given recovery policy, taking no action if the policy is nil
+func reconcileRecoveryPolicy(service winsvc.Service, policy *servicescm.RecoveryPolicy) error {
+	actions, err := service.RecoveryActions()
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		if len(actions) == 0 {
+			return nil
+		}
+		return service.ResetRecoveryActions()
+	}
+	resetPeriod, err := service.ResetPeriod()
+	if err != nil {
+		return err
+	}
+	expectedActions := make([]mgr.RecoveryAction, 0, len(policy.Actions))
+	for _, action := range policy.Actions {
+		actionType := mgr.NoAction
+		if action.Type == servicescm.RecoveryActionRestart {
+			actionType = mgr.ServiceRestart
+		}
+		expectedActions = append(expectedActions, mgr.RecoveryAction{Type: actionType,
+			Delay: time.Duration(action.DelaySeconds) * time.Second})
+	}
+	if resetPeriod == policy.ResetPeriodSeconds && len(actions) == len(expectedActions) &&
+		(len(actions) == 0 || reflect.DeepEqual(actions, expectedActions)) {
+		return nil
+	}
+	return service.SetRecoveryActions(expectedActions, policy.ResetPeriodSeconds)
 }
 
 // expectedServiceCommand returns the full command that the given service should run with
@@ -514,6 +721,45 @@ func (sc *ServiceController) waitUntilNodeReady() error {
 		})
 }
 
+// setNodeCondition sets the given condition on the given node, keeping its transition time if its status is unchanged.
+// A condition which is not True is only set if the node already has a condition of its type.
+func (sc *ServiceController) setNodeCondition(node *core.Node, condition core.NodeCondition) error {
+	index := -1
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == condition.Type {
+			index = i
+			break
+		}
+	}
+	now := meta.Now()
+	condition.LastHeartbeatTime = now
+	condition.LastTransitionTime = now
+	if index == -1 {
+		if condition.Status != core.ConditionTrue {
+			return nil
+		}
+	} else {
+		existing := node.Status.Conditions[index]
+		if existing.Status == condition.Status && existing.Message == condition.Message {
+			return nil
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+	}
+
+	patch := client.StrategicMergeFrom(node.DeepCopy())
+	if index == -1 {
+		node.Status.Conditions = append(node.Status.Conditions, condition)
+	} else {
+		node.Status.Conditions[index] = condition
+	}
+	if err := sc.client.Status().Patch(sc.ctx, node, patch); err != nil {
+		return fmt.Errorf("error setting %s condition of node %s: %w", condition.Type, node.GetName(), err)
+	}
+	return nil
+}
+
 // newPeriodicEventGenerator returns a channel which will have an empty event sent on it at an interval specified by the
 // given period
 func newPeriodicEventGenerator(ctx context.Context, period time.Duration) <-chan event.GenericEvent {
@@ -618,6 +864,18 @@ func slicesEquivalent(s1, s2 []string) bool {
 
 }
 
+// accountsEquivalent returns true if the given service logon accounts are the same account. An empty account is
+// LocalSystem, as services created without an account log on as LocalSystem.
+func accountsEquivalent(a1, a2 string) bool {
+	if a1 == "" {
+		a1 = servicescm.LocalSystemAccount
+	}
+	if a2 == "" {
+		a2 = servicescm.LocalSystemAccount
+	}
+	return strings.EqualFold(a1, a2)
+}
+
 // isAwaitingReboot returns true if the given object is a node that is awaiting a reboot by WMCO
 func isAwaitingReboot(obj runtime.Object) bool {
 	node, ok := obj.(*core.Node)
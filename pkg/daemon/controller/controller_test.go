@@ -8,6 +8,7 @@ import (
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -363,6 +364,30 @@ func TestReconcileService(t *testing.T) {
 			},
 			expectErr: false,
 		},
+		{
+			name: "Service logon account corrected",
+			service: fake.NewFakeService(
+				"fakeservice",
+				mgr.Config{
+					BinaryPathName:   "fakeservice",
+					Description:      "OpenShift managed fakeservice",
+					ServiceStartName: "LocalSystem",
+				},
+				svc.Status{
+					State: svc.Running,
+				}),
+			expectedService: servicescm.Service{
+				Name:    "fakeservice",
+				Command: "fakeservice",
+				RunAs:   "NT AUTHORITY\\NetworkService",
+			},
+			expectedServiceConfig: mgr.Config{
+				BinaryPathName:   "fakeservice",
+				Description:      "OpenShift managed fakeservice",
+				ServiceStartName: "NT AUTHORITY\\NetworkService",
+			},
+			expectErr: false,
+		},
 	}
 	for _, test := range testIO {
 		t.Run(test.name, func(t *testing.T) {
@@ -395,6 +420,95 @@ func TestReconcileService(t *testing.T) {
 	}
 }
 
+// fakeLimiter records the resource limits applied to each service
+type fakeLimiter struct {
+	limits map[string]*servicescm.ResourceLimits
+}
+
+func (f *fakeLimiter) Apply(service string, _ uint32, limits *servicescm.ResourceLimits) error {
+	f.limits[service] = limits
+	return nil
+}
+
+func (f *fakeLimiter) Release(service string) {
+	delete(f.limits, service)
+}
+
+func TestReconcileServiceLimits(t *testing.T) {
+	limits := &servicescm.ResourceLimits{CPUPercent: 10, MemoryBytes: 512 * 1024 * 1024}
+	service := fake.NewFakeService("fakeservice", mgr.Config{}, svc.Status{State: svc.Stopped})
+	limiter := &fakeLimiter{limits: make(map[string]*servicescm.ResourceLimits)}
+	c, err := NewServiceController(context.TODO(), "node", wmcoNamespace, Options{
+		Client:  clientfake.NewClientBuilder().Build(),
+		Mgr:     fake.NewTestMgr(map[string]*fake.FakeService{"fakeservice": service}),
+		limiter: limiter,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, c.reconcileService(service,
+		servicescm.Service{Name: "fakeservice", Command: "fakeservice", Limits: limits}))
+	assert.Equal(t, limits, limiter.limits["fakeservice"])
+
+	require.NoError(t, c.reconcileService(service, servicescm.Service{Name: "fakeservice", Command: "fakeservice"}))
+	assert.Contains(t, limiter.limits, "fakeservice")
+	assert.Nil(t, limiter.limits["fakeservice"], "limits should be lifted once removed")
+}
+
+func TestReconcileServiceUpdateIsNotFailure(t *testing.T) {
+	running := svc.Status{State: svc.Running, ProcessId: 1}
+	service := fake.NewFakeService("kubelet", mgr.Config{BinaryPathName: "old-kubelet.exe"}, running)
+	dependent := fake.NewFakeService("kube-proxy", mgr.Config{BinaryPathName: "kube-proxy.exe",
+		Dependencies: []string{"kubelet"}}, svc.Status{State: svc.Running, ProcessId: 2})
+	c, err := NewServiceController(context.TODO(), "node", wmcoNamespace, Options{
+		Client: clientfake.NewClientBuilder().Build(),
+		Mgr:    fake.NewTestMgr(map[string]*fake.FakeService{"kubelet": service, "kube-proxy": dependent}),
+	})
+	require.NoError(t, err)
+	c.crashLoops.started("kubelet", running)
+	c.crashLoops.started("kube-proxy", svc.Status{State: svc.Running, ProcessId: 2})
+
+	// Services stopped to update the config of a service are not seen as failed
+	require.NoError(t, c.reconcileService(service, servicescm.Service{Name: "kubelet", Command: "kubelet.exe"}))
+	status, err := dependent.Query()
+	require.NoError(t, err)
+	assert.Equal(t, svc.Stopped, status.State)
+	assert.False(t, c.crashLoops.observe("kube-proxy", status))
+	assert.Zero(t, c.crashLoops.delayStart("kube-proxy"))
+	assert.Zero(t, c.crashLoops.delayStart("kubelet"))
+}
+
+func TestAccountsEquivalent(t *testing.T) {
+	assert.True(t, accountsEquivalent("", "LocalSystem"))
+	assert.True(t, accountsEquivalent("localsystem", ""))
+	assert.True(t, accountsEquivalent("NT AUTHORITY\\NetworkService", "NT Authority\\networkservice"))
+	assert.False(t, accountsEquivalent("", "NT AUTHORITY\\NetworkService"))
+}
+
+func TestReconcileRecoveryPolicy(t *testing.T) {
+	policy := &servicescm.RecoveryPolicy{
+		Actions: []servicescm.RecoveryAction{
+			{Type: servicescm.RecoveryActionRestart, DelaySeconds: 10},
+			{Type: servicescm.RecoveryActionNone},
+		},
+		ResetPeriodSeconds: 300,
+	}
+	service := fake.NewFakeService("fakeservice", mgr.Config{}, svc.Status{State: svc.Running})
+
+	require.NoError(t, reconcileRecoveryPolicy(service, policy))
+	actions, err := service.RecoveryActions()
+	require.NoError(t, err)
+	assert.Equal(t, []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.NoAction}}, actions)
+	resetPeriod, err := service.ResetPeriod()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(300), resetPeriod)
+
+	require.NoError(t, reconcileRecoveryPolicy(service, nil))
+	actions, err = service.RecoveryActions()
+	require.NoError(t, err)
+	assert.Empty(t, actions)
+}
+
 func TestBootstrap(t *testing.T) {
 	testIO := []struct {
 		name                         string
@@ -634,6 +748,31 @@ func TestReconcile(t *testing.T) {
 			expectedServicesNameCmdPairs: map[string]string{"test1": "test1 arg1", "test2": "test2 arg1 arg2"},
 			expectErr:                    false,
 		},
+		{
+			name: "Managed services which are not in the ConfigMap are removed",
+			existingServices: map[string]*fake.FakeService{
+				"removed": fake.NewFakeService("removed",
+					mgr.Config{BinaryPathName: "removed arg1", Description: "OpenShift managed removed"},
+					svc.Status{State: svc.Running}),
+				"unmanaged": fake.NewFakeService("unmanaged",
+					mgr.Config{BinaryPathName: "unmanaged arg1"}, svc.Status{State: svc.Running}),
+				"windows-instance-config-daemon": fake.NewFakeService("windows-instance-config-daemon",
+					mgr.Config{BinaryPathName: "wicd", Description: "OpenShift managed windows-instance-config-daemon"},
+					svc.Status{State: svc.Running}),
+			},
+			configMapServices: []servicescm.Service{
+				{
+					Name:         "test1",
+					Command:      "test1 arg1",
+					Dependencies: nil,
+					Bootstrap:    false,
+					Priority:     0,
+				},
+			},
+			expectedServicesNameCmdPairs: map[string]string{"test1": "test1 arg1", "unmanaged": "unmanaged arg1",
+				"windows-instance-config-daemon": "wicd"},
+			expectErr: false,
+		},
 		{
 			name:                         "Reboot annotation is applied when env vars are set",
 			configMapServices:            []servicescm.Service{},
@@ -0,0 +1,292 @@
+//go:build windows
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	// crashLoopThreshold is the number of failures within crashLoopWindow after which a service is crash looping
+	crashLoopThreshold = 3
+	// crashLoopWindow is the time without failures after which the failure count of a service is reset
+	crashLoopWindow = 10 * time.Minute
+	// initialStartBackoff is the time WICD waits before starting a service which failed once
+	initialStartBackoff = 10 * time.Second
+	// maxStartBackoff is the longest time WICD waits before starting a failed service
+	maxStartBackoff = 5 * time.Minute
+	// serviceStatePollPeriod is how often the services managed by WICD are checked for failures
+	serviceStatePollPeriod = 15 * time.Second
+)
+
+// serviceFailures tracks the failures of a Windows service
+type serviceFailures struct {
+	// running is true if the service was running when last seen
+	running bool
+	// pid is the ID of the process of the service when last seen running
+	pid uint32
+	// count is the number of failures since the failure count was last reset
+	count int
+	// lastFailure is when the service was last seen failing
+	lastFailure time.Time
+	// awaitingStart is true if the service was left stopped until its backoff expires
+	awaitingStart bool
+	// reported is true if the service has been reported as crash looping
+	reported bool
+}
+
+// crashLoopDetector detects Windows services which keep failing, either by stopping or by being restarted by the
+// service control manager, and determines how long WICD should wait before starting them again
+type crashLoopDetector struct {
+	mu       sync.Mutex
+	services map[string]*serviceFailures
+	// now returns the current time
+	now func() time.Time
+}
+
+// newCrashLoopDetector returns a crashLoopDetector tracking no services
+func newCrashLoopDetector() *crashLoopDetector {
+	return &crashLoopDetector{services: make(map[string]*serviceFailures), now: time.Now}
+}
+
+// get returns the failures of the given service, tracking it if it is not tracked yet. The caller must hold the lock.
+func (d *crashLoopDetector) get(name string) *serviceFailures {
+	failures, present := d.services[name]
+	if !present {
+		failures = &serviceFailures{}
+		d.services[name] = failures
+	}
+	return failures
+}
+
+// hasFailed returns true if the given status shows that the service failed since it was last seen running
+func (f *serviceFailures) hasFailed(status svc.Status) bool {
+	return f.running && (status.State != svc.Running || status.ProcessId != f.pid)
+}
+
+// observe records the given status of the given service, returning true if the service failed since it was last seen
+// running
+func (d *crashLoopDetector) observe(name string, status svc.Status) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	failures := d.get(name)
+	failed := failures.hasFailed(status)
+	if failed {
+		now := d.now()
+		if now.Sub(failures.lastFailure) > crashLoopWindow {
+			failures.count = 0
+		}
+		failures.count++
+		failures.lastFailure = now
+	}
+	failures.running = status.State == svc.Running
+	failures.pid = status.ProcessId
+	return failed
+}
+
+// started records that the given service was started by WICD, and now has the given status
+func (d *crashLoopDetector) started(name string, status svc.Status) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	failures := d.get(name)
+	failures.running = status.State == svc.Running
+	failures.pid = status.ProcessId
+	failures.awaitingStart = false
+}
+
+// stopped records that the given service was stopped by WICD, which is not a failure
+func (d *crashLoopDetector) stopped(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	failures := d.get(name)
+	failures.running = false
+	failures.pid = 0
+}
+
+// runningServices returns the names of the services which were running when last seen, sorted
+func (d *crashLoopDetector) runningServices() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var names []string
+	for name, failures := range d.services {
+		if failures.running {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// delayStart returns how long WICD should wait before starting the given stopped service. The service is recorded as
+// awaiting its start if the returned delay is not zero.
+func (d *crashLoopDetector) delayStart(name string) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	failures := d.get(name)
+	if failures.count == 0 {
+		return 0
+	}
+	delay := failures.lastFailure.Add(startBackoff(failures.count)).Sub(d.now())
+	if delay <= 0 {
+		return 0
+	}
+	failures.awaitingStart = true
+	return delay
+}
+
+// nextStartDelay returns how long until the backoff of the first service awaiting its start expires, or zero if no
+// service is awaiting its start
+func (d *crashLoopDetector) nextStartDelay() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var next time.Duration
+	for _, failures := range d.services {
+		if !failures.awaitingStart {
+			continue
+		}
+		delay := failures.lastFailure.Add(startBackoff(failures.count)).Sub(d.now())
+		if delay <= 0 {
+			// Expired backoffs are handled by the next reconcile as soon as possible
+			delay = time.Second
+		}
+		if next == 0 || delay < next {
+			next = delay
+		}
+	}
+	return next
+}
+
+// forget stops tracking the given service
+func (d *crashLoopDetector) forget(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.services, name)
+}
+
+// names returns the names of the tracked services
+func (d *crashLoopDetector) names() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	names := make([]string, 0, len(d.services))
+	for name := range d.services {
+		names = append(names, name)
+	}
+	return names
+}
+
+// changed returns true if the given status of the given service shows that it failed since it was last observed,
+// without recording the status
+func (d *crashLoopDetector) changed(name string, status svc.Status) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	failures, present := d.services[name]
+	return present && failures.hasFailed(status)
+}
+
+// report returns the names of the crash looping services, and the names of those which were not reported as crash
+// looping before, both sorted. A service is crash looping if it failed at least crashLoopThreshold times, without
+// crashLoopWindow passing between failures.
+func (d *crashLoopDetector) report() ([]string, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var crashLooping, newlyCrashLooping []string
+	for name, failures := range d.services {
+		if failures.count < crashLoopThreshold || d.now().Sub(failures.lastFailure) > crashLoopWindow {
+			failures.reported = false
+			continue
+		}
+		crashLooping = append(crashLooping, name)
+		if !failures.reported {
+			failures.reported = true
+			newlyCrashLooping = append(newlyCrashLooping, name)
+		}
+	}
+	sort.Strings(crashLooping)
+	sort.Strings(newlyCrashLooping)
+	return crashLooping, newlyCrashLooping
+}
+
+// startBackoff returns how long WICD waits before starting a service which failed the given number of times, doubling
+// with each failure
+func startBackoff(failures int) time.Duration {
+	backoff := initialStartBackoff
+	for i := 1; i < failures && backoff < maxStartBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxStartBackoff {
+		return maxStartBackoff
+	}
+	return backoff
+}
+
+// reportCrashLoops sets the ServicesCrashLoopingCondition of the given node to reflect the services which are crash
+// looping, generating an event for each service which started crash looping. The condition is only added to the node
+// once a service starts crash looping.
+func (sc *ServiceController) reportCrashLoops(node *core.Node) error {
+	crashLooping, newlyCrashLooping := sc.crashLoops.report()
+	for _, name := range newlyCrashLooping {
+		sc.recorder.Eventf(node, core.EventTypeWarning, "ServiceCrashLooping",
+			"Windows service %s is failing repeatedly, and is restarted with an increasing delay", name)
+	}
+	condition := core.NodeCondition{
+		Type:    ServicesCrashLoopingCondition,
+		Status:  core.ConditionFalse,
+		Reason:  "ServicesStable",
+		Message: "No Windows service is failing repeatedly",
+	}
+	if len(crashLooping) > 0 {
+		condition.Status = core.ConditionTrue
+		condition.Reason = "ServicesCrashLooping"
+		condition.Message = fmt.Sprintf("Windows services failing repeatedly: %s", strings.Join(crashLooping, ", "))
+	}
+	return sc.setNodeCondition(node, condition)
+}
+
+// newServiceFailureEventGenerator returns a channel which will have an empty event sent on it whenever a service
+// managed by WICD is found to have failed, checking the services at an interval specified by the given period
+func (sc *ServiceController) newServiceFailureEventGenerator(ctx context.Context,
+	period time.Duration) <-chan event.GenericEvent {
+	eventChan := make(chan event.GenericEvent)
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				close(eventChan)
+				return
+			case <-ticker.C:
+				if sc.anyServiceFailed() {
+					eventChan <- event.GenericEvent{}
+				}
+			}
+		}
+	}()
+	return eventChan
+}
+
+// anyServiceFailed returns true if any service managed by WICD failed since it was last reconciled
+func (sc *ServiceController) anyServiceFailed() bool {
+	for _, name := range sc.crashLoops.names() {
+		service, err := sc.OpenService(name)
+		if err != nil {
+			// Services which cannot be opened are handled by the next reconcile
+			continue
+		}
+		status, err := service.Query()
+		service.Close()
+		if err == nil && sc.crashLoops.changed(name, status) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+//go:build windows
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows/svc"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/daemon/fake"
+)
+
+func TestCrashLoopDetector(t *testing.T) {
+	now := time.Now()
+	d := newCrashLoopDetector()
+	d.now = func() time.Time { return now }
+	running := func(pid uint32) svc.Status { return svc.Status{State: svc.Running, ProcessId: pid} }
+	stopped := svc.Status{State: svc.Stopped}
+
+	// A service which was never seen running has not failed
+	assert.False(t, d.observe("kubelet", stopped))
+	assert.Zero(t, d.delayStart("kubelet"))
+	d.started("kubelet", running(1))
+	assert.False(t, d.observe("kubelet", running(1)))
+
+	// A stopped service is delayed by the backoff, doubling with each failure
+	assert.True(t, d.changed("kubelet", stopped))
+	assert.True(t, d.observe("kubelet", stopped))
+	assert.False(t, d.changed("kubelet", stopped), "a failure should only be seen once")
+	assert.Equal(t, initialStartBackoff, d.delayStart("kubelet"))
+	assert.Equal(t, initialStartBackoff, d.nextStartDelay())
+	now = now.Add(initialStartBackoff)
+	assert.Zero(t, d.delayStart("kubelet"))
+	d.started("kubelet", running(2))
+	assert.Zero(t, d.nextStartDelay())
+
+	// A service restarted by the service control manager has failed
+	assert.True(t, d.observe("kubelet", running(3)))
+	crashLooping, newlyCrashLooping := d.report()
+	assert.Empty(t, crashLooping)
+	assert.Empty(t, newlyCrashLooping)
+	assert.True(t, d.observe("kubelet", stopped))
+	assert.Equal(t, 4*initialStartBackoff, d.delayStart("kubelet"))
+
+	crashLooping, newlyCrashLooping = d.report()
+	assert.Equal(t, []string{"kubelet"}, crashLooping)
+	assert.Equal(t, []string{"kubelet"}, newlyCrashLooping)
+	crashLooping, newlyCrashLooping = d.report()
+	assert.Equal(t, []string{"kubelet"}, crashLooping)
+	assert.Empty(t, newlyCrashLooping, "a crash loop should only be reported once")
+
+	// Crash loops end once a window passes without failures, which resets the failure count
+	now = now.Add(crashLoopWindow + time.Second)
+	crashLooping, _ = d.report()
+	assert.Empty(t, crashLooping)
+	d.started("kubelet", running(4))
+	assert.True(t, d.observe("kubelet", stopped))
+	assert.Equal(t, initialStartBackoff, d.delayStart("kubelet"))
+
+	d.forget("kubelet")
+	assert.Empty(t, d.names())
+}
+
+func TestStartBackoff(t *testing.T) {
+	assert.Equal(t, 10*time.Second, startBackoff(1))
+	assert.Equal(t, 20*time.Second, startBackoff(2))
+	assert.Equal(t, 160*time.Second, startBackoff(5))
+	assert.Equal(t, maxStartBackoff, startBackoff(6))
+	assert.Equal(t, maxStartBackoff, startBackoff(100))
+}
+
+func TestReportCrashLoops(t *testing.T) {
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "node"}}
+	recorder := record.NewFakeRecorder(10)
+	c, err := NewServiceController(context.TODO(), "node", wmcoNamespace, Options{
+		Client:   clientfake.NewClientBuilder().WithObjects(node).WithStatusSubresource(node).Build(),
+		Mgr:      fake.NewTestMgr(nil),
+		recorder: recorder,
+	})
+	require.NoError(t, err)
+	getCondition := func() *core.NodeCondition {
+		require.NoError(t, c.client.Get(c.ctx, client.ObjectKey{Name: "node"}, node))
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == ServicesCrashLoopingCondition {
+				return &condition
+			}
+		}
+		return nil
+	}
+
+	// The condition is not added while no service is crash looping
+	require.NoError(t, c.reportCrashLoops(node))
+	assert.Nil(t, getCondition())
+
+	c.crashLoops.started("containerd", svc.Status{State: svc.Running, ProcessId: 1})
+	for i := 0; i < crashLoopThreshold; i++ {
+		c.crashLoops.observe("containerd", svc.Status{State: svc.Stopped})
+		c.crashLoops.started("containerd", svc.Status{State: svc.Running, ProcessId: uint32(i + 2)})
+	}
+	require.NoError(t, c.reportCrashLoops(node))
+	condition := getCondition()
+	require.NotNil(t, condition)
+	assert.Equal(t, core.ConditionTrue, condition.Status)
+	assert.Contains(t, condition.Message, "containerd")
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "ServiceCrashLooping")
+
+	c.crashLoops.forget("containerd")
+	require.NoError(t, c.reportCrashLoops(node))
+	condition = getCondition()
+	require.NotNil(t, condition)
+	assert.Equal(t, core.ConditionFalse, condition.Status)
+}
@@ -0,0 +1,424 @@
+//go:build windows
+
+package controller
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/windows/svc"
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+)
+
+const (
+	// fileVerificationPeriod is how often the files copied by WMCO are verified against their expected checksums
+	fileVerificationPeriod = 10 * time.Minute
+	// fileDriftMetricsPath is the file the file drift metrics are written to, which is exposed by the textfile
+	// collector of windows_exporter
+	fileDriftMetricsPath = windows.WindowsExporterTextfileDir + "\\wicd_file_drift.prom"
+	// restoreSuffix is the suffix of the temporary copy of a file being restored
+	restoreSuffix = ".wicd-restore"
+	// replacedSuffix is the suffix a modified file is renamed to when it cannot be overwritten, as is the case for
+	// executables which are running. Replaced files are removed once no longer in use.
+	replacedSuffix = ".wicd-replaced"
+)
+
+// errNotCached is returned when a file cannot be restored as the payload cache does not hold a valid copy of it
+var errNotCached = errors.New("payload cache does not hold the expected contents")
+
+// fileDrift describes a file copied by WMCO which no longer has its expected contents
+type fileDrift struct {
+	file servicescm.FileInfo
+	err  error
+}
+
+// fileVerifier periodically verifies that the files copied to the instance by WMCO have their expected checksums, and
+// restores modified files from the payload cache
+type fileVerifier struct {
+	mu sync.Mutex
+	// cacheDir is the payload cache files are restored from
+	cacheDir string
+	// lastVerified is when the files were last verified
+	lastVerified time.Time
+	// drifted are the files found to be modified by the last verification, by path
+	drifted map[string]error
+	// awaitingRepush is true if WMCO was asked to populate the payload cache again
+	awaitingRepush bool
+	// deferredUntil is the start of the maintenance window restorations requiring service restarts were deferred to
+	deferredUntil time.Time
+	// now returns the current time
+	now func() time.Time
+	// metricsPath is the file the file drift metrics are written to. Metrics are not written if it is empty.
+	metricsPath  string
+	registry     *prometheus.Registry
+	driftGauge   *prometheus.GaugeVec
+	restoreCount *prometheus.CounterVec
+}
+
+// newFileVerifier returns a fileVerifier which has not verified any file yet, restoring files from the given payload
+// cache and writing its metrics to the given file
+func newFileVerifier(cacheDir, metricsPath string) *fileVerifier {
+	v := &fileVerifier{
+		cacheDir:    cacheDir,
+		drifted:     make(map[string]error),
+		now:         time.Now,
+		metricsPath: metricsPath,
+		registry:    prometheus.NewRegistry(),
+		driftGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wicd_file_drift",
+			Help: "Whether a file copied by WMCO was modified (1) or has its expected checksum (0)",
+		}, []string{"path"}),
+		restoreCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wicd_file_restores_total",
+			Help: "Number of times a modified file copied by WMCO was restored by WICD",
+		}, []string{"path"}),
+	}
+	v.registry.MustRegister(v.driftGauge, v.restoreCount)
+	return v
+}
+
+// due returns true if the files should be verified, either as the verification period passed, as WMCO populated the
+// payload cache again since the last verification, or as the maintenance window restorations were deferred to started
+func (v *fileVerifier) due(repushPending bool) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	return now.Sub(v.lastVerified) >= fileVerificationPeriod || (v.awaitingRepush && !repushPending) ||
+		(!v.deferredUntil.IsZero() && !now.Before(v.deferredUntil))
+}
+
+// verify compares the given files against their expected checksums, returning the files which were modified and
+// recording them as drifted. Only the files which were not modified when last verified are returned as newly drifted.
+func (v *fileVerifier) verify(files []servicescm.FileInfo) ([]fileDrift, []fileDrift) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lastVerified = v.now()
+	v.awaitingRepush = false
+	v.deferredUntil = time.Time{}
+	previouslyDrifted := v.drifted
+	v.drifted = make(map[string]error)
+	v.driftGauge.Reset()
+	var drifted, newlyDrifted []fileDrift
+	for _, file := range files {
+		// Files replaced by a previous restoration can be removed once the process using them has been restarted
+		if err := os.Remove(file.Path + replacedSuffix); err != nil && !os.IsNotExist(err) {
+			klog.V(1).Infof("unable to remove replaced file %s: %v", file.Path+replacedSuffix, err)
+		}
+		if err := verifyChecksum(file.Path, file.Checksum); err != nil {
+			drift := fileDrift{file: file, err: err}
+			drifted = append(drifted, drift)
+			if _, present := previouslyDrifted[file.Path]; !present {
+				newlyDrifted = append(newlyDrifted, drift)
+			}
+			v.drifted[file.Path] = err
+			v.driftGauge.WithLabelValues(file.Path).Set(1)
+			continue
+		}
+		v.driftGauge.WithLabelValues(file.Path).Set(0)
+	}
+	v.writeMetrics()
+	return drifted, newlyDrifted
+}
+
+// restore replaces the given file with its copy from the payload cache. errNotCached is returned if the payload cache
+// does not hold a copy with the expected checksum.
+func (v *fileVerifier) restore(file servicescm.FileInfo) error {
+	cachePath := filepath.Join(v.cacheDir, file.Checksum)
+	if err := verifyChecksum(cachePath, file.Checksum); err != nil {
+		return fmt.Errorf("%w: %v", errNotCached, err)
+	}
+	if err := replaceFile(cachePath, file.Path); err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.drifted, file.Path)
+	v.driftGauge.WithLabelValues(file.Path).Set(0)
+	v.restoreCount.WithLabelValues(file.Path).Inc()
+	v.writeMetrics()
+	return nil
+}
+
+// repushRequested records that WMCO was asked to populate the payload cache again, so that the files are verified
+// again once it has
+func (v *fileVerifier) repushRequested() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.awaitingRepush = true
+}
+
+// restoreDeferred records that restorations were deferred to the maintenance window starting at the given time, so that
+// the files are verified again once it starts
+func (v *fileVerifier) restoreDeferred(nextWindow time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.deferredUntil = nextWindow
+}
+
+// report returns the paths of the files which were modified and not restored, sorted
+func (v *fileVerifier) report() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	paths := make([]string, 0, len(v.drifted))
+	for path := range v.drifted {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// writeMetrics writes the file drift metrics to the metrics file. The caller must hold the lock.
+func (v *fileVerifier) writeMetrics() {
+	if v.metricsPath == "" {
+		return
+	}
+	if err := prometheus.WriteToTextfile(v.metricsPath, v.registry); err != nil {
+		klog.Errorf("unable to write file drift metrics to %s: %v", v.metricsPath, err)
+	}
+}
+
+// verifyChecksum returns an error if the file at the given path does not exist or does not have the given SHA256
+func verifyChecksum(path, checksum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if actual := fmt.Sprintf("%x", hash.Sum(nil)); actual != checksum {
+		return fmt.Errorf("%s has checksum %s, expected %s", path, actual, checksum)
+	}
+	return nil
+}
+
+// replaceFile replaces the destination with a copy of the source file. A destination which cannot be overwritten, such
+// as a running executable, is renamed out of the way first.
+func replaceFile(source, destination string) error {
+	if err := os.MkdirAll(filepath.Dir(destination), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory of %s: %w", destination, err)
+	}
+	tmpPath := destination + restoreSuffix
+	if err := copyFile(source, tmpPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, destination); err == nil {
+		return nil
+	}
+	replacedPath := destination + replacedSuffix
+	if err := os.Remove(replacedPath); err != nil && !os.IsNotExist(err) {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error removing previously replaced file %s: %w", replacedPath, err)
+	}
+	if err := os.Rename(destination, replacedPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error moving %s out of the way: %w", destination, err)
+	}
+	if err := os.Rename(tmpPath, destination); err != nil {
+		return fmt.Errorf("error replacing %s: %w", destination, err)
+	}
+	return nil
+}
+
+// copyFile copies the source file to the destination, overwriting it if it exists
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(destination)
+		return fmt.Errorf("error copying %s to %s: %w", source, destination, err)
+	}
+	return out.Close()
+}
+
+// reconcileFiles verifies the files copied by WMCO, if due, generating an event for each newly modified file. Modified
+// files are restored if the remediation given by the ConfigMap data allows it, restarting the services using them.
+// Restoring a file used by a running service is deferred until the maintenance window given by the ConfigMap data, as
+// restarting the service disrupts workloads. WMCO is asked to populate the payload cache again if it does not hold a
+// valid copy of a modified file.
+func (sc *ServiceController) reconcileFiles(node *core.Node, cmData *servicescm.Data) error {
+	_, repushPending := node.GetAnnotations()[metadata.PayloadRepushAnnotation]
+	if len(cmData.Files) == 0 || !sc.files.due(repushPending) {
+		return nil
+	}
+	drifted, newlyDrifted := sc.files.verify(cmData.Files)
+	for _, drift := range newlyDrifted {
+		sc.recorder.Eventf(node, core.EventTypeWarning, "FileModified",
+			"File %s copied by WMCO was modified: %v", drift.file.Path, drift.err)
+	}
+	if cmData.DriftRemediation() != servicescm.FileDriftRemediationRestore {
+		return nil
+	}
+	window, err := cmData.Window()
+	if err != nil {
+		return fmt.Errorf("invalid maintenance window: %w", err)
+	}
+	var nextWindow time.Time
+	if now := sc.files.now(); window != nil && !window.Active(now) {
+		nextWindow = window.NextStart(now)
+	}
+	running := sc.runningServices(cmData.Services)
+	newlyDriftedPaths := make(map[string]bool)
+	for _, drift := range newlyDrifted {
+		newlyDriftedPaths[drift.file.Path] = true
+	}
+	var restored []string
+	notCached := false
+	for _, drift := range drifted {
+		if !nextWindow.IsZero() && usedByRunningService(drift.file.Path, cmData.Services, running) {
+			sc.files.restoreDeferred(nextWindow)
+			if newlyDriftedPaths[drift.file.Path] {
+				sc.recorder.Eventf(node, core.EventTypeNormal, "FileRestoreDeferred",
+					"Restoring modified file %s requires restarting the services using it, deferred until the "+
+						"maintenance window starting at %s", drift.file.Path, nextWindow.UTC().Format(time.RFC3339))
+			}
+			continue
+		}
+		if err := sc.files.restore(drift.file); err != nil {
+			if errors.Is(err, errNotCached) {
+				notCached = true
+				continue
+			}
+			sc.recorder.Eventf(node, core.EventTypeWarning, "FileRestoreFailed",
+				"Modified file %s could not be restored: %v", drift.file.Path, err)
+			continue
+		}
+		klog.Infof("restored file %s from the payload cache", drift.file.Path)
+		sc.recorder.Eventf(node, core.EventTypeNormal, "FileRestored",
+			"Modified file %s was restored from the payload cache", drift.file.Path)
+		restored = append(restored, drift.file.Path)
+	}
+	if notCached {
+		sc.files.repushRequested()
+		if !repushPending {
+			// Applying the re-push annotation results in an event picked up by WMCO's node controller to populate the
+			// payload cache again
+			if err := metadata.ApplyPayloadRepushAnnotation(sc.ctx, sc.client, *node); err != nil {
+				return fmt.Errorf("error setting payload re-push annotation on node %s: %w", sc.nodeName, err)
+			}
+		}
+	}
+	return sc.stopServicesUsing(restored, cmData.Services, running)
+}
+
+// runningServices returns whether each of the given services is running, by name. Services which cannot be queried are
+// left out, as they are handled by the service reconciliation.
+func (sc *ServiceController) runningServices(services []servicescm.Service) map[string]bool {
+	running := make(map[string]bool)
+	for _, service := range services {
+		status, err := sc.serviceStatus(service.Name)
+		if err != nil {
+			continue
+		}
+		running[service.Name] = status.State == svc.Running
+	}
+	return running
+}
+
+// usedByRunningService returns true if the command of any of the given services which is running refers to the given
+// path
+func usedByRunningService(path string, services []servicescm.Service, running map[string]bool) bool {
+	for _, service := range services {
+		if running[service.Name] && commandUsesAny(service.Command, []string{path}) {
+			return true
+		}
+	}
+	return false
+}
+
+// stopServicesUsing stops the services which were running, as given, and whose command refers to any of the given
+// files, so that they are started again using the restored files. Services stopped this way are not considered to have
+// failed.
+func (sc *ServiceController) stopServicesUsing(paths []string, services []servicescm.Service,
+	running map[string]bool) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	for _, service := range services {
+		if !running[service.Name] || !commandUsesAny(service.Command, paths) {
+			continue
+		}
+		klog.Infof("restarting service %s as a file it uses was restored", service.Name)
+		winSvc, err := sc.OpenService(service.Name)
+		if err != nil {
+			return fmt.Errorf("error opening service %s: %w", service.Name, err)
+		}
+		err = sc.EnsureServiceState(winSvc, svc.Stopped)
+		winSvc.Close()
+		if err != nil {
+			return fmt.Errorf("error stopping service %s: %w", service.Name, err)
+		}
+	}
+	// Services depending on the stopped services were stopped along with them
+	for name, wasRunning := range running {
+		if !wasRunning {
+			continue
+		}
+		if status, err := sc.serviceStatus(name); err == nil && status.State != svc.Running {
+			sc.crashLoops.stopped(name)
+		}
+	}
+	return nil
+}
+
+// serviceStatus returns the status of the service of the given name
+func (sc *ServiceController) serviceStatus(name string) (svc.Status, error) {
+	service, err := sc.OpenService(name)
+	if err != nil {
+		return svc.Status{}, err
+	}
+	defer service.Close()
+	return service.Query()
+}
+
+// commandUsesAny returns true if the given service command refers to any of the given paths
+func commandUsesAny(command string, paths []string) bool {
+	command = strings.ToLower(command)
+	for _, path := range paths {
+		if strings.Contains(command, strings.ToLower(path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportFileDrift sets the FilesModifiedCondition of the given node to reflect the files copied by WMCO which were
+// modified and have not been restored. The condition is only added to the node once a modified file is found.
+func (sc *ServiceController) reportFileDrift(node *core.Node) error {
+	condition := core.NodeCondition{
+		Type:    FilesModifiedCondition,
+		Status:  core.ConditionFalse,
+		Reason:  "FilesUnmodified",
+		Message: "All files copied by WMCO have their expected contents",
+	}
+	if drifted := sc.files.report(); len(drifted) > 0 {
+		condition.Status = core.ConditionTrue
+		condition.Reason = "FilesModified"
+		condition.Message = fmt.Sprintf("Files copied by WMCO were modified: %s", strings.Join(drifted, ", "))
+	}
+	return sc.setNodeCondition(node, condition)
+}
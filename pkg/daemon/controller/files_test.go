@@ -0,0 +1,214 @@
+//go:build windows
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/daemon/fake"
+	"github.com/openshift/windows-machine-config-operator/pkg/maintenance"
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+)
+
+// checksum returns the SHA256 of the given contents
+func checksum(contents string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(contents)))
+}
+
+// readFile returns the contents of the file at the given path
+func readFile(t *testing.T, path string) string {
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(contents)
+}
+
+func TestFileVerifier(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+	now := time.Now()
+	v := newFileVerifier(cacheDir, "")
+	v.now = func() time.Time { return now }
+	kubelet := servicescm.FileInfo{Path: filepath.Join(dir, "kubelet.exe"), Checksum: checksum("kubelet")}
+	kubeProxy := servicescm.FileInfo{Path: filepath.Join(dir, "kube-proxy.exe"), Checksum: checksum("kube-proxy")}
+	require.NoError(t, os.WriteFile(kubelet.Path, []byte("kubelet"), 0644))
+	require.NoError(t, os.WriteFile(kubeProxy.Path, []byte("modified"), 0644))
+	files := []servicescm.FileInfo{kubelet, kubeProxy}
+
+	assert.True(t, v.due(false))
+	drifted, newlyDrifted := v.verify(files)
+	require.Len(t, drifted, 1)
+	assert.Equal(t, kubeProxy, drifted[0].file)
+	assert.Equal(t, drifted, newlyDrifted)
+	assert.Equal(t, []string{kubeProxy.Path}, v.report())
+	assert.False(t, v.due(false))
+
+	// A modified file is only newly drifted once
+	now = now.Add(fileVerificationPeriod)
+	assert.True(t, v.due(false))
+	drifted, newlyDrifted = v.verify(files)
+	assert.Len(t, drifted, 1)
+	assert.Empty(t, newlyDrifted)
+
+	// A file without a valid cached copy is not restored, until WMCO populates the cache again
+	assert.ErrorIs(t, v.restore(kubeProxy), errNotCached)
+	v.repushRequested()
+	assert.False(t, v.due(true))
+	assert.True(t, v.due(false))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, kubeProxy.Checksum), []byte("modified"), 0644))
+	assert.ErrorIs(t, v.restore(kubeProxy), errNotCached)
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, kubeProxy.Checksum), []byte("kube-proxy"), 0644))
+	require.NoError(t, v.restore(kubeProxy))
+	assert.Equal(t, "kube-proxy", readFile(t, kubeProxy.Path))
+	assert.Empty(t, v.report())
+
+	// Missing files are modified as well
+	require.NoError(t, os.Remove(kubelet.Path))
+	drifted, newlyDrifted = v.verify(files)
+	require.Len(t, drifted, 1)
+	assert.Equal(t, kubelet, newlyDrifted[0].file)
+}
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	require.NoError(t, os.WriteFile(source, []byte("expected"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "destination"), []byte("modified"), 0644))
+
+	// Both modified and missing files are replaced, creating the directory of the file if needed
+	for _, destination := range []string{filepath.Join(dir, "destination"), filepath.Join(dir, "missing", "file")} {
+		require.NoError(t, replaceFile(source, destination))
+		assert.Equal(t, "expected", readFile(t, destination))
+		_, err := os.Stat(destination + restoreSuffix)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestReconcileFiles(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+	containerdPath := filepath.Join(dir, "containerd.exe")
+	cniPath := filepath.Join(dir, "win-overlay.exe")
+	files := []servicescm.FileInfo{
+		{Path: containerdPath, Checksum: checksum("containerd")},
+		{Path: cniPath, Checksum: checksum("win-overlay")},
+	}
+	require.NoError(t, os.WriteFile(containerdPath, []byte("modified"), 0644))
+	require.NoError(t, os.WriteFile(cniPath, []byte("modified"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, checksum("containerd")), []byte("containerd"), 0644))
+
+	running := svc.Status{State: svc.Running, ProcessId: 1}
+	containerd := fake.NewFakeService("containerd", mgr.Config{BinaryPathName: containerdPath}, running)
+	kubelet := fake.NewFakeService("kubelet", mgr.Config{BinaryPathName: "kubelet.exe",
+		Dependencies: []string{"containerd"}}, running)
+	services := []servicescm.Service{
+		{Name: "containerd", Command: containerdPath + " --run-service"},
+		{Name: "kubelet", Command: "kubelet.exe", Dependencies: []string{"containerd"}},
+	}
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "node",
+		Annotations: map[string]string{metadata.DesiredVersionAnnotation: "1.0.0"}}}
+	recorder := record.NewFakeRecorder(10)
+	c, err := NewServiceController(context.TODO(), "node", wmcoNamespace, Options{
+		Client:   clientfake.NewClientBuilder().WithObjects(node).WithStatusSubresource(node).Build(),
+		Mgr:      fake.NewTestMgr(map[string]*fake.FakeService{"containerd": containerd, "kubelet": kubelet}),
+		recorder: recorder,
+	})
+	require.NoError(t, err)
+	c.files = newFileVerifier(cacheDir, "")
+	for _, service := range services {
+		c.crashLoops.started(service.Name, running)
+	}
+	cmData := &servicescm.Data{Services: services, Files: files,
+		FileDriftRemediation: servicescm.FileDriftRemediationRestore}
+	getNode := func() *core.Node {
+		require.NoError(t, c.client.Get(c.ctx, client.ObjectKey{Name: "node"}, node))
+		return node
+	}
+
+	// The cached file is restored, and the services using it are stopped so they are started with the restored file
+	require.NoError(t, c.reconcileFiles(getNode(), cmData))
+	assert.Equal(t, "containerd", readFile(t, containerdPath))
+	for _, service := range []*fake.FakeService{containerd, kubelet} {
+		status, err := service.Query()
+		require.NoError(t, err)
+		assert.Equal(t, svc.Stopped, status.State)
+	}
+	assert.False(t, c.crashLoops.observe("containerd", svc.Status{State: svc.Stopped}),
+		"a service stopped by WICD should not be seen as failed")
+	assert.False(t, c.crashLoops.observe("kubelet", svc.Status{State: svc.Stopped}),
+		"a dependent service stopped by WICD should not be seen as failed")
+	// WMCO is asked to re-push the file missing from the cache
+	_, present := getNode().Annotations[metadata.PayloadRepushAnnotation]
+	assert.True(t, present)
+	require.Len(t, recorder.Events, 3)
+
+	require.NoError(t, c.reportFileDrift(getNode()))
+	var condition *core.NodeCondition
+	for i := range getNode().Status.Conditions {
+		if node.Status.Conditions[i].Type == FilesModifiedCondition {
+			condition = &node.Status.Conditions[i]
+		}
+	}
+	require.NotNil(t, condition)
+	assert.Equal(t, core.ConditionTrue, condition.Status)
+	assert.Contains(t, condition.Message, cniPath)
+	assert.NotContains(t, condition.Message, containerdPath)
+
+	// Files are not verified again while the re-push is pending
+	assert.False(t, c.files.due(true))
+
+	// Outside of the maintenance window, files used by running services are not restored
+	for _, service := range []*fake.FakeService{containerd, kubelet} {
+		require.NoError(t, service.Start())
+	}
+	require.NoError(t, os.WriteFile(cniPath, []byte("win-overlay"), 0644))
+	require.NoError(t, os.WriteFile(containerdPath, []byte("modified"), 0644))
+	cmData.MaintenanceWindow = &maintenance.Spec{Schedule: "0 1 * * 6", TimeZone: "UTC", Duration: "1h"}
+	windowStart := time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC)
+	now := windowStart.Add(-time.Hour)
+	c.files = newFileVerifier(cacheDir, "")
+	c.files.now = func() time.Time { return now }
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
+	require.NoError(t, c.reconcileFiles(getNode(), cmData))
+	assert.Equal(t, "modified", readFile(t, containerdPath))
+	status, err := containerd.Query()
+	require.NoError(t, err)
+	assert.Equal(t, svc.Running, status.State)
+	// A FileModified and a FileRestoreDeferred event are generated
+	assert.Len(t, recorder.Events, 2)
+	// The files are verified again once the window starts, restoring the file
+	assert.False(t, c.files.due(false))
+	now = windowStart
+	assert.True(t, c.files.due(false))
+	require.NoError(t, c.reconcileFiles(getNode(), cmData))
+	assert.Equal(t, "containerd", readFile(t, containerdPath))
+
+	// Modified files are only reported when not remediated
+	c.files = newFileVerifier(cacheDir, "")
+	cmData.FileDriftRemediation = servicescm.FileDriftRemediationNone
+	require.NoError(t, os.WriteFile(containerdPath, []byte("modified"), 0644))
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
+	require.NoError(t, c.reconcileFiles(getNode(), cmData))
+	assert.Equal(t, "modified", readFile(t, containerdPath))
+	assert.Len(t, recorder.Events, 1)
+}
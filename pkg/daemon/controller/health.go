@@ -0,0 +1,271 @@
+//go:build windows
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/daemon/probe"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+)
+
+const (
+	// healthMetricsPath is the file the health probe metrics are written to, which is exposed by the textfile
+	// collector of windows_exporter
+	healthMetricsPath = windows.WindowsExporterTextfileDir + "\\wicd_health_probes.prom"
+)
+
+// serviceHealth tracks the health probe results of a Windows service
+type serviceHealth struct {
+	// probe is the health probe of the service
+	probe servicescm.HealthProbe
+	// cancel stops the periodic probing of the service
+	cancel context.CancelFunc
+	// failures is the number of consecutive failures of the probe
+	failures uint32
+	// lastErr is the error of the last failed probe
+	lastErr error
+}
+
+// unhealthy returns true if the probe failed enough times in a row for the service to be restarted
+func (h *serviceHealth) unhealthy() bool {
+	return h.failures >= h.probe.Threshold()
+}
+
+// serviceRestart describes a Windows service restarted by WICD as its health probe failed persistently
+type serviceRestart struct {
+	name string
+	err  error
+}
+
+// healthProber periodically runs the health probes of the Windows services managed by WICD, and tracks which of them
+// are unhealthy
+type healthProber struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	services map[string]*serviceHealth
+	// restarts are the services restarted since the last report
+	restarts []serviceRestart
+	// run runs a health probe once, returning an error if it fails
+	run func(*servicescm.HealthProbe) error
+	// eventChan has an empty event sent on it whenever the result of a probe changes, or a service becomes unhealthy
+	eventChan chan event.GenericEvent
+	// metricsPath is the file the probe metrics are written to. Metrics are not written if it is empty.
+	metricsPath   string
+	registry      *prometheus.Registry
+	probeSuccess  *prometheus.GaugeVec
+	probeFailures *prometheus.CounterVec
+	restartCount  *prometheus.CounterVec
+}
+
+// newHealthProber returns a healthProber probing no services, which writes its metrics to the given file
+func newHealthProber(ctx context.Context, metricsPath string) *healthProber {
+	h := &healthProber{
+		ctx:         ctx,
+		services:    make(map[string]*serviceHealth),
+		run:         probe.Run,
+		eventChan:   make(chan event.GenericEvent, 1),
+		metricsPath: metricsPath,
+		registry:    prometheus.NewRegistry(),
+		probeSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wicd_service_health_probe_success",
+			Help: "Whether the last health probe of a Windows service succeeded (1) or failed (0)",
+		}, []string{"service"}),
+		probeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wicd_service_health_probe_failures_total",
+			Help: "Number of failed health probes of a Windows service",
+		}, []string{"service"}),
+		restartCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wicd_service_health_restarts_total",
+			Help: "Number of restarts of a Windows service by WICD due to its health probe failing",
+		}, []string{"service"}),
+	}
+	h.registry.MustRegister(h.probeSuccess, h.probeFailures, h.restartCount)
+	return h
+}
+
+// events returns a channel which has an empty event sent on it whenever the health of a service changes
+func (h *healthProber) events() <-chan event.GenericEvent {
+	return h.eventChan
+}
+
+// update ensures that exactly the health probes of the given services are run, restarting the probing of services
+// whose probe changed
+func (h *healthProber) update(services []servicescm.Service) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	expected := make(map[string]*servicescm.HealthProbe)
+	for _, service := range services {
+		if service.HealthProbe != nil {
+			expected[service.Name] = service.HealthProbe
+		}
+	}
+	for name, health := range h.services {
+		if healthProbe, present := expected[name]; present && reflect.DeepEqual(*healthProbe, health.probe) {
+			continue
+		}
+		health.cancel()
+		delete(h.services, name)
+		h.probeSuccess.DeleteLabelValues(name)
+		h.probeFailures.DeleteLabelValues(name)
+		h.restartCount.DeleteLabelValues(name)
+	}
+	for name, healthProbe := range expected {
+		if _, present := h.services[name]; present {
+			continue
+		}
+		ctx, cancel := context.WithCancel(h.ctx)
+		health := &serviceHealth{probe: *healthProbe, cancel: cancel}
+		h.services[name] = health
+		go h.watch(ctx, name, health)
+	}
+}
+
+// watch runs the health probe of the given service at the interval given by the probe, until the context is done.
+// The first probe is run once an interval has passed, giving the service time to start.
+func (h *healthProber) watch(ctx context.Context, name string, health *serviceHealth) {
+	ticker := time.NewTicker(health.probe.Period())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if h.record(name, health, h.run(&health.probe)) {
+				h.notify()
+			}
+		}
+	}
+}
+
+// notify sends an event on the event channel, unless an event is already pending
+func (h *healthProber) notify() {
+	select {
+	case h.eventChan <- event.GenericEvent{}:
+	default:
+	}
+}
+
+// record records the result of a health probe of the given service, returning true if the probe started or stopped
+// failing, or if the service became unhealthy. Results for a service no longer probed as given are ignored.
+func (h *healthProber) record(name string, health *serviceHealth, err error) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.services[name] != health {
+		return false
+	}
+	wasFailing := health.failures > 0
+	wasUnhealthy := health.unhealthy()
+	if err != nil {
+		klog.V(1).Infof("health probe of service %s failed: %v", name, err)
+		health.failures++
+		health.lastErr = err
+		h.probeSuccess.WithLabelValues(name).Set(0)
+		h.probeFailures.WithLabelValues(name).Inc()
+	} else {
+		health.failures = 0
+		health.lastErr = nil
+		h.probeSuccess.WithLabelValues(name).Set(1)
+	}
+	h.writeMetrics()
+	return wasFailing != (health.failures > 0) || (!wasUnhealthy && health.unhealthy())
+}
+
+// isUnhealthy returns true if the health probe of the given service failed persistently
+func (h *healthProber) isUnhealthy(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health, present := h.services[name]
+	return present && health.unhealthy()
+}
+
+// restarted records that the given unhealthy service was restarted by WICD, resetting its probe failures
+func (h *healthProber) restarted(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health, present := h.services[name]
+	if !present {
+		return
+	}
+	h.restarts = append(h.restarts, serviceRestart{name: name, err: health.lastErr})
+	health.failures = 0
+	health.lastErr = nil
+	h.restartCount.WithLabelValues(name).Inc()
+	h.writeMetrics()
+}
+
+// reset clears the probe failures of the given service, which was just started
+func (h *healthProber) reset(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if health, present := h.services[name]; present {
+		health.failures = 0
+		health.lastErr = nil
+	}
+}
+
+// report returns the services whose last health probe failed, by name, along with the services restarted since the
+// last report
+func (h *healthProber) report() (map[string]error, []serviceRestart) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	failing := make(map[string]error)
+	for name, health := range h.services {
+		if health.failures > 0 {
+			failing[name] = health.lastErr
+		}
+	}
+	restarts := h.restarts
+	h.restarts = nil
+	return failing, restarts
+}
+
+// writeMetrics writes the probe metrics to the metrics file. The caller must hold the lock.
+func (h *healthProber) writeMetrics() {
+	if h.metricsPath == "" {
+		return
+	}
+	if err := prometheus.WriteToTextfile(h.metricsPath, h.registry); err != nil {
+		klog.Errorf("unable to write health probe metrics to %s: %v", h.metricsPath, err)
+	}
+}
+
+// reportHealth sets the ServicesUnhealthyCondition of the given node to reflect the services whose health probe is
+// failing, generating an event for each service restarted due to its health probe. The condition is only added to the
+// node once a health probe fails.
+func (sc *ServiceController) reportHealth(node *core.Node) error {
+	failing, restarts := sc.health.report()
+	for _, restart := range restarts {
+		sc.recorder.Eventf(node, core.EventTypeWarning, "ServiceUnhealthy",
+			"Windows service %s was restarted as its health probe failed persistently: %v", restart.name, restart.err)
+	}
+	condition := core.NodeCondition{
+		Type:    ServicesUnhealthyCondition,
+		Status:  core.ConditionFalse,
+		Reason:  "HealthProbesPassing",
+		Message: "All Windows service health probes are passing",
+	}
+	if len(failing) > 0 {
+		names := make([]string, 0, len(failing))
+		for name := range failing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		condition.Status = core.ConditionTrue
+		condition.Reason = "HealthProbesFailing"
+		condition.Message = fmt.Sprintf("Windows service health probes failing: %s", strings.Join(names, ", "))
+	}
+	return sc.setNodeCondition(node, condition)
+}
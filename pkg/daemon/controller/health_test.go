@@ -0,0 +1,158 @@
+//go:build windows
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/daemon/fake"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+)
+
+func TestHealthProber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := newHealthProber(ctx, "")
+	healthProbe := &servicescm.HealthProbe{TCPSocket: &servicescm.TCPSocketProbe{Address: "127.0.0.1:10250"},
+		PeriodSeconds: 3600, FailureThreshold: 2}
+
+	h.update([]servicescm.Service{{Name: "kubelet", HealthProbe: healthProbe}, {Name: "containerd"}})
+	require.Len(t, h.services, 1, "only services with a health probe should be probed")
+	kubelet := h.services["kubelet"]
+	require.NotNil(t, kubelet)
+
+	// The first failure is reported, but does not make the service unhealthy
+	assert.True(t, h.record("kubelet", kubelet, fmt.Errorf("connection refused")))
+	assert.False(t, h.isUnhealthy("kubelet"))
+	assert.True(t, h.record("kubelet", kubelet, fmt.Errorf("connection refused")))
+	assert.True(t, h.isUnhealthy("kubelet"))
+	assert.False(t, h.record("kubelet", kubelet, fmt.Errorf("connection refused")))
+	failing, restarts := h.report()
+	assert.Contains(t, failing, "kubelet")
+	assert.Empty(t, restarts)
+
+	h.restarted("kubelet")
+	assert.False(t, h.isUnhealthy("kubelet"))
+	failing, restarts = h.report()
+	assert.Empty(t, failing)
+	require.Len(t, restarts, 1)
+	assert.Equal(t, "kubelet", restarts[0].name)
+	assert.Error(t, restarts[0].err)
+	_, restarts = h.report()
+	assert.Empty(t, restarts, "a restart should only be reported once")
+
+	// Results of a replaced probe are ignored
+	changedProbe := *healthProbe
+	changedProbe.FailureThreshold = 5
+	h.update([]servicescm.Service{{Name: "kubelet", HealthProbe: &changedProbe}})
+	assert.NotSame(t, kubelet, h.services["kubelet"])
+	assert.False(t, h.record("kubelet", kubelet, fmt.Errorf("connection refused")))
+	failing, _ = h.report()
+	assert.Empty(t, failing)
+
+	h.update(nil)
+	assert.Empty(t, h.services)
+	assert.False(t, h.isUnhealthy("kubelet"))
+}
+
+func TestReconcileUnhealthyService(t *testing.T) {
+	healthProbe := &servicescm.HealthProbe{TCPSocket: &servicescm.TCPSocketProbe{Address: "127.0.0.1:10250"},
+		PeriodSeconds: 3600, FailureThreshold: 1}
+	expected := servicescm.Service{Name: "kubelet", Command: "kubelet.exe", HealthProbe: healthProbe}
+	service := fake.NewFakeService("kubelet", mgr.Config{BinaryPathName: "kubelet.exe",
+		Description: "OpenShift managed kubelet"}, svc.Status{State: svc.Running, ProcessId: 1})
+	dependent := fake.NewFakeService("kube-proxy", mgr.Config{BinaryPathName: "kube-proxy.exe",
+		Dependencies: []string{"kubelet"}}, svc.Status{State: svc.Running, ProcessId: 2})
+	c, err := NewServiceController(context.TODO(), "node", wmcoNamespace, Options{
+		Client: clientfake.NewClientBuilder().Build(),
+		Mgr:    fake.NewTestMgr(map[string]*fake.FakeService{"kubelet": service, "kube-proxy": dependent}),
+	})
+	require.NoError(t, err)
+	c.health = newHealthProber(context.TODO(), "")
+	c.health.update([]servicescm.Service{expected})
+	defer c.health.update(nil)
+	c.crashLoops.started("kubelet", svc.Status{State: svc.Running, ProcessId: 1})
+	c.crashLoops.started("kube-proxy", svc.Status{State: svc.Running, ProcessId: 2})
+
+	// A healthy service is left running
+	require.NoError(t, c.reconcileService(service, expected))
+	status, err := service.Query()
+	require.NoError(t, err)
+	assert.Equal(t, svc.Running, status.State)
+
+	// An unhealthy service is stopped, and started again once its backoff expires
+	c.health.record("kubelet", c.health.services["kubelet"], fmt.Errorf("connection refused"))
+	require.True(t, c.health.isUnhealthy("kubelet"))
+	require.NoError(t, c.reconcileService(service, expected))
+	status, err = service.Query()
+	require.NoError(t, err)
+	assert.Equal(t, svc.Stopped, status.State)
+	assert.False(t, c.health.isUnhealthy("kubelet"))
+	assert.Equal(t, initialStartBackoff, c.crashLoops.nextStartDelay().Round(initialStartBackoff))
+	// The dependent service stopped along with it is not seen as failed
+	status, err = dependent.Query()
+	require.NoError(t, err)
+	assert.Equal(t, svc.Stopped, status.State)
+	assert.False(t, c.crashLoops.observe("kube-proxy", status))
+}
+
+func TestReportHealth(t *testing.T) {
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "node"}}
+	recorder := record.NewFakeRecorder(10)
+	c, err := NewServiceController(context.TODO(), "node", wmcoNamespace, Options{
+		Client:   clientfake.NewClientBuilder().WithObjects(node).WithStatusSubresource(node).Build(),
+		Mgr:      fake.NewTestMgr(nil),
+		recorder: recorder,
+	})
+	require.NoError(t, err)
+	c.health = newHealthProber(context.TODO(), "")
+	healthProbe := &servicescm.HealthProbe{
+		NamedPipe:     &servicescm.NamedPipeProbe{Path: `\\.\pipe\containerd-containerd`},
+		PeriodSeconds: 3600,
+	}
+	c.health.update([]servicescm.Service{{Name: "containerd", HealthProbe: healthProbe}})
+	defer c.health.update(nil)
+	getCondition := func() *core.NodeCondition {
+		require.NoError(t, c.client.Get(c.ctx, client.ObjectKey{Name: "node"}, node))
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == ServicesUnhealthyCondition {
+				return &condition
+			}
+		}
+		return nil
+	}
+
+	// The condition is not added while all probes are passing
+	require.NoError(t, c.reportHealth(node))
+	assert.Nil(t, getCondition())
+
+	for i := uint32(0); i < healthProbe.Threshold(); i++ {
+		c.health.record("containerd", c.health.services["containerd"], fmt.Errorf("pipe not found"))
+	}
+	require.NoError(t, c.reportHealth(node))
+	condition := getCondition()
+	require.NotNil(t, condition)
+	assert.Equal(t, core.ConditionTrue, condition.Status)
+	assert.Contains(t, condition.Message, "containerd")
+	assert.Empty(t, recorder.Events)
+
+	c.health.restarted("containerd")
+	require.NoError(t, c.reportHealth(node))
+	condition = getCondition()
+	require.NotNil(t, condition)
+	assert.Equal(t, core.ConditionFalse, condition.Status)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "ServiceUnhealthy")
+}
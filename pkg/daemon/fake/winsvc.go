@@ -10,10 +10,12 @@ import (
 )
 
 type FakeService struct {
-	name        string
-	config      mgr.Config
-	status      svc.Status
-	serviceList *fakeServiceList
+	name            string
+	config          mgr.Config
+	status          svc.Status
+	recoveryActions []mgr.RecoveryAction
+	resetPeriod     uint32
+	serviceList     *fakeServiceList
 }
 
 func (f *FakeService) Close() error {
@@ -97,6 +99,29 @@ func (f *FakeService) UpdateConfig(config mgr.Config) error {
 	return nil
 }
 
+func (f *FakeService) RecoveryActions() ([]mgr.RecoveryAction, error) {
+	return f.recoveryActions, nil
+}
+
+func (f *FakeService) ResetPeriod() (uint32, error) {
+	return f.resetPeriod, nil
+}
+
+func (f *FakeService) SetRecoveryActions(recoveryActions []mgr.RecoveryAction, resetPeriod uint32) error {
+	if recoveryActions == nil {
+		return fmt.Errorf("recoveryActions cannot be nil")
+	}
+	f.recoveryActions = recoveryActions
+	f.resetPeriod = resetPeriod
+	return nil
+}
+
+func (f *FakeService) ResetRecoveryActions() error {
+	f.recoveryActions = nil
+	f.resetPeriod = 0
+	return nil
+}
+
 func NewFakeService(name string, config mgr.Config, status svc.Status) *FakeService {
 	return &FakeService{
 		name:   name,
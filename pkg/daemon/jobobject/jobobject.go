@@ -0,0 +1,150 @@
+//go:build windows
+
+package jobobject
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+)
+
+const (
+	// jobNamePrefix is the prefix of the names of the job objects of Windows services
+	jobNamePrefix = "wicd-"
+	// cpuRateControlEnable enables CPU rate control of a job object
+	cpuRateControlEnable = 0x1
+	// cpuRateControlHardCap prevents a job object from using more CPU than its rate, even if the CPU is idle
+	cpuRateControlHardCap = 0x4
+)
+
+// isProcessInJob is a handle to the IsProcessInJob syscall
+// https://learn.microsoft.com/en-us/windows/win32/api/jobapi/nf-jobapi-isprocessinjob
+// This is global to prevent having to load the dll into memory and search for the API call every time it is used
+var isProcessInJob = windows.NewLazySystemDLL("kernel32.dll").NewProc("IsProcessInJob")
+
+// cpuRateControlInformation implements the JOBOBJECT_CPU_RATE_CONTROL_INFORMATION type as defined in the Windows API,
+// with the CpuRate member of its union
+type cpuRateControlInformation struct {
+	ControlFlags uint32
+	CPURate      uint32
+}
+
+// Limiter limits the resources used by the processes of Windows services, by assigning them to a job object per
+// service. The child processes of a process are assigned to its job object.
+type Limiter interface {
+	// Apply ensures that the process of the given ID, running the given service, is limited as described. Nil limits
+	// lift the limits previously applied to the service.
+	Apply(service string, pid uint32, limits *servicescm.ResourceLimits) error
+	// Release closes the job object of the given service, which is no longer managed
+	Release(service string)
+}
+
+// limiter implements Limiter, keeping a handle to the job object of each limited service
+type limiter struct {
+	mu   sync.Mutex
+	jobs map[string]windows.Handle
+}
+
+// NewLimiter returns a Limiter which has not limited any service yet
+func NewLimiter() Limiter {
+	return &limiter{jobs: make(map[string]windows.Handle)}
+}
+
+func (l *limiter) Apply(service string, pid uint32, limits *servicescm.ResourceLimits) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pid == 0 {
+		// A service without a process has nothing to limit
+		return nil
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE|
+		windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return fmt.Errorf("error opening process %d: %w", pid, err)
+	}
+	defer windows.CloseHandle(process)
+
+	job, known := l.jobs[service]
+	if limits == nil && !known {
+		// Only the job object of a service limited before WICD was restarted can hold the process
+		inJob, err := processInJob(process, 0)
+		if err != nil || !inJob {
+			return err
+		}
+	}
+	if !known {
+		job, err = windows.CreateJobObject(nil, windows.StringToUTF16Ptr(jobNamePrefix+service))
+		if err != nil {
+			return fmt.Errorf("error creating job object of service %s: %w", service, err)
+		}
+		l.jobs[service] = job
+	}
+	if err := setLimits(job, limits); err != nil {
+		return fmt.Errorf("error setting limits of job object of service %s: %w", service, err)
+	}
+	if limits == nil {
+		return nil
+	}
+	inJob, err := processInJob(process, job)
+	if err != nil {
+		return err
+	}
+	if inJob {
+		return nil
+	}
+	if err := windows.AssignProcessToJobObject(job, process); err != nil {
+		return fmt.Errorf("error assigning process %d to job object of service %s: %w", pid, service, err)
+	}
+	return nil
+}
+
+func (l *limiter) Release(service string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if job, known := l.jobs[service]; known {
+		windows.CloseHandle(job)
+		delete(l.jobs, service)
+	}
+}
+
+// setLimits sets the given limits on the given job object, lifting the limits which are not set
+func setLimits(job windows.Handle, limits *servicescm.ResourceLimits) error {
+	if limits == nil {
+		limits = &servicescm.ResourceLimits{}
+	}
+	var memoryInfo windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	if limits.MemoryBytes > 0 {
+		memoryInfo.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_JOB_MEMORY
+		memoryInfo.JobMemoryLimit = uintptr(limits.MemoryBytes)
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&memoryInfo)), uint32(unsafe.Sizeof(memoryInfo))); err != nil {
+		return fmt.Errorf("error setting memory limit: %w", err)
+	}
+	var cpuInfo cpuRateControlInformation
+	if limits.CPUPercent > 0 {
+		cpuInfo.ControlFlags = cpuRateControlEnable | cpuRateControlHardCap
+		// The rate is given in hundredths of a percent
+		cpuInfo.CPURate = limits.CPUPercent * 100
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectCpuRateControlInformation,
+		uintptr(unsafe.Pointer(&cpuInfo)), uint32(unsafe.Sizeof(cpuInfo))); err != nil {
+		return fmt.Errorf("error setting CPU limit: %w", err)
+	}
+	return nil
+}
+
+// processInJob returns true if the given process is assigned to the given job object, or to any job object if the
+// given job is zero
+func processInJob(process, job windows.Handle) (bool, error) {
+	var result int32
+	ret, _, err := isProcessInJob.Call(uintptr(process), uintptr(job), uintptr(unsafe.Pointer(&result)))
+	if ret == 0 {
+		return false, fmt.Errorf("error determining the job object of the process: %w", err)
+	}
+	return result != 0, nil
+}
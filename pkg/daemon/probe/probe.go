@@ -0,0 +1,78 @@
+//go:build windows
+
+package probe
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+)
+
+// Run runs the given health probe once, returning an error if it fails
+func Run(probe *servicescm.HealthProbe) error {
+	switch {
+	case probe.HTTPGet != nil:
+		return httpGet(probe.HTTPGet, probe)
+	case probe.TCPSocket != nil:
+		return tcpSocket(probe.TCPSocket, probe)
+	case probe.NamedPipe != nil:
+		return namedPipe(probe.NamedPipe)
+	default:
+		return fmt.Errorf("health probe has no check")
+	}
+}
+
+// httpGet sends a GET request to the URL of the given probe, succeeding if the response has a 2xx or 3xx status code
+func httpGet(httpProbe *servicescm.HTTPGetProbe, probe *servicescm.HealthProbe) error {
+	client := &http.Client{
+		Timeout: probe.Timeout(),
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: httpProbe.InsecureSkipTLSVerify},
+			DisableKeepAlives: true,
+			// Services are probed directly, even when the cluster-wide proxy is set in the environment of WICD
+			Proxy: nil,
+		},
+		// Redirects are not followed, a redirect shows that the service is responding
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(httpProbe.URL)
+	if err != nil {
+		return fmt.Errorf("error requesting %s: %w", httpProbe.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("request to %s returned status %s", httpProbe.URL, resp.Status)
+	}
+	return nil
+}
+
+// tcpSocket opens a TCP connection to the address of the given probe
+func tcpSocket(tcpProbe *servicescm.TCPSocketProbe, probe *servicescm.HealthProbe) error {
+	conn, err := net.DialTimeout("tcp", tcpProbe.Address, probe.Timeout())
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", tcpProbe.Address, err)
+	}
+	return conn.Close()
+}
+
+// namedPipe connects to the named pipe of the given probe. A pipe whose instances are all busy is being served, and so
+// does not fail the probe.
+func namedPipe(pipeProbe *servicescm.NamedPipeProbe) error {
+	pipe, err := os.OpenFile(pipeProbe.Path, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_PIPE_BUSY) {
+			return nil
+		}
+		return fmt.Errorf("error connecting to named pipe %s: %w", pipeProbe.Path, err)
+	}
+	return pipe.Close()
+}
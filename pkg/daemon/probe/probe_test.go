@@ -0,0 +1,101 @@
+//go:build windows
+
+package probe
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+)
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusOK)
+		case "/moved":
+			http.Redirect(w, r, "/healthz", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddress := closedListener.Addr().String()
+	require.NoError(t, closedListener.Close())
+
+	testCases := []struct {
+		name        string
+		probe       *servicescm.HealthProbe
+		expectedErr bool
+	}{
+		{
+			name:        "healthy HTTP endpoint",
+			probe:       &servicescm.HealthProbe{HTTPGet: &servicescm.HTTPGetProbe{URL: server.URL + "/healthz"}},
+			expectedErr: false,
+		},
+		{
+			name:        "redirecting HTTP endpoint",
+			probe:       &servicescm.HealthProbe{HTTPGet: &servicescm.HTTPGetProbe{URL: server.URL + "/moved"}},
+			expectedErr: false,
+		},
+		{
+			name:        "failing HTTP endpoint",
+			probe:       &servicescm.HealthProbe{HTTPGet: &servicescm.HTTPGetProbe{URL: server.URL + "/broken"}},
+			expectedErr: true,
+		},
+		{
+			name:        "untrusted HTTPS endpoint",
+			probe:       &servicescm.HealthProbe{HTTPGet: &servicescm.HTTPGetProbe{URL: tlsServer.URL}},
+			expectedErr: true,
+		},
+		{
+			name: "untrusted HTTPS endpoint without verification",
+			probe: &servicescm.HealthProbe{HTTPGet: &servicescm.HTTPGetProbe{URL: tlsServer.URL,
+				InsecureSkipTLSVerify: true}},
+			expectedErr: false,
+		},
+		{
+			name:        "listening TCP socket",
+			probe:       &servicescm.HealthProbe{TCPSocket: &servicescm.TCPSocketProbe{Address: listener.Addr().String()}},
+			expectedErr: false,
+		},
+		{
+			name:        "closed TCP socket",
+			probe:       &servicescm.HealthProbe{TCPSocket: &servicescm.TCPSocketProbe{Address: closedAddress}},
+			expectedErr: true,
+		},
+		{
+			name:        "missing named pipe",
+			probe:       &servicescm.HealthProbe{NamedPipe: &servicescm.NamedPipeProbe{Path: `\\.\pipe\wicd-missing`}},
+			expectedErr: true,
+		},
+		{
+			name:        "no check",
+			probe:       &servicescm.HealthProbe{},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := Run(test.probe)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
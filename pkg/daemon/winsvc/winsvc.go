@@ -19,6 +19,10 @@ type Service interface {
 	Control(svc.Cmd) (svc.Status, error)
 	Query() (svc.Status, error)
 	UpdateConfig(mgr.Config) error
+	RecoveryActions() ([]mgr.RecoveryAction, error)
+	ResetPeriod() (uint32, error)
+	SetRecoveryActions([]mgr.RecoveryAction, uint32) error
+	ResetRecoveryActions() error
 }
 
 // WaitForState retries until the services reaches the expected state, or reaches timeout
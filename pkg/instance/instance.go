@@ -10,6 +10,59 @@ import (
 	"github.com/openshift/windows-machine-config-operator/version"
 )
 
+// Transport is the protocol used to remotely access an instance
+type Transport string
+
+const (
+	// TransportSSH accesses the instance over SSH, authenticating with a private key
+	TransportSSH Transport = "SSH"
+	// TransportWinRM accesses the instance over WinRM using HTTPS, authenticating with a client certificate
+	TransportWinRM Transport = "WinRM"
+)
+
+// ParseTransport returns the Transport represented by the given value. An empty value indicates SSH.
+func ParseTransport(value string) (Transport, error) {
+	switch Transport(value) {
+	case "", TransportSSH:
+		return TransportSSH, nil
+	case TransportWinRM:
+		return TransportWinRM, nil
+	default:
+		return "", fmt.Errorf("invalid transport %q, must be one of %s or %s", value, TransportSSH, TransportWinRM)
+	}
+}
+
+// JumpHost is an SSH host which connections to an instance are tunneled through
+type JumpHost struct {
+	// Address is the address of the jump host, optionally followed by a port
+	Address string
+	// Username is the name of the user used to access the jump host
+	Username string
+	// PrivateKeySecret is the name of the secret containing the private key used to access the jump host. An empty
+	// value indicates that the private key used to access the instance should be used.
+	PrivateKeySecret string
+	// SSHHostKey is the host key the jump host must present, in authorized_keys format
+	SSHHostKey string
+}
+
+// SSHRoute describes how SSH connections to an instance which cannot be reached directly are made
+type SSHRoute struct {
+	// ProxyURL is the URL of the HTTP CONNECT or SOCKS5 proxy connections are made through. An empty value indicates
+	// that connections are made without a proxy.
+	ProxyURL string
+	// ProxyCredentialsSecret is the name of the secret containing the credentials used to authenticate against the
+	// proxy. An empty value indicates that the proxy does not require authentication.
+	ProxyCredentialsSecret string
+	// JumpHosts are the SSH hosts connections are tunneled through, in order. The first jump host is reached through
+	// the proxy, if any.
+	JumpHosts []JumpHost
+}
+
+// IsDirect returns true if connections are made directly to the instance
+func (r SSHRoute) IsDirect() bool {
+	return r.ProxyURL == "" && len(r.JumpHosts) == 0
+}
+
 // Info represents a instance that is meant to be joined to the cluster
 type Info struct {
 	// Address is the network address of the instance as specified by the associated ConfigMap entry.
@@ -25,17 +78,38 @@ type Info struct {
 	SetNodeIP bool
 	// Node is an optional pointer to the Node object associated with the instance, if it has one.
 	Node *core.Node
+	// PrivateKeySecret is the name of the secret containing the private key used to access the instance. An empty
+	// value indicates that the operator's private key secret should be used.
+	PrivateKeySecret string
+	// Transport is the protocol used to remotely access the instance. An empty value indicates SSH.
+	Transport Transport
+	// SSHHostKey is the host key the instance must present when accessed over SSH, in authorized_keys format. An empty
+	// value indicates that the key presented by the first connection should be trusted.
+	SSHHostKey string
+	// SSHRoute is how SSH connections to the instance are made. A direct route indicates that the route configured for
+	// all instances should be used.
+	SSHRoute SSHRoute
 }
 
 // NewInfo returns a new Info. newHostname being set means that the instance's hostname should be
-// changed. An empty value is a no-op.
+// changed. An empty value is a no-op. The SSH host key recorded on the given node, if any, is expected to be presented
+// by the instance.
 func NewInfo(address, username, newHostname string, setNodeIP bool, node *core.Node) (*Info, error) {
 	ip, err := net.ResolveIPAddr("ip4", address)
 	if err != nil {
 		return nil, fmt.Errorf("invalid address %s, unable to create instance info: %w", address, err)
 	}
-	return &Info{Address: address, IPv4Address: ip.String(), Username: username, NewHostname: newHostname,
-		SetNodeIP: setNodeIP, Node: node}, nil
+	info := &Info{Address: address, IPv4Address: ip.String(), Username: username, NewHostname: newHostname,
+		SetNodeIP: setNodeIP, Node: node}
+	if node != nil {
+		info.SSHHostKey = node.GetAnnotations()[metadata.SSHHostKeyAnnotation]
+	}
+	return info, nil
+}
+
+// UsesWinRM returns true if the instance is accessed over WinRM
+func (i *Info) UsesWinRM() bool {
+	return i.Transport == TransportWinRM
 }
 
 // UpToDate returns true if the instance was configured by the current WMCO version
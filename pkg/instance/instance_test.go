@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -105,3 +106,27 @@ func TestUpgradeRequired(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTransport(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expectedOut Transport
+		expectedErr bool
+	}{
+		{input: "", expectedOut: TransportSSH, expectedErr: false},
+		{input: "SSH", expectedOut: TransportSSH, expectedErr: false},
+		{input: "WinRM", expectedOut: TransportWinRM, expectedErr: false},
+		{input: "winrm", expectedErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.input, func(t *testing.T) {
+			out, err := ParseTransport(test.input)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedOut, out)
+		})
+	}
+}
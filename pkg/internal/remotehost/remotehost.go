@@ -0,0 +1,29 @@
+// Package remotehost allows hosts other than Windows VMs, such as the emulated instance provided by the windows/fake
+// package, to be configured through the windows package. It is internal so that only tests within this repository can
+// configure such hosts.
+package remotehost
+
+import (
+	"io"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+)
+
+// Host is a host which can be interacted with in place of a Windows VM
+type Host interface {
+	// Init initialises the connection to the host
+	Init() error
+	// Run executes the given command on the host
+	Run(cmd string) (string, error)
+	// Transfer reads from reader and creates a file in the remote directory, creating the remote directory if needed
+	Transfer(reader io.Reader, filename, remoteDir string) error
+	// WriteChunk writes the data read from reader to the remote file at the given offset, creating the file and its
+	// directory if needed. Any content of the file beyond the offset is discarded.
+	WriteChunk(reader io.Reader, remotePath string, offset int64) error
+}
+
+// NewWindows returns a windows.Windows object which interacts with the given Host. The WICD executable is copied from
+// the given local path, no other payload files are transferred when bootstrapping the instance. It is set by the
+// windows package, which cannot be imported here without creating an import cycle.
+var NewWindows func(clusterDNS string, instanceInfo *instance.Info, host Host, wicdPayloadPath string) (interface{},
+	error)
@@ -0,0 +1,165 @@
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field describes the range of values a schedule field can hold
+type field struct {
+	name string
+	min  int
+	max  int
+}
+
+var (
+	// fields are the fields of a schedule, in the order they appear in a cron expression
+	fields = []field{
+		{name: "minute", min: 0, max: 59},
+		{name: "hour", min: 0, max: 23},
+		{name: "day of month", min: 1, max: 31},
+		{name: "month", min: 1, max: 12},
+		// 7 is accepted as an alias for Sunday
+		{name: "day of week", min: 0, max: 7},
+	}
+)
+
+// Schedule is a set of times described by a standard five field cron expression
+type Schedule struct {
+	minutes    map[int]bool
+	hours      map[int]bool
+	daysOfMon  map[int]bool
+	months     map[int]bool
+	daysOfWeek map[int]bool
+	// domRestricted and dowRestricted are true if the day of month and day of week fields are not wildcards. When both
+	// are restricted, a day matches the schedule if it matches either field.
+	domRestricted bool
+	dowRestricted bool
+}
+
+// ParseSchedule returns the Schedule described by the given cron expression, made up of the minute, hour, day of
+// month, month and day of week fields. Each field is either a wildcard or a comma separated list of values and
+// ranges, optionally followed by a step, e.g. "0 22 * * 1-5" or "*/30 0-6 * * 0,6".
+func ParseSchedule(expression string) (*Schedule, error) {
+	tokens := strings.Fields(expression)
+	if len(tokens) != len(fields) {
+		return nil, fmt.Errorf("expected %d fields in schedule %q, found %d", len(fields), expression, len(tokens))
+	}
+	values := make([]map[int]bool, len(fields))
+	for i, token := range tokens {
+		var err error
+		values[i], err = parseField(token, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s field in schedule %q: %w", fields[i].name, expression, err)
+		}
+	}
+	// Sunday can be specified as either 0 or 7
+	if values[4][7] {
+		values[4][0] = true
+	}
+	return &Schedule{
+		minutes:       values[0],
+		hours:         values[1],
+		daysOfMon:     values[2],
+		months:        values[3],
+		daysOfWeek:    values[4],
+		domRestricted: tokens[2] != "*",
+		dowRestricted: tokens[4] != "*",
+	}, nil
+}
+
+// parseField returns the set of values described by the given token
+func parseField(token string, f field) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(token, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		start, end := f.min, f.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = parseValue(bounds[0], f); err != nil {
+				return nil, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = parseValue(bounds[1], f); err != nil {
+					return nil, err
+				}
+			} else if step != 1 {
+				// A single value with a step, e.g. 5/15, covers the range from the value to the maximum
+				end = f.max
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid range %q", rangePart)
+			}
+		}
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// parseValue returns the integer represented by the given string, erroring if it is outside of the field's range
+func parseValue(value string, f field) (int, error) {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if parsed < f.min || parsed > f.max {
+		return 0, fmt.Errorf("value %d outside of range %d-%d", parsed, f.min, f.max)
+	}
+	return parsed, nil
+}
+
+// Matches returns true if the minute containing the given time is part of the schedule
+func (s *Schedule) Matches(t time.Time) bool {
+	return s.minutes[t.Minute()] && s.hours[t.Hour()] && s.matchesDay(t) && s.months[int(t.Month())]
+}
+
+// matchesDay returns true if the day of the given time is part of the schedule
+func (s *Schedule) matchesDay(t time.Time) bool {
+	domMatch := s.daysOfMon[t.Day()]
+	dowMatch := s.daysOfWeek[int(t.Weekday())]
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// Next returns the first minute after the given time which is part of the schedule, in the location of the given
+// time. The zero time is returned if the schedule does not match any time within the next five years.
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
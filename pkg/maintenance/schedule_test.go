@@ -0,0 +1,166 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	testCases := []struct {
+		name        string
+		input       string
+		expectedErr bool
+	}{
+		{
+			name:        "wildcards",
+			input:       "* * * * *",
+			expectedErr: false,
+		},
+		{
+			name:        "lists ranges and steps",
+			input:       "0,30 22-23 1-15/2 */3 1-5",
+			expectedErr: false,
+		},
+		{
+			name:        "sunday as 7",
+			input:       "0 2 * * 7",
+			expectedErr: false,
+		},
+		{
+			name:        "too few fields",
+			input:       "0 2 * *",
+			expectedErr: true,
+		},
+		{
+			name:        "value out of range",
+			input:       "60 2 * * *",
+			expectedErr: true,
+		},
+		{
+			name:        "inverted range",
+			input:       "0 5-2 * * *",
+			expectedErr: true,
+		},
+		{
+			name:        "zero step",
+			input:       "*/0 * * * *",
+			expectedErr: true,
+		},
+		{
+			name:        "non-numeric value",
+			input:       "0 2 * * mon",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseSchedule(test.input)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestScheduleMatches(t *testing.T) {
+	// 2024-01-07 is a Sunday
+	sunday := time.Date(2024, 1, 7, 2, 0, 30, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		schedule string
+		input    time.Time
+		expected bool
+	}{
+		{
+			name:     "exact minute",
+			schedule: "0 2 * * *",
+			input:    sunday,
+			expected: true,
+		},
+		{
+			name:     "wrong minute",
+			schedule: "1 2 * * *",
+			input:    sunday,
+			expected: false,
+		},
+		{
+			name:     "sunday as 7",
+			schedule: "0 2 * * 7",
+			input:    sunday,
+			expected: true,
+		},
+		{
+			name:     "weekdays only",
+			schedule: "0 2 * * 1-5",
+			input:    sunday,
+			expected: false,
+		},
+		{
+			name:     "step",
+			schedule: "*/15 */2 * * *",
+			input:    sunday,
+			expected: true,
+		},
+		{
+			name:     "day of month or day of week when both restricted",
+			schedule: "0 2 1 * 0",
+			input:    sunday,
+			expected: true,
+		},
+		{
+			name:     "day of month when day of week unrestricted",
+			schedule: "0 2 1 * *",
+			input:    sunday,
+			expected: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.schedule)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, schedule.Matches(test.input))
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	after := time.Date(2024, 1, 31, 23, 30, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		schedule string
+		expected time.Time
+	}{
+		{
+			name:     "next minute",
+			schedule: "* * * * *",
+			expected: time.Date(2024, 1, 31, 23, 31, 0, 0, time.UTC),
+		},
+		{
+			name:     "next day",
+			schedule: "0 2 * * *",
+			expected: time.Date(2024, 2, 1, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "leap day",
+			schedule: "0 0 29 2 *",
+			expected: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "never matches",
+			schedule: "0 0 31 2 *",
+			expected: time.Time{},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.schedule)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, schedule.Next(after))
+		})
+	}
+}
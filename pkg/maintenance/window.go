@@ -0,0 +1,93 @@
+package maintenance
+
+import (
+	"fmt"
+	"time"
+	// Embed the time zone database, so that time zones can be loaded regardless of the contents of the operator image
+	_ "time/tzdata"
+)
+
+const (
+	// maxDuration is the maximum length of a maintenance window
+	maxDuration = 7 * 24 * time.Hour
+)
+
+// Window describes recurring periods of time during which disruptive operations can be performed on Windows nodes
+type Window struct {
+	// expression is the cron expression the schedule was parsed from
+	expression string
+	// schedule describes when each maintenance window starts
+	schedule *Schedule
+	// location is the time zone the schedule is evaluated in
+	location *time.Location
+	// duration is the length of each maintenance window
+	duration time.Duration
+}
+
+// Spec is the serializable description of a Window, through which the maintenance window is given to WICD
+type Spec struct {
+	// Schedule is the cron expression describing when each maintenance window starts
+	Schedule string `json:"schedule"`
+	// TimeZone is the IANA time zone the schedule is evaluated in
+	TimeZone string `json:"timeZone"`
+	// Duration is the length of each maintenance window
+	Duration string `json:"duration"`
+}
+
+// Window returns the Window described by the spec
+func (s *Spec) Window() (*Window, error) {
+	duration, err := time.ParseDuration(s.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration %q: %w", s.Duration, err)
+	}
+	return NewWindow(s.Schedule, s.TimeZone, duration)
+}
+
+// NewWindow returns a Window starting at the times described by the given cron expression, evaluated in the given IANA
+// time zone, and lasting for the given duration. An empty time zone is treated as UTC.
+func NewWindow(schedule, timeZone string, duration time.Duration) (*Window, error) {
+	parsedSchedule, err := ParseSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", timeZone, err)
+	}
+	if duration < time.Minute || duration > maxDuration {
+		return nil, fmt.Errorf("duration %s must be between %s and %s", duration, time.Minute, maxDuration)
+	}
+	if parsedSchedule.Next(time.Now().In(location)).IsZero() {
+		return nil, fmt.Errorf("schedule %q never matches", schedule)
+	}
+	return &Window{expression: schedule, schedule: parsedSchedule, location: location, duration: duration}, nil
+}
+
+// Active returns true if the given time is within a maintenance window
+func (w *Window) Active(now time.Time) bool {
+	now = now.In(w.location)
+	// Check if a window started within the window duration before the given time
+	earliestStart := now.Add(-w.duration)
+	for start := now.Truncate(time.Minute); start.After(earliestStart); start = start.Add(-time.Minute) {
+		if w.schedule.Matches(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns the start of the first maintenance window after the given time. The zero time is returned if the
+// schedule never matches.
+func (w *Window) NextStart(now time.Time) time.Time {
+	return w.schedule.Next(now.In(w.location))
+}
+
+// String returns a description of the maintenance window
+func (w *Window) String() string {
+	return fmt.Sprintf("%q in %s for %s", w.expression, w.location, w.duration)
+}
+
+// Spec returns the serializable description of the window
+func (w *Window) Spec() *Spec {
+	return &Spec{Schedule: w.expression, TimeZone: w.location.String(), Duration: w.duration.String()}
+}
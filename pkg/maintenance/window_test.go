@@ -0,0 +1,77 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWindow(t *testing.T) {
+	_, err := NewWindow("0 2 * * *", "", time.Hour)
+	assert.NoError(t, err)
+	_, err = NewWindow("0 2 * * *", "Not/AZone", time.Hour)
+	assert.Error(t, err)
+	_, err = NewWindow("0 2 * * *", "UTC", time.Second)
+	assert.Error(t, err)
+	_, err = NewWindow("0 0 31 2 *", "UTC", time.Hour)
+	assert.Error(t, err)
+}
+
+func TestWindow(t *testing.T) {
+	// Windows start at 22:00 New York time and last for four hours, crossing midnight
+	window, err := NewWindow("0 22 * * *", "America/New_York", 4*time.Hour)
+	require.NoError(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name              string
+		now               time.Time
+		expectedActive    bool
+		expectedNextStart time.Time
+	}{
+		{
+			name:              "before window",
+			now:               time.Date(2024, 1, 10, 21, 59, 0, 0, newYork),
+			expectedActive:    false,
+			expectedNextStart: time.Date(2024, 1, 10, 22, 0, 0, 0, newYork),
+		},
+		{
+			name:              "start of window",
+			now:               time.Date(2024, 1, 10, 22, 0, 0, 0, newYork),
+			expectedActive:    true,
+			expectedNextStart: time.Date(2024, 1, 11, 22, 0, 0, 0, newYork),
+		},
+		{
+			name:              "after midnight in window given in UTC",
+			now:               time.Date(2024, 1, 11, 6, 30, 0, 0, time.UTC),
+			expectedActive:    true,
+			expectedNextStart: time.Date(2024, 1, 11, 22, 0, 0, 0, newYork),
+		},
+		{
+			name:              "end of window",
+			now:               time.Date(2024, 1, 11, 2, 0, 0, 0, newYork),
+			expectedActive:    false,
+			expectedNextStart: time.Date(2024, 1, 11, 22, 0, 0, 0, newYork),
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expectedActive, window.Active(test.now))
+			assert.True(t, test.expectedNextStart.Equal(window.NextStart(test.now)))
+		})
+	}
+}
+
+func TestSpec(t *testing.T) {
+	window, err := NewWindow("0 22 * * 1-5", "America/New_York", 90*time.Minute)
+	require.NoError(t, err)
+	parsed, err := window.Spec().Window()
+	require.NoError(t, err)
+	assert.Equal(t, window.String(), parsed.String())
+
+	_, err = (&Spec{Schedule: "0 22 * * *", TimeZone: "UTC", Duration: "90"}).Window()
+	assert.Error(t, err)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path"
 	"strings"
+	"time"
 
 	core "k8s.io/api/core/v1"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
@@ -23,6 +24,18 @@ const (
 	DesiredVersionAnnotation = "windowsmachineconfig.openshift.io/desired-version"
 	// RebootAnnotation indicates the node's underlying instance needs to be restarted
 	RebootAnnotation = "windowsmachineconfig.openshift.io/reboot-required"
+	// PayloadRepushAnnotation indicates the payload files in the payload cache of the node's underlying instance are
+	// missing or modified, and must be transferred again so that modified payload files can be restored
+	PayloadRepushAnnotation = "windowsmachineconfig.openshift.io/payload-repush-required"
+	// DisruptionPendingAnnotation indicates that a disruptive operation on the node, such as a reboot or an upgrade, has
+	// been deferred until the next maintenance window. The value is the deferred operation.
+	DisruptionPendingAnnotation = "windowsmachineconfig.openshift.io/disruption-pending"
+	// NextMaintenanceWindowAnnotation is the start of the maintenance window a pending disruption is deferred until,
+	// in RFC 3339 format
+	NextMaintenanceWindowAnnotation = "windowsmachineconfig.openshift.io/next-maintenance-window"
+	// SSHHostKeyAnnotation is the SSH host key the node's underlying instance must present, in authorized_keys format.
+	// It is recorded by the first successful configuration of the instance if one was not provided beforehand.
+	SSHHostKeyAnnotation = "windowsmachineconfig.openshift.io/ssh-host-key"
 	// UpgradingLabel indicates the node's underlying instance is performing an upgrade
 	UpgradingLabel = "windowsmachineconfig.openshift.io/upgrading"
 )
@@ -126,6 +139,12 @@ func ApplyRebootAnnotation(ctx context.Context, c client.Client, node core.Node)
 	return ApplyLabelsAndAnnotations(ctx, c, node, nil, map[string]string{RebootAnnotation: ""})
 }
 
+// ApplyPayloadRepushAnnotation applies an annotation to the given Node communicating that the payload cache of the
+// instance needs to be populated again
+func ApplyPayloadRepushAnnotation(ctx context.Context, c client.Client, node core.Node) error {
+	return ApplyLabelsAndAnnotations(ctx, c, node, nil, map[string]string{PayloadRepushAnnotation: ""})
+}
+
 // RemoveVersionAnnotation clears the version annotation from the node object, indicating the node is not configured
 func RemoveVersionAnnotation(ctx context.Context, c client.Client, node core.Node) error {
 	if _, present := node.GetAnnotations()[VersionAnnotation]; present {
@@ -156,6 +175,60 @@ func RemoveRebootAnnotation(ctx context.Context, c client.Client, node core.Node
 	return nil
 }
 
+// RemovePayloadRepushAnnotation clears the payload re-push annotation from the node, indicating the payload cache of
+// the instance has been populated again
+func RemovePayloadRepushAnnotation(ctx context.Context, c client.Client, node core.Node) error {
+	if _, present := node.GetAnnotations()[PayloadRepushAnnotation]; present {
+		patchData, err := GenerateRemovePatch([]string{}, []string{PayloadRepushAnnotation})
+		if err != nil {
+			return fmt.Errorf("error creating payload re-push annotation remove request: %w", err)
+		}
+		err = c.Patch(ctx, &node, client.RawPatch(kubeTypes.JSONPatchType, patchData))
+		if err != nil {
+			return fmt.Errorf("error removing payload re-push annotation from node %s: %w", node.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// ApplyDisruptionPendingAnnotations applies annotations to the given Node communicating that the given disruptive
+// operation has been deferred until the maintenance window starting at the given time
+func ApplyDisruptionPendingAnnotations(ctx context.Context, c client.Client, node core.Node, operation string,
+	nextWindow time.Time) error {
+	return ApplyLabelsAndAnnotations(ctx, c, node, nil, map[string]string{DisruptionPendingAnnotation: operation,
+		NextMaintenanceWindowAnnotation: nextWindow.UTC().Format(time.RFC3339)})
+}
+
+// IsDisruptionPending returns true if the given Node is annotated as having the given disruptive operation deferred
+// until the maintenance window starting at the given time
+func IsDisruptionPending(node core.Node, operation string, nextWindow time.Time) bool {
+	return node.GetAnnotations()[DisruptionPendingAnnotation] == operation &&
+		node.GetAnnotations()[NextMaintenanceWindowAnnotation] == nextWindow.UTC().Format(time.RFC3339)
+}
+
+// RemoveDisruptionPendingAnnotations clears the disruption pending annotations from the node, indicating no disruptive
+// operation is waiting for a maintenance window
+func RemoveDisruptionPendingAnnotations(ctx context.Context, c client.Client, node core.Node) error {
+	var present []string
+	for _, annotation := range []string{DisruptionPendingAnnotation, NextMaintenanceWindowAnnotation} {
+		if _, ok := node.GetAnnotations()[annotation]; ok {
+			present = append(present, annotation)
+		}
+	}
+	if len(present) == 0 {
+		return nil
+	}
+	patchData, err := GenerateRemovePatch([]string{}, present)
+	if err != nil {
+		return fmt.Errorf("error creating disruption pending annotations remove request: %w", err)
+	}
+	err = c.Patch(ctx, &node, client.RawPatch(kubeTypes.JSONPatchType, patchData))
+	if err != nil {
+		return fmt.Errorf("error removing disruption pending annotations from node %s: %w", node.GetName(), err)
+	}
+	return nil
+}
+
 // WaitForVersionAnnotation checks if the node object has equivalent version and desiredVersion annotations.
 // Waits for retry.Interval seconds and returns an error if the version annotation does not appear in that time frame.
 func WaitForVersionAnnotation(ctx context.Context, c client.Client, nodeName string) error {
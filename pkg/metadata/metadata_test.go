@@ -2,9 +2,12 @@ package metadata
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/patch"
 )
@@ -138,3 +141,41 @@ func TestGeneratePatch(t *testing.T) {
 		})
 	}
 }
+
+func TestIsDisruptionPending(t *testing.T) {
+	nextWindow := time.Date(2024, 1, 6, 2, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expectedOut bool
+	}{
+		{
+			name:        "no pending disruption",
+			expectedOut: false,
+		},
+		{
+			name: "operation pending until the window",
+			annotations: map[string]string{DisruptionPendingAnnotation: "Reboot",
+				NextMaintenanceWindowAnnotation: "2024-01-06T02:00:00Z"},
+			expectedOut: true,
+		},
+		{
+			name: "operation pending until an earlier window",
+			annotations: map[string]string{DisruptionPendingAnnotation: "Reboot",
+				NextMaintenanceWindowAnnotation: "2024-01-05T02:00:00Z"},
+			expectedOut: false,
+		},
+		{
+			name: "other operation pending",
+			annotations: map[string]string{DisruptionPendingAnnotation: "Upgrade",
+				NextMaintenanceWindowAnnotation: "2024-01-06T02:00:00Z"},
+			expectedOut: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := core.Node{ObjectMeta: meta.ObjectMeta{Annotations: test.annotations}}
+			assert.Equal(t, test.expectedOut, IsDisruptionPending(node, "Reboot", nextWindow))
+		})
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 
 	monv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	monclient "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned/typed/monitoring/v1"
 	"k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -25,7 +29,8 @@ import (
 )
 
 //+kubebuilder:rbac:groups="",resources=services;services/finalizers,verbs=create;get;delete
-//+kubebuilder:rbac:groups="",resources=endpoints,verbs=create;get;delete;update;patch
+//+kubebuilder:rbac:groups="",resources=endpoints,verbs=create;get;update;patch
+//+kubebuilder:rbac:groups="discovery.k8s.io",resources=endpointslices,verbs=create;get;update;delete
 //+kubebuilder:rbac:groups="",resources=namespaces,verbs=get
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=list
 //+kubebuilder:rbac:groups="monitoring.coreos.com",resources=servicemonitors,verbs=create,get,delete
@@ -47,6 +52,9 @@ const (
 	// WindowsMetricsResource is the name for objects created for Prometheus monitoring
 	// by current operator version. Its name is defined through the bundle manifests
 	WindowsMetricsResource = "windows-exporter"
+	// EndpointSliceManager is the managed-by label value of the metrics EndpointSlices, which prevents the
+	// EndpointSlice controllers of Kubernetes from managing them
+	EndpointSliceManager = "windows-machine-config-operator"
 )
 
 // PrometheusNodeConfig holds the information required to configure Prometheus, so that it can scrape metrics from the
@@ -99,40 +107,99 @@ func NewConfig(mgr manager.Manager, cfg *rest.Config, namespace string) (*Config
 	}, nil
 }
 
-// syncMetricsEndpoint updates the endpoint object with the new list of IP addresses from the Windows nodes and the
-// metrics port.
-func (pc *PrometheusNodeConfig) syncMetricsEndpoint(nodeEndpointAdressess []v1.EndpointAddress) error {
-	// Update EndpointSubset field with list of Windows Nodes endpoint addresses and required metrics port information
-	// We need to patch the entire endpoint subset field, since addresses and ports both fields are deleted when there
-	// are no Windows nodes.
-	var subsets []v1.EndpointSubset
-	if nodeEndpointAdressess != nil {
-		subsets = []v1.EndpointSubset{{
-			Addresses: nodeEndpointAdressess,
-			Ports: []v1.EndpointPort{{
-				Name:     PortName,
-				Port:     Port,
-				Protocol: v1.ProtocolTCP,
-			}},
-		}}
+// endpointSliceName returns the name of the EndpointSlice holding the metrics endpoints of the given address type
+func endpointSliceName(addressType discovery.AddressType) string {
+	return WindowsMetricsResource + "-" + strings.ToLower(string(addressType))
+}
+
+// syncEndpointSlice ensures the EndpointSlice of the given service and address type holds the given endpoints. The
+// EndpointSlice is updated in place when the endpoints change, so that the targets which remain are not dropped by
+// Prometheus, and it is removed when there are no endpoints of its address type.
+func (pc *PrometheusNodeConfig) syncEndpointSlice(service *v1.Service, addressType discovery.AddressType,
+	endpoints []discovery.Endpoint) error {
+	name := endpointSliceName(addressType)
+	slices := pc.k8sclientset.DiscoveryV1().EndpointSlices(pc.namespace)
+	existing, err := slices.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error retrieving EndpointSlice %s: %w", name, err)
 	}
+	found := err == nil
 
-	patchData := []*patch.JSONPatch{patch.NewJSONPatch("replace", "/subsets", subsets)}
-	// convert patch data to bytes
-	patchDataBytes, err := json.Marshal(patchData)
-	if err != nil {
-		return fmt.Errorf("unable to get patch data in bytes: %w", err)
+	if len(endpoints) == 0 {
+		if !found {
+			return nil
+		}
+		if err := slices.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting EndpointSlice %s: %w", name, err)
+		}
+		log.Info("Prometheus configured", "deleted EndpointSlice", name)
+		return nil
 	}
 
-	_, err = pc.k8sclientset.CoreV1().Endpoints(pc.namespace).
-		Patch(context.TODO(), WindowsMetricsResource, types.JSONPatchType, patchDataBytes, metav1.PatchOptions{})
-	if err != nil {
-		return fmt.Errorf("unable to sync metrics endpoints: %w", err)
+	expected := newEndpointSlice(name, pc.namespace, addressType, endpoints, service)
+	if !found {
+		if _, err := slices.Create(context.TODO(), expected, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating EndpointSlice %s: %w", name, err)
+		}
+		log.Info("Prometheus configured", "EndpointSlice", name, "endpoints", len(endpoints))
+		return nil
+	}
+	if isEndpointSliceValid(existing, expected) {
+		return nil
 	}
+	existing.SetLabels(expected.GetLabels())
+	existing.SetOwnerReferences(expected.GetOwnerReferences())
+	existing.Endpoints = expected.Endpoints
+	existing.Ports = expected.Ports
+	if _, err := slices.Update(context.TODO(), existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating EndpointSlice %s: %w", name, err)
+	}
+	log.Info("Prometheus configured", "EndpointSlice", name, "endpoints", len(endpoints))
 	return nil
 }
 
-// Configure patches the endpoint object to reflect the current list Windows nodes.
+// newEndpointSlice returns an EndpointSlice of the given service, holding the given metrics endpoints
+func newEndpointSlice(name, namespace string, addressType discovery.AddressType, endpoints []discovery.Endpoint,
+	service *v1.Service) *discovery.EndpointSlice {
+	portName := PortName
+	port := Port
+	protocol := v1.ProtocolTCP
+	return &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"name":                     WindowsMetricsResource,
+				discovery.LabelServiceName: WindowsMetricsResource,
+				discovery.LabelManagedBy:   EndpointSliceManager,
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(service,
+				v1.SchemeGroupVersion.WithKind("Service"))},
+		},
+		AddressType: addressType,
+		Endpoints:   endpoints,
+		Ports: []discovery.EndpointPort{{
+			Name:     &portName,
+			Port:     &port,
+			Protocol: &protocol,
+		}},
+	}
+}
+
+// isEndpointSliceValid returns true if the given EndpointSlice has the labels, owner, endpoints and ports of the
+// expected one
+func isEndpointSliceValid(existing, expected *discovery.EndpointSlice) bool {
+	for key, value := range expected.GetLabels() {
+		if existing.GetLabels()[key] != value {
+			return false
+		}
+	}
+	return reflect.DeepEqual(existing.GetOwnerReferences(), expected.GetOwnerReferences()) &&
+		reflect.DeepEqual(existing.Endpoints, expected.Endpoints) && reflect.DeepEqual(existing.Ports, expected.Ports)
+}
+
+// Configure updates the metrics EndpointSlices and Endpoints to reflect the current list of Windows nodes.
 func (pc *PrometheusNodeConfig) Configure() error {
 	// Check if metrics are enabled in current cluster
 	if !metricsEnabled {
@@ -146,18 +213,33 @@ func (pc *PrometheusNodeConfig) Configure() error {
 		return fmt.Errorf("could not get Windows nodes: %w", err)
 	}
 
-	// get Metrics Endpoints object
+	service, err := pc.k8sclientset.CoreV1().Services(pc.namespace).Get(context.TODO(), WindowsMetricsResource,
+		metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error retrieving %s service: %w", WindowsMetricsResource, err)
+	}
+	// Only the addresses of the service's IP family are published, so that nodes of dual-stack clusters are scraped
+	// once. The EndpointSlice of the other address type is removed.
+	addressType := serviceAddressType(service)
+	for _, sliceAddressType := range []discovery.AddressType{discovery.AddressTypeIPv4, discovery.AddressTypeIPv6} {
+		var endpoints []discovery.Endpoint
+		if sliceAddressType == addressType {
+			endpoints = getNodeEndpoints(nodes, addressType)
+		}
+		if err := pc.syncEndpointSlice(service, sliceAddressType, endpoints); err != nil {
+			return fmt.Errorf("error syncing metrics endpoints: %w", err)
+		}
+	}
+
+	// The Endpoints object is kept in sync alongside the EndpointSlices, as Prometheus discovers the metrics endpoints
+	// through it
 	endpoints, err := pc.k8sclientset.CoreV1().Endpoints(pc.namespace).Get(context.TODO(),
 		WindowsMetricsResource, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("could not get metrics endpoints %v: %w", WindowsMetricsResource, err)
 	}
-
 	if !isEndpointsValid(nodes, endpoints) {
-		// check if we can get list of endpoint addresses
-		windowsIPList := getNodeEndpointAddresses(nodes)
-		// sync metrics endpoints object with the current list of addresses
-		if err := pc.syncMetricsEndpoint(windowsIPList); err != nil {
+		if err := pc.syncMetricsEndpoint(getNodeEndpointAddresses(nodes, addressType)); err != nil {
 			return fmt.Errorf("error updating endpoints object with list of endpoint addresses: %w", err)
 		}
 		log.Info("Prometheus configured", "endpoints", WindowsMetricsResource, "port", Port, "name", PortName)
@@ -165,14 +247,48 @@ func (pc *PrometheusNodeConfig) Configure() error {
 	return nil
 }
 
-// getNodeEndpointAddresses returns a list of endpoint addresses according to the given list of Windows nodes
-func getNodeEndpointAddresses(nodes *v1.NodeList) []v1.EndpointAddress {
+// syncMetricsEndpoint updates the endpoint object with the new list of IP addresses from the Windows nodes and the
+// metrics port.
+func (pc *PrometheusNodeConfig) syncMetricsEndpoint(nodeEndpointAdressess []v1.EndpointAddress) error {
+	// Update EndpointSubset field with list of Windows Nodes endpoint addresses and required metrics port information
+	// We need to patch the entire endpoint subset field, since addresses and ports both fields are deleted when there
+	// are no Windows nodes.
+	var subsets []v1.EndpointSubset
+	if nodeEndpointAdressess != nil {
+		subsets = []v1.EndpointSubset{{
+			Addresses: nodeEndpointAdressess,
+			Ports: []v1.EndpointPort{{
+				Name:     PortName,
+				Port:     Port,
+				Protocol: v1.ProtocolTCP,
+			}},
+		}}
+	}
+
+	patchData := []*patch.JSONPatch{patch.NewJSONPatch("replace", "/subsets", subsets)}
+	// convert patch data to bytes
+	patchDataBytes, err := json.Marshal(patchData)
+	if err != nil {
+		return fmt.Errorf("unable to get patch data in bytes: %w", err)
+	}
+
+	_, err = pc.k8sclientset.CoreV1().Endpoints(pc.namespace).
+		Patch(context.TODO(), WindowsMetricsResource, types.JSONPatchType, patchDataBytes, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to sync metrics endpoints: %w", err)
+	}
+	return nil
+}
+
+// getNodeEndpointAddresses returns a list of endpoint addresses according to the given list of Windows nodes. The
+// address of each node is its first InternalIP of the given address type.
+func getNodeEndpointAddresses(nodes *v1.NodeList, addressType discovery.AddressType) []v1.EndpointAddress {
 	// an empty list to store node IP addresses
 	var nodeIPAddress []v1.EndpointAddress
 	// loops through nodes
 	for _, node := range nodes.Items {
 		for _, address := range node.Status.Addresses {
-			if address.Type == "InternalIP" && address.Address != "" {
+			if address.Type == v1.NodeInternalIP && addressTypeOf(address.Address) == addressType {
 				// add IP address address to the endpoint address list
 				nodeIPAddress = append(nodeIPAddress, v1.EndpointAddress{
 					IP:       address.Address,
@@ -218,41 +334,80 @@ func isEndpointsValid(nodes *v1.NodeList, endpoints *v1.Endpoints) bool {
 	return true
 }
 
+// getNodeEndpoints returns the metrics endpoints of the given address type of the given Windows nodes, sorted by node
+// name. The endpoint of each node is its first InternalIP of the address type.
+func getNodeEndpoints(nodes *v1.NodeList, addressType discovery.AddressType) []discovery.Endpoint {
+	var endpoints []discovery.Endpoint
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type != v1.NodeInternalIP || addressTypeOf(address.Address) != addressType {
+				continue
+			}
+			ready := true
+			nodeName := node.GetName()
+			endpoints = append(endpoints, discovery.Endpoint{
+				Addresses:  []string{address.Address},
+				Conditions: discovery.EndpointConditions{Ready: &ready},
+				NodeName:   &nodeName,
+				TargetRef: &v1.ObjectReference{
+					Kind: "Node",
+					Name: nodeName,
+				},
+			})
+			break
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return *endpoints[i].NodeName < *endpoints[j].NodeName
+	})
+	return endpoints
+}
+
+// serviceAddressType returns the address type of the primary IP family of the given service, which is the primary IP
+// family of the cluster. IPv4 is returned if the service has no IP family.
+func serviceAddressType(service *v1.Service) discovery.AddressType {
+	if len(service.Spec.IPFamilies) > 0 && service.Spec.IPFamilies[0] == v1.IPv6Protocol {
+		return discovery.AddressTypeIPv6
+	}
+	return discovery.AddressTypeIPv4
+}
+
+// addressTypeOf returns the address type of the given IP address, or an empty address type if it is not an IP
+// address
+func addressTypeOf(address string) discovery.AddressType {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return discovery.AddressTypeIPv4
+	default:
+		return discovery.AddressTypeIPv6
+	}
+}
+
 // Configure takes care of all the required configuration steps
 // for Prometheus monitoring like validating monitoring label
-// and creating metrics Endpoints object.
+// and syncing the metrics Endpoints and EndpointSlices.
 func (c *Config) Configure(ctx context.Context) error {
 	// validate if cluster monitoring is enabled in the operator namespace
 	enabled, err := c.validate(ctx)
 	if err != nil {
 		return fmt.Errorf("error validating cluster monitoring label: %s", err)
 	}
-	// Create Metrics Endpoint object only if monitoring is enabled
+	// Sync the metrics endpoints only if monitoring is enabled
 	if !enabled {
 		return nil
 	}
 	if err := c.ensureServiceMonitor(); err != nil {
 		return fmt.Errorf("error ensuring serviceMonitor exists: %w", err)
 	}
-	// In the case of an operator restart, a previous Endpoint object will be deleted and a new one will
-	// be created to ensure we have a correct spec.
-	var subsets []v1.EndpointSubset
-	existingEndpoint, err := c.CoreV1().Endpoints(c.namespace).Get(ctx, WindowsMetricsResource, metav1.GetOptions{})
-	if err != nil {
-		if !apierrors.IsNotFound(err) {
-			return fmt.Errorf("error retrieving %s endpoint: %w", WindowsMetricsResource, err)
-		}
-	} else {
-		subsets = existingEndpoint.Subsets
-		err = c.CoreV1().Endpoints(c.namespace).Delete(ctx, WindowsMetricsResource, metav1.DeleteOptions{})
-		if err != nil {
-			return fmt.Errorf("error deleting %s endpoint: %w", WindowsMetricsResource, err)
-		}
+	if err := c.ensureEndpoints(ctx); err != nil {
+		return fmt.Errorf("error ensuring metrics Endpoints exists: %w", err)
 	}
-	if err := c.createEndpoint(subsets); err != nil {
-		return fmt.Errorf("error creating metrics Endpoint: %w", err)
-	}
-	return nil
+	// The metrics endpoints are kept across operator restarts, and only updated to reflect the current Windows nodes
+	pc := &PrometheusNodeConfig{k8sclientset: c.Clientset, namespace: c.namespace}
+	return pc.Configure()
 }
 
 // validate will verify if cluster monitoring is enabled in the operator namespace. If the label is set to false or not
@@ -280,28 +435,40 @@ func (c *Config) validate(ctx context.Context) (bool, error) {
 	return metricsEnabled, nil
 }
 
-// createEndpoint creates an endpoint object in the operator namespace.
-// WMCO is no longer creating a service with a selector therefore no Endpoint
-// object is created and WMCO needs to create the Endpoint object.
-// We cannot create endpoints as a part of manifests deployment as
-// Endpoints resources are not currently OLM-supported for bundle creation.
-func (c *Config) createEndpoint(subsets []v1.EndpointSubset) error {
-	// create new Endpoint
-	newEndpoint := &v1.Endpoints{
-		TypeMeta: metav1.TypeMeta{
-			Kind: "Endpoints",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      WindowsMetricsResource,
-			Namespace: c.namespace,
-			Labels:    map[string]string{"name": WindowsMetricsResource},
-		},
-		Subsets: subsets,
-	}
-	_, err := c.CoreV1().Endpoints(c.namespace).Create(context.TODO(),
-		newEndpoint, metav1.CreateOptions{})
+// ensureEndpoints creates the metrics Endpoints object in the operator namespace if it does not exist. An existing
+// Endpoints object is updated in place rather than recreated, so that its targets are kept across operator restarts.
+// The Endpoints object is excluded from EndpointSlice mirroring, as the EndpointSlices of the service are managed by
+// WMCO. We cannot create endpoints as a part of manifests deployment as Endpoints resources are not currently
+// OLM-supported for bundle creation.
+func (c *Config) ensureEndpoints(ctx context.Context) error {
+	existing, err := c.CoreV1().Endpoints(c.namespace).Get(ctx, WindowsMetricsResource, metav1.GetOptions{})
 	if err != nil {
-		return fmt.Errorf("error creating metrics Endpoint: %w", err)
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error retrieving %s endpoints: %w", WindowsMetricsResource, err)
+		}
+		endpoints := &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      WindowsMetricsResource,
+				Namespace: c.namespace,
+				Labels:    map[string]string{"name": WindowsMetricsResource, discovery.LabelSkipMirror: "true"},
+			},
+		}
+		if _, err := c.CoreV1().Endpoints(c.namespace).Create(ctx, endpoints, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating %s endpoints: %w", WindowsMetricsResource, err)
+		}
+		return nil
+	}
+	if existing.GetLabels()[discovery.LabelSkipMirror] == "true" {
+		return nil
+	}
+	labels := existing.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[discovery.LabelSkipMirror] = "true"
+	existing.SetLabels(labels)
+	if _, err := c.CoreV1().Endpoints(c.namespace).Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating %s endpoints: %w", WindowsMetricsResource, err)
 	}
 	return nil
 }
@@ -5,9 +5,212 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// nodeWithAddresses returns a node with the given name and addresses
+func nodeWithAddresses(name string, addresses ...v1.NodeAddress) v1.Node {
+	return v1.Node{ObjectMeta: meta.ObjectMeta{Name: name}, Status: v1.NodeStatus{Addresses: addresses}}
+}
+
+// endpointAddresses returns the node names and addresses of the given endpoints
+func endpointAddresses(endpoints []discovery.Endpoint) map[string][]string {
+	addresses := make(map[string][]string)
+	for _, endpoint := range endpoints {
+		addresses[*endpoint.NodeName] = endpoint.Addresses
+	}
+	return addresses
+}
+
+func TestGetNodeEndpoints(t *testing.T) {
+	nodes := &v1.NodeList{Items: []v1.Node{
+		nodeWithAddresses("ipv6-only",
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "fd00::2"}),
+		nodeWithAddresses("dual-stack",
+			v1.NodeAddress{Type: v1.NodeHostName, Address: "dual-stack"},
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: "203.0.113.1"},
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.2"},
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "fd00::1"}),
+		nodeWithAddresses("ipv4-only",
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.3"}),
+		nodeWithAddresses("no-internal-ip",
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: ""},
+			v1.NodeAddress{Type: v1.NodeInternalDNS, Address: "no-internal-ip.example.com"}),
+	}}
+
+	tests := []struct {
+		name        string
+		addressType discovery.AddressType
+		want        map[string][]string
+	}{
+		{
+			name:        "IPv4",
+			addressType: discovery.AddressTypeIPv4,
+			want:        map[string][]string{"dual-stack": {"10.0.0.1"}, "ipv4-only": {"10.0.0.3"}},
+		},
+		{
+			name:        "IPv6",
+			addressType: discovery.AddressTypeIPv6,
+			want:        map[string][]string{"dual-stack": {"fd00::1"}, "ipv6-only": {"fd00::2"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoints := getNodeEndpoints(nodes, test.addressType)
+			assert.Equal(t, test.want, endpointAddresses(endpoints))
+			for i, endpoint := range endpoints {
+				assert.Equal(t, "Node", endpoint.TargetRef.Kind)
+				assert.Equal(t, *endpoint.NodeName, endpoint.TargetRef.Name)
+				if i > 0 {
+					assert.Less(t, *endpoints[i-1].NodeName, *endpoint.NodeName, "endpoints must be sorted")
+				}
+			}
+		})
+	}
+}
+
+func TestGetNodeEndpointAddresses(t *testing.T) {
+	nodes := &v1.NodeList{Items: []v1.Node{
+		nodeWithAddresses("ipv6-only",
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "fd00::2"}),
+		nodeWithAddresses("dual-stack",
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: "203.0.113.1"},
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "fd00::1"}),
+		nodeWithAddresses("ipv4-only",
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.3"}),
+	}}
+
+	tests := []struct {
+		name        string
+		addressType discovery.AddressType
+		want        map[string]string
+	}{
+		{
+			name:        "IPv4",
+			addressType: discovery.AddressTypeIPv4,
+			want:        map[string]string{"dual-stack": "10.0.0.1", "ipv4-only": "10.0.0.3"},
+		},
+		{
+			name:        "IPv6",
+			addressType: discovery.AddressTypeIPv6,
+			want:        map[string]string{"dual-stack": "fd00::1", "ipv6-only": "fd00::2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addresses := make(map[string]string)
+			for _, address := range getNodeEndpointAddresses(nodes, test.addressType) {
+				addresses[address.TargetRef.Name] = address.IP
+			}
+			assert.Equal(t, test.want, addresses)
+		})
+	}
+}
+
+func TestServiceAddressType(t *testing.T) {
+	tests := []struct {
+		name       string
+		ipFamilies []v1.IPFamily
+		want       discovery.AddressType
+	}{
+		{
+			name: "no IP family",
+			want: discovery.AddressTypeIPv4,
+		},
+		{
+			name:       "IPv4 primary",
+			ipFamilies: []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol},
+			want:       discovery.AddressTypeIPv4,
+		},
+		{
+			name:       "IPv6 primary",
+			ipFamilies: []v1.IPFamily{v1.IPv6Protocol, v1.IPv4Protocol},
+			want:       discovery.AddressTypeIPv6,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &v1.Service{Spec: v1.ServiceSpec{IPFamilies: test.ipFamilies}}
+			assert.Equal(t, test.want, serviceAddressType(service))
+		})
+	}
+}
+
+func TestIsEndpointSliceValid(t *testing.T) {
+	service := &v1.Service{ObjectMeta: meta.ObjectMeta{Name: WindowsMetricsResource, UID: "service-uid"}}
+	nodes := &v1.NodeList{Items: []v1.Node{
+		nodeWithAddresses("node-one", v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"}),
+		nodeWithAddresses("node-two", v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.2"}),
+	}}
+	expected := newEndpointSlice("windows-exporter-ipv4", "test", discovery.AddressTypeIPv4,
+		getNodeEndpoints(nodes, discovery.AddressTypeIPv4), service)
+
+	tests := []struct {
+		name   string
+		modify func(*discovery.EndpointSlice)
+		want   bool
+	}{
+		{
+			name:   "identical",
+			modify: func(*discovery.EndpointSlice) {},
+			want:   true,
+		},
+		{
+			name: "additional labels",
+			modify: func(slice *discovery.EndpointSlice) {
+				slice.Labels["extra"] = "label"
+			},
+			want: true,
+		},
+		{
+			name: "managed by another controller",
+			modify: func(slice *discovery.EndpointSlice) {
+				slice.Labels[discovery.LabelManagedBy] = "endpointslicemirroring-controller.k8s.io"
+			},
+			want: false,
+		},
+		{
+			name: "missing owner",
+			modify: func(slice *discovery.EndpointSlice) {
+				slice.OwnerReferences = nil
+			},
+			want: false,
+		},
+		{
+			name: "missing endpoint",
+			modify: func(slice *discovery.EndpointSlice) {
+				slice.Endpoints = slice.Endpoints[:1]
+			},
+			want: false,
+		},
+		{
+			name: "changed address",
+			modify: func(slice *discovery.EndpointSlice) {
+				slice.Endpoints[1].Addresses = []string{"10.0.0.3"}
+			},
+			want: false,
+		},
+		{
+			name: "missing ports",
+			modify: func(slice *discovery.EndpointSlice) {
+				slice.Ports = nil
+			},
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			existing := newEndpointSlice("windows-exporter-ipv4", "test", discovery.AddressTypeIPv4,
+				getNodeEndpoints(nodes, discovery.AddressTypeIPv4), service)
+			test.modify(existing)
+			assert.Equal(t, test.want, isEndpointSliceValid(existing, expected))
+		})
+	}
+}
+
 func TestIsEndpointsValid(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/version"
+)
+
+// windowsNodesCollector collects the number of Windows nodes configured by each version of WMCO
+type windowsNodesCollector struct {
+	// client is used to list the Windows nodes each time metrics are collected
+	client client.Reader
+	// desc describes the metric collected
+	desc *prometheus.Desc
+}
+
+// NewWindowsNodesCollector returns a collector of the number of Windows nodes by the version of WMCO which configured
+// them, and whether that is the current version. Nodes which are not yet configured have an empty version.
+func NewWindowsNodesCollector(c client.Reader) prometheus.Collector {
+	return &windowsNodesCollector{
+		client: c,
+		desc: prometheus.NewDesc("wmco_windows_nodes",
+			"Number of Windows nodes by the version of WMCO which configured them",
+			[]string{"version", "up_to_date"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *windowsNodesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *windowsNodesCollector) Collect(ch chan<- prometheus.Metric) {
+	nodes := &core.NodeList{}
+	if err := c.client.List(context.TODO(), nodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	counts := make(map[string]int)
+	for _, node := range nodes.Items {
+		counts[node.GetAnnotations()[metadata.VersionAnnotation]]++
+	}
+	for nodeVersion, count := range counts {
+		upToDate := "false"
+		if nodeVersion != "" && nodeVersion == version.Get() {
+			upToDate = "true"
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), nodeVersion, upToDate)
+	}
+}
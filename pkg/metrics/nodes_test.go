@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/version"
+)
+
+// testNode returns a node with the given name and OS, annotated with the given WMCO version if not empty
+func testNode(name, os, wmcoVersion string) *core.Node {
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: name, Labels: map[string]string{core.LabelOSStable: os}}}
+	if wmcoVersion != "" {
+		node.SetAnnotations(map[string]string{metadata.VersionAnnotation: wmcoVersion})
+	}
+	return node
+}
+
+func TestWindowsNodesCollector(t *testing.T) {
+	previousVersion := version.Version
+	version.Version = "9.0.0"
+	defer func() { version.Version = previousVersion }()
+
+	c := clientfake.NewClientBuilder().WithObjects(
+		testNode("linux", "linux", ""),
+		testNode("current-1", "windows", version.Get()),
+		testNode("current-2", "windows", version.Get()),
+		testNode("previous", "windows", "8.0.0"),
+		testNode("unconfigured", "windows", ""),
+	).Build()
+
+	ch := make(chan prometheus.Metric, 10)
+	NewWindowsNodesCollector(c).Collect(ch)
+	close(ch)
+
+	actual := make(map[string]float64)
+	for metric := range ch {
+		m := &dto.Metric{}
+		require.NoError(t, metric.Write(m))
+		labels := make(map[string]string)
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		actual[labels["version"]+"/"+labels["up_to_date"]] = m.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{
+		"9.0.0/true":  2,
+		"8.0.0/false": 1,
+		"/false":      1,
+	}, actual)
+}
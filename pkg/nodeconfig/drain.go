@@ -0,0 +1,101 @@
+package nodeconfig
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubectl/pkg/drain"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
+)
+
+// notReadySkipWaitForDeleteSeconds is the time after which the deletion of a pod evicted from a node which is not
+// ready is no longer waited on, as the pod cannot terminate until the node is back
+const notReadySkipWaitForDeleteSeconds = 60
+
+// DrainError indicates that a node could not be drained, and that the operation requiring the drain was not performed
+type DrainError struct {
+	// Node is the name of the node which could not be drained
+	Node string
+	// Err is the reason the drain failed
+	Err error
+}
+
+// Error returns a description of the drain failure
+func (e *DrainError) Error() string {
+	return fmt.Sprintf("unable to drain node %s: %v", e.Node, e.Err)
+}
+
+// Unwrap returns the reason the drain failed
+func (e *DrainError) Unwrap() error {
+	return e.Err
+}
+
+// cordonAndDrain cordons and drains the node, as described by the operator's drain policy. If the drain fails, a
+// best effort is made to uncordon the node, so that it keeps serving workloads until the operation is retried, and a
+// DrainError is returned.
+func (nc *nodeConfig) cordonAndDrain(ctx context.Context) error {
+	config, err := operatorconfig.Get(ctx, nc.client, nc.wmcoNamespace)
+	if err != nil {
+		return err
+	}
+	drainCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	helper := nc.newDrainHelper()
+	helper.Ctx = drainCtx
+	blockedPod := applyDrainPolicy(helper, config.Drain, cancel)
+	if !nodeutil.IsReady(nc.node) {
+		helper.SkipWaitForDeleteTimeoutSeconds = notReadySkipWaitForDeleteSeconds
+	}
+
+	if err := drain.RunCordonOrUncordon(helper, nc.node, true); err != nil {
+		return fmt.Errorf("unable to cordon node %s: %w", nc.node.GetName(), err)
+	}
+	if err := drain.RunNodeDrain(helper, nc.node.GetName()); err != nil {
+		if pod := blockedPod(); pod != "" {
+			err = fmt.Errorf("eviction of pod %s blocked by a PodDisruptionBudget: %w", pod, err)
+		}
+		if uncordonErr := drain.RunCordonOrUncordon(nc.newDrainHelper(), nc.node, false); uncordonErr != nil {
+			nc.log.Info("unable to uncordon", "node", nc.node.GetName(), "error", uncordonErr)
+		}
+		return &DrainError{Node: nc.node.GetName(), Err: err}
+	}
+	return nil
+}
+
+// applyDrainPolicy configures the given drain helper as described by the given policy. If blocked evictions should
+// not be retried, the given cancel function is called when an eviction is blocked, stopping the drain. The returned
+// function returns the namespaced name of the pod whose eviction was blocked, if any.
+func applyDrainPolicy(helper *drain.Helper, policy operatorconfig.DrainPolicy, cancel func()) func() string {
+	helper.GracePeriodSeconds = policy.GracePeriodSeconds
+	helper.Timeout = policy.Timeout
+	helper.DeleteEmptyDirData = policy.DeleteEmptyDirData
+	helper.DisableEviction = policy.DisableEviction
+
+	var lock sync.Mutex
+	blockedPod := ""
+	if !policy.DisableEviction && !policy.RetryBlockedEvictions {
+		started := make(map[types.UID]struct{})
+		// An eviction is only started more than once for the same pod when the previous attempt was rejected, which
+		// happens when the eviction would violate a PodDisruptionBudget
+		helper.OnPodDeletionOrEvictionStarted = func(pod *core.Pod, _ bool) {
+			lock.Lock()
+			defer lock.Unlock()
+			if _, present := started[pod.GetUID()]; present {
+				blockedPod = pod.GetNamespace() + "/" + pod.GetName()
+				cancel()
+				return
+			}
+			started[pod.GetUID()] = struct{}{}
+		}
+	}
+	return func() string {
+		lock.Lock()
+		defer lock.Unlock()
+		return blockedPod
+	}
+}
@@ -0,0 +1,64 @@
+package nodeconfig
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubectl/pkg/drain"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
+)
+
+func TestApplyDrainPolicy(t *testing.T) {
+	pod := &core.Pod{ObjectMeta: meta.ObjectMeta{Name: "pod", Namespace: "ns", UID: "uid"}}
+
+	testCases := []struct {
+		name            string
+		policy          operatorconfig.DrainPolicy
+		expectCancelled bool
+	}{
+		{
+			name: "blocked evictions retried",
+			policy: operatorconfig.DrainPolicy{GracePeriodSeconds: 30, Timeout: time.Minute,
+				DeleteEmptyDirData: true, RetryBlockedEvictions: true},
+			expectCancelled: false,
+		},
+		{
+			name:            "blocked evictions not retried",
+			policy:          operatorconfig.DrainPolicy{GracePeriodSeconds: -1, RetryBlockedEvictions: false},
+			expectCancelled: true,
+		},
+		{
+			name:            "pods deleted rather than evicted",
+			policy:          operatorconfig.DrainPolicy{GracePeriodSeconds: -1, DisableEviction: true},
+			expectCancelled: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			helper := &drain.Helper{}
+			cancelled := false
+			blockedPod := applyDrainPolicy(helper, test.policy, func() { cancelled = true })
+			assert.Equal(t, test.policy.GracePeriodSeconds, helper.GracePeriodSeconds)
+			assert.Equal(t, test.policy.Timeout, helper.Timeout)
+			assert.Equal(t, test.policy.DeleteEmptyDirData, helper.DeleteEmptyDirData)
+			assert.Equal(t, test.policy.DisableEviction, helper.DisableEviction)
+
+			// Simulate an eviction being rejected and attempted again
+			if helper.OnPodDeletionOrEvictionStarted != nil {
+				helper.OnPodDeletionOrEvictionStarted(pod, true)
+				assert.False(t, cancelled)
+				helper.OnPodDeletionOrEvictionStarted(pod, true)
+			}
+			assert.Equal(t, test.expectCancelled, cancelled)
+			if test.expectCancelled {
+				assert.Equal(t, "ns/pod", blockedPod())
+			} else {
+				assert.Empty(t, blockedPod())
+			}
+		})
+	}
+}
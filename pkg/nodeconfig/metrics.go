@@ -0,0 +1,76 @@
+package nodeconfig
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// operationConfigure is the configuration of an instance which was not yet a Node
+	operationConfigure = "configure"
+	// operationUpgrade is the configuration of an instance which was configured by a previous version of WMCO
+	operationUpgrade = "upgrade"
+	// operationDeconfigure is the removal of the configuration of an instance
+	operationDeconfigure = "deconfigure"
+)
+
+var (
+	// durationBuckets are the buckets of the configuration duration histograms, from one second to over an hour
+	durationBuckets = prometheus.ExponentialBuckets(1, 2, 13)
+	// operationDuration observes the time taken by each operation on an instance, by result
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wmco_instance_operation_duration_seconds",
+		Help:    "Time taken to configure, upgrade or deconfigure Windows instances",
+		Buckets: durationBuckets,
+	}, []string{"operation", "result"})
+	// stepDuration observes the time taken by each step of the operations on instances
+	stepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wmco_instance_operation_step_duration_seconds",
+		Help:    "Time taken by each step of the configuration, upgrade or deconfiguration of Windows instances",
+		Buckets: durationBuckets,
+	}, []string{"operation", "step"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(operationDuration, stepDuration)
+}
+
+// operationTimer times an operation on an instance and each of its steps
+type operationTimer struct {
+	// operation is the operation being timed
+	operation string
+	// start is when the operation started
+	start time.Time
+	// step is the current step of the operation, if any
+	step Step
+	// stepStart is when the current step started
+	stepStart time.Time
+}
+
+// newOperationTimer returns a timer of the given operation, starting now
+func newOperationTimer(operation string) *operationTimer {
+	return &operationTimer{operation: operation, start: time.Now()}
+}
+
+// startStep records the duration of the current step, if any, and starts timing the given step. The duration of the
+// Configured step, which marks the end of the configuration, is not recorded.
+func (t *operationTimer) startStep(step Step) {
+	now := time.Now()
+	if t.step != "" && t.step != StepConfigured {
+		stepDuration.WithLabelValues(t.operation, string(t.step)).Observe(now.Sub(t.stepStart).Seconds())
+	}
+	t.step = step
+	t.stepStart = now
+}
+
+// finish records the duration of the current step and of the operation, which failed if the given error is not nil
+func (t *operationTimer) finish(err error) {
+	t.startStep("")
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	operationDuration.WithLabelValues(t.operation, result).Observe(time.Since(t.start).Seconds())
+}
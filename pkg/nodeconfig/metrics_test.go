@@ -0,0 +1,42 @@
+package nodeconfig
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleCount returns the number of observations of the given histogram with the given label values
+func sampleCount(t *testing.T, histogram *prometheus.HistogramVec, labelValues ...string) uint64 {
+	metric := &dto.Metric{}
+	require.NoError(t, histogram.WithLabelValues(labelValues...).(prometheus.Metric).Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestOperationTimer(t *testing.T) {
+	operationDuration.Reset()
+	stepDuration.Reset()
+
+	timer := newOperationTimer(operationUpgrade)
+	timer.startStep(StepTLSCerts)
+	timer.startStep(StepTLSCerts)
+	timer.startStep(StepUncordon)
+	timer.startStep(StepConfigured)
+	timer.finish(nil)
+	assert.Equal(t, uint64(2), sampleCount(t, stepDuration, operationUpgrade, string(StepTLSCerts)))
+	assert.Equal(t, uint64(1), sampleCount(t, stepDuration, operationUpgrade, string(StepUncordon)))
+	assert.Equal(t, uint64(0), sampleCount(t, stepDuration, operationUpgrade, string(StepConfigured)))
+	assert.Equal(t, uint64(1), sampleCount(t, operationDuration, operationUpgrade, "success"))
+
+	// The step in progress when the operation fails is recorded
+	timer = newOperationTimer(operationDeconfigure)
+	timer.startStep(StepDeconfigure)
+	timer.finish(errors.New("failed"))
+	assert.Equal(t, uint64(1), sampleCount(t, stepDuration, operationDeconfigure, string(StepDeconfigure)))
+	assert.Equal(t, uint64(1), sampleCount(t, operationDuration, operationDeconfigure, "failure"))
+	assert.Equal(t, uint64(0), sampleCount(t, operationDuration, operationDeconfigure, "success"))
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"path/filepath"
 	"strings"
 	"time"
@@ -37,9 +38,11 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/registries"
 	"github.com/openshift/windows-machine-config-operator/pkg/retry"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
+	wmcosigner "github.com/openshift/windows-machine-config-operator/pkg/signer"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 	"github.com/openshift/windows-machine-config-operator/version"
 )
@@ -73,7 +76,7 @@ const (
 type nodeConfig struct {
 	client client.Client
 	// k8sclientset holds the information related to kubernetes clientset
-	k8sclientset *kubernetes.Clientset
+	k8sclientset kubernetes.Interface
 	// Windows holds the information related to the windows VM
 	windows.Windows
 	// Node holds the information related to node object
@@ -91,6 +94,10 @@ type nodeConfig struct {
 	platformType configv1.PlatformType
 	// wmcoNamespace is the namespace WMCO is deployed to
 	wmcoNamespace string
+	// stepRecorder is called each time the configuration process reaches a new Step
+	stepRecorder func(Step)
+	// timer times the operation in progress on the instance, if any
+	timer *operationTimer
 }
 
 // ErrWriter is a wrapper to enable error-level logging inside kubectl drainer implementation
@@ -132,7 +139,26 @@ func NewNodeConfig(c client.Client, clientset *kubernetes.Clientset, clusterServ
 	}
 
 	log := ctrl.Log.WithName(fmt.Sprintf("nc %s", instanceInfo.Address))
-	win, err := windows.New(clusterDNS, instanceInfo, signer, &platformType)
+	winrmTLSConfig, err := secrets.WinRMTLSConfigForInstance(instanceInfo, wmcoNamespace, c)
+	if err != nil {
+		return nil, err
+	}
+	operatorConfig, err := operatorconfig.Get(context.TODO(), c, wmcoNamespace)
+	if err != nil {
+		return nil, err
+	}
+	sshRoute, err := windows.NewSSHRoute(instanceInfo, operatorConfig.SSHRoute, signer,
+		func(secretName string) (ssh.Signer, error) {
+			return wmcosigner.Create(types.NamespacedName{Namespace: wmcoNamespace, Name: secretName}, c)
+		},
+		func(secretName string) (*url.Userinfo, error) {
+			return secrets.GetProxyCredentials(types.NamespacedName{Namespace: wmcoNamespace, Name: secretName}, c)
+		})
+	if err != nil {
+		return nil, err
+	}
+	win, err := windows.New(clusterDNS, instanceInfo, signer, sshRoute, winrmTLSConfig, &platformType,
+		operatorConfig.TransferBandwidthLimit)
 	if err != nil {
 		return nil, fmt.Errorf("error instantiating Windows instance from VM: %w", err)
 	}
@@ -144,7 +170,16 @@ func NewNodeConfig(c client.Client, clientset *kubernetes.Clientset, clusterServ
 }
 
 // Configure configures the Windows VM to make it a Windows worker node
-func (nc *nodeConfig) Configure() error {
+func (nc *nodeConfig) Configure() (err error) {
+	operation := operationConfigure
+	if nc.node != nil {
+		if _, present := nc.node.GetAnnotations()[metadata.VersionAnnotation]; present {
+			operation = operationUpgrade
+		}
+	}
+	nc.timer = newOperationTimer(operation)
+	defer func() { nc.timer.finish(err) }()
+
 	drainHelper := nc.newDrainHelper()
 	// If a Node object exists already, it implies that we are reconfiguring and we should cordon the node
 	if nc.node != nil {
@@ -154,15 +189,19 @@ func (nc *nodeConfig) Configure() error {
 		}
 	}
 
+	nc.recordStep(StepBootstrapFiles)
 	if err := nc.createBootstrapFiles(); err != nil {
 		return err
 	}
+	nc.recordStep(StepTLSCerts)
 	if err := nc.createTLSCerts(); err != nil {
 		return err
 	}
+	nc.recordStep(StepRegistryConfig)
 	if err := nc.createRegistryConfigFiles(); err != nil {
 		return err
 	}
+	nc.recordStep(StepWICDBootstrap)
 	if err := nc.SyncTrustedCABundle(); err != nil {
 		return err
 	}
@@ -192,8 +231,12 @@ func (nc *nodeConfig) Configure() error {
 		}
 
 		// Ensure we are labeling and annotating the node as soon as the Node object is created, so that we can identify
-		// which controller should be watching it
+		// which controller should be watching it. The SSH host key is pinned, so that later connections to the instance
+		// cannot be intercepted.
 		annotationsToApply := map[string]string{PubKeyHashAnnotation: nc.publicKeyHash}
+		if hostKey := nc.Windows.SSHHostKey(); hostKey != "" {
+			annotationsToApply[metadata.SSHHostKeyAnnotation] = hostKey
+		}
 		for key, value := range nc.additionalAnnotations {
 			annotationsToApply[key] = value
 		}
@@ -212,6 +255,7 @@ func (nc *nodeConfig) Configure() error {
 		}
 
 		// Wait for version annotation. This prevents uncordoning the node until all node services and networks are up
+		nc.recordStep(StepVersionAnnotationWait)
 		if err := metadata.WaitForVersionAnnotation(context.TODO(), nc.client, nc.node.Name); err != nil {
 			return fmt.Errorf("error waiting for proper %s annotation for node %s: %w", metadata.VersionAnnotation,
 				nc.node.GetName(), err)
@@ -238,6 +282,7 @@ func (nc *nodeConfig) Configure() error {
 		}
 
 		// Uncordon the node now that it is fully configured
+		nc.recordStep(StepUncordon)
 		if err := drain.RunCordonOrUncordon(drainHelper, nc.node, false); err != nil {
 			return fmt.Errorf("error uncordoning the node %s: %w", nc.node.GetName(), err)
 		}
@@ -248,6 +293,7 @@ func (nc *nodeConfig) Configure() error {
 
 		nc.log.Info("instance has been configured as a worker node", "version",
 			nc.node.Annotations[metadata.VersionAnnotation])
+		nc.recordStep(StepConfigured)
 		return nil
 	}()
 
@@ -268,12 +314,9 @@ func (nc *nodeConfig) SafeReboot(ctx context.Context) error {
 		return fmt.Errorf("safe reboot of the instance requires an associated node")
 	}
 
-	drainer := nc.newDrainHelper()
-	if err := drain.RunCordonOrUncordon(drainer, nc.node, true); err != nil {
-		return fmt.Errorf("unable to cordon node %s: %w", nc.node.Name, err)
-	}
-	if err := drain.RunNodeDrain(drainer, nc.node.Name); err != nil {
-		return fmt.Errorf("unable to drain node %s: %w", nc.node.Name, err)
+	// The reboot is skipped if the node cannot be drained
+	if err := nc.cordonAndDrain(ctx); err != nil {
+		return err
 	}
 
 	if err := nc.Windows.RebootAndReinitialize(); err != nil {
@@ -284,12 +327,40 @@ func (nc *nodeConfig) SafeReboot(ctx context.Context) error {
 		return err
 	}
 
-	if err := drain.RunCordonOrUncordon(drainer, nc.node, false); err != nil {
+	if err := drain.RunCordonOrUncordon(nc.newDrainHelper(), nc.node, false); err != nil {
 		return fmt.Errorf("unable to uncordon node %s: %w", nc.node.Name, err)
 	}
 	return nil
 }
 
+// RepushPayload transfers the payload files which are missing from the payload cache of the instance, or whose cached
+// copy has been modified, so that WICD can restore the modified payload files from it
+func (nc *nodeConfig) RepushPayload(ctx context.Context) error {
+	if nc.node == nil {
+		return fmt.Errorf("payload re-push requires an associated node")
+	}
+	if err := nc.Windows.CachePayload(); err != nil {
+		return err
+	}
+	return metadata.RemovePayloadRepushAnnotation(ctx, nc.client, *nc.node)
+}
+
+// RestartServices restarts the Windows services of the instance without rebooting it. All WICD-managed services are
+// stopped by the WICD cleanup command, and are started again by WICD once its own service is restarted.
+func (nc *nodeConfig) RestartServices() error {
+	wicdKC, err := nc.generateWICDKubeconfig()
+	if err != nil {
+		return err
+	}
+	if err := nc.Windows.RunWICDCleanup(nc.wmcoNamespace, wicdKC); err != nil {
+		return fmt.Errorf("unable to stop services: %w", err)
+	}
+	if err := nc.Windows.ConfigureWICD(nc.wmcoNamespace, wicdKC); err != nil {
+		return fmt.Errorf("unable to start services: %w", err)
+	}
+	return nil
+}
+
 // getWICDServiceAccountSecret returns the secret which holds the credentials for the WICD ServiceAccount, creating one
 // if necessary
 func (nc *nodeConfig) getWICDServiceAccountSecret() (*core.Secret, error) {
@@ -507,7 +578,8 @@ func (nc *nodeConfig) setNode(quickCheck bool) error {
 	return nil
 }
 
-// newDrainHelper returns new drain.Helper instance
+// newDrainHelper returns new drain.Helper instance. The returned helper should only be used for cordoning, draining
+// must be done through cordonAndDrain, which applies the operator's drain policy.
 func (nc *nodeConfig) newDrainHelper() *drain.Helper {
 	return &drain.Helper{
 		Ctx:    context.TODO(),
@@ -524,18 +596,17 @@ func (nc *nodeConfig) newDrainHelper() *drain.Helper {
 }
 
 // Deconfigure removes the node from the cluster, reverting changes made by the Configure function
-func (nc *nodeConfig) Deconfigure() error {
+func (nc *nodeConfig) Deconfigure() (err error) {
 	if nc.node == nil {
 		return fmt.Errorf("instance does not a have an associated node to deconfigure")
 	}
+	nc.timer = newOperationTimer(operationDeconfigure)
+	defer func() { nc.timer.finish(err) }()
 	nc.log.Info("deconfiguring")
+	nc.recordStep(StepDeconfigure)
 	// Cordon and drain the Node before we interact with the instance
-	drainHelper := nc.newDrainHelper()
-	if err := drain.RunCordonOrUncordon(drainHelper, nc.node, true); err != nil {
-		return fmt.Errorf("unable to cordon node %s: %w", nc.node.GetName(), err)
-	}
-	if err := drain.RunNodeDrain(drainHelper, nc.node.GetName()); err != nil {
-		return fmt.Errorf("unable to drain node %s: %w", nc.node.GetName(), err)
+	if err := nc.cordonAndDrain(context.TODO()); err != nil {
+		return err
 	}
 
 	// Revert all changes we've made to the instance by removing installed services, files, and the version annotation
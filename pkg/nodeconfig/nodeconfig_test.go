@@ -1,13 +1,36 @@
 package nodeconfig
 
 import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
+	mcfg "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	config "k8s.io/kubelet/config/v1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/yaml"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+	"github.com/openshift/windows-machine-config-operator/pkg/internal/remotehost"
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/registries"
+	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows/fake"
+	"github.com/openshift/windows-machine-config-operator/version"
 )
 
 func TestNewKubeConfigFromSecret(t *testing.T) {
@@ -148,3 +171,170 @@ func TestModifyCredentialProviderConfig(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, expected, output)
 }
+
+func TestCreateTLSCertsAndKubeletClientCA(t *testing.T) {
+	host := fake.NewHost("test-host", false)
+	require.NoError(t, host.WriteFile(windows.TLSCertsPath+"\\stale.crt", []byte("stale")))
+	win, err := remotehost.NewWindows("172.30.0.10", &instance.Info{Address: "10.0.0.1"}, host, "")
+	require.NoError(t, err)
+	tlsSecret := &core.Secret{
+		ObjectMeta: meta.ObjectMeta{Name: secrets.TLSSecret, Namespace: "wmco"},
+		Data:       map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+	}
+	nc := &nodeConfig{client: clientfake.NewClientBuilder().WithObjects(tlsSecret).Build(),
+		Windows: win.(windows.Windows), wmcoNamespace: "wmco", log: logr.Discard()}
+
+	require.NoError(t, nc.createTLSCerts())
+	require.NoError(t, nc.UpdateKubeletClientCA([]byte("ca")))
+
+	assert.False(t, host.Exists(windows.TLSCertsPath+"\\stale.crt"))
+	for path, expected := range map[string]string{
+		windows.TLSCertsPath + "\\tls.crt":              "cert",
+		windows.TLSCertsPath + "\\tls.key":              "key",
+		windows.K8sDir + "\\" + KubeletClientCAFilename: "ca",
+	} {
+		contents, exists := host.ReadFile(path)
+		require.True(t, exists, path)
+		assert.Equal(t, expected, string(contents))
+	}
+}
+
+// newTestNodeConfig returns a nodeConfig for a Windows instance emulated by a new fake.Host, in a cluster containing
+// the given node along with the objects required to configure the instance
+func newTestNodeConfig(t *testing.T, node *core.Node) (*nodeConfig, *fake.Host) {
+	wicdPayloadPath := filepath.Join(t.TempDir(), "windows-instance-config-daemon.exe")
+	require.NoError(t, os.WriteFile(wicdPayloadPath, []byte("wicd"), 0644))
+	host := fake.NewHost("test-host", true)
+	host.SetFeatureEnabled("Containers", true)
+	win, err := remotehost.NewWindows("172.30.0.10", &instance.Info{Address: "10.0.0.1", IPv4Address: "10.0.0.1"},
+		host, wicdPayloadPath)
+	require.NoError(t, err)
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, configv1.AddToScheme(scheme))
+	require.NoError(t, mcfg.AddToScheme(scheme))
+	kubeletUnit := "[Service]\nExecStart=/usr/bin/kubelet \\\n  --config=/etc/kubernetes/kubelet.conf\n\n"
+	ignitionConfig, err := json.Marshal(map[string]interface{}{
+		"ignition": map[string]string{"version": "3.4.0"},
+		"systemd": map[string]interface{}{
+			"units": []map[string]string{{"name": "kubelet.service", "contents": kubeletUnit}},
+		},
+	})
+	require.NoError(t, err)
+	tokenData := map[string][]byte{core.ServiceAccountRootCAKey: []byte("ca"), core.ServiceAccountTokenKey: []byte("token")}
+	wicdSecret := &core.Secret{
+		ObjectMeta: meta.ObjectMeta{Name: windows.WicdServiceName, Namespace: "wmco",
+			Annotations: map[string]string{core.ServiceAccountNameKey: windows.WicdServiceName}},
+		Type: core.SecretTypeServiceAccountToken,
+		Data: tokenData,
+	}
+	bootstrapSecret := &core.Secret{
+		ObjectMeta: meta.ObjectMeta{Name: mcoBootstrapSecret, Namespace: mcoNamespace},
+		Data:       map[string][]byte{core.ServiceAccountRootCAKey: []byte("ca"), core.ServiceAccountTokenKey: []byte("token")},
+	}
+	c := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		node.DeepCopy(),
+		wicdSecret,
+		&core.Secret{
+			ObjectMeta: meta.ObjectMeta{Name: secrets.TLSSecret, Namespace: "wmco"},
+			Data:       map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+		},
+		&core.Secret{
+			ObjectMeta: meta.ObjectMeta{Name: registries.GlobalPullSecretName,
+				Namespace: registries.GlobalPullSecretNamespace},
+			Data: map[string][]byte{core.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+		},
+		&mcfg.MachineConfig{
+			ObjectMeta: meta.ObjectMeta{Name: ignition.RenderedWorkerPrefix + "abc"},
+			Spec:       mcfg.MachineConfigSpec{Config: runtime.RawExtension{Raw: ignitionConfig}},
+		},
+		// The ControllerConfig is cluster-scoped, but is retrieved using the operator namespace
+		&mcfg.ControllerConfig{
+			ObjectMeta: meta.ObjectMeta{Name: MccName, Namespace: "wmco"},
+			Spec:       mcfg.ControllerConfigSpec{KubeAPIServerServingCAData: []byte("kubelet-ca")},
+		},
+	).Build()
+	return &nodeConfig{client: c, k8sclientset: k8sfake.NewSimpleClientset(node.DeepCopy(), bootstrapSecret),
+		Windows: win.(windows.Windows), clusterServiceCIDR: "172.30.0.0/16", wmcoNamespace: "wmco",
+		log: logr.Discard()}, host
+}
+
+// newTestNode returns a Windows node with the given address and annotations
+func newTestNode(address string, annotations map[string]string) *core.Node {
+	return &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: "windows-node", Labels: map[string]string{core.LabelOSStable: "windows",
+			"node.openshift.io/os_id": "Windows"}, Annotations: annotations},
+		Status: core.NodeStatus{Addresses: []core.NodeAddress{{Type: core.NodeInternalIP, Address: address}}},
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	// WICD is emulated as having already brought the node to the desired version
+	node := newTestNode("10.0.0.1", map[string]string{metadata.VersionAnnotation: version.Get()})
+	nc, host := newTestNodeConfig(t, node)
+	var steps []Step
+	nc.stepRecorder = func(step Step) { steps = append(steps, step) }
+
+	require.NoError(t, nc.Configure())
+	assert.Equal(t, []Step{StepBootstrapFiles, StepTLSCerts, StepRegistryConfig, StepWICDBootstrap,
+		StepVersionAnnotationWait, StepUncordon, StepConfigured}, steps)
+
+	for path, expected := range map[string]string{
+		windows.TLSCertsPath + "\\tls.crt":              "cert",
+		windows.K8sDir + "\\" + KubeletClientCAFilename: "kubelet-ca",
+	} {
+		contents, exists := host.ReadFile(path)
+		require.True(t, exists, path)
+		assert.Equal(t, expected, string(contents))
+	}
+	for _, path := range []string{windows.BootstrapKubeconfigPath, windows.KubeletConfigPath,
+		windows.TrustedCABundlePath} {
+		assert.True(t, host.Exists(path), path)
+	}
+	wicd, exists := host.Service(windows.WicdServiceName)
+	require.True(t, exists)
+	assert.True(t, wicd.Running)
+
+	configured := &core.Node{}
+	require.NoError(t, nc.client.Get(context.TODO(), types.NamespacedName{Name: node.Name}, configured))
+	assert.Equal(t, nc.publicKeyHash, configured.Annotations[PubKeyHashAnnotation])
+	assert.Equal(t, version.Get(), configured.Annotations[metadata.DesiredVersionAnnotation])
+	clientsetNode, err := nc.k8sclientset.CoreV1().Nodes().Get(context.TODO(), node.Name, meta.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, clientsetNode.Spec.Unschedulable)
+}
+
+func TestConfigureBootstrapFailure(t *testing.T) {
+	nc, host := newTestNodeConfig(t, newTestNode("10.0.0.1", nil))
+	host.HandleCommand(windows.K8sDir+"\\windows-instance-config-daemon.exe bootstrap",
+		func(string) (string, error) { return "bootstrap failed", assert.AnError })
+
+	require.ErrorContains(t, nc.Configure(), "bootstrapping the Windows instance failed")
+	_, exists := host.Service(windows.WicdServiceName)
+	assert.False(t, exists)
+}
+
+func TestDeconfigure(t *testing.T) {
+	node := newTestNode("10.0.0.1", map[string]string{metadata.VersionAnnotation: version.Get()})
+	nc, host := newTestNodeConfig(t, node)
+
+	require.Error(t, nc.Deconfigure(), "deconfiguring an instance without a node should fail")
+
+	nc.node = node
+	require.NoError(t, host.WriteFile(windows.KubeletPath, []byte("kubelet")))
+	host.AddService(fake.Service{Name: windows.KubeletServiceName, BinaryPath: windows.KubeletPath,
+		Running: true})
+	host.AddHNSNetwork(windows.OVNKubeOverlayNetwork)
+	host.AddHNSNetwork("nat")
+
+	require.NoError(t, nc.Deconfigure())
+	assert.Empty(t, host.Services())
+	assert.Equal(t, []string{"nat"}, host.HNSNetworks())
+	for _, dir := range windows.RequiredDirectories {
+		assert.False(t, host.Exists(dir), dir)
+	}
+	clientsetNode, err := nc.k8sclientset.CoreV1().Nodes().Get(context.TODO(), node.Name, meta.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, clientsetNode.Spec.Unschedulable)
+}
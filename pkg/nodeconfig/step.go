@@ -0,0 +1,39 @@
+package nodeconfig
+
+// Step is a stage of the process of configuring an instance into a Node
+type Step string
+
+const (
+	// StepDeconfigure is the removal of the configuration made by a previous version of WMCO
+	StepDeconfigure Step = "Deconfigure"
+	// StepBootstrapFiles is the creation of the files required to bootstrap the kubelet
+	StepBootstrapFiles Step = "BootstrapFiles"
+	// StepTLSCerts is the transfer of the TLS certificates used by Windows services
+	StepTLSCerts Step = "TLSCerts"
+	// StepRegistryConfig is the transfer of the container registry configuration
+	StepRegistryConfig Step = "RegistryConfig"
+	// StepWICDBootstrap is the bootstrapping of the instance through WICD, resulting in a Node object
+	StepWICDBootstrap Step = "WICDBootstrap"
+	// StepVersionAnnotationWait is the wait for WICD to configure the Windows services and apply the version annotation
+	StepVersionAnnotationWait Step = "VersionAnnotationWait"
+	// StepUncordon is the uncordoning of the Node
+	StepUncordon Step = "Uncordon"
+	// StepConfigured indicates that configuration has completed
+	StepConfigured Step = "Configured"
+)
+
+// SetStepRecorder sets a function which is called each time the configuration process reaches a new Step
+func (nc *nodeConfig) SetStepRecorder(recorder func(Step)) {
+	nc.stepRecorder = recorder
+}
+
+// recordStep times the given step as part of the operation in progress, and passes it to the step recorder, if one
+// has been set
+func (nc *nodeConfig) recordStep(step Step) {
+	if nc.timer != nil {
+		nc.timer.startStep(step)
+	}
+	if nc.stepRecorder != nil {
+		nc.stepRecorder(step)
+	}
+}
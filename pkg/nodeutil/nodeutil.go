@@ -16,3 +16,13 @@ func FindByAddress(address string, nodes *core.NodeList) *core.Node {
 	}
 	return nil
 }
+
+// IsReady returns true if the given node has a Ready condition with a status of true
+func IsReady(node *core.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == core.NodeReady {
+			return condition.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
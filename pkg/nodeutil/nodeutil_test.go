@@ -77,3 +77,38 @@ func TestFindNode(t *testing.T) {
 	}
 
 }
+
+func TestIsReady(t *testing.T) {
+	testCases := []struct {
+		name        string
+		conditions  []core.NodeCondition
+		expectedOut bool
+	}{
+		{
+			name:        "no conditions",
+			conditions:  nil,
+			expectedOut: false,
+		},
+		{
+			name:        "ready",
+			conditions:  []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			expectedOut: true,
+		},
+		{
+			name:        "not ready",
+			conditions:  []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionFalse}},
+			expectedOut: false,
+		},
+		{
+			name:        "unknown readiness",
+			conditions:  []core.NodeCondition{{Type: core.NodeMemoryPressure, Status: core.ConditionTrue}},
+			expectedOut: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{Status: core.NodeStatus{Conditions: test.conditions}}
+			assert.Equal(t, test.expectedOut, IsReady(node))
+		})
+	}
+}
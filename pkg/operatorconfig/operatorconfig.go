@@ -0,0 +1,531 @@
+package operatorconfig
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+	"github.com/openshift/windows-machine-config-operator/pkg/maintenance"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/wiparser"
+)
+
+var log = ctrl.Log.WithName("operatorconfig")
+
+const (
+	// ConfigMapName is the name of the optional ConfigMap, in the operator namespace, holding user provided operator
+	// configuration
+	ConfigMapName = "windows-operator-config"
+	// maxConcurrentInstanceConfigurationsKey is an optional key whose value is the maximum number of BYOH instances
+	// that are configured at the same time
+	maxConcurrentInstanceConfigurationsKey = "maxConcurrentInstanceConfigurations"
+	// defaultMaxConcurrentInstanceConfigurations is the number of BYOH instances configured at the same time when not
+	// specified by the user
+	defaultMaxConcurrentInstanceConfigurations = 5
+	// upgradeMaxUnavailableKey is an optional key whose value is the maximum number of Windows nodes within an upgrade
+	// pool which can be unavailable due to upgrades, as a count or a percentage of the nodes in the pool
+	upgradeMaxUnavailableKey = "upgradeMaxUnavailable"
+	// upgradesPausedKey is an optional key which, when "true", prevents Windows nodes from being upgraded
+	upgradesPausedKey = "upgradesPaused"
+	// UpgradeMaxUnavailableAnnotation is a MachineSet annotation overriding the upgradeMaxUnavailable value for the
+	// Windows nodes backed by the MachineSet
+	UpgradeMaxUnavailableAnnotation = "windowsmachineconfig.openshift.io/upgrade-max-unavailable"
+	// UpgradesPausedAnnotation is a MachineSet annotation overriding the upgradesPaused value for the Windows nodes
+	// backed by the MachineSet
+	UpgradesPausedAnnotation = "windowsmachineconfig.openshift.io/upgrades-paused"
+	// drainGracePeriodSecondsKey is an optional key whose value is the number of seconds pods are given to terminate
+	// when a node is drained. A negative value uses the termination grace period of each pod.
+	drainGracePeriodSecondsKey = "drainGracePeriodSeconds"
+	// drainTimeoutSecondsKey is an optional key whose value is the number of seconds after which draining a node is
+	// given up on. Zero waits indefinitely.
+	drainTimeoutSecondsKey = "drainTimeoutSeconds"
+	// drainDeleteEmptyDirDataKey is an optional key which, when "false", prevents pods using emptyDir volumes from
+	// being removed when draining a node
+	drainDeleteEmptyDirDataKey = "drainDeleteEmptyDirData"
+	// drainDisableEvictionKey is an optional key which, when "true", causes pods to be deleted rather than evicted when
+	// draining a node, bypassing PodDisruptionBudgets
+	drainDisableEvictionKey = "drainDisableEviction"
+	// drainRetryBlockedEvictionsKey is an optional key which, when "false", causes a drain to fail as soon as an
+	// eviction is blocked by a PodDisruptionBudget, instead of retrying the eviction until the drain times out
+	drainRetryBlockedEvictionsKey = "drainRetryBlockedEvictions"
+	// maintenanceWindowScheduleKey is an optional key whose value is a five field cron expression describing when
+	// maintenance windows start. When present, disruptive operations on Windows nodes are only performed within a
+	// maintenance window.
+	maintenanceWindowScheduleKey = "maintenanceWindowSchedule"
+	// maintenanceWindowTimeZoneKey is an optional key whose value is the IANA time zone the maintenance window schedule
+	// is evaluated in. Defaults to UTC.
+	maintenanceWindowTimeZoneKey = "maintenanceWindowTimeZone"
+	// maintenanceWindowDurationKey is the length of each maintenance window, e.g. "4h". Required when a maintenance
+	// window schedule is given.
+	maintenanceWindowDurationKey = "maintenanceWindowDuration"
+	// transferBandwidthLimitKey is an optional key whose value is the maximum rate files are transferred to each
+	// Windows instance at, in bytes per second, as a quantity such as "10Mi"
+	transferBandwidthLimitKey = "transferBandwidthLimit"
+	// sshJumpHostsKey is an optional key whose value is a YAML list of the SSH jump hosts connections to Windows
+	// instances are tunneled through, in the format of the jumpHosts field of a WindowsInstance
+	sshJumpHostsKey = "sshJumpHosts"
+	// sshProxyURLKey is an optional key whose value is the URL of the HTTP CONNECT or SOCKS5 proxy connections to
+	// Windows instances are made through. The URL must not contain credentials.
+	sshProxyURLKey = "sshProxyURL"
+	// sshProxyCredentialsSecretKey is an optional key whose value is the name of the secret in the operator namespace
+	// holding the credentials used to authenticate against the proxy given by sshProxyURL
+	sshProxyCredentialsSecretKey = "sshProxyCredentialsSecret"
+	// sshKeyRotationKey is an optional key whose value is the KeyRotationPolicy applied when the private key used to
+	// access Windows instances changes
+	sshKeyRotationKey = "sshKeyRotation"
+	// machineDeletionMaxUnhealthyKey is an optional key whose value is the maximum number of unhealthy Machines within
+	// a Windows MachineSet for WMCO to delete another of its Machines, as a count or a percentage of the MachineSet's
+	// replicas
+	machineDeletionMaxUnhealthyKey = "machineDeletionMaxUnhealthy"
+	// MachineDeletionMaxUnhealthyAnnotation is a MachineSet annotation overriding the machineDeletionMaxUnhealthy value
+	// for the MachineSet
+	MachineDeletionMaxUnhealthyAnnotation = "windowsmachineconfig.openshift.io/machine-deletion-max-unhealthy"
+	// remediationStepsKey is an optional key whose value is a comma separated, ordered list of the RemediationSteps
+	// taken to bring back Machine-backed Windows nodes which are not ready. Nodes are not remediated when empty.
+	remediationStepsKey = "remediationSteps"
+	// remediationTimeoutSecondsKey is an optional key whose value is the number of seconds a node must be not ready
+	// before it is remediated, and the number of seconds each remediation step is given to bring the node back
+	remediationTimeoutSecondsKey = "remediationTimeoutSeconds"
+	// fileDriftRemediationKey is an optional key whose value is the FileDriftRemediation taken by WICD when a file
+	// copied to a Windows instance by WMCO is found to have been modified
+	fileDriftRemediationKey = "fileDriftRemediation"
+	// defaultRemediationTimeout is the remediation timeout used when not specified by the user
+	defaultRemediationTimeout = 10 * time.Minute
+)
+
+// RemediationStep is an action taken to bring back a Windows node which is not ready
+type RemediationStep string
+
+const (
+	// RemediationRestartServices restarts the Windows services of the node, by restarting WICD
+	RemediationRestartServices RemediationStep = "RestartServices"
+	// RemediationReboot drains and reboots the instance of the node
+	RemediationReboot RemediationStep = "Reboot"
+	// RemediationDeleteMachine deletes the Machine of the node, so that it is recreated, within the Machine deletion
+	// budget of its MachineSet
+	RemediationDeleteMachine RemediationStep = "DeleteMachine"
+)
+
+// KeyRotationPolicy describes how Windows nodes are moved to a new private key
+type KeyRotationPolicy string
+
+const (
+	// KeyRotationRecreate deletes the Machines of Windows nodes configured with the previous key, so that they are
+	// recreated with the new key. BYOH instances must be given the new key by the user.
+	KeyRotationRecreate KeyRotationPolicy = "Recreate"
+	// KeyRotationInPlace authorizes the new key on each instance, accessing it with the previous key
+	KeyRotationInPlace KeyRotationPolicy = "InPlace"
+)
+
+// DrainPolicy describes how Windows nodes are drained before disruptive operations
+type DrainPolicy struct {
+	// GracePeriodSeconds is the time pods are given to terminate. A negative value uses the termination grace period
+	// of each pod.
+	GracePeriodSeconds int
+	// Timeout is the time after which draining a node is given up on. Zero waits indefinitely.
+	Timeout time.Duration
+	// DeleteEmptyDirData allows pods using emptyDir volumes to be removed, deleting the data in the volumes
+	DeleteEmptyDirData bool
+	// DisableEviction causes pods to be deleted rather than evicted, bypassing PodDisruptionBudgets
+	DisableEviction bool
+	// RetryBlockedEvictions causes evictions blocked by a PodDisruptionBudget to be retried until the drain times out.
+	// If false, the drain fails as soon as an eviction is blocked.
+	RetryBlockedEvictions bool
+}
+
+// UpgradePolicy describes how the Windows nodes within an upgrade pool are upgraded to a new operator version
+type UpgradePolicy struct {
+	// MaxUnavailable is the maximum number of nodes within the pool which can be unavailable due to upgrades, as a
+	// count or a percentage of the nodes in the pool
+	MaxUnavailable intstr.IntOrString
+	// Paused prevents nodes within the pool from being upgraded
+	Paused bool
+}
+
+// WithOverrides returns a copy of the policy, with the values specified by the given annotations taking precedence
+func (p UpgradePolicy) WithOverrides(annotations map[string]string) (UpgradePolicy, error) {
+	return parseUpgradePolicy(p, annotations, UpgradeMaxUnavailableAnnotation, UpgradesPausedAnnotation)
+}
+
+// MaxUnavailableCount returns the maximum number of nodes which can be unavailable due to upgrades within a pool of
+// the given size. Percentages are rounded down, and at least one node is always allowed to be upgraded, as upgrades
+// are stopped by pausing them instead.
+func (p UpgradePolicy) MaxUnavailableCount(poolSize int) (int, error) {
+	maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(&p.MaxUnavailable, poolSize, false)
+	if err != nil {
+		return 0, err
+	}
+	if maxUnavailable < 1 {
+		return 1, nil
+	}
+	return maxUnavailable, nil
+}
+
+// MachineDeletionPolicy limits the deletion of Machines within a Windows MachineSet
+type MachineDeletionPolicy struct {
+	// MaxUnhealthy is the maximum number of unhealthy Machines within the MachineSet for another of its Machines to be
+	// deleted, as a count or a percentage of the MachineSet's replicas
+	MaxUnhealthy intstr.IntOrString
+}
+
+// WithOverrides returns a copy of the policy, with the values specified by the given annotations taking precedence
+func (p MachineDeletionPolicy) WithOverrides(annotations map[string]string) (MachineDeletionPolicy, error) {
+	if value, present := annotations[MachineDeletionMaxUnhealthyAnnotation]; present {
+		maxUnhealthy, err := parseIntOrPercent(MachineDeletionMaxUnhealthyAnnotation, value)
+		if err != nil {
+			return p, err
+		}
+		p.MaxUnhealthy = maxUnhealthy
+	}
+	return p, nil
+}
+
+// MaxUnhealthyCount returns the maximum number of unhealthy Machines within a MachineSet with the given number of
+// replicas for another of its Machines to be deleted. Percentages are rounded down, and at least one Machine is always
+// allowed to be unhealthy, so that Machines can still be recreated with a new private key.
+func (p MachineDeletionPolicy) MaxUnhealthyCount(replicas int) (int, error) {
+	maxUnhealthy, err := intstr.GetScaledValueFromIntOrPercent(&p.MaxUnhealthy, replicas, false)
+	if err != nil {
+		return 0, err
+	}
+	if maxUnhealthy < 1 {
+		return 1, nil
+	}
+	return maxUnhealthy, nil
+}
+
+// RemediationPolicy describes how Machine-backed Windows nodes which are not ready are brought back
+type RemediationPolicy struct {
+	// Steps are taken in order, one at a time, until the node is ready. Nodes are not remediated if empty.
+	Steps []RemediationStep
+	// Timeout is the time a node must be not ready before the first step is taken, and the time each step is given to
+	// bring the node back before the next step is taken
+	Timeout time.Duration
+}
+
+// Config holds the user configurable behavior of the operator
+type Config struct {
+	// MaxConcurrentInstanceConfigurations is the maximum number of BYOH instances configured at the same time
+	MaxConcurrentInstanceConfigurations int
+	// Upgrade is the upgrade policy of BYOH instances, and the default policy of Machine-backed instances
+	Upgrade UpgradePolicy
+	// Drain is the policy used when draining Windows nodes
+	Drain DrainPolicy
+	// MaintenanceWindow restricts when disruptive operations, such as reboots and upgrades, are performed on Windows
+	// nodes. Nil allows disruptive operations at any time.
+	MaintenanceWindow *maintenance.Window
+	// TransferBandwidthLimit is the maximum rate, in bytes per second, files are transferred to each Windows instance
+	// at. Zero is unlimited.
+	TransferBandwidthLimit int64
+	// SSHRoute is how SSH connections are made to Windows instances which do not specify their own route
+	SSHRoute instance.SSHRoute
+	// SSHKeyRotation is how Windows nodes are moved to a new private key
+	SSHKeyRotation KeyRotationPolicy
+	// MachineDeletion is the default policy limiting the deletion of Machines within Windows MachineSets
+	MachineDeletion MachineDeletionPolicy
+	// Remediation is how Machine-backed Windows nodes which are not ready are brought back
+	Remediation RemediationPolicy
+	// WindowsExporter describes the metrics windows_exporter exposes on Windows nodes
+	WindowsExporter WindowsExporterConfig
+	// FileDriftRemediation is the action taken when a file copied to a Windows instance by WMCO is modified
+	FileDriftRemediation servicescm.FileDriftRemediation
+}
+
+// Default returns the configuration used when the user has not specified any
+func Default() *Config {
+	return &Config{
+		MaxConcurrentInstanceConfigurations: defaultMaxConcurrentInstanceConfigurations,
+		Upgrade:                             UpgradePolicy{MaxUnavailable: intstr.FromInt32(1)},
+		Drain: DrainPolicy{
+			GracePeriodSeconds:    -1,
+			DeleteEmptyDirData:    true,
+			RetryBlockedEvictions: true,
+		},
+		SSHKeyRotation:  KeyRotationRecreate,
+		MachineDeletion: MachineDeletionPolicy{MaxUnhealthy: intstr.FromInt32(1)},
+		Remediation:     RemediationPolicy{Timeout: defaultRemediationTimeout},
+		WindowsExporter: WindowsExporterConfig{
+			Collectors: append([]string(nil), defaultWindowsExporterCollectors...),
+		},
+		FileDriftRemediation: servicescm.FileDriftRemediationNone,
+	}
+}
+
+// Parse returns the configuration described by the given ConfigMap data. Defaults are used for any value not present,
+// and for any value which is invalid. Values are validated independently, so that an invalid value only affects the
+// setting it is given for. An error describing every invalid value is returned along with the configuration.
+func Parse(data map[string]string) (*Config, error) {
+	config := Default()
+	var errs []error
+	if value, present := data[maxConcurrentInstanceConfigurationsKey]; present {
+		maxConcurrent, err := parsePositiveInt(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", maxConcurrentInstanceConfigurationsKey, err))
+		} else {
+			config.MaxConcurrentInstanceConfigurations = maxConcurrent
+		}
+	}
+	upgradePolicy, err := parseUpgradePolicy(config.Upgrade, data, upgradeMaxUnavailableKey, upgradesPausedKey)
+	config.Upgrade = upgradePolicy
+	errs = append(errs, err)
+	drainPolicy, err := parseDrainPolicy(config.Drain, data)
+	config.Drain = drainPolicy
+	errs = append(errs, err)
+	maintenanceWindow, err := parseMaintenanceWindow(data)
+	config.MaintenanceWindow = maintenanceWindow
+	errs = append(errs, err)
+	if value, present := data[transferBandwidthLimitKey]; present {
+		limit, err := resource.ParseQuantity(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", transferBandwidthLimitKey, err))
+		} else if limit.Value() < 1 {
+			errs = append(errs, fmt.Errorf("invalid %s value: %s must be at least one byte per second",
+				transferBandwidthLimitKey, value))
+		} else {
+			config.TransferBandwidthLimit = limit.Value()
+		}
+	}
+	sshRoute, err := parseSSHRoute(data)
+	config.SSHRoute = sshRoute
+	errs = append(errs, err)
+	if value, present := data[sshKeyRotationKey]; present {
+		switch policy := KeyRotationPolicy(value); policy {
+		case KeyRotationRecreate, KeyRotationInPlace:
+			config.SSHKeyRotation = policy
+		default:
+			errs = append(errs, fmt.Errorf("invalid %s value %q, must be %s or %s", sshKeyRotationKey, value,
+				KeyRotationRecreate, KeyRotationInPlace))
+		}
+	}
+	if value, present := data[machineDeletionMaxUnhealthyKey]; present {
+		maxUnhealthy, err := parseIntOrPercent(machineDeletionMaxUnhealthyKey, value)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			config.MachineDeletion.MaxUnhealthy = maxUnhealthy
+		}
+	}
+	remediationPolicy, err := parseRemediationPolicy(config.Remediation, data)
+	config.Remediation = remediationPolicy
+	errs = append(errs, err)
+	windowsExporter, err := parseWindowsExporterConfig(config.WindowsExporter, data)
+	config.WindowsExporter = windowsExporter
+	errs = append(errs, err)
+	if value, present := data[fileDriftRemediationKey]; present {
+		remediation := servicescm.FileDriftRemediation(value)
+		if err := remediation.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", fileDriftRemediationKey, err))
+		} else {
+			config.FileDriftRemediation = remediation
+		}
+	}
+	return config, kerrors.NewAggregate(errs)
+}
+
+// Get returns the configuration described by the operator config ConfigMap in the given namespace. The default
+// configuration is returned if the ConfigMap does not exist. Invalid values are logged, and replaced by their defaults
+// so that a single invalid value does not prevent the operator from working.
+func Get(ctx context.Context, c client.Client, namespace string) (*Config, error) {
+	cm := &core.ConfigMap{}
+	if err := c.Get(ctx, kubeTypes.NamespacedName{Namespace: namespace, Name: ConfigMapName}, cm); err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return Default(), nil
+		}
+		return nil, fmt.Errorf("unable to get ConfigMap %s: %w", ConfigMapName, err)
+	}
+	config, err := Parse(cm.Data)
+	if err != nil {
+		log.Error(err, "using the default values in place of invalid values", "ConfigMap", ConfigMapName)
+	}
+	return config, nil
+}
+
+// parsePositiveInt returns the integer represented by the given string, erroring if it is not greater than zero
+func parsePositiveInt(value string) (int, error) {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 1 {
+		return 0, fmt.Errorf("%d must be greater than zero", parsed)
+	}
+	return parsed, nil
+}
+
+// parseUpgradePolicy returns a copy of the given policy, overridden by any valid values present in the given data under
+// the given keys. An error describing any invalid values is returned along with the policy.
+func parseUpgradePolicy(policy UpgradePolicy, data map[string]string, maxUnavailableKey,
+	pausedKey string) (UpgradePolicy, error) {
+	var errs []error
+	if value, present := data[maxUnavailableKey]; present {
+		maxUnavailable, err := parseIntOrPercent(maxUnavailableKey, value)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			policy.MaxUnavailable = maxUnavailable
+		}
+	}
+	if value, present := data[pausedKey]; present {
+		paused, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", pausedKey, err))
+		} else {
+			policy.Paused = paused
+		}
+	}
+	return policy, kerrors.NewAggregate(errs)
+}
+
+// parseIntOrPercent returns the count or percentage represented by the given value of the given key, erroring if it
+// is a count which is not greater than zero
+func parseIntOrPercent(key, value string) (intstr.IntOrString, error) {
+	parsed := intstr.Parse(value)
+	if parsed.Type == intstr.Int && parsed.IntVal < 1 {
+		return parsed, fmt.Errorf("invalid %s value: %d must be greater than zero", key, parsed.IntVal)
+	}
+	if _, err := intstr.GetScaledValueFromIntOrPercent(&parsed, 100, false); err != nil {
+		return parsed, fmt.Errorf("invalid %s value: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// parseRemediationPolicy returns a copy of the given policy, overridden by any valid values present in the given
+// data. An error describing any invalid values is returned along with the policy.
+func parseRemediationPolicy(policy RemediationPolicy, data map[string]string) (RemediationPolicy, error) {
+	var errs []error
+	if value, present := data[remediationStepsKey]; present {
+		steps, err := parseRemediationSteps(value)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			policy.Steps = steps
+		}
+	}
+	if value, present := data[remediationTimeoutSecondsKey]; present {
+		timeout, err := parsePositiveInt(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", remediationTimeoutSecondsKey, err))
+		} else {
+			policy.Timeout = time.Duration(timeout) * time.Second
+		}
+	}
+	return policy, kerrors.NewAggregate(errs)
+}
+
+// parseRemediationSteps returns the remediation steps listed by the given comma separated value
+func parseRemediationSteps(value string) ([]RemediationStep, error) {
+	var steps []RemediationStep
+	for _, field := range strings.Split(value, ",") {
+		step := RemediationStep(strings.TrimSpace(field))
+		if step == "" {
+			continue
+		}
+		switch step {
+		case RemediationRestartServices, RemediationReboot, RemediationDeleteMachine:
+		default:
+			return nil, fmt.Errorf("invalid %s value: unknown step %q, must be one of %s, %s or %s",
+				remediationStepsKey, step, RemediationRestartServices, RemediationReboot, RemediationDeleteMachine)
+		}
+		for _, existing := range steps {
+			if existing == step {
+				return nil, fmt.Errorf("invalid %s value: step %s is given more than once", remediationStepsKey, step)
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// parseDrainPolicy returns a copy of the given policy, overridden by any valid values present in the given data. An
+// error describing any invalid values is returned along with the policy.
+func parseDrainPolicy(policy DrainPolicy, data map[string]string) (DrainPolicy, error) {
+	var errs []error
+	if value, present := data[drainGracePeriodSecondsKey]; present {
+		gracePeriod, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", drainGracePeriodSecondsKey, err))
+		} else {
+			policy.GracePeriodSeconds = gracePeriod
+		}
+	}
+	if value, present := data[drainTimeoutSecondsKey]; present {
+		timeout, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", drainTimeoutSecondsKey, err))
+		} else if timeout < 0 {
+			errs = append(errs, fmt.Errorf("invalid %s value: %d must not be negative", drainTimeoutSecondsKey,
+				timeout))
+		} else {
+			policy.Timeout = time.Duration(timeout) * time.Second
+		}
+	}
+	for key, field := range map[string]*bool{
+		drainDeleteEmptyDirDataKey:    &policy.DeleteEmptyDirData,
+		drainDisableEvictionKey:       &policy.DisableEviction,
+		drainRetryBlockedEvictionsKey: &policy.RetryBlockedEvictions,
+	} {
+		value, present := data[key]
+		if !present {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", key, err))
+			continue
+		}
+		*field = parsed
+	}
+	return policy, kerrors.NewAggregate(errs)
+}
+
+// parseSSHRoute returns the SSH route described by the given data
+func parseSSHRoute(data map[string]string) (instance.SSHRoute, error) {
+	var jumpHosts []wmcov1.JumpHost
+	if value, present := data[sshJumpHostsKey]; present {
+		if err := yaml.UnmarshalStrict([]byte(value), &jumpHosts); err != nil {
+			return instance.SSHRoute{}, fmt.Errorf("invalid %s value: %w", sshJumpHostsKey, err)
+		}
+	}
+	route, err := wiparser.ToSSHRoute(data[sshProxyURLKey], data[sshProxyCredentialsSecretKey], jumpHosts)
+	if err != nil {
+		return instance.SSHRoute{}, fmt.Errorf("invalid SSH route: %w", err)
+	}
+	return route, nil
+}
+
+// parseMaintenanceWindow returns the maintenance window described by the given data, or nil if no maintenance window
+// schedule is present
+func parseMaintenanceWindow(data map[string]string) (*maintenance.Window, error) {
+	schedule, present := data[maintenanceWindowScheduleKey]
+	if !present {
+		return nil, nil
+	}
+	durationValue, present := data[maintenanceWindowDurationKey]
+	if !present {
+		return nil, fmt.Errorf("%s must be given along with %s", maintenanceWindowDurationKey,
+			maintenanceWindowScheduleKey)
+	}
+	duration, err := time.ParseDuration(durationValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %w", maintenanceWindowDurationKey, err)
+	}
+	window, err := maintenance.NewWindow(schedule, data[maintenanceWindowTimeZoneKey], duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance window: %w", err)
+	}
+	return window, nil
+}
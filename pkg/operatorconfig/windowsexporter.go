@@ -0,0 +1,139 @@
+package operatorconfig
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// windowsExporterCollectorsKey is an optional key whose value is a comma separated list of the windows_exporter
+	// collectors enabled on Windows nodes, replacing the default list
+	windowsExporterCollectorsKey = "windowsExporterCollectors"
+	// windowsExporterCollectorFlagsKey is an optional key whose value is a YAML map of windows_exporter collector
+	// flags, without their leading dashes, to their values, e.g. "collector.process.include: sqlservr|w3wp"
+	windowsExporterCollectorFlagsKey = "windowsExporterCollectorFlags"
+	// TextfileCollector is the windows_exporter collector exposing the metrics of the .prom files dropped into the
+	// textfile collector directory of the node
+	TextfileCollector = "textfile"
+)
+
+var (
+	// defaultWindowsExporterCollectors are the windows_exporter collectors enabled when not specified by the user
+	defaultWindowsExporterCollectors = []string{"cpu", "cs", "logical_disk", "net", "os", "service", "system",
+		TextfileCollector, "container", "memory", "cpu_info"}
+	// collectorNameRegex matches valid windows_exporter collector names
+	collectorNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	// collectorFlagRegex matches valid windows_exporter collector flag names, capturing the collector the flag is of.
+	// Older windows_exporter versions prefix some collector flags with "collectors" rather than "collector".
+	collectorFlagRegex = regexp.MustCompile(`^collectors?\.([a-z][a-z0-9_]*)\.[a-z0-9][a-z0-9._-]*$`)
+	// reservedCollectorFlags are the collector flags set by WMCO, which cannot be given by the user
+	reservedCollectorFlags = []string{"collector.textfile.directories", "collector.textfile.directory"}
+)
+
+// WindowsExporterConfig describes the metrics windows_exporter exposes on Windows nodes
+type WindowsExporterConfig struct {
+	// Collectors are the enabled windows_exporter collectors
+	Collectors []string
+	// CollectorFlags are the collector flags windows_exporter is run with, by flag name without leading dashes
+	CollectorFlags map[string]string
+}
+
+// IsEnabled returns true if the given collector is enabled
+func (c WindowsExporterConfig) IsEnabled(collector string) bool {
+	for _, enabled := range c.Collectors {
+		if enabled == collector {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindowsExporterConfig returns a copy of the given configuration, overridden by any valid values present in the
+// given data. An error describing any invalid values is returned along with the configuration.
+func parseWindowsExporterConfig(config WindowsExporterConfig, data map[string]string) (WindowsExporterConfig, error) {
+	var errs []error
+	if value, present := data[windowsExporterCollectorsKey]; present {
+		collectors, err := parseCollectors(value)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			config.Collectors = collectors
+		}
+	}
+	if value, present := data[windowsExporterCollectorFlagsKey]; present {
+		flags, err := parseCollectorFlags(config, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", windowsExporterCollectorFlagsKey, err))
+		} else {
+			config.CollectorFlags = flags
+		}
+	}
+	return config, kerrors.NewAggregate(errs)
+}
+
+// parseCollectors returns the collectors listed by the given comma separated value
+func parseCollectors(value string) ([]string, error) {
+	var collectors []string
+	for _, field := range strings.Split(value, ",") {
+		collector := strings.TrimSpace(field)
+		if collector == "" {
+			continue
+		}
+		if !collectorNameRegex.MatchString(collector) {
+			return nil, fmt.Errorf("invalid %s value: invalid collector name %q", windowsExporterCollectorsKey,
+				collector)
+		}
+		for _, existing := range collectors {
+			if existing == collector {
+				return nil, fmt.Errorf("invalid %s value: collector %s is given more than once",
+					windowsExporterCollectorsKey, collector)
+			}
+		}
+		collectors = append(collectors, collector)
+	}
+	if len(collectors) == 0 {
+		return nil, fmt.Errorf("invalid %s value: at least one collector must be enabled",
+			windowsExporterCollectorsKey)
+	}
+	return collectors, nil
+}
+
+// parseCollectorFlags returns the collector flags described by the given YAML value, validated against the given
+// configuration
+func parseCollectorFlags(config WindowsExporterConfig, value string) (map[string]string, error) {
+	var flags map[string]string
+	if err := yaml.UnmarshalStrict([]byte(value), &flags); err != nil {
+		return nil, err
+	}
+	for flag, flagValue := range flags {
+		if err := validateCollectorFlag(config, flag, flagValue); err != nil {
+			return nil, err
+		}
+	}
+	return flags, nil
+}
+
+// validateCollectorFlag returns an error if the given flag cannot be given to windows_exporter with the given
+// configuration
+func validateCollectorFlag(config WindowsExporterConfig, flag, value string) error {
+	matches := collectorFlagRegex.FindStringSubmatch(flag)
+	if matches == nil {
+		return fmt.Errorf("%q is not a collector flag", flag)
+	}
+	for _, reserved := range reservedCollectorFlags {
+		if flag == reserved {
+			return fmt.Errorf("flag %s is set by WMCO", flag)
+		}
+	}
+	if !config.IsEnabled(matches[1]) {
+		return fmt.Errorf("flag %s is of collector %s, which is not enabled", flag, matches[1])
+	}
+	if strings.ContainsAny(value, "\"\r\n") {
+		return fmt.Errorf("value of flag %s must not contain quotes or line breaks", flag)
+	}
+	return nil
+}
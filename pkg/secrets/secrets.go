@@ -2,16 +2,22 @@ package secrets
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net/url"
 
 	oconfig "github.com/openshift/api/config/v1"
 	"golang.org/x/crypto/ssh"
 	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 )
 
 const (
@@ -19,10 +25,40 @@ const (
 	UserDataSecret = "windows-user-data"
 	// PrivateKeySecret is the name of the private key secret provided by the user
 	PrivateKeySecret = "cloud-private-key"
+	// PreviousPrivateKeySecret is the name of the secret WMCO keeps a copy of the private key secret in, once all nodes
+	// have been moved to its key. It holds the previous key while instances are moved to a new key in place.
+	PreviousPrivateKeySecret = "cloud-private-key-previous"
 	// PrivateKeySecretKey is the key within the private key secret which holds the private key
 	PrivateKeySecretKey = "private-key.pem"
+	// AgentPublicKeySecretKey is the key within a private key secret which holds, instead of a private key, the public
+	// key in authorized_keys format of a key held by the SSH agent listening on the operator's SSH_AUTH_SOCK socket
+	AgentPublicKeySecretKey = "agent-public-key"
+	// PassphraseSecretAnnotation is an annotation on a private key secret, holding the name of the secret containing
+	// the passphrase the private key is encrypted with
+	PassphraseSecretAnnotation = "windowsmachineconfig.openshift.io/passphrase-secret"
+	// PassphraseSecretKey is the key within a passphrase secret which holds the passphrase
+	PassphraseSecretKey = "passphrase"
+	// UsernameEncryptionKeySecret is the name of the secret created by WMCO, holding the key the username annotations
+	// of nodes are encrypted with
+	UsernameEncryptionKeySecret = "windows-username-encryption-key"
+	// UsernameEncryptionKeySecretKey is the key within the username encryption key secret which holds the key
+	UsernameEncryptionKeySecretKey = "key"
+	// usernameEncryptionKeySize is the size in bytes of generated username encryption keys
+	usernameEncryptionKeySize = 32
 	// TLSSecret is the name of the TLS secret that servica-ca-operator creates
 	TLSSecret = "windows-machine-config-operator-tls"
+	// WinRMClientCertificateSecret is the name of the TLS secret provided by the user, holding the client certificate
+	// used to access instances over WinRM. The certificate must be mapped to a local user on each instance.
+	WinRMClientCertificateSecret = "winrm-client-certificate"
+	// WinRMCACertKey is the key within the WinRM client certificate secret holding the CA bundle the WinRM listener
+	// certificates of the instances are verified against
+	WinRMCACertKey = "ca.crt"
+	// ProxyUsernameKey is the key within a proxy credentials secret which holds the username used to authenticate
+	// against the proxy SSH connections are made through
+	ProxyUsernameKey = "username"
+	// ProxyPasswordKey is the key within a proxy credentials secret which holds the password used to authenticate
+	// against the proxy SSH connections are made through
+	ProxyPasswordKey = "password"
 )
 
 // GetPrivateKey fetches the specified secret and extracts the private key data
@@ -39,6 +75,125 @@ func GetPrivateKey(secret kubeTypes.NamespacedName, c client.Client) ([]byte, er
 	return privateKey, nil
 }
 
+// GetPassphrase returns the passphrase held by the secret referenced by the given private key secret's
+// PassphraseSecretAnnotation
+func GetPassphrase(privateKeySecret *core.Secret, c client.Client) ([]byte, error) {
+	passphraseSecretName := privateKeySecret.GetAnnotations()[PassphraseSecretAnnotation]
+	if passphraseSecretName == "" {
+		return nil, fmt.Errorf("private key in secret %s is passphrase protected, but the secret has no %s "+
+			"annotation", privateKeySecret.GetName(), PassphraseSecretAnnotation)
+	}
+	passphraseSecret := &core.Secret{}
+	if err := c.Get(context.TODO(), kubeTypes.NamespacedName{Namespace: privateKeySecret.GetNamespace(),
+		Name: passphraseSecretName}, passphraseSecret); err != nil {
+		return nil, fmt.Errorf("unable to get passphrase secret %s: %w", passphraseSecretName, err)
+	}
+	passphrase, ok := passphraseSecret.Data[PassphraseSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s missing '%s' key", passphraseSecretName, PassphraseSecretKey)
+	}
+	return passphrase, nil
+}
+
+// GetUsernameEncryptionKey returns the key the username annotations of nodes are encrypted with, held by the username
+// encryption key secret in the given namespace
+func GetUsernameEncryptionKey(namespace string, c client.Client) ([]byte, error) {
+	keySecret := &core.Secret{}
+	if err := c.Get(context.TODO(), kubeTypes.NamespacedName{Namespace: namespace,
+		Name: UsernameEncryptionKeySecret}, keySecret); err != nil {
+		return nil, fmt.Errorf("unable to get secret %s: %w", UsernameEncryptionKeySecret, err)
+	}
+	key, ok := keySecret.Data[UsernameEncryptionKeySecretKey]
+	if !ok || len(key) == 0 {
+		return nil, fmt.Errorf("secret %s missing '%s' key", UsernameEncryptionKeySecret,
+			UsernameEncryptionKeySecretKey)
+	}
+	return key, nil
+}
+
+// EnsureUsernameEncryptionKey creates the username encryption key secret in the given namespace, holding a new random
+// key, if it does not exist. An existing key is never replaced, as the username annotations encrypted with it could no
+// longer be decrypted.
+func EnsureUsernameEncryptionKey(ctx context.Context, namespace string, c client.Client) error {
+	err := c.Get(ctx, kubeTypes.NamespacedName{Namespace: namespace, Name: UsernameEncryptionKeySecret}, &core.Secret{})
+	if err == nil || !k8sapierrors.IsNotFound(err) {
+		return err
+	}
+	key := make([]byte, usernameEncryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("error generating username encryption key: %w", err)
+	}
+	keySecret := &core.Secret{
+		ObjectMeta: meta.ObjectMeta{Name: UsernameEncryptionKeySecret, Namespace: namespace},
+		Data:       map[string][]byte{UsernameEncryptionKeySecretKey: key},
+	}
+	if err := c.Create(ctx, keySecret); err != nil && !k8sapierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating secret %s: %w", UsernameEncryptionKeySecret, err)
+	}
+	return nil
+}
+
+// GetProxyCredentials fetches the specified proxy credentials secret and returns the credentials it holds. The
+// password is optional.
+func GetProxyCredentials(secret kubeTypes.NamespacedName, c client.Client) (*url.Userinfo, error) {
+	credentialsSecret := &core.Secret{}
+	if err := c.Get(context.TODO(), secret, credentialsSecret); err != nil {
+		return nil, fmt.Errorf("unable to get secret %s: %w", secret.Name, err)
+	}
+	username := string(credentialsSecret.Data[ProxyUsernameKey])
+	if username == "" {
+		return nil, fmt.Errorf("secret %s missing '%s' key", secret.Name, ProxyUsernameKey)
+	}
+	password, present := credentialsSecret.Data[ProxyPasswordKey]
+	if !present {
+		return url.User(username), nil
+	}
+	return url.UserPassword(username, string(password)), nil
+}
+
+// GetWinRMTLSConfig fetches the specified secret and returns the TLS configuration used to authenticate against
+// instances over WinRM. The instance's certificate is verified against the CA bundle held by the secret.
+func GetWinRMTLSConfig(secret kubeTypes.NamespacedName, c client.Client) (*tls.Config, error) {
+	certSecret := &core.Secret{}
+	if err := c.Get(context.TODO(), secret, certSecret); err != nil {
+		return nil, err
+	}
+	return parseWinRMTLSConfig(certSecret)
+}
+
+// WinRMTLSConfigForInstance returns the TLS configuration used to access the given instance over WinRM, built from the
+// WinRM client certificate secret in the given namespace. Nil is returned if the instance is not accessed over WinRM.
+func WinRMTLSConfigForInstance(instanceInfo *instance.Info, namespace string, c client.Client) (*tls.Config, error) {
+	if !instanceInfo.UsesWinRM() {
+		return nil, nil
+	}
+	tlsConfig, err := GetWinRMTLSConfig(kubeTypes.NamespacedName{Namespace: namespace,
+		Name: WinRMClientCertificateSecret}, c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get WinRM TLS configuration for instance %s: %w", instanceInfo.Address, err)
+	}
+	return tlsConfig, nil
+}
+
+// parseWinRMTLSConfig returns the WinRM TLS configuration described by the given secret
+func parseWinRMTLSConfig(certSecret *core.Secret) (*tls.Config, error) {
+	cert, err := tls.X509KeyPair(certSecret.Data[core.TLSCertKey], certSecret.Data[core.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate in secret %s: %w", certSecret.GetName(), err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	caBundle, present := certSecret.Data[WinRMCACertKey]
+	if !present {
+		return nil, fmt.Errorf("secret %s is missing %s, required to verify the instances' WinRM listener certificates",
+			certSecret.GetName(), WinRMCACertKey)
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("invalid %s in secret %s", WinRMCACertKey, certSecret.GetName())
+	}
+	return tlsConfig, nil
+}
+
 // GenerateUserData generates the desired value of userdata secret.
 func GenerateUserData(platformType oconfig.PlatformType, publicKey ssh.PublicKey) (*core.Secret, error) {
 	pubKeyBytes := ssh.MarshalAuthorizedKey(publicKey)
@@ -1,10 +1,25 @@
 package secrets
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
 	"testing"
+	"time"
 
 	oconfig "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestProcessTags(t *testing.T) {
@@ -34,3 +49,164 @@ func TestProcessTags(t *testing.T) {
 		})
 	}
 }
+
+// generateTestCertificate returns a PEM encoded self-signed certificate and private key
+func generateTestCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Administrator"},
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestParseWinRMTLSConfig(t *testing.T) {
+	cert, key := generateTestCertificate(t)
+	testCases := []struct {
+		name        string
+		data        map[string][]byte
+		expectedErr bool
+	}{
+		{
+			name:        "client certificate only",
+			data:        map[string][]byte{core.TLSCertKey: cert, core.TLSPrivateKeyKey: key},
+			expectedErr: true,
+		},
+		{
+			name:        "client certificate with CA",
+			data:        map[string][]byte{core.TLSCertKey: cert, core.TLSPrivateKeyKey: key, WinRMCACertKey: cert},
+			expectedErr: false,
+		},
+		{
+			name:        "missing private key",
+			data:        map[string][]byte{core.TLSCertKey: cert},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid CA",
+			data:        map[string][]byte{core.TLSCertKey: cert, core.TLSPrivateKeyKey: key, WinRMCACertKey: []byte("ca")},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := parseWinRMTLSConfig(&core.Secret{Data: test.data})
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, out.Certificates, 1)
+			assert.False(t, out.InsecureSkipVerify)
+			assert.NotNil(t, out.RootCAs)
+		})
+	}
+}
+
+func TestEnsureUsernameEncryptionKey(t *testing.T) {
+	c := fake.NewClientBuilder().Build()
+	require.NoError(t, EnsureUsernameEncryptionKey(context.TODO(), "wmco", c))
+	key, err := GetUsernameEncryptionKey("wmco", c)
+	require.NoError(t, err)
+	assert.Len(t, key, usernameEncryptionKeySize)
+
+	// An existing key is kept
+	require.NoError(t, EnsureUsernameEncryptionKey(context.TODO(), "wmco", c))
+	existingKey, err := GetUsernameEncryptionKey("wmco", c)
+	require.NoError(t, err)
+	assert.Equal(t, key, existingKey)
+}
+
+func TestGetPassphrase(t *testing.T) {
+	passphraseSecret := &core.Secret{ObjectMeta: meta.ObjectMeta{Name: "passphrase", Namespace: "wmco"},
+		Data: map[string][]byte{PassphraseSecretKey: []byte("secret")}}
+	c := fake.NewClientBuilder().WithObjects(passphraseSecret).Build()
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:        "passphrase secret exists",
+			annotations: map[string]string{PassphraseSecretAnnotation: "passphrase"},
+			expected:    "secret",
+			expectedErr: false,
+		},
+		{
+			name:        "missing annotation",
+			expectedErr: true,
+		},
+		{
+			name:        "missing passphrase secret",
+			annotations: map[string]string{PassphraseSecretAnnotation: "other"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			keySecret := &core.Secret{ObjectMeta: meta.ObjectMeta{Name: PrivateKeySecret, Namespace: "wmco",
+				Annotations: test.annotations}}
+			passphrase, err := GetPassphrase(keySecret, c)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(passphrase))
+		})
+	}
+}
+
+func TestGetProxyCredentials(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		&core.Secret{ObjectMeta: meta.ObjectMeta{Name: "full", Namespace: "wmco"},
+			Data: map[string][]byte{ProxyUsernameKey: []byte("user"), ProxyPasswordKey: []byte("pass")}},
+		&core.Secret{ObjectMeta: meta.ObjectMeta{Name: "username-only", Namespace: "wmco"},
+			Data: map[string][]byte{ProxyUsernameKey: []byte("user")}},
+		&core.Secret{ObjectMeta: meta.ObjectMeta{Name: "password-only", Namespace: "wmco"},
+			Data: map[string][]byte{ProxyPasswordKey: []byte("pass")}},
+	).Build()
+	testCases := []struct {
+		name        string
+		secret      string
+		expected    *url.Userinfo
+		expectedErr bool
+	}{
+		{
+			name:     "username and password",
+			secret:   "full",
+			expected: url.UserPassword("user", "pass"),
+		},
+		{
+			name:     "username only",
+			secret:   "username-only",
+			expected: url.User("user"),
+		},
+		{
+			name:        "missing username",
+			secret:      "password-only",
+			expectedErr: true,
+		},
+		{
+			name:        "missing secret",
+			secret:      "missing",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			credentials, err := GetProxyCredentials(kubeTypes.NamespacedName{Namespace: "wmco", Name: test.secret}, c)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, credentials)
+		})
+	}
+}
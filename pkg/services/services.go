@@ -3,13 +3,16 @@ package services
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	config "github.com/openshift/api/config/v1"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
+	"github.com/openshift/windows-machine-config-operator/pkg/maintenance"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 )
@@ -22,28 +25,34 @@ const (
 	// hostnameOverrideVar is the variable that should be replaced with the value of the desired instance hostname
 	hostnameOverrideVar = "HOSTNAME_OVERRIDE"
 	NodeIPVar           = "NODE_IP"
+	// kubeletHealthzURL is the URL of the default healthz endpoint of the kubelet
+	kubeletHealthzURL = "http://127.0.0.1:10248/healthz"
+	// kubeProxyHealthzURL is the URL of the default healthz endpoint of kube-proxy
+	kubeProxyHealthzURL = "http://127.0.0.1:10256/healthz"
+	// containerdPipePath is the named pipe the containerd API is served on
+	containerdPipePath = `\\.\pipe\containerd-containerd`
+	// windowsExporterCPUPercent is the share of the CPU capacity of an instance windows_exporter can use, so that
+	// expensive collectors cannot starve the kubelet
+	windowsExporterCPUPercent = 10
+	// windowsExporterMemoryBytes is the memory windows_exporter can commit
+	windowsExporterMemoryBytes = 512 * 1024 * 1024
 )
 
 // GenerateManifest returns the expected state of the Windows service configmap. If debug is true, debug logging
-// will be enabled for services that support it.
+// will be enabled for services that support it. windows_exporter is run with the given configuration. The given
+// user-defined services are managed alongside the services of WMCO. The given payload files are verified by WICD,
+// which takes the given remediation when one of them is modified, restarting the services using restored files within
+// the given maintenance window, if any.
 func GenerateManifest(kubeletArgsFromIgnition map[string]string, vxlanPort string, platform config.PlatformType,
-	debug bool) (*servicescm.Data, error) {
-	windowsExporterServiceCommand := fmt.Sprintf("%s --collectors.enabled "+
-		"cpu,cs,logical_disk,net,os,service,system,textfile,container,memory,cpu_info --web.config.file %s",
-		windows.WindowsExporterPath, windows.TLSConfPath)
+	debug bool, windowsExporter operatorconfig.WindowsExporterConfig, userServices []servicescm.Service,
+	files []servicescm.FileInfo, fileDriftRemediation servicescm.FileDriftRemediation,
+	maintenanceWindow *maintenance.Window) (*servicescm.Data, error) {
 	kubeletConfiguration, err := getKubeletServiceConfiguration(kubeletArgsFromIgnition, debug, platform)
 	if err != nil {
 		return nil, fmt.Errorf("could not determine kubelet service configuration spec: %w", err)
 	}
-	services := &[]servicescm.Service{{
-		Name:                   windows.WindowsExporterServiceName,
-		Command:                windowsExporterServiceCommand,
-		NodeVariablesInCommand: nil,
-		PowershellPreScripts:   nil,
-		Dependencies:           nil,
-		Bootstrap:              false,
-		Priority:               2,
-	},
+	services := &[]servicescm.Service{
+		windowsExporterConfiguration(windowsExporter),
 		containerdConfiguration(debug),
 		kubeletConfiguration,
 		hybridOverlayConfiguration(vxlanPort, debug),
@@ -53,13 +62,80 @@ func GenerateManifest(kubeletArgsFromIgnition map[string]string, vxlanPort strin
 	if platform == config.AzurePlatformType {
 		*services = append(*services, azureCloudNodeManagerConfiguration())
 	}
-	// TODO: All payload filenames and checksums must be added here https://issues.redhat.com/browse/WINC-847
-	files := &[]servicescm.FileInfo{}
+	*services = append(*services, userServices...)
+	if files == nil {
+		files = []servicescm.FileInfo{}
+	}
 	var watchedEnvVars []string
 	for _, envVar := range cluster.WatchedEnvironmentVars {
 		watchedEnvVars = append(watchedEnvVars, envVar)
 	}
-	return servicescm.NewData(services, files, cluster.GetProxyVars(), watchedEnvVars)
+	data, err := servicescm.NewData(services, &files, cluster.GetProxyVars(), watchedEnvVars)
+	if err != nil {
+		return nil, err
+	}
+	data.FileDriftRemediation = fileDriftRemediation
+	if maintenanceWindow != nil {
+		data.MaintenanceWindow = maintenanceWindow.Spec()
+	}
+	return data, nil
+}
+
+// defaultRecoveryPolicy returns the recovery policy of the Windows services managed by WICD. A failed service is
+// restarted after 10, 30 and 60 seconds, and then every 2 minutes, with the failure count being reset after 5 minutes
+// without failures.
+func defaultRecoveryPolicy() *servicescm.RecoveryPolicy {
+	return &servicescm.RecoveryPolicy{
+		Actions: []servicescm.RecoveryAction{
+			{Type: servicescm.RecoveryActionRestart, DelaySeconds: 10},
+			{Type: servicescm.RecoveryActionRestart, DelaySeconds: 30},
+			{Type: servicescm.RecoveryActionRestart, DelaySeconds: 60},
+			{Type: servicescm.RecoveryActionRestart, DelaySeconds: 120},
+		},
+		ResetPeriodSeconds: 300,
+	}
+}
+
+// httpHealthProbe returns a health probe requesting the given URL, using the default period and failure threshold
+func httpHealthProbe(url string) *servicescm.HealthProbe {
+	return &servicescm.HealthProbe{HTTPGet: &servicescm.HTTPGetProbe{URL: url}}
+}
+
+// windowsExporterConfiguration returns the service specification for windows_exporter, run with the given
+// configuration. The textfile collector, when enabled, reads the .prom files of the directory provisioned by WMCO.
+func windowsExporterConfiguration(exporter operatorconfig.WindowsExporterConfig) servicescm.Service {
+	cmd := fmt.Sprintf("%s --collectors.enabled %s --web.config.file %s", windows.WindowsExporterPath,
+		strings.Join(exporter.Collectors, ","), windows.TLSConfPath)
+	if exporter.IsEnabled(operatorconfig.TextfileCollector) {
+		cmd += " --collector.textfile.directories " + windows.WindowsExporterTextfileDir
+	}
+	// Flags are sorted so that the command does not change between calls
+	flags := make([]string, 0, len(exporter.CollectorFlags))
+	for flag := range exporter.CollectorFlags {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+	for _, flag := range flags {
+		value := exporter.CollectorFlags[flag]
+		if value == "" || strings.ContainsAny(value, " \t") {
+			value = "\"" + value + "\""
+		}
+		cmd += fmt.Sprintf(" --%s=%s", flag, value)
+	}
+	return servicescm.Service{
+		Name:                   windows.WindowsExporterServiceName,
+		Command:                cmd,
+		NodeVariablesInCommand: nil,
+		PowershellPreScripts:   nil,
+		Dependencies:           nil,
+		Bootstrap:              false,
+		Priority:               2,
+		Recovery:               defaultRecoveryPolicy(),
+		Limits: &servicescm.ResourceLimits{
+			CPUPercent:  windowsExporterCPUPercent,
+			MemoryBytes: windowsExporterMemoryBytes,
+		},
+	}
 }
 
 // containerdConfiguration returns the service specification for the Windows containerd service
@@ -81,6 +157,10 @@ func containerdConfiguration(debug bool) servicescm.Service {
 		Dependencies: nil,
 		Bootstrap:    true,
 		Priority:     0,
+		Recovery:     defaultRecoveryPolicy(),
+		HealthProbe: &servicescm.HealthProbe{
+			NamedPipe: &servicescm.NamedPipeProbe{Path: containerdPipePath},
+		},
 	}
 }
 
@@ -100,6 +180,7 @@ func azureCloudNodeManagerConfiguration() servicescm.Service {
 		Dependencies:         nil,
 		Bootstrap:            false,
 		Priority:             3,
+		Recovery:             defaultRecoveryPolicy(),
 	}
 }
 
@@ -131,6 +212,7 @@ func hybridOverlayConfiguration(vxlanPort string, debug bool) servicescm.Service
 		Dependencies:         []string{windows.KubeletServiceName},
 		Bootstrap:            false,
 		Priority:             2,
+		Recovery:             defaultRecoveryPolicy(),
 	}
 }
 
@@ -163,6 +245,8 @@ func kubeProxyConfiguration(debug bool) servicescm.Service {
 		Dependencies: []string{windows.HybridOverlayServiceName},
 		Bootstrap:    false,
 		Priority:     3,
+		Recovery:     defaultRecoveryPolicy(),
+		HealthProbe:  httpHealthProbe(kubeProxyHealthzURL),
 	}
 }
 
@@ -173,13 +257,14 @@ func csiProxyConfiguration(debug bool) servicescm.Service {
 	// Set log level
 	serviceCmd = fmt.Sprintf("%s %s", serviceCmd, klogVerbosityArg(debug))
 	return servicescm.Service{
-		Name:                   "csi-proxy",
+		Name:                   windows.CSIProxyServiceName,
 		Command:                serviceCmd,
 		NodeVariablesInCommand: nil,
 		PowershellPreScripts:   nil,
 		Dependencies:           nil,
 		Bootstrap:              false,
 		Priority:               2,
+		Recovery:               defaultRecoveryPolicy(),
 	}
 }
 
@@ -225,6 +310,8 @@ func getKubeletServiceConfiguration(argsFromIginition map[string]string, debug b
 		Name:                   windows.KubeletServiceName,
 		Command:                kubeletServiceCmd,
 		Priority:               1,
+		Recovery:               defaultRecoveryPolicy(),
+		HealthProbe:            httpHealthProbe(kubeletHealthzURL),
 		Bootstrap:              true,
 		Dependencies:           []string{windows.ContainerdServiceName},
 		PowershellPreScripts:   preScripts,
@@ -5,6 +5,8 @@ import (
 
 	config "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/operatorconfig"
 )
 
 func TestGetHostnameCmd(t *testing.T) {
@@ -41,3 +43,48 @@ func TestGetHostnameCmd(t *testing.T) {
 		})
 	}
 }
+
+func TestWindowsExporterConfiguration(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter operatorconfig.WindowsExporterConfig
+		expected string
+	}{
+		{
+			name:     "default configuration",
+			exporter: operatorconfig.Default().WindowsExporter,
+			expected: "C:\\k\\windows_exporter.exe --collectors.enabled " +
+				"cpu,cs,logical_disk,net,os,service,system,textfile,container,memory,cpu_info " +
+				"--web.config.file C:\\k\\tls\\windows-exporter-webconfig.yaml " +
+				"--collector.textfile.directories C:\\ProgramData\\wmco\\windows_exporter\\textfile_inputs",
+		},
+		{
+			name: "textfile collector disabled",
+			exporter: operatorconfig.WindowsExporterConfig{
+				Collectors: []string{"cpu", "os"},
+			},
+			expected: "C:\\k\\windows_exporter.exe --collectors.enabled cpu,os " +
+				"--web.config.file C:\\k\\tls\\windows-exporter-webconfig.yaml",
+		},
+		{
+			name: "collector flags",
+			exporter: operatorconfig.WindowsExporterConfig{
+				Collectors: []string{"process", "service"},
+				CollectorFlags: map[string]string{
+					"collector.service.services-where": "Name='sqlserver' OR Name='w3svc'",
+					"collector.process.include":        "sqlservr|w3wp",
+				},
+			},
+			expected: "C:\\k\\windows_exporter.exe --collectors.enabled process,service " +
+				"--web.config.file C:\\k\\tls\\windows-exporter-webconfig.yaml " +
+				"--collector.process.include=sqlservr|w3wp " +
+				"--collector.service.services-where=\"Name='sqlserver' OR Name='w3svc'\"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := windowsExporterConfiguration(test.exporter)
+			assert.Equal(t, test.expected, actual.Command)
+		})
+	}
+}
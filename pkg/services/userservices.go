@@ -0,0 +1,159 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/client-go/util/jsonpath"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+)
+
+const (
+	// userServicePriorityOffset is added to the priority of user-defined services, so that they are always created
+	// after the services of WMCO
+	userServicePriorityOffset = 10
+)
+
+var (
+	// reservedServiceNames are the names of the Windows services of WMCO, which user-defined services cannot take
+	reservedServiceNames = append([]string{windows.CSIProxyServiceName, windows.AzureCloudNodeManagerServiceName},
+		windows.RequiredServices...)
+)
+
+// UserDefinedServices returns the specifications of the Windows services described by the given WindowsServices,
+// ordered by name, along with the reason each WindowsService which cannot be managed by WICD was left out, by name
+func UserDefinedServices(windowsServices []wmcov1.WindowsService) ([]servicescm.Service, map[string]error) {
+	rejected := make(map[string]error)
+	byName := make(map[string]servicescm.Service)
+	for i := range windowsServices {
+		svc, err := userDefinedService(&windowsServices[i])
+		if err != nil {
+			rejected[windowsServices[i].GetName()] = err
+			continue
+		}
+		byName[svc.Name] = svc
+	}
+	// A service is created after its dependencies only if they do not have a higher priority
+	for name, svc := range byName {
+		for _, dependency := range svc.Dependencies {
+			if dependencySvc, present := byName[dependency]; present && dependencySvc.Priority > svc.Priority {
+				rejected[name] = fmt.Errorf("dependency %s has a higher priority", dependency)
+			}
+		}
+	}
+	var userServices []servicescm.Service
+	for name, svc := range byName {
+		if _, present := rejected[name]; !present {
+			userServices = append(userServices, svc)
+		}
+	}
+	sort.Slice(userServices, func(i, j int) bool {
+		return userServices[i].Name < userServices[j].Name
+	})
+	return userServices, rejected
+}
+
+// userDefinedService returns the specification of the Windows service described by the given WindowsService, or an
+// error if it is not valid
+func userDefinedService(windowsService *wmcov1.WindowsService) (servicescm.Service, error) {
+	name := windowsService.GetName()
+	for _, reserved := range reservedServiceNames {
+		if name == reserved {
+			return servicescm.Service{}, fmt.Errorf("%s is the name of a service managed by WMCO", name)
+		}
+	}
+	spec := windowsService.Spec
+	if spec.Command == "" {
+		return servicescm.Service{}, fmt.Errorf("command must be set")
+	}
+	if spec.Priority < 0 {
+		return servicescm.Service{}, fmt.Errorf("priority must not be negative")
+	}
+	if err := servicescm.ValidateAccount(name, spec.RunAs); err != nil {
+		return servicescm.Service{}, err
+	}
+	svc := servicescm.Service{
+		Name:      name,
+		Command:   spec.Command,
+		Bootstrap: false,
+		Priority:  uint(spec.Priority) + userServicePriorityOffset,
+		Recovery:  defaultRecoveryPolicy(),
+		RunAs:     spec.RunAs,
+	}
+	if spec.Limits != nil {
+		limits, err := userServiceLimits(spec.Limits)
+		if err != nil {
+			return servicescm.Service{}, err
+		}
+		svc.Limits = limits
+	}
+	// Empty lists are left nil, as they are when read back from the services ConfigMap
+	if len(spec.Dependencies) > 0 {
+		svc.Dependencies = spec.Dependencies
+	}
+	variables := make(map[string]struct{})
+	addVariable := func(variable string) error {
+		if _, present := variables[variable]; present {
+			return fmt.Errorf("variable %s is given more than once", variable)
+		}
+		variables[variable] = struct{}{}
+		return nil
+	}
+	for _, nodeVar := range spec.NodeVariablesInCommand {
+		if nodeVar.Name == "" {
+			return servicescm.Service{}, fmt.Errorf("node variable names must be set")
+		}
+		if err := addVariable(nodeVar.Name); err != nil {
+			return servicescm.Service{}, err
+		}
+		if err := jsonpath.New(nodeVar.Name).Parse(nodeVar.NodeObjectJSONPath); err != nil {
+			return servicescm.Service{}, fmt.Errorf("invalid JSON path of node variable %s: %w", nodeVar.Name, err)
+		}
+		svc.NodeVariablesInCommand = append(svc.NodeVariablesInCommand, servicescm.NodeCmdArg{
+			Name:               nodeVar.Name,
+			NodeObjectJsonPath: nodeVar.NodeObjectJSONPath,
+		})
+	}
+	for _, script := range spec.PowershellPreScripts {
+		if script.Path == "" {
+			return servicescm.Service{}, fmt.Errorf("PowerShell pre-script paths must be set")
+		}
+		if script.VariableName != "" {
+			if err := addVariable(script.VariableName); err != nil {
+				return servicescm.Service{}, err
+			}
+		}
+		svc.PowershellPreScripts = append(svc.PowershellPreScripts, servicescm.PowershellPreScript{
+			VariableName: script.VariableName,
+			Path:         script.Path,
+		})
+	}
+	for _, dependency := range spec.Dependencies {
+		if dependency == name {
+			return servicescm.Service{}, fmt.Errorf("service cannot depend on itself")
+		}
+	}
+	return svc, nil
+}
+
+// userServiceLimits returns the resource limits described by the given WindowsService limits, or nil if they do not
+// limit anything
+func userServiceLimits(limits *wmcov1.WindowsServiceLimits) (*servicescm.ResourceLimits, error) {
+	if limits.CPUPercent < 0 || limits.CPUPercent > 100 {
+		return nil, fmt.Errorf("CPU limit must be between 1 and 100 percent")
+	}
+	resourceLimits := &servicescm.ResourceLimits{CPUPercent: uint32(limits.CPUPercent)}
+	if limits.Memory != nil {
+		if limits.Memory.Sign() <= 0 {
+			return nil, fmt.Errorf("memory limit must be positive")
+		}
+		resourceLimits.MemoryBytes = uint64(limits.Memory.Value())
+	}
+	if resourceLimits.CPUPercent == 0 && resourceLimits.MemoryBytes == 0 {
+		return nil, nil
+	}
+	return resourceLimits, nil
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+)
+
+func TestUserDefinedServices(t *testing.T) {
+	windowsService := func(name string, spec wmcov1.WindowsServiceSpec) wmcov1.WindowsService {
+		return wmcov1.WindowsService{ObjectMeta: meta.ObjectMeta{Name: name}, Spec: spec}
+	}
+	tests := []struct {
+		name             string
+		windowsServices  []wmcov1.WindowsService
+		expected         []servicescm.Service
+		expectedRejected []string
+	}{
+		{
+			name:            "no services",
+			windowsServices: nil,
+			expected:        nil,
+		},
+		{
+			name: "valid services",
+			windowsServices: []wmcov1.WindowsService{
+				windowsService("shipper", wmcov1.WindowsServiceSpec{
+					Command: "shipper.exe --node NODE_NAME --token TOKEN",
+					NodeVariablesInCommand: []wmcov1.NodeCommandVariable{{Name: "NODE_NAME",
+						NodeObjectJSONPath: "{.metadata.name}"}},
+					PowershellPreScripts: []wmcov1.PowershellPreScript{{Path: "C:\\k\\token.ps1",
+						VariableName: "TOKEN"}},
+					Dependencies: []string{"agent", "kubelet"},
+					Priority:     1,
+				}),
+				windowsService("agent", wmcov1.WindowsServiceSpec{Command: "agent.exe"}),
+			},
+			expected: []servicescm.Service{
+				{
+					Name:     "agent",
+					Command:  "agent.exe",
+					Priority: userServicePriorityOffset,
+					Recovery: defaultRecoveryPolicy(),
+				},
+				{
+					Name:    "shipper",
+					Command: "shipper.exe --node NODE_NAME --token TOKEN",
+					NodeVariablesInCommand: []servicescm.NodeCmdArg{{Name: "NODE_NAME",
+						NodeObjectJsonPath: "{.metadata.name}"}},
+					PowershellPreScripts: []servicescm.PowershellPreScript{{Path: "C:\\k\\token.ps1",
+						VariableName: "TOKEN"}},
+					Dependencies: []string{"agent", "kubelet"},
+					Priority:     userServicePriorityOffset + 1,
+					Recovery:     defaultRecoveryPolicy(),
+				},
+			},
+		},
+		{
+			name: "reserved name",
+			windowsServices: []wmcov1.WindowsService{
+				windowsService("kubelet", wmcov1.WindowsServiceSpec{Command: "kubelet.exe"}),
+				windowsService("agent", wmcov1.WindowsServiceSpec{Command: "agent.exe"}),
+			},
+			expected: []servicescm.Service{{Name: "agent", Command: "agent.exe", Priority: 10,
+				Recovery: defaultRecoveryPolicy()}},
+			expectedRejected: []string{"kubelet"},
+		},
+		{
+			name: "invalid JSON path",
+			windowsServices: []wmcov1.WindowsService{
+				windowsService("agent", wmcov1.WindowsServiceSpec{
+					Command: "agent.exe NODE_NAME",
+					NodeVariablesInCommand: []wmcov1.NodeCommandVariable{{Name: "NODE_NAME",
+						NodeObjectJSONPath: "{.metadata.name"}},
+				}),
+			},
+			expectedRejected: []string{"agent"},
+		},
+		{
+			name: "duplicate variable",
+			windowsServices: []wmcov1.WindowsService{
+				windowsService("agent", wmcov1.WindowsServiceSpec{
+					Command: "agent.exe NODE_NAME",
+					NodeVariablesInCommand: []wmcov1.NodeCommandVariable{{Name: "NODE_NAME",
+						NodeObjectJSONPath: "{.metadata.name}"}},
+					PowershellPreScripts: []wmcov1.PowershellPreScript{{Path: "C:\\k\\name.ps1",
+						VariableName: "NODE_NAME"}},
+				}),
+			},
+			expectedRejected: []string{"agent"},
+		},
+		{
+			name: "self dependency",
+			windowsServices: []wmcov1.WindowsService{
+				windowsService("agent", wmcov1.WindowsServiceSpec{Command: "agent.exe",
+					Dependencies: []string{"agent"}}),
+			},
+			expectedRejected: []string{"agent"},
+		},
+		{
+			name: "account and limits",
+			windowsServices: []wmcov1.WindowsService{
+				windowsService("agent", wmcov1.WindowsServiceSpec{
+					Command: "agent.exe",
+					RunAs:   "NT SERVICE\\agent",
+					Limits: &wmcov1.WindowsServiceLimits{CPUPercent: 10,
+						Memory: resource.NewQuantity(256*1024*1024, resource.BinarySI)},
+				}),
+				windowsService("shipper", wmcov1.WindowsServiceSpec{
+					Command: "shipper.exe",
+					Limits:  &wmcov1.WindowsServiceLimits{},
+				}),
+			},
+			expected: []servicescm.Service{
+				{
+					Name:     "agent",
+					Command:  "agent.exe",
+					Priority: userServicePriorityOffset,
+					Recovery: defaultRecoveryPolicy(),
+					RunAs:    "NT SERVICE\\agent",
+					Limits:   &servicescm.ResourceLimits{CPUPercent: 10, MemoryBytes: 256 * 1024 * 1024},
+				},
+				{
+					Name:     "shipper",
+					Command:  "shipper.exe",
+					Priority: userServicePriorityOffset,
+					Recovery: defaultRecoveryPolicy(),
+				},
+			},
+		},
+		{
+			name: "account requiring a password",
+			windowsServices: []wmcov1.WindowsService{
+				windowsService("agent", wmcov1.WindowsServiceSpec{Command: "agent.exe", RunAs: "CONTOSO\\agent"}),
+			},
+			expectedRejected: []string{"agent"},
+		},
+		{
+			name: "dependency with higher priority",
+			windowsServices: []wmcov1.WindowsService{
+				windowsService("agent", wmcov1.WindowsServiceSpec{Command: "agent.exe", Priority: 2}),
+				windowsService("shipper", wmcov1.WindowsServiceSpec{Command: "shipper.exe",
+					Dependencies: []string{"agent"}, Priority: 1}),
+			},
+			expected: []servicescm.Service{{Name: "agent", Command: "agent.exe", Priority: 12,
+				Recovery: defaultRecoveryPolicy()}},
+			expectedRejected: []string{"shipper"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userServices, rejected := UserDefinedServices(test.windowsServices)
+			assert.Equal(t, test.expected, userServices)
+			var rejectedNames []string
+			for name, err := range rejected {
+				assert.Error(t, err)
+				rejectedNames = append(rejectedNames, name)
+			}
+			assert.ElementsMatch(t, test.expectedRejected, rejectedNames)
+		})
+	}
+}
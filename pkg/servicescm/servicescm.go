@@ -4,14 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
 	"reflect"
 	"sort"
 	"strings"
+	"time"
 
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/openshift/windows-machine-config-operator/pkg/maintenance"
 	"github.com/openshift/windows-machine-config-operator/version"
 )
 
@@ -30,6 +34,30 @@ const (
 	// watchedEnvironmentVarsKey is an optional key which lists the watched env vars in the services ConfigMap.
 	// The value for this key is a string slice.
 	watchedEnvironmentVarsKey = "watchedEnvironmentVars"
+	// fileDriftRemediationKey is an optional key in the services ConfigMap. The value for this key is the
+	// FileDriftRemediation taken when a file copied by WMCO is found to have been modified.
+	fileDriftRemediationKey = "fileDriftRemediation"
+	// maintenanceWindowKey is an optional key in the services ConfigMap. The value for this key is the maintenance.Spec
+	// of the window restarts of services using restored files are deferred to.
+	maintenanceWindowKey = "maintenanceWindow"
+	// defaultProbePeriodSeconds is how often a health probe is run if its period is not set
+	defaultProbePeriodSeconds = 30
+	// defaultProbeTimeoutSeconds is how long a health probe can take if its timeout is not set
+	defaultProbeTimeoutSeconds = 5
+	// defaultProbeFailureThreshold is the number of consecutive failures of a health probe after which the service is
+	// unhealthy, if the threshold is not set
+	defaultProbeFailureThreshold = 3
+	// namedPipePrefix is the prefix of the paths of local named pipes
+	namedPipePrefix = `\\.\pipe\`
+	// LocalSystemAccount is the account Windows services log on as by default
+	LocalSystemAccount = "LocalSystem"
+	// virtualAccountDomain is the domain of the virtual accounts of Windows services, named after their service
+	virtualAccountDomain = "NT SERVICE"
+)
+
+var (
+	// builtInAccounts are the built-in accounts, other than LocalSystem, Windows services can log on as
+	builtInAccounts = []string{`NT AUTHORITY\LocalService`, `NT AUTHORITY\NetworkService`}
 )
 
 var (
@@ -60,6 +88,105 @@ type PowershellPreScript struct {
 	Path string `json:"path"`
 }
 
+// RecoveryActionType is an action the service control manager can take when a Windows service fails
+type RecoveryActionType string
+
+const (
+	// RecoveryActionRestart restarts the failed service
+	RecoveryActionRestart RecoveryActionType = "restart"
+	// RecoveryActionNone leaves the failed service stopped
+	RecoveryActionNone RecoveryActionType = "none"
+)
+
+// RecoveryAction describes an action taken by the service control manager when a Windows service fails
+type RecoveryAction struct {
+	// Type is the action to take
+	Type RecoveryActionType `json:"type"`
+	// DelaySeconds is the time to wait after the failure before taking the action
+	DelaySeconds uint32 `json:"delaySeconds,omitempty"`
+}
+
+// RecoveryPolicy describes how the service control manager reacts to a Windows service failing
+type RecoveryPolicy struct {
+	// Actions are the actions taken on the first, second and following failures of the service. The last action is
+	// taken on every failure beyond the number of actions.
+	Actions []RecoveryAction `json:"actions"`
+	// ResetPeriodSeconds is the time without failures after which the failure count of the service is reset to zero
+	ResetPeriodSeconds uint32 `json:"resetPeriodSeconds,omitempty"`
+}
+
+// HTTPGetProbe describes a health probe which sends an HTTP GET request. The probe succeeds if the response has a
+// status code in the 2xx or 3xx range.
+type HTTPGetProbe struct {
+	// URL is the http or https URL requested
+	URL string `json:"url"`
+	// InsecureSkipTLSVerify skips the verification of the certificate of an https URL
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+// TCPSocketProbe describes a health probe which succeeds if a TCP connection can be opened
+type TCPSocketProbe struct {
+	// Address is the host:port address connected to
+	Address string `json:"address"`
+}
+
+// NamedPipeProbe describes a health probe which succeeds if a named pipe can be connected to
+type NamedPipeProbe struct {
+	// Path is the path of the named pipe, such as \\.\pipe\containerd-containerd
+	Path string `json:"path"`
+}
+
+// HealthProbe describes how WICD checks that a running Windows service is healthy. Exactly one of HTTPGet, TCPSocket
+// and NamedPipe must be set.
+type HealthProbe struct {
+	// HTTPGet is set if the probe sends an HTTP GET request
+	HTTPGet *HTTPGetProbe `json:"httpGet,omitempty"`
+	// TCPSocket is set if the probe opens a TCP connection
+	TCPSocket *TCPSocketProbe `json:"tcpSocket,omitempty"`
+	// NamedPipe is set if the probe connects to a named pipe
+	NamedPipe *NamedPipeProbe `json:"namedPipe,omitempty"`
+	// PeriodSeconds is how often the probe is run. Defaults to 30 seconds.
+	PeriodSeconds uint32 `json:"periodSeconds,omitempty"`
+	// TimeoutSeconds is how long the probe can take before it fails. Defaults to 5 seconds.
+	TimeoutSeconds uint32 `json:"timeoutSeconds,omitempty"`
+	// FailureThreshold is the number of consecutive failures of the probe after which the service is unhealthy and is
+	// restarted by WICD. Defaults to 3.
+	FailureThreshold uint32 `json:"failureThreshold,omitempty"`
+}
+
+// Period returns how often the probe is run
+func (p *HealthProbe) Period() time.Duration {
+	if p.PeriodSeconds == 0 {
+		return defaultProbePeriodSeconds * time.Second
+	}
+	return time.Duration(p.PeriodSeconds) * time.Second
+}
+
+// Timeout returns how long the probe can take before it fails
+func (p *HealthProbe) Timeout() time.Duration {
+	if p.TimeoutSeconds == 0 {
+		return defaultProbeTimeoutSeconds * time.Second
+	}
+	return time.Duration(p.TimeoutSeconds) * time.Second
+}
+
+// Threshold returns the number of consecutive failures of the probe after which the service is unhealthy
+func (p *HealthProbe) Threshold() uint32 {
+	if p.FailureThreshold == 0 {
+		return defaultProbeFailureThreshold
+	}
+	return p.FailureThreshold
+}
+
+// ResourceLimits describes the resources the processes of a Windows service can use, enforced through a job object.
+// A zero value is no limit.
+type ResourceLimits struct {
+	// CPUPercent is the maximum CPU usage of the processes, as a percentage of the CPU capacity of the instance
+	CPUPercent uint32 `json:"cpuPercent,omitempty"`
+	// MemoryBytes is the maximum memory committed by the processes
+	MemoryBytes uint64 `json:"memoryBytes,omitempty"`
+}
+
 // Service represents the configuration spec of a Windows service
 type Service struct {
 	// Name is the name of the Windows service
@@ -79,6 +206,26 @@ type Service struct {
 	// Priority is a non-negative integer that will be used to order the creation of the services.
 	// Priority 0 is created first
 	Priority uint `json:"priority"`
+	// Recovery is the reaction of the service control manager to the service failing. If nil, the service control
+	// manager takes no action, and the service is only restarted by WICD.
+	Recovery *RecoveryPolicy `json:"recovery,omitempty"`
+	// HealthProbe is how WICD checks that the service is healthy while it is running. If nil, a running service is
+	// considered healthy.
+	HealthProbe *HealthProbe `json:"healthProbe,omitempty"`
+	// RunAs is the account the service logs on as. It can be a built-in service account such as
+	// NT AUTHORITY\NetworkService, the virtual account of the service, NT SERVICE\<name>, or a group managed service
+	// account, DOMAIN\<account>$. Defaults to LocalSystem.
+	RunAs string `json:"runAs,omitempty"`
+	// Limits are the resources the processes of the service can use. If nil, their resources are not limited.
+	Limits *ResourceLimits `json:"limits,omitempty"`
+}
+
+// Account returns the account the service logs on as
+func (s *Service) Account() string {
+	if s.RunAs == "" {
+		return LocalSystemAccount
+	}
+	return s.RunAs
 }
 
 // FileInfo contains the path and checksum of a file copied to an instance by WMCO
@@ -89,6 +236,28 @@ type FileInfo struct {
 	Checksum string `json:"checksum"`
 }
 
+// FileDriftRemediation is the action WICD takes when a file copied to an instance by WMCO no longer has its expected
+// checksum
+type FileDriftRemediation string
+
+const (
+	// FileDriftRemediationNone only reports modified files
+	FileDriftRemediationNone FileDriftRemediation = "None"
+	// FileDriftRemediationRestore restores modified files from the payload cache of the instance, requesting WMCO to
+	// transfer the file to the cache again if the cached copy is missing or modified as well. Files used by running
+	// services are only restored within the maintenance window, as the services are restarted to use them.
+	FileDriftRemediationRestore FileDriftRemediation = "Restore"
+)
+
+// Validate returns an error if the remediation is not known
+func (r FileDriftRemediation) Validate() error {
+	if r != FileDriftRemediationNone && r != FileDriftRemediationRestore {
+		return fmt.Errorf("unknown file drift remediation %q, must be %s or %s", r, FileDriftRemediationNone,
+			FileDriftRemediationRestore)
+	}
+	return nil
+}
+
 // Data represents the Data field of a `windows-services` ConfigMap resource, which is all the required information to
 // configure a Windows instance as a Node
 type Data struct {
@@ -100,6 +269,29 @@ type Data struct {
 	EnvironmentVars map[string]string `json:"environmentVars,omitempty"`
 	// WatchedEnvironmentVars contains information about the WMCO watched environment variables
 	WatchedEnvironmentVars []string `json:"watchedEnvironmentVars,omitempty"`
+	// FileDriftRemediation is the action taken when one of the Files is modified. Modified files are only reported if
+	// empty.
+	FileDriftRemediation FileDriftRemediation `json:"fileDriftRemediation,omitempty"`
+	// MaintenanceWindow is the window restarts of services using restored files are deferred to. Services are
+	// restarted at any time if nil.
+	MaintenanceWindow *maintenance.Spec `json:"maintenanceWindow,omitempty"`
+}
+
+// DriftRemediation returns the action taken when one of the files copied by WMCO is modified
+func (cmData *Data) DriftRemediation() FileDriftRemediation {
+	if cmData.FileDriftRemediation == "" {
+		return FileDriftRemediationNone
+	}
+	return cmData.FileDriftRemediation
+}
+
+// Window returns the maintenance window restarts of services using restored files are deferred to, or nil if services
+// can be restarted at any time
+func (cmData *Data) Window() (*maintenance.Window, error) {
+	if cmData.MaintenanceWindow == nil {
+		return nil, nil
+	}
+	return cmData.MaintenanceWindow.Window()
 }
 
 // NewData returns a new 'Data' object with the given services, files, watched ENV vars and
@@ -186,6 +378,16 @@ func Generate(name, namespace string, data *Data) (*core.ConfigMap, error) {
 		return nil, err
 	}
 	servicesConfigMap.Data[watchedEnvironmentVarsKey] = string(jsonWatchedEnvVars)
+	if data.FileDriftRemediation != "" {
+		servicesConfigMap.Data[fileDriftRemediationKey] = string(data.FileDriftRemediation)
+	}
+	if data.MaintenanceWindow != nil {
+		jsonWindow, err := json.Marshal(data.MaintenanceWindow)
+		if err != nil {
+			return nil, err
+		}
+		servicesConfigMap.Data[maintenanceWindowKey] = string(jsonWindow)
+	}
 	return servicesConfigMap, nil
 }
 
@@ -193,10 +395,12 @@ func Generate(name, namespace string, data *Data) (*core.ConfigMap, error) {
 // Returns error if the given data is invalid in structure
 func Parse(dataFromCM map[string]string) (*Data, error) {
 	// 2 required keys: services, files
-	// 2 optional keys: watchedEnvironmentVars, environmentVars which won't be present in the services CM if nil or empty
-	if len(dataFromCM) < 2 || len(dataFromCM) > 4 {
+	// 4 optional keys: watchedEnvironmentVars, environmentVars, fileDriftRemediation and maintenanceWindow which won't
+	// be present in the services CM if nil or empty
+	if len(dataFromCM) < 2 || len(dataFromCM) > 6 {
 		return nil, fmt.Errorf("services ConfigMap can only have the required services, files" +
-			", and an optional watchedEnvironmentVars key or environmentVars key")
+			", and an optional watchedEnvironmentVars key, environmentVars key, fileDriftRemediation key or " +
+			"maintenanceWindow key")
 	}
 
 	value, ok := dataFromCM[servicesKey]
@@ -232,7 +436,28 @@ func Parse(dataFromCM map[string]string) (*Data, error) {
 			return nil, err
 		}
 	}
-	return NewData(services, files, envVars, watchedEnvVars)
+	cmData, err := NewData(services, files, envVars, watchedEnvVars)
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := dataFromCM[fileDriftRemediationKey]; ok {
+		remediation := FileDriftRemediation(value)
+		if err := remediation.Validate(); err != nil {
+			return nil, err
+		}
+		cmData.FileDriftRemediation = remediation
+	}
+	if value, ok := dataFromCM[maintenanceWindowKey]; ok {
+		spec := &maintenance.Spec{}
+		if err := json.Unmarshal([]byte(value), spec); err != nil {
+			return nil, err
+		}
+		if _, err := spec.Window(); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", maintenanceWindowKey, err)
+		}
+		cmData.MaintenanceWindow = spec
+	}
+	return cmData, nil
 }
 
 // GetBootstrapServices filters the cmData object's services list and returns only the bootstrap services
@@ -254,9 +479,128 @@ func (cmData *Data) validate() error {
 	if err := validateDependencies(cmData.Services); err != nil {
 		return err
 	}
+	if err := validateRecoveryPolicies(cmData.Services); err != nil {
+		return err
+	}
+	if err := validateHealthProbes(cmData.Services); err != nil {
+		return err
+	}
+	if err := validateAccountsAndLimits(cmData.Services); err != nil {
+		return err
+	}
 	return validatePriorities(cmData.Services)
 }
 
+// validateRecoveryPolicies ensures that the recovery policy of each service, if any, only consists of known actions
+func validateRecoveryPolicies(services []Service) error {
+	for _, svc := range services {
+		if svc.Recovery == nil {
+			continue
+		}
+		if len(svc.Recovery.Actions) == 0 {
+			return fmt.Errorf("recovery policy of service %s must have at least one action", svc.Name)
+		}
+		for _, action := range svc.Recovery.Actions {
+			if action.Type != RecoveryActionRestart && action.Type != RecoveryActionNone {
+				return fmt.Errorf("recovery policy of service %s has unknown action %q", svc.Name, action.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// validateHealthProbes ensures that the health probe of each service, if any, describes exactly one valid check
+func validateHealthProbes(services []Service) error {
+	for _, svc := range services {
+		if svc.HealthProbe == nil {
+			continue
+		}
+		if err := svc.HealthProbe.validate(); err != nil {
+			return fmt.Errorf("invalid health probe of service %s: %w", svc.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate ensures that the probe describes exactly one valid check
+func (p *HealthProbe) validate() error {
+	checks := 0
+	if p.HTTPGet != nil {
+		checks++
+		probeURL, err := url.Parse(p.HTTPGet.URL)
+		if err != nil {
+			return fmt.Errorf("invalid URL %s: %w", p.HTTPGet.URL, err)
+		}
+		if (probeURL.Scheme != "http" && probeURL.Scheme != "https") || probeURL.Host == "" {
+			return fmt.Errorf("URL %s must be an absolute http or https URL", p.HTTPGet.URL)
+		}
+	}
+	if p.TCPSocket != nil {
+		checks++
+		if _, _, err := net.SplitHostPort(p.TCPSocket.Address); err != nil {
+			return fmt.Errorf("invalid address %s: %w", p.TCPSocket.Address, err)
+		}
+	}
+	if p.NamedPipe != nil {
+		checks++
+		if !strings.HasPrefix(p.NamedPipe.Path, namedPipePrefix) || p.NamedPipe.Path == namedPipePrefix {
+			return fmt.Errorf("named pipe path %s must start with %s", p.NamedPipe.Path, namedPipePrefix)
+		}
+	}
+	if checks != 1 {
+		return fmt.Errorf("exactly one of httpGet, tcpSocket and namedPipe must be set")
+	}
+	return nil
+}
+
+// validateAccountsAndLimits ensures that each service logs on as an account which does not require a password, and
+// that its resource limits, if any, are valid
+func validateAccountsAndLimits(services []Service) error {
+	for _, svc := range services {
+		if err := ValidateAccount(svc.Name, svc.RunAs); err != nil {
+			return fmt.Errorf("invalid account of service %s: %w", svc.Name, err)
+		}
+		if svc.Limits == nil {
+			continue
+		}
+		if svc.Limits.CPUPercent == 0 && svc.Limits.MemoryBytes == 0 {
+			return fmt.Errorf("resource limits of service %s must limit CPU or memory", svc.Name)
+		}
+		if svc.Limits.CPUPercent > 100 {
+			return fmt.Errorf("CPU limit of service %s must not exceed 100 percent", svc.Name)
+		}
+	}
+	return nil
+}
+
+// ValidateAccount ensures that the given account, which the service of the given name logs on as, is LocalSystem, a
+// built-in service account, the virtual account of the service or a group managed service account
+func ValidateAccount(serviceName, account string) error {
+	if account == "" || strings.EqualFold(account, LocalSystemAccount) {
+		return nil
+	}
+	for _, builtIn := range builtInAccounts {
+		if strings.EqualFold(account, builtIn) {
+			return nil
+		}
+	}
+	domain, name, found := strings.Cut(account, `\`)
+	if !found || domain == "" || name == "" || strings.Contains(name, `\`) {
+		return fmt.Errorf("account %s must be of the form DOMAIN\\name", account)
+	}
+	if strings.EqualFold(domain, virtualAccountDomain) {
+		if !strings.EqualFold(name, serviceName) {
+			return fmt.Errorf("virtual account %s is not the account of the service", account)
+		}
+		return nil
+	}
+	if !strings.HasSuffix(name, "$") {
+		return fmt.Errorf("account %s requires a password, only group managed service accounts ending in $ can be "+
+			"used", account)
+	}
+	return nil
+}
+
 // ValidateExpectedContent ensures that the given slices are all comprised of only the expected services, files, and
 // environment variables
 func (cmData *Data) ValidateExpectedContent(expected *Data) error {
@@ -294,6 +638,12 @@ func (cmData *Data) ValidateExpectedContent(expected *Data) error {
 		return fmt.Errorf("required environment variables are not present as expected "+
 			"expected: %v, actual: %v", cmData.WatchedEnvironmentVars, expected.WatchedEnvironmentVars)
 	}
+	if cmData.FileDriftRemediation != expected.FileDriftRemediation {
+		return fmt.Errorf("unexpected file drift remediation %q", cmData.FileDriftRemediation)
+	}
+	if !reflect.DeepEqual(cmData.MaintenanceWindow, expected.MaintenanceWindow) {
+		return fmt.Errorf("unexpected maintenance window %v", cmData.MaintenanceWindow)
+	}
 	return nil
 }
 
@@ -3,9 +3,12 @@ package servicescm
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/maintenance"
 )
 
 func TestParse(t *testing.T) {
@@ -85,6 +88,42 @@ func TestParse(t *testing.T) {
 			},
 			expectedErr: true,
 		},
+		{
+			name: "file drift remediation",
+			input: map[string]string{
+				servicesKey:             "[]",
+				filesKey:                "[]",
+				fileDriftRemediationKey: "None",
+			},
+			expectedErr: false,
+		},
+		{
+			name: "unknown file drift remediation",
+			input: map[string]string{
+				servicesKey:             "[]",
+				filesKey:                "[]",
+				fileDriftRemediationKey: "Replace",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "maintenance window",
+			input: map[string]string{
+				servicesKey:          "[]",
+				filesKey:             "[]",
+				maintenanceWindowKey: `{"schedule":"0 1 * * 6","timeZone":"UTC","duration":"4h0m0s"}`,
+			},
+			expectedErr: false,
+		},
+		{
+			name: "invalid maintenance window",
+			input: map[string]string{
+				servicesKey:          "[]",
+				filesKey:             "[]",
+				maintenanceWindowKey: `{"schedule":"0 1 * * 6","timeZone":"UTC","duration":"4"}`,
+			},
+			expectedErr: true,
+		},
 		{
 			name: "too many keys",
 			input: map[string]string{
@@ -92,6 +131,8 @@ func TestParse(t *testing.T) {
 				filesKey:                  "[]",
 				envVarsKey:                "{}",
 				watchedEnvironmentVarsKey: "[]",
+				fileDriftRemediationKey:   "None",
+				maintenanceWindowKey:      `{"schedule":"0 1 * * 6","timeZone":"UTC","duration":"4h0m0s"}`,
 				"testKey":                 "[]",
 			},
 			expectedErr: true,
@@ -222,6 +263,54 @@ func TestGenerate(t *testing.T) {
 		require.NoError(t, err)
 		assert.Error(t, parsed.ValidateExpectedContent(expectedData))
 	})
+	t.Run("ConfigMaps with different file drift remediations", func(t *testing.T) {
+		services := testServices
+		files := testFiles
+		expectedData, err := NewData(&services, &files, nil, testEnvVars)
+		require.NoError(t, err)
+		expectedData.FileDriftRemediation = FileDriftRemediationRestore
+		existingData, err := NewData(&services, &files, nil, testEnvVars)
+		require.NoError(t, err)
+		configMap, err := Generate(Name, "testNamespace", existingData)
+		require.NoError(t, err)
+		parsed, err := Parse(configMap.Data)
+		require.NoError(t, err)
+		assert.Equal(t, FileDriftRemediationNone, parsed.DriftRemediation())
+		assert.Error(t, parsed.ValidateExpectedContent(expectedData))
+
+		configMap, err = Generate(Name, "testNamespace", expectedData)
+		require.NoError(t, err)
+		parsed, err = Parse(configMap.Data)
+		require.NoError(t, err)
+		assert.Equal(t, FileDriftRemediationRestore, parsed.DriftRemediation())
+		assert.NoError(t, parsed.ValidateExpectedContent(expectedData))
+	})
+	t.Run("ConfigMaps with different maintenance windows", func(t *testing.T) {
+		services := testServices
+		files := testFiles
+		expectedData, err := NewData(&services, &files, nil, testEnvVars)
+		require.NoError(t, err)
+		expectedData.MaintenanceWindow = &maintenance.Spec{Schedule: "0 1 * * 6", TimeZone: "UTC", Duration: "4h0m0s"}
+		existingData, err := NewData(&services, &files, nil, testEnvVars)
+		require.NoError(t, err)
+		configMap, err := Generate(Name, "testNamespace", existingData)
+		require.NoError(t, err)
+		parsed, err := Parse(configMap.Data)
+		require.NoError(t, err)
+		window, err := parsed.Window()
+		require.NoError(t, err)
+		assert.Nil(t, window)
+		assert.Error(t, parsed.ValidateExpectedContent(expectedData))
+
+		configMap, err = Generate(Name, "testNamespace", expectedData)
+		require.NoError(t, err)
+		parsed, err = Parse(configMap.Data)
+		require.NoError(t, err)
+		window, err = parsed.Window()
+		require.NoError(t, err)
+		assert.NotNil(t, window)
+		assert.NoError(t, parsed.ValidateExpectedContent(expectedData))
+	})
 }
 
 func TestGetBootstrapServices(t *testing.T) {
@@ -751,3 +840,212 @@ func TestValidatePriorities(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateRecoveryPolicies(t *testing.T) {
+	testCases := []struct {
+		name        string
+		input       []Service
+		expectedErr bool
+	}{
+		{
+			name:        "no recovery policy",
+			input:       []Service{{Name: "test-service"}},
+			expectedErr: false,
+		},
+		{
+			name: "valid recovery policy",
+			input: []Service{{
+				Name: "test-service",
+				Recovery: &RecoveryPolicy{
+					Actions: []RecoveryAction{
+						{Type: RecoveryActionRestart, DelaySeconds: 10},
+						{Type: RecoveryActionNone},
+					},
+					ResetPeriodSeconds: 300,
+				},
+			}},
+			expectedErr: false,
+		},
+		{
+			name:        "no actions",
+			input:       []Service{{Name: "test-service", Recovery: &RecoveryPolicy{ResetPeriodSeconds: 300}}},
+			expectedErr: true,
+		},
+		{
+			name: "unknown action",
+			input: []Service{{
+				Name:     "test-service",
+				Recovery: &RecoveryPolicy{Actions: []RecoveryAction{{Type: "reboot", DelaySeconds: 10}}},
+			}},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateRecoveryPolicies(test.input)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestValidateHealthProbes(t *testing.T) {
+	testCases := []struct {
+		name        string
+		probe       *HealthProbe
+		expectedErr bool
+	}{
+		{
+			name:        "no health probe",
+			probe:       nil,
+			expectedErr: false,
+		},
+		{
+			name:        "valid HTTP probe",
+			probe:       &HealthProbe{HTTPGet: &HTTPGetProbe{URL: "http://127.0.0.1:10248/healthz"}, PeriodSeconds: 10},
+			expectedErr: false,
+		},
+		{
+			name:        "valid TCP probe",
+			probe:       &HealthProbe{TCPSocket: &TCPSocketProbe{Address: "127.0.0.1:9182"}},
+			expectedErr: false,
+		},
+		{
+			name:        "valid named pipe probe",
+			probe:       &HealthProbe{NamedPipe: &NamedPipeProbe{Path: `\\.\pipe\containerd-containerd`}},
+			expectedErr: false,
+		},
+		{
+			name:        "no check",
+			probe:       &HealthProbe{PeriodSeconds: 10},
+			expectedErr: true,
+		},
+		{
+			name: "multiple checks",
+			probe: &HealthProbe{HTTPGet: &HTTPGetProbe{URL: "http://127.0.0.1:10248/healthz"},
+				TCPSocket: &TCPSocketProbe{Address: "127.0.0.1:10248"}},
+			expectedErr: true,
+		},
+		{
+			name:        "relative URL",
+			probe:       &HealthProbe{HTTPGet: &HTTPGetProbe{URL: "/healthz"}},
+			expectedErr: true,
+		},
+		{
+			name:        "unsupported URL scheme",
+			probe:       &HealthProbe{HTTPGet: &HTTPGetProbe{URL: "ftp://127.0.0.1/healthz"}},
+			expectedErr: true,
+		},
+		{
+			name:        "address without port",
+			probe:       &HealthProbe{TCPSocket: &TCPSocketProbe{Address: "127.0.0.1"}},
+			expectedErr: true,
+		},
+		{
+			name:        "file path instead of named pipe",
+			probe:       &HealthProbe{NamedPipe: &NamedPipeProbe{Path: `C:\k\containerd.sock`}},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateHealthProbes([]Service{{Name: "test-service", HealthProbe: test.probe}})
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestHealthProbeDefaults(t *testing.T) {
+	probe := &HealthProbe{TCPSocket: &TCPSocketProbe{Address: "127.0.0.1:9182"}}
+	assert.Equal(t, 30*time.Second, probe.Period())
+	assert.Equal(t, 5*time.Second, probe.Timeout())
+	assert.Equal(t, uint32(3), probe.Threshold())
+
+	probe = &HealthProbe{TCPSocket: &TCPSocketProbe{Address: "127.0.0.1:9182"}, PeriodSeconds: 10, TimeoutSeconds: 1,
+		FailureThreshold: 5}
+	assert.Equal(t, 10*time.Second, probe.Period())
+	assert.Equal(t, time.Second, probe.Timeout())
+	assert.Equal(t, uint32(5), probe.Threshold())
+}
+
+func TestValidateAccountsAndLimits(t *testing.T) {
+	testCases := []struct {
+		name        string
+		runAs       string
+		limits      *ResourceLimits
+		expectedErr bool
+	}{
+		{
+			name:        "defaults",
+			expectedErr: false,
+		},
+		{
+			name:        "LocalSystem",
+			runAs:       "LocalSystem",
+			expectedErr: false,
+		},
+		{
+			name:        "built-in account",
+			runAs:       `NT AUTHORITY\NetworkService`,
+			expectedErr: false,
+		},
+		{
+			name:        "virtual account of the service",
+			runAs:       `NT SERVICE\test-service`,
+			expectedErr: false,
+		},
+		{
+			name:        "virtual account of another service",
+			runAs:       `NT SERVICE\kubelet`,
+			expectedErr: true,
+		},
+		{
+			name:        "group managed service account",
+			runAs:       `CONTOSO\agent$`,
+			expectedErr: false,
+		},
+		{
+			name:        "account requiring a password",
+			runAs:       `CONTOSO\agent`,
+			expectedErr: true,
+		},
+		{
+			name:        "account without domain",
+			runAs:       "agent$",
+			expectedErr: true,
+		},
+		{
+			name:        "valid limits",
+			limits:      &ResourceLimits{CPUPercent: 10, MemoryBytes: 512 * 1024 * 1024},
+			expectedErr: false,
+		},
+		{
+			name:        "empty limits",
+			limits:      &ResourceLimits{},
+			expectedErr: true,
+		},
+		{
+			name:        "CPU limit above 100 percent",
+			limits:      &ResourceLimits{CPUPercent: 150},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateAccountsAndLimits([]Service{{Name: "test-service", RunAs: test.runAs,
+				Limits: test.limits}})
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
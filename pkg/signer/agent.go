@@ -0,0 +1,98 @@
+package signer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentSocketEnv is the environment variable holding the path to the socket of the SSH agent holding the keys of
+// private key secrets which contain an agent public key. Keys stored in hardware, such as in a PKCS#11 token, can be
+// used by loading them into the agent.
+const agentSocketEnv = "SSH_AUTH_SOCK"
+
+// agentSigner signs data with a key held by an SSH agent, connecting to the agent for every signature so that the
+// agent can be restarted without invalidating the signer
+type agentSigner struct {
+	// socket is the path to the socket the agent listens on
+	socket string
+	// publicKey is the public key of the key used to sign data
+	publicKey ssh.PublicKey
+}
+
+// newAgentSigner returns a signer using the key held by the operator's SSH agent with the given public key, in
+// authorized_keys format
+func newAgentSigner(authorizedKey []byte) (ssh.Signer, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(authorizedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse agent public key: %w", err)
+	}
+	socket := os.Getenv(agentSocketEnv)
+	if socket == "" {
+		return nil, fmt.Errorf("the %s environment variable must be set to use keys held by an SSH agent",
+			agentSocketEnv)
+	}
+	s := &agentSigner{socket: socket, publicKey: publicKey}
+	// Fail early if the agent does not hold the key, rather than on the first connection to an instance
+	if err := s.withAgent(func(client agent.ExtendedAgent) error {
+		keys, err := client.List()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if string(key.Marshal()) == string(publicKey.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("key %s not found", ssh.FingerprintSHA256(publicKey))
+	}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// PublicKey returns the public key of the key used to sign data
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+// Sign returns a signature of the given data using the default algorithm for the key
+func (s *agentSigner) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(nil, data, "")
+}
+
+// SignWithAlgorithm returns a signature of the given data using the given algorithm. RSA keys support the rsa-sha2
+// algorithms required by instances which do not accept SHA-1 signatures.
+func (s *agentSigner) SignWithAlgorithm(_ io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.KeyAlgoRSASHA256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512:
+		flags = agent.SignatureFlagRsaSha512
+	}
+	var signature *ssh.Signature
+	err := s.withAgent(func(client agent.ExtendedAgent) error {
+		var err error
+		signature, err = client.SignWithFlags(s.publicKey, data, flags)
+		return err
+	})
+	return signature, err
+}
+
+// withAgent calls the given function with a client connected to the agent
+func (s *agentSigner) withAgent(f func(agent.ExtendedAgent) error) error {
+	conn, err := net.Dial("unix", s.socket)
+	if err != nil {
+		return fmt.Errorf("unable to connect to SSH agent: %w", err)
+	}
+	defer conn.Close()
+	if err := f(agent.NewClient(conn)); err != nil {
+		return fmt.Errorf("SSH agent error: %w", err)
+	}
+	return nil
+}
@@ -1,24 +1,64 @@
 package signer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 )
 
-// Create creates a signer using the private key data
+// Create creates a signer from the given private key secret. The secret holds either a private key, which is decrypted
+// with the passphrase from the secret referenced by its passphrase annotation if it is passphrase protected, or the
+// public key of a key held by the operator's SSH agent.
 func Create(secret kubeTypes.NamespacedName, c client.Client) (ssh.Signer, error) {
-	privateKey, err := secrets.GetPrivateKey(secret, c)
-	if err != nil {
+	keySecret := &core.Secret{}
+	if err := c.Get(context.TODO(), secret, keySecret); err != nil {
 		return nil, err
 	}
+	privateKey, ok := keySecret.Data[secrets.PrivateKeySecretKey]
+	if !ok {
+		if agentPublicKey, ok := keySecret.Data[secrets.AgentPublicKeySecretKey]; ok {
+			return newAgentSigner(agentPublicKey)
+		}
+		return nil, fmt.Errorf("secret %s missing '%s' key", secret.Name, secrets.PrivateKeySecretKey)
+	}
 	signer, err := ssh.ParsePrivateKey(privateKey)
+	var passphraseMissingErr *ssh.PassphraseMissingError
+	if errors.As(err, &passphraseMissingErr) {
+		passphrase, err := secrets.GetPassphrase(keySecret, c)
+		if err != nil {
+			return nil, err
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKey, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt private key with passphrase: %w", err)
+		}
+		return signer, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse private key: %w", err)
 	}
 	return signer, nil
 }
+
+// ForInstance returns the signer which should be used to access the given instance. If the instance specifies its own
+// private key secret, a signer is created from it. Otherwise the given default signer is returned.
+func ForInstance(instanceInfo *instance.Info, defaultSigner ssh.Signer, namespace string,
+	c client.Client) (ssh.Signer, error) {
+	if instanceInfo == nil || instanceInfo.PrivateKeySecret == "" {
+		return defaultSigner, nil
+	}
+	instanceSigner, err := Create(kubeTypes.NamespacedName{Namespace: namespace, Name: instanceInfo.PrivateKeySecret},
+		c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create signer for instance %s: %w", instanceInfo.Address, err)
+	}
+	return instanceSigner, nil
+}
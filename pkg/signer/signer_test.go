@@ -0,0 +1,158 @@
+package signer
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
+)
+
+// newKeySecret returns a private key secret with the given data and annotations
+func newKeySecret(data map[string][]byte, annotations map[string]string) *core.Secret {
+	return &core.Secret{ObjectMeta: meta.ObjectMeta{Name: "key", Namespace: "wmco", Annotations: annotations},
+		Data: data}
+}
+
+func TestCreate(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	expected, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+	plainPEM, err := ssh.MarshalPrivateKey(privateKey, "")
+	require.NoError(t, err)
+	encryptedPEM, err := ssh.MarshalPrivateKeyWithPassphrase(privateKey, "", []byte("secret"))
+	require.NoError(t, err)
+	passphraseAnnotation := map[string]string{secrets.PassphraseSecretAnnotation: "passphrase"}
+	passphraseSecret := &core.Secret{ObjectMeta: meta.ObjectMeta{Name: "passphrase", Namespace: "wmco"},
+		Data: map[string][]byte{secrets.PassphraseSecretKey: []byte("secret")}}
+	wrongPassphraseSecret := &core.Secret{ObjectMeta: meta.ObjectMeta{Name: "passphrase", Namespace: "wmco"},
+		Data: map[string][]byte{secrets.PassphraseSecretKey: []byte("wrong")}}
+
+	testCases := []struct {
+		name        string
+		objects     []*core.Secret
+		expectedErr bool
+	}{
+		{
+			name: "unencrypted private key",
+			objects: []*core.Secret{newKeySecret(
+				map[string][]byte{secrets.PrivateKeySecretKey: pem.EncodeToMemory(plainPEM)}, nil)},
+			expectedErr: false,
+		},
+		{
+			name: "passphrase protected private key",
+			objects: []*core.Secret{newKeySecret(map[string][]byte{
+				secrets.PrivateKeySecretKey: pem.EncodeToMemory(encryptedPEM)}, passphraseAnnotation),
+				passphraseSecret},
+			expectedErr: false,
+		},
+		{
+			name: "passphrase protected private key without passphrase annotation",
+			objects: []*core.Secret{newKeySecret(
+				map[string][]byte{secrets.PrivateKeySecretKey: pem.EncodeToMemory(encryptedPEM)}, nil)},
+			expectedErr: true,
+		},
+		{
+			name: "passphrase protected private key with wrong passphrase",
+			objects: []*core.Secret{newKeySecret(map[string][]byte{
+				secrets.PrivateKeySecretKey: pem.EncodeToMemory(encryptedPEM)}, passphraseAnnotation),
+				wrongPassphraseSecret},
+			expectedErr: true,
+		},
+		{
+			name:        "missing private key",
+			objects:     []*core.Secret{newKeySecret(map[string][]byte{}, nil)},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder()
+			for _, obj := range test.objects {
+				builder = builder.WithObjects(obj)
+			}
+			signer, err := Create(kubeTypes.NamespacedName{Namespace: "wmco", Name: "key"}, builder.Build())
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, expected.PublicKey().Marshal(), signer.PublicKey().Marshal())
+		})
+	}
+}
+
+func TestCreateWithAgent(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyring := agent.NewKeyring()
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: privateKey}))
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv(agentSocketEnv, socket)
+
+	agentKey, err := ssh.NewPublicKey(privateKey.Public())
+	require.NoError(t, err)
+	c := fake.NewClientBuilder().WithObjects(newKeySecret(
+		map[string][]byte{secrets.AgentPublicKeySecretKey: ssh.MarshalAuthorizedKey(agentKey)}, nil)).Build()
+	signer, err := Create(kubeTypes.NamespacedName{Namespace: "wmco", Name: "key"}, c)
+	require.NoError(t, err)
+	signature, err := signer.Sign(rand.Reader, []byte("data"))
+	require.NoError(t, err)
+	assert.NoError(t, agentKey.Verify([]byte("data"), signature))
+
+	// RSA keys held by the agent are used with the SHA-2 signature algorithms
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: rsaKey}))
+	rsaPublicKey, err := ssh.NewPublicKey(rsaKey.Public())
+	require.NoError(t, err)
+	c = fake.NewClientBuilder().WithObjects(newKeySecret(
+		map[string][]byte{secrets.AgentPublicKeySecretKey: ssh.MarshalAuthorizedKey(rsaPublicKey)}, nil)).Build()
+	signer, err = Create(kubeTypes.NamespacedName{Namespace: "wmco", Name: "key"}, c)
+	require.NoError(t, err)
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	require.True(t, ok)
+	signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, []byte("data"), ssh.KeyAlgoRSASHA512)
+	require.NoError(t, err)
+	assert.Equal(t, ssh.KeyAlgoRSASHA512, signature.Format)
+	assert.NoError(t, rsaPublicKey.Verify([]byte("data"), signature))
+
+	// A key the agent does not hold cannot be used
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublicKey, err := ssh.NewPublicKey(otherKey.Public())
+	require.NoError(t, err)
+	c = fake.NewClientBuilder().WithObjects(newKeySecret(
+		map[string][]byte{secrets.AgentPublicKeySecretKey: ssh.MarshalAuthorizedKey(otherPublicKey)}, nil)).Build()
+	_, err = Create(kubeTypes.NamespacedName{Namespace: "wmco", Name: "key"}, c)
+	assert.Error(t, err)
+}
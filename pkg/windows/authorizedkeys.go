@@ -0,0 +1,47 @@
+package windows
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthorizedKeysPath is the file holding the public keys which can be used to access the instance as an administrator
+const AuthorizedKeysPath = "C:\\ProgramData\\ssh\\administrators_authorized_keys"
+
+func (vm *windows) AuthorizeKey(key ssh.PublicKey) error {
+	if out, err := vm.Run(authorizeKeyCmd(authorizedKeyEntry(key)), true); err != nil {
+		return fmt.Errorf("unable to authorize key %s, out: %s: %w", ssh.FingerprintSHA256(key), out, err)
+	}
+	return nil
+}
+
+func (vm *windows) RevokeKey(key ssh.PublicKey) error {
+	if out, err := vm.Run(revokeKeyCmd(authorizedKeyEntry(key)), true); err != nil {
+		return fmt.Errorf("unable to revoke key %s, out: %s: %w", ssh.FingerprintSHA256(key), out, err)
+	}
+	return nil
+}
+
+// authorizedKeyEntry returns the given key in authorized_keys format, without a comment or options. Lines of the
+// authorized keys file which authorize the key contain the entry.
+func authorizedKeyEntry(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// authorizeKeyCmd returns the PowerShell command adding the given entry to the authorized keys file, if no line of the
+// file contains it. The file is rewritten rather than appended to, as its last line may not be terminated.
+func authorizeKeyCmd(entry string) string {
+	return fmt.Sprintf("$f = '%s'; $keys = @(if(Test-Path $f) {Get-Content -Path $f}); "+
+		"if(-not ($keys | Where-Object {$_.Contains('%s')})) {Set-Content -Path $f -Value ($keys + '%s') "+
+		"-Encoding ascii}", AuthorizedKeysPath, entry, entry)
+}
+
+// revokeKeyCmd returns the PowerShell command removing the lines containing the given entry from the authorized keys
+// file
+func revokeKeyCmd(entry string) string {
+	return fmt.Sprintf("$f = '%s'; if(Test-Path $f) {$keys = @(Get-Content -Path $f); "+
+		"Set-Content -Path $f -Value @($keys | Where-Object {-not $_.Contains('%s')}) -Encoding ascii}",
+		AuthorizedKeysPath, entry)
+}
@@ -0,0 +1,38 @@
+package windows
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestPublicKey returns a new ed25519 public key
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(public)
+	require.NoError(t, err)
+	return key
+}
+
+func TestAuthorizeAndRevokeKey(t *testing.T) {
+	vm, host := newTestWindows(t, true)
+	previousKey, newKey := newTestPublicKey(t), newTestPublicKey(t)
+	// The last line of a user provided file may not be terminated, and keys may have comments
+	require.NoError(t, host.WriteFile(AuthorizedKeysPath, []byte(authorizedKeyEntry(previousKey)+" user@host")))
+
+	require.NoError(t, vm.AuthorizeKey(newKey))
+	require.NoError(t, vm.AuthorizeKey(newKey))
+	contents, exists := host.ReadFile(AuthorizedKeysPath)
+	require.True(t, exists)
+	assert.Equal(t, authorizedKeyEntry(previousKey)+" user@host\r\n"+authorizedKeyEntry(newKey)+"\r\n",
+		string(contents))
+
+	require.NoError(t, vm.RevokeKey(previousKey))
+	contents, _ = host.ReadFile(AuthorizedKeysPath)
+	assert.Equal(t, authorizedKeyEntry(newKey)+"\r\n", string(contents))
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"strings"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"golang.org/x/crypto/ssh"
 	"k8s.io/apimachinery/pkg/util/wait"
 
+	"github.com/openshift/windows-machine-config-operator/pkg/internal/remotehost"
 	"github.com/openshift/windows-machine-config-operator/pkg/retry"
 )
 
@@ -25,7 +28,7 @@ type AuthErr struct {
 }
 
 func (e *AuthErr) Error() string {
-	return fmt.Sprintf("SSH authentication failed: %s", e.err)
+	return fmt.Sprintf("authentication failed: %s", e.err)
 }
 
 // newAuthErr returns a new AuthErr
@@ -33,17 +36,58 @@ func newAuthErr(err error) *AuthErr {
 	return &AuthErr{err: err.Error()}
 }
 
+// HostKeyMismatchErr occurs when the VM presents an SSH host key other than the one it is expected to present, which
+// indicates that the connection is being intercepted, or that the VM's host keys were regenerated
+type HostKeyMismatchErr struct {
+	// expected is the fingerprint of the expected host key
+	expected string
+	// presented is the fingerprint of the host key presented by the VM
+	presented string
+}
+
+func (e *HostKeyMismatchErr) Error() string {
+	return fmt.Sprintf("SSH host key mismatch: expected host key with fingerprint %s, VM presented %s", e.expected,
+		e.presented)
+}
+
+// connectivity is the transport used to remotely access a Windows VM
 type connectivity interface {
 	// init initialises the connectivity medium
 	init() error
 	// run executes the given command on the remote system
 	run(cmd string) (string, error)
-	// createSFTPClient initializes an SFTP client from the existing SSH client. Caller should close the connection.
-	createSFTPClient() (*sftp.Client, error)
 	// transfer reads from reader and creates a file in the remote VM directory, creating the remote directory if needed
-	transfer(*sftp.Client, io.Reader, string, string) error
+	transfer(io.Reader, string, string) error
 	// transferFiles transfers the given files to a given remote directory
-	transferFiles(*sftp.Client, map[string][]byte, string) error
+	transferFiles(map[string][]byte, string) error
+	// writeChunk writes the data read from reader to the remote file at the given offset, creating the file and its
+	// directory if needed. Any content of the file beyond the offset is discarded.
+	writeChunk(reader io.Reader, remotePath string, offset int64) error
+}
+
+// remoteHostConnectivity implements the connectivity interface for a host emulating a Windows VM
+type remoteHostConnectivity struct {
+	host remotehost.Host
+}
+
+func (c *remoteHostConnectivity) init() error {
+	return c.host.Init()
+}
+
+func (c *remoteHostConnectivity) run(cmd string) (string, error) {
+	return c.host.Run(cmd)
+}
+
+func (c *remoteHostConnectivity) transfer(reader io.Reader, filename, remoteDir string) error {
+	return c.host.Transfer(reader, filename, remoteDir)
+}
+
+func (c *remoteHostConnectivity) transferFiles(files map[string][]byte, remoteDir string) error {
+	return forEachFile(files, remoteDir, c.host.Transfer)
+}
+
+func (c *remoteHostConnectivity) writeChunk(reader io.Reader, remotePath string, offset int64) error {
+	return c.host.WriteChunk(reader, remotePath, offset)
 }
 
 // sshConnectivity encapsulates the information needed to connect to the Windows VM over ssh
@@ -54,47 +98,107 @@ type sshConnectivity struct {
 	ipAddress string
 	// signer is used for authenticating against the VM
 	signer ssh.Signer
-	// sshClient is the client used to access the Windows VM via ssh
+	// hostKey is the host key the VM must present. If nil, the key presented by the first connection is trusted and
+	// pinned for all subsequent connections.
+	hostKey ssh.PublicKey
+	// route is the route connections to the VM are made through. Connections are made directly if nil.
+	route *SSHRoute
+	// sshClient is the client used to access the Windows VM via ssh, which may be shared with other connections
 	sshClient *ssh.Client
+	// poolEntry is the entry of sshPool the client belongs to
+	poolEntry *sshPoolEntry
 	log       logr.Logger
 }
 
-// newSshConnectivity returns an instance of sshConnectivity
-func newSshConnectivity(username, ipAddress string, signer ssh.Signer, logger logr.Logger) (connectivity, error) {
+// newSshConnectivity returns an instance of sshConnectivity. hostKey is the host key the VM must present, in
+// authorized_keys format. If empty, the key presented by the first connection is trusted. Connections are made
+// through the given route, or directly if route is nil.
+func newSshConnectivity(username, ipAddress string, signer ssh.Signer, hostKey string, route *SSHRoute,
+	logger logr.Logger) (connectivity, error) {
 	c := &sshConnectivity{
 		username:  username,
 		ipAddress: ipAddress,
 		signer:    signer,
+		route:     route,
 		log:       logger,
 	}
+	if hostKey != "" {
+		var err error
+		if c.hostKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(hostKey)); err != nil {
+			return nil, fmt.Errorf("invalid SSH host key: %w", err)
+		}
+	}
 	if err := c.init(); err != nil {
 		return nil, fmt.Errorf("error instantiating SSH client: %w", err)
 	}
 	return c, nil
 }
 
-// init initialises the key based SSH client
+// init initialises the key based SSH client, sharing the pooled client to the VM if there is one. Reinitializing
+// replaces the client, as it is then believed to be broken, for example because the VM was rebooted.
 func (c *sshConnectivity) init() error {
 	if c.username == "" || c.ipAddress == "" || c.signer == nil {
 		return fmt.Errorf("incomplete sshConnectivity information: %v", c)
 	}
+	if c.sshClient != nil {
+		sshPool.invalidate(c.poolEntry, c.sshClient)
+		c.sshClient = nil
+	}
 
+	key := sshPoolKey{address: c.ipAddress, username: c.username,
+		keyFingerprint: ssh.FingerprintSHA256(c.signer.PublicKey()), route: c.route.String()}
+	entry, sshClient, hostKey, err := sshPool.get(key, c.hostKey, c.dial)
+	if err != nil {
+		var authErr *AuthErr
+		if errors.As(err, &authErr) {
+			// The keys accepted by the VM have changed, so clients authenticated with other keys are stale
+			sshPool.invalidateAddress(c.ipAddress)
+		}
+		return fmt.Errorf("unable to connect to Windows VM %s: %w", c.ipAddress, err)
+	}
+	c.poolEntry, c.sshClient, c.hostKey = entry, sshClient, hostKey
+	return nil
+}
+
+// dial creates a new SSH client to the VM, returning it along with the host key it verified
+func (c *sshConnectivity) dial() (*ssh.Client, ssh.PublicKey, error) {
 	config := &ssh.ClientConfig{
 		User: c.username,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(c.signer),
 		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: c.verifyHostKey,
+	}
+	if c.hostKey != nil {
+		// Only negotiate the algorithms of the expected key, as the VM would otherwise be free to present another key
+		config.HostKeyAlgorithms = hostKeyAlgorithms(c.hostKey)
 	}
 	var err error
 	var sshClient *ssh.Client
 	// Retry if we are unable to create a client as the VM could still be executing the steps in its user data
 	err = wait.PollImmediate(time.Minute, retry.Timeout, func() (bool, error) {
-		sshClient, err = ssh.Dial("tcp", c.ipAddress+":"+sshPort, config)
+		start := time.Now()
+		sshClient, err = c.dialClient(config)
 		if err == nil {
+			sshDialDuration.WithLabelValues(c.ipAddress).Observe(time.Since(start).Seconds())
 			return true, nil
 		}
-		c.log.V(1).Info("SSH dial", "IP Address", c.ipAddress, "error", err)
+		sshDialFailures.WithLabelValues(c.ipAddress, sshDialFailureReason(err)).Inc()
+		c.log.V(1).Info("SSH dial", "IP Address", c.ipAddress, "route", c.route.String(), "error", err)
+		var mismatchErr *HostKeyMismatchErr
+		if errors.As(err, &mismatchErr) {
+			// Retrying cannot resolve a mismatch, and must not result in a connection being made
+			return false, err
+		}
+		if isRouteAuthErr(err) {
+			// Retrying cannot resolve rejected proxy or jump host credentials, which say nothing about the keys
+			// accepted by the VM
+			return false, err
+		}
+		var jumpErr *jumpHostErr
+		if errors.As(err, &jumpErr) {
+			return false, nil
+		}
 		if strings.Contains(err.Error(), "unable to authenticate") {
 			// Authentication failure is a special case that must be handled differently
 			return false, newAuthErr(err)
@@ -102,17 +206,72 @@ func (c *sshConnectivity) init() error {
 		return false, nil
 	})
 	if err != nil {
-		return fmt.Errorf("unable to connect to Windows VM %s: %w", c.ipAddress, err)
+		return nil, nil, err
+	}
+	return sshClient, c.hostKey, nil
+}
+
+// dialClient creates an SSH client to the VM through the route, using the given configuration. Connections to jump
+// hosts are closed once the client is closed.
+func (c *sshConnectivity) dialClient(config *ssh.ClientConfig) (*ssh.Client, error) {
+	address := net.JoinHostPort(c.ipAddress, sshPort)
+	conn, closeRoute, err := c.route.dial(address)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		_ = conn.Close()
+		closeRoute()
+		return nil, err
+	}
+	sshClient := ssh.NewClient(sshConn, channels, requests)
+	go func() {
+		_ = sshClient.Wait()
+		closeRoute()
+	}()
+	return sshClient, nil
+}
+
+// verifyHostKey is the callback checking the host key presented by the VM during the SSH handshake. The key presented by
+// the first connection is trusted if no host key is expected.
+func (c *sshConnectivity) verifyHostKey(_ string, _ net.Addr, key ssh.PublicKey) error {
+	if c.hostKey == nil {
+		c.log.Info("trusting SSH host key presented on first connection", "fingerprint",
+			ssh.FingerprintSHA256(key))
+		c.hostKey = key
+		return nil
+	}
+	if !bytes.Equal(key.Marshal(), c.hostKey.Marshal()) {
+		return &HostKeyMismatchErr{expected: ssh.FingerprintSHA256(c.hostKey), presented: ssh.FingerprintSHA256(key)}
 	}
-	c.sshClient = sshClient
 	return nil
 }
 
+// authorizedHostKey returns the host key of the VM in authorized_keys format, or an empty string if no connection has
+// been made yet
+func (c *sshConnectivity) authorizedHostKey() string {
+	if c.hostKey == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(c.hostKey)))
+}
+
+// hostKeyAlgorithms returns the host key algorithms which can be negotiated for the given key. RSA keys can be used
+// with SHA-2 signatures in addition to the SHA-1 signatures of their key type.
+func hostKeyAlgorithms(key ssh.PublicKey) []string {
+	if key.Type() == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{key.Type()}
+}
+
 // run instantiates a new SSH session and runs the command on the VM and returns the combined stdout and stderr output
 func (c *sshConnectivity) run(cmd string) (string, error) {
 	if c.sshClient == nil {
 		return "", fmt.Errorf("run cannot be called with nil SSH client")
 	}
+	defer sshPool.use(c.poolEntry)()
 
 	session, err := c.sshClient.NewSession()
 	if err != nil {
@@ -130,6 +289,7 @@ func (c *sshConnectivity) run(cmd string) (string, error) {
 	return string(out), err
 }
 
+// createSFTPClient initializes an SFTP client from the existing SSH client. Caller should close the connection.
 func (c *sshConnectivity) createSFTPClient() (*sftp.Client, error) {
 	if c.sshClient == nil {
 		return nil, fmt.Errorf("cannot be called with nil SSH client")
@@ -142,7 +302,19 @@ func (c *sshConnectivity) createSFTPClient() (*sftp.Client, error) {
 	return sftpClient, nil
 }
 
-func (c *sshConnectivity) transfer(sftpClient *sftp.Client, reader io.Reader, filename, remoteDir string) error {
+func (c *sshConnectivity) transfer(reader io.Reader, filename, remoteDir string) error {
+	defer sshPool.use(c.poolEntry)()
+	sftpClient, err := c.createSFTPClient()
+	if err != nil {
+		return fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer c.closeSFTPClient(sftpClient)
+	return c.transferWithClient(sftpClient, reader, filename, remoteDir)
+}
+
+// transferWithClient reads from reader and creates a file in the remote VM directory using the given SFTP client
+func (c *sshConnectivity) transferWithClient(sftpClient *sftp.Client, reader io.Reader, filename,
+	remoteDir string) error {
 	if sftpClient == nil {
 		return fmt.Errorf("transfer cannot be called with nil SFTP client")
 	}
@@ -171,7 +343,63 @@ func (c *sshConnectivity) transfer(sftpClient *sftp.Client, reader io.Reader, fi
 	return nil
 }
 
-func (c *sshConnectivity) transferFiles(sftpClient *sftp.Client, files map[string][]byte, remoteDir string) error {
+func (c *sshConnectivity) transferFiles(files map[string][]byte, remoteDir string) error {
+	defer sshPool.use(c.poolEntry)()
+	// A single SFTP client is used for all files
+	sftpClient, err := c.createSFTPClient()
+	if err != nil {
+		return fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer c.closeSFTPClient(sftpClient)
+	return forEachFile(files, remoteDir, func(reader io.Reader, filename, writeDir string) error {
+		return c.transferWithClient(sftpClient, reader, filename, writeDir)
+	})
+}
+
+func (c *sshConnectivity) writeChunk(reader io.Reader, remotePath string, offset int64) error {
+	defer sshPool.use(c.poolEntry)()
+	sftpClient, err := c.createSFTPClient()
+	if err != nil {
+		return fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer c.closeSFTPClient(sftpClient)
+
+	remoteDir, _ := SplitPath(remotePath)
+	if err := sftpClient.MkdirAll(strings.TrimSuffix(remoteDir, "\\")); err != nil {
+		return fmt.Errorf("error creating remote directory %s: %w", remoteDir, err)
+	}
+	dstFile, err := sftpClient.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return fmt.Errorf("error opening %s file on Windows VM: %w", remotePath, err)
+	}
+	defer func() {
+		if err := dstFile.Close(); err != nil {
+			c.log.Error(err, "error closing remote file", "file", remotePath)
+		}
+	}()
+	if err := dstFile.Truncate(offset); err != nil {
+		return fmt.Errorf("error truncating %s to %d bytes: %w", remotePath, offset, err)
+	}
+	if _, err := dstFile.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking to offset %d of %s: %w", offset, remotePath, err)
+	}
+	if _, err := io.Copy(dstFile, reader); err != nil {
+		return fmt.Errorf("error writing to %s at offset %d: %w", remotePath, offset, err)
+	}
+	return nil
+}
+
+// closeSFTPClient closes the given SFTP client, logging any error
+func (c *sshConnectivity) closeSFTPClient(sftpClient *sftp.Client) {
+	if err := sftpClient.Close(); err != nil {
+		c.log.Error(err, "error closing SFTP connection")
+	}
+}
+
+// forEachFile calls transfer for each of the given files, with the file name and directory each file should be
+// written to within the given remote directory
+func forEachFile(files map[string][]byte, remoteDir string,
+	transfer func(reader io.Reader, filename, writeDir string) error) error {
 	for workingPath, content := range files {
 		reader := bytes.NewReader(content)
 
@@ -182,7 +410,7 @@ func (c *sshConnectivity) transferFiles(sftpClient *sftp.Client, files map[strin
 		writeDir := dstPath[:splitIndex]
 		filename := dstPath[splitIndex+1:]
 
-		err := c.transfer(sftpClient, reader, filename, writeDir)
+		err := transfer(reader, filename, writeDir)
 		if err != nil {
 			return fmt.Errorf("failed to transfer file %s to %s: %w", filename, writeDir, err)
 		}
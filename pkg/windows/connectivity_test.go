@@ -0,0 +1,95 @@
+package windows
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestHostKey returns a new ed25519 SSH public key
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return key
+}
+
+func TestVerifyHostKey(t *testing.T) {
+	hostKey := newTestHostKey(t)
+	otherKey := newTestHostKey(t)
+	authorizedHostKey := string(ssh.MarshalAuthorizedKey(hostKey))
+
+	testCases := []struct {
+		name          string
+		pinnedKey     string
+		presentedKeys []ssh.PublicKey
+		expectedErr   bool
+	}{
+		{
+			name:          "first connection trusts the presented key",
+			presentedKeys: []ssh.PublicKey{hostKey, hostKey},
+			expectedErr:   false,
+		},
+		{
+			name:          "key changed after first connection",
+			presentedKeys: []ssh.PublicKey{hostKey, otherKey},
+			expectedErr:   true,
+		},
+		{
+			name:          "pinned key presented",
+			pinnedKey:     authorizedHostKey,
+			presentedKeys: []ssh.PublicKey{hostKey},
+			expectedErr:   false,
+		},
+		{
+			name:          "pinned key mismatch",
+			pinnedKey:     authorizedHostKey,
+			presentedKeys: []ssh.PublicKey{otherKey},
+			expectedErr:   true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			c := &sshConnectivity{log: logr.Discard()}
+			if test.pinnedKey != "" {
+				var err error
+				c.hostKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(test.pinnedKey))
+				require.NoError(t, err)
+			}
+			var err error
+			for _, key := range test.presentedKeys {
+				if err = c.verifyHostKey("", nil, key); err != nil {
+					break
+				}
+			}
+			if test.expectedErr {
+				var mismatchErr *HostKeyMismatchErr
+				assert.True(t, errors.As(err, &mismatchErr))
+				assert.Contains(t, err.Error(), ssh.FingerprintSHA256(otherKey))
+				return
+			}
+			require.NoError(t, err)
+			// The key the VM presented is the one recorded, regardless of whether it was pinned beforehand
+			assert.Equal(t, authorizedHostKey, c.authorizedHostKey()+"\n")
+		})
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPublicKey, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, hostKeyAlgorithms(newTestHostKey(t)))
+	assert.Equal(t, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA},
+		hostKeyAlgorithms(rsaPublicKey))
+}
@@ -0,0 +1,868 @@
+package fake
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// fakeRoot is the root of the Host filesystem. All paths are normalized to lowercase, as Windows paths are
+	// case-insensitive.
+	fakeRoot = "c:"
+	// remotePowerShellCmdPrefix is the prefix added by formatRemotePowerShellCommand
+	remotePowerShellCmdPrefix = "powershell.exe -NonInteractive -ExecutionPolicy Bypass \""
+	// cmdPrefix is the prefix added by Run to cmd commands when the default shell is PowerShell
+	cmdPrefix = "cmd /c "
+	// scServiceNotFound is the output of sc.exe when operating on a service which does not exist
+	scServiceNotFound = "[SC] OpenService FAILED 1060:\r\n\r\n" +
+		"The specified service does not exist as an installed service.\r\n"
+	// containersFeatureName is the name of the Windows feature required to run containers
+	containersFeatureName = "Containers"
+	// wicdServiceName is the name of the Windows Instance Config Daemon service
+	wicdServiceName = "windows-instance-config-daemon"
+	// wicdExecutable is the name of the WICD executable
+	wicdExecutable = wicdServiceName + ".exe"
+)
+
+var (
+	// errConnectionLost is returned by a Host when the connection to it has been dropped
+	errConnectionLost = errors.New("connection lost")
+
+	// getHostnameFQDNRegex matches the command used to get the FQDN of the host
+	getHostnameFQDNRegex = regexp.MustCompile(`^\$output = Invoke-Expression 'ipconfig /all'; `)
+	fileHashRegex        = regexp.MustCompile(`^\$out = Get-FileHash (\S+) -Algorithm SHA256; \$out\.Hash$`)
+	rmDirRegex           = regexp.MustCompile(`^if\(Test-Path (\S+)\) \{Remove-Item -Recurse -Force (\S+)\}$`)
+	rmFilesExcludeRegex  = regexp.MustCompile(
+		`^if\(Test-Path (\S+)\) \{Get-ChildItem (\S+) -Recurse -Exclude (\S+) \| Remove-Item -Force -Recurse\}$`)
+	chunkHashesRegex = regexp.MustCompile(`^if\(Test-Path -LiteralPath '((?:[^']|'')+)'\) \{\$f = ` +
+		`\[IO\.File\]::OpenRead\('(?:[^']|'')+'\); .*New-Object byte\[\] (\d+);`)
+	moveItemRegex = regexp.MustCompile(
+		`^Move-Item -Force -LiteralPath '((?:[^']|'')+)' -Destination '((?:[^']|'')+)'$`)
+	copyItemRegex = regexp.MustCompile(
+		`^Copy-Item -Force -LiteralPath '((?:[^']|'')+)' -Destination '((?:[^']|'')+)'$`)
+	pruneDirRegex = regexp.MustCompile(`^if\(Test-Path -LiteralPath '((?:[^']|'')+)'\) \{Get-ChildItem ` +
+		`-LiteralPath '(?:[^']|'')+' -File \| Where-Object \{ @\(([^)]*)\) -notcontains \$_\.Name \} \| ` +
+		`Remove-Item -Force\}$`)
+	authorizeKeyRegex = regexp.MustCompile(`^\$f = '([^']+)'; \$keys = @\(if\(Test-Path \$f\) ` +
+		`\{Get-Content -Path \$f\}\); if\(-not \(\$keys \| Where-Object \{\$_\.Contains\('([^']+)'\)\}\)\) ` +
+		`\{Set-Content -Path \$f -Value \(\$keys \+ '[^']+'\) -Encoding ascii\}$`)
+	revokeKeyRegex = regexp.MustCompile(`^\$f = '([^']+)'; if\(Test-Path \$f\) \{\$keys = @\(Get-Content -Path \$f\); ` +
+		`Set-Content -Path \$f -Value @\(\$keys \| Where-Object \{-not \$_\.Contains\('([^']+)'\)\}\) ` +
+		`-Encoding ascii\}$`)
+	renameComputerRegex = regexp.MustCompile(`^Rename-Computer -NewName (\S+) -Force$`)
+	getFeatureRegex     = regexp.MustCompile(`^Get-WindowsOptionalFeature -FeatureName (\S+) -Online$`)
+	installFeatureRegex = regexp.MustCompile(`Install-WindowsFeature -Name (\S+)$`)
+	hnsNetworkRegex     = regexp.MustCompile(
+		`^Get-HnsNetwork \| where \{ \$_\.Name -eq '([^']+)'\}( \| Remove-HnsNetwork;)?$`)
+	mkdirRegex       = regexp.MustCompile(`^if not exist (\S+) mkdir (\S+) ?$`)
+	scRegex          = regexp.MustCompile(`^sc\.exe (\w+) (\S+)\s*(.*)$`)
+	scBinPathRegex   = regexp.MustCompile(`binPath=["']([^"']*)["']`)
+	scDependRegex    = regexp.MustCompile(`depend=(\S+)`)
+	quotedValueRegex = regexp.MustCompile(`^["'](.*)["']$`)
+	// repeatedSeparatorRegex matches consecutive path separators, which Windows treats as one
+	repeatedSeparatorRegex = regexp.MustCompile(`\\{2,}`)
+)
+
+// CommandHandler handles a command run on a Host, returning its output
+type CommandHandler func(cmd string) (string, error)
+
+// Service describes a Windows service on a Host
+type Service struct {
+	// Name is the name of the service
+	Name string
+	// BinaryPath is the command line run by the service, including its arguments
+	BinaryPath string
+	// Dependencies are the names of the services this service depends on
+	Dependencies []string
+	// Description is the description of the service
+	Description string
+	// RecoveryActions are the failure actions of the service, in the sc.exe format
+	RecoveryActions string
+	// Running is true if the service is running
+	Running bool
+}
+
+// commandHandler is a CommandHandler registered for commands starting with prefix
+type commandHandler struct {
+	prefix  string
+	handler CommandHandler
+}
+
+// exitError is returned when a command run on a Host exits with a non-zero status, mirroring ssh.ExitError
+type exitError struct {
+	status int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("Process exited with status %d", e.status)
+}
+
+// Host is an in-memory emulation of a Windows instance, implementing the remotehost.Host interface. It emulates
+// the subset of cmd and PowerShell commands run by the windows package against a virtual filesystem rooted at C:\, a
+// service table managed through sc.exe, HNS networks, Windows features, the hostname and reboots.
+type Host struct {
+	lock sync.Mutex
+	// defaultShellPowerShell indicates if commands are run through PowerShell rather than cmd
+	defaultShellPowerShell bool
+	// hostname is the current hostname, pendingHostname is the hostname to be applied on the next reboot
+	hostname        string
+	pendingHostname string
+	// dirs and files make up the filesystem, keyed by normalized path
+	dirs  map[string]struct{}
+	files map[string][]byte
+	// services are the installed Windows services, keyed by name
+	services map[string]*Service
+	// networks are the names of the existing HNS networks
+	networks map[string]struct{}
+	// features maps the Windows features to whether they are enabled, pendingFeatures are enabled on the next reboot
+	features        map[string]bool
+	pendingFeatures []string
+	// reachable is false if connections to the host are refused
+	reachable bool
+	// connected is true if the current connection to the host is usable
+	connected bool
+	// rebooting is true from the time a reboot is requested until a new connection is made
+	rebooting bool
+	// reboots is the number of completed reboots
+	reboots int
+	// transfers is the number of file transfers started
+	transfers int
+	// dropTransfers is the number of transfers which will lose the connection midway, once skipTransfers transfers
+	// have succeeded
+	skipTransfers int
+	dropTransfers int
+	// commands are all the commands run, as received
+	commands []string
+	// handlers override the emulation of commands
+	handlers []commandHandler
+}
+
+// NewHost returns a reachable Host with the given hostname, an empty C:\ drive, no services or HNS networks,
+// and the Containers feature disabled
+func NewHost(hostname string, defaultShellPowerShell bool) *Host {
+	return &Host{
+		defaultShellPowerShell: defaultShellPowerShell,
+		hostname:               hostname,
+		dirs:                   map[string]struct{}{fakeRoot: {}},
+		files:                  make(map[string][]byte),
+		services:               make(map[string]*Service),
+		networks:               make(map[string]struct{}),
+		features:               map[string]bool{containersFeatureName: false},
+		reachable:              true,
+	}
+}
+
+// HandleCommand registers a handler for commands starting with the given prefix, overriding the emulated behavior.
+// Commands are matched after removing the prefix used to run them in a non-default shell.
+func (h *Host) HandleCommand(prefix string, handler CommandHandler) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.handlers = append(h.handlers, commandHandler{prefix: prefix, handler: handler})
+}
+
+// DropConnectionDuringTransfers causes count file transfers, following the next skip transfers, to lose the
+// connection after writing half of the data. Transfers include both whole files and chunks of files. The connection
+// is usable again once it is reinitialized.
+func (h *Host) DropConnectionDuringTransfers(skip, count int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.skipTransfers = skip
+	h.dropTransfers = count
+}
+
+// Disconnect drops the current connection to the host
+func (h *Host) Disconnect() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.connected = false
+}
+
+// SetReachable sets whether new connections to the host succeed. An unreachable host is also disconnected.
+func (h *Host) SetReachable(reachable bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.reachable = reachable
+	if !reachable {
+		h.connected = false
+	}
+}
+
+// WriteFile creates or overwrites the file at the given path, creating its parent directories
+func (h *Host) WriteFile(path string, contents []byte) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	dir, _ := splitPath(path)
+	if err := h.mkdirAll(dir); err != nil {
+		return err
+	}
+	return h.writeFile(path, contents)
+}
+
+// ReadFile returns the contents of the file at the given path, and whether it exists
+func (h *Host) ReadFile(path string) ([]byte, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	contents, exists := h.files[normalizePath(path)]
+	return contents, exists
+}
+
+// Exists returns true if a file or directory exists at the given path
+func (h *Host) Exists(path string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.exists(normalizePath(path))
+}
+
+// Files returns the sorted, normalized paths of all files on the host
+func (h *Host) Files() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var paths []string
+	for path := range h.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// AddService installs the given service
+func (h *Host) AddService(svc Service) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.services[svc.Name] = &svc
+}
+
+// Service returns a copy of the service with the given name, and whether it exists
+func (h *Host) Service(name string) (Service, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	svc, exists := h.services[name]
+	if !exists {
+		return Service{}, false
+	}
+	return *svc, true
+}
+
+// Services returns the sorted names of all installed services
+func (h *Host) Services() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var names []string
+	for name := range h.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddHNSNetwork creates an HNS network with the given name
+func (h *Host) AddHNSNetwork(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.networks[name] = struct{}{}
+}
+
+// HNSNetworks returns the sorted names of all HNS networks
+func (h *Host) HNSNetworks() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var names []string
+	for name := range h.networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetFeatureEnabled sets whether the given Windows feature is enabled
+func (h *Host) SetFeatureEnabled(feature string, enabled bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.features[feature] = enabled
+}
+
+// FeatureEnabled returns true if the given Windows feature is enabled
+func (h *Host) FeatureEnabled(feature string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.features[feature]
+}
+
+// Hostname returns the current hostname of the host
+func (h *Host) Hostname() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hostname
+}
+
+// Reboots returns the number of completed reboots
+func (h *Host) Reboots() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.reboots
+}
+
+// Transfers returns the number of file transfers started
+func (h *Host) Transfers() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.transfers
+}
+
+// Commands returns all commands run on the host, as they were received
+func (h *Host) Commands() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]string{}, h.commands...)
+}
+
+// remotehost.Host interface methods
+
+// Init connects to the host, completing any reboot in progress
+func (h *Host) Init() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.reachable {
+		return fmt.Errorf("unable to connect to fake host %s: connection refused", h.hostname)
+	}
+	if h.rebooting {
+		h.completeReboot()
+	}
+	h.connected = true
+	return nil
+}
+
+// Run emulates the given command, returning its combined output
+func (h *Host) Run(cmd string) (string, error) {
+	h.lock.Lock()
+	if !h.connected {
+		h.lock.Unlock()
+		return "", errConnectionLost
+	}
+	h.commands = append(h.commands, cmd)
+
+	powerShell := h.defaultShellPowerShell
+	if powerShell && strings.HasPrefix(cmd, cmdPrefix) {
+		cmd = strings.TrimPrefix(cmd, cmdPrefix)
+		powerShell = false
+	} else if !powerShell && strings.HasPrefix(cmd, remotePowerShellCmdPrefix) && strings.HasSuffix(cmd, "\"") {
+		cmd = strings.TrimSuffix(strings.TrimPrefix(cmd, remotePowerShellCmdPrefix), "\"")
+		powerShell = true
+	}
+	for _, handler := range h.handlers {
+		if strings.HasPrefix(cmd, handler.prefix) {
+			// Handlers are called without the lock held so that they can interact with the host
+			h.lock.Unlock()
+			return handler.handler(cmd)
+		}
+	}
+	defer h.lock.Unlock()
+	if powerShell {
+		return h.runPowerShell(cmd)
+	}
+	return h.runCmd(cmd)
+}
+
+// Transfer writes the data read from reader to the given file within remoteDir, creating the directory if needed
+func (h *Host) Transfer(reader io.Reader, filename, remoteDir string) error {
+	return h.receive(reader, remoteDir+"\\"+filename, 0)
+}
+
+// WriteChunk writes the data read from reader to the given file at the given offset, discarding any content beyond it
+func (h *Host) WriteChunk(reader io.Reader, remotePath string, offset int64) error {
+	return h.receive(reader, remotePath, offset)
+}
+
+// receive writes the data read from reader to the given file at the given offset, truncating the file after the
+// written data and creating the file's directory if needed
+func (h *Host) receive(reader io.Reader, remotePath string, offset int64) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.connected {
+		return errConnectionLost
+	}
+	h.transfers++
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("error reading data for %s: %w", remotePath, err)
+	}
+	remoteDir, _ := splitPath(remotePath)
+	if err := h.mkdirAll(remoteDir); err != nil {
+		return fmt.Errorf("error creating remote directory %s: %w", remoteDir, err)
+	}
+	existing := h.files[normalizePath(remotePath)]
+	if int64(len(existing)) < offset {
+		return fmt.Errorf("cannot write to %s at offset %d beyond its size %d", remotePath, offset, len(existing))
+	}
+	dropConnection := false
+	if h.skipTransfers > 0 {
+		h.skipTransfers--
+	} else if h.dropTransfers > 0 {
+		h.dropTransfers--
+		// Leave truncated content behind, as an interrupted transfer would
+		data = data[:len(data)/2]
+		dropConnection = true
+	}
+	if err := h.writeFile(remotePath, append(existing[:offset:offset], data...)); err != nil {
+		return err
+	}
+	if dropConnection {
+		h.connected = false
+		return fmt.Errorf("error copying data to %s: %w", remotePath, errConnectionLost)
+	}
+	return nil
+}
+
+// Command emulation, called with the lock held
+
+// runPowerShell emulates the given PowerShell command
+func (h *Host) runPowerShell(cmd string) (string, error) {
+	switch {
+	case cmd == "Get-Help":
+		return "Get-Help displays information about Windows PowerShell commands and concepts.", nil
+	case getHostnameFQDNRegex.MatchString(cmd):
+		return h.hostname + "\r\n", nil
+	case cmd == "Restart-Computer -Force":
+		h.rebooting = true
+		h.connected = false
+		return "", nil
+	case strings.HasPrefix(cmd, "Test-Path "):
+		if h.exists(normalizePath(strings.TrimPrefix(cmd, "Test-Path "))) {
+			return "True\r\n", nil
+		}
+		return "False\r\n", nil
+	}
+	if match := fileHashRegex.FindStringSubmatch(cmd); match != nil {
+		contents, exists := h.files[normalizePath(match[1])]
+		if !exists {
+			return "Get-FileHash : Cannot find path '" + match[1] + "' because it does not exist.",
+				&exitError{status: 1}
+		}
+		return fmt.Sprintf("%X\r\n", sha256.Sum256(contents)), nil
+	}
+	if match := rmFilesExcludeRegex.FindStringSubmatch(cmd); match != nil {
+		h.removeAllExcept(normalizePath(match[2]), strings.Split(match[3], ","))
+		return "", nil
+	}
+	if match := rmDirRegex.FindStringSubmatch(cmd); match != nil {
+		h.removeAll(normalizePath(match[2]))
+		return "", nil
+	}
+	if match := chunkHashesRegex.FindStringSubmatch(cmd); match != nil {
+		contents, exists := h.files[normalizePath(unescapeQuotes(match[1]))]
+		if !exists {
+			return "", nil
+		}
+		chunkSize, err := strconv.Atoi(match[2])
+		if err != nil || chunkSize < 1 {
+			return "Cannot convert value to type System.Int32.", &exitError{status: 1}
+		}
+		out := ""
+		for offset := 0; offset < len(contents); offset += chunkSize {
+			out += fmt.Sprintf("%X\r\n", sha256.Sum256(contents[offset:min(offset+chunkSize, len(contents))]))
+		}
+		return out, nil
+	}
+	if match := moveItemRegex.FindStringSubmatch(cmd); match != nil {
+		source, destination := normalizePath(unescapeQuotes(match[1])), normalizePath(unescapeQuotes(match[2]))
+		contents, exists := h.files[source]
+		if !exists {
+			return "Move-Item : Cannot find path '" + match[1] + "' because it does not exist.",
+				&exitError{status: 1}
+		}
+		if err := h.writeFile(destination, contents); err != nil {
+			return "Move-Item : " + err.Error(), &exitError{status: 1}
+		}
+		delete(h.files, source)
+		return "", nil
+	}
+	if match := copyItemRegex.FindStringSubmatch(cmd); match != nil {
+		source, destination := normalizePath(unescapeQuotes(match[1])), normalizePath(unescapeQuotes(match[2]))
+		contents, exists := h.files[source]
+		if !exists {
+			return "Copy-Item : Cannot find path '" + match[1] + "' because it does not exist.",
+				&exitError{status: 1}
+		}
+		if err := h.writeFile(destination, contents); err != nil {
+			return "Copy-Item : " + err.Error(), &exitError{status: 1}
+		}
+		return "", nil
+	}
+	if match := pruneDirRegex.FindStringSubmatch(cmd); match != nil {
+		dir := normalizePath(unescapeQuotes(match[1]))
+		keep := make(map[string]struct{})
+		for _, name := range strings.Split(match[2], ",") {
+			keep[normalizePath(unescapeQuotes(unquote(name)))] = struct{}{}
+		}
+		for path := range h.files {
+			name, found := strings.CutPrefix(path, dir+"\\")
+			if !found || strings.Contains(name, "\\") {
+				continue
+			}
+			if _, kept := keep[name]; !kept {
+				delete(h.files, path)
+			}
+		}
+		return "", nil
+	}
+	if match := authorizeKeyRegex.FindStringSubmatch(cmd); match != nil {
+		path := normalizePath(match[1])
+		lines := h.fileLines(path)
+		for _, line := range lines {
+			if strings.Contains(line, match[2]) {
+				return "", nil
+			}
+		}
+		if err := h.writeFile(path, []byte(strings.Join(append(lines, match[2]), "\r\n")+"\r\n")); err != nil {
+			return "Set-Content : " + err.Error(), &exitError{status: 1}
+		}
+		return "", nil
+	}
+	if match := revokeKeyRegex.FindStringSubmatch(cmd); match != nil {
+		path := normalizePath(match[1])
+		if !h.exists(path) {
+			return "", nil
+		}
+		var kept []string
+		for _, line := range h.fileLines(path) {
+			if !strings.Contains(line, match[2]) {
+				kept = append(kept, line)
+			}
+		}
+		if err := h.writeFile(path, []byte(strings.Join(append(kept, ""), "\r\n"))); err != nil {
+			return "Set-Content : " + err.Error(), &exitError{status: 1}
+		}
+		return "", nil
+	}
+	if match := renameComputerRegex.FindStringSubmatch(cmd); match != nil {
+		h.pendingHostname = match[1]
+		return "WARNING: The changes will take effect after you restart the computer " + h.hostname + ".", nil
+	}
+	if match := getFeatureRegex.FindStringSubmatch(cmd); match != nil {
+		enabled, known := h.features[match[1]]
+		if !known {
+			return "Get-WindowsOptionalFeature : Feature name " + match[1] + " is unknown.", &exitError{status: 1}
+		}
+		state := "Disabled"
+		if enabled {
+			state = "Enabled"
+		}
+		return "FeatureName : " + match[1] + "\r\nState       : " + state + "\r\n", nil
+	}
+	if match := installFeatureRegex.FindStringSubmatch(cmd); match != nil {
+		if _, known := h.features[match[1]]; !known {
+			return "Install-WindowsFeature : ArgumentNotValid: The role, role service, or feature name is not " +
+				"valid: '" + match[1] + "'.", &exitError{status: 1}
+		}
+		h.pendingFeatures = append(h.pendingFeatures, match[1])
+		return "Success Restart Needed Exit Code      Feature Result\r\n" +
+			"True    Yes            SuccessRest... {Containers}\r\n", nil
+	}
+	if match := hnsNetworkRegex.FindStringSubmatch(cmd); match != nil {
+		if _, exists := h.networks[match[1]]; !exists {
+			return "", nil
+		}
+		if match[2] == "" {
+			return "Name : " + match[1] + "\r\nType : Overlay\r\n", nil
+		}
+		// Removing an HNS network resets the network adapter, dropping the connection
+		delete(h.networks, match[1])
+		h.connected = false
+		return "", errors.New("wait: remote command exited without exit status or exit signal")
+	}
+	return h.runExecutable(cmd, "The term '%s' is not recognized as the name of a cmdlet, function, script file, "+
+		"or operable program.")
+}
+
+// fileLines returns the lines of the given file, as read by Get-Content, or nothing if the file does not exist
+func (h *Host) fileLines(path string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(string(h.files[path]), "\r\n", "\n"), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// runCmd emulates the given cmd command
+func (h *Host) runCmd(cmd string) (string, error) {
+	if match := mkdirRegex.FindStringSubmatch(cmd); match != nil {
+		if err := h.mkdirAll(match[2]); err != nil {
+			return "The system cannot find the path specified.\r\n", &exitError{status: 1}
+		}
+		return "", nil
+	}
+	if match := scRegex.FindStringSubmatch(cmd); match != nil {
+		return h.runServiceControl(match[1], match[2], match[3])
+	}
+	return h.runExecutable(cmd, "'%s' is not recognized as an internal or external command,\r\n"+
+		"operable program or batch file.\r\n")
+}
+
+// runExecutable emulates running the executable given as the first field of the command. notFoundFormat is used to
+// format the output when the executable does not exist.
+func (h *Host) runExecutable(cmd, notFoundFormat string) (string, error) {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	executable := fields[0]
+	if _, exists := h.files[normalizePath(executable)]; !exists || !strings.HasSuffix(executable, ".exe") {
+		return fmt.Sprintf(notFoundFormat, executable), &exitError{status: 1}
+	}
+	// WICD cleanup removes all the services managed by WICD, which excludes WICD itself
+	_, executableName := splitPath(executable)
+	if strings.EqualFold(executableName, wicdExecutable) && len(fields) > 1 && fields[1] == "cleanup" {
+		for name := range h.services {
+			if name != wicdServiceName {
+				delete(h.services, name)
+			}
+		}
+	}
+	return "", nil
+}
+
+// runServiceControl emulates the given sc.exe command against the named service
+func (h *Host) runServiceControl(command, name, args string) (string, error) {
+	svc, exists := h.services[name]
+	if command == "create" {
+		if exists {
+			return "[SC] CreateService FAILED 1073:\r\n\r\nThe specified service already exists.\r\n",
+				&exitError{status: 1073}
+		}
+		binPath := scBinPathRegex.FindStringSubmatch(args)
+		if binPath == nil {
+			return "DESCRIPTION:\r\n        Creates a service entry in the registry and Service Database.\r\n",
+				&exitError{status: 1639}
+		}
+		svc = &Service{Name: name, BinaryPath: binPath[1]}
+		if depend := scDependRegex.FindStringSubmatch(args); depend != nil {
+			svc.Dependencies = strings.Split(depend[1], "/")
+		}
+		h.services[name] = svc
+		return "[SC] CreateService SUCCESS\r\n", nil
+	}
+	if !exists {
+		return scServiceNotFound, &exitError{status: 1060}
+	}
+	switch command {
+	case "qc":
+		return fmt.Sprintf("[SC] QueryServiceConfig SUCCESS\r\n\r\nSERVICE_NAME: %s\r\n"+
+			"        BINARY_PATH_NAME   : %s\r\n", name, svc.BinaryPath), nil
+	case "query":
+		state := "1  STOPPED"
+		if svc.Running {
+			state = "4  RUNNING"
+		}
+		return fmt.Sprintf("\r\nSERVICE_NAME: %s\r\n        TYPE               : 10  WIN32_OWN_PROCESS\r\n"+
+			"        STATE              : %s\r\n", name, state), nil
+	case "description":
+		svc.Description = unquote(strings.TrimSpace(args))
+		return "[SC] ChangeServiceConfig2 SUCCESS\r\n", nil
+	case "failure":
+		svc.RecoveryActions = strings.TrimSpace(args)
+		return "[SC] ChangeServiceConfig2 SUCCESS\r\n", nil
+	case "start":
+		return h.startService(svc)
+	case "stop":
+		if !svc.Running {
+			return "[SC] ControlService FAILED 1062:\r\n\r\nThe service has not been started.\r\n",
+				&exitError{status: 1062}
+		}
+		for _, other := range h.services {
+			if other.Running && contains(other.Dependencies, name) {
+				return "[SC] ControlService FAILED 1051:\r\n\r\nA stop control has been sent to a service that " +
+					"other running services are dependent on.\r\n", &exitError{status: 1051}
+			}
+		}
+		svc.Running = false
+		return fmt.Sprintf("\r\nSERVICE_NAME: %s\r\n        STATE              : 3  STOP_PENDING\r\n", name), nil
+	case "delete":
+		delete(h.services, name)
+		return "[SC] DeleteService SUCCESS\r\n", nil
+	}
+	return "ERROR:  Unrecognized command\r\n", &exitError{status: 1}
+}
+
+// startService starts the given service and the services it depends on
+func (h *Host) startService(svc *Service) (string, error) {
+	if svc.Running {
+		return "[SC] StartService FAILED 1056:\r\n\r\nAn instance of the service is already running.\r\n",
+			&exitError{status: 1056}
+	}
+	for _, dependency := range svc.Dependencies {
+		dependencySvc, exists := h.services[dependency]
+		if !exists {
+			return "[SC] StartService FAILED 1075:\r\n\r\nThe dependency service does not exist or has been " +
+				"marked for deletion.\r\n", &exitError{status: 1075}
+		}
+		if dependencySvc.Running {
+			continue
+		}
+		if out, err := h.startService(dependencySvc); err != nil {
+			return out, err
+		}
+	}
+	if !h.binaryExists(svc) {
+		return "[SC] StartService FAILED 2:\r\n\r\nThe system cannot find the file specified.\r\n",
+			&exitError{status: 2}
+	}
+	svc.Running = true
+	return fmt.Sprintf("\r\nSERVICE_NAME: %s\r\n        STATE              : 2  START_PENDING\r\n", svc.Name), nil
+}
+
+// binaryExists returns true if the executable run by the given service exists
+func (h *Host) binaryExists(svc *Service) bool {
+	fields := strings.Fields(svc.BinaryPath)
+	if len(fields) == 0 {
+		return false
+	}
+	_, exists := h.files[normalizePath(fields[0])]
+	return exists
+}
+
+// completeReboot applies the changes which take effect on reboot, and starts all services which can be started
+func (h *Host) completeReboot() {
+	h.rebooting = false
+	h.reboots++
+	if h.pendingHostname != "" {
+		h.hostname = h.pendingHostname
+		h.pendingHostname = ""
+	}
+	for _, feature := range h.pendingFeatures {
+		h.features[feature] = true
+	}
+	h.pendingFeatures = nil
+	for _, svc := range h.services {
+		svc.Running = false
+	}
+	for _, svc := range h.services {
+		if !svc.Running {
+			// Services which fail to start are left stopped
+			h.startService(svc)
+		}
+	}
+}
+
+// Filesystem helpers, called with the lock held
+
+// exists returns true if a file or directory exists at the given normalized path
+func (h *Host) exists(path string) bool {
+	if _, exists := h.files[path]; exists {
+		return true
+	}
+	_, exists := h.dirs[path]
+	return exists
+}
+
+// mkdirAll creates the given directory and any missing parents
+func (h *Host) mkdirAll(dir string) error {
+	path := normalizePath(dir)
+	if path != fakeRoot && !strings.HasPrefix(path, fakeRoot+"\\") {
+		return fmt.Errorf("invalid path %s", dir)
+	}
+	current := fakeRoot
+	for _, element := range strings.Split(path, "\\")[1:] {
+		current = current + "\\" + element
+		if _, isFile := h.files[current]; isFile {
+			return fmt.Errorf("cannot create directory %s: %s is a file", dir, current)
+		}
+		h.dirs[current] = struct{}{}
+	}
+	return nil
+}
+
+// writeFile writes the file at the given path, its parent directory must exist
+func (h *Host) writeFile(path string, contents []byte) error {
+	normalized := normalizePath(path)
+	if _, isDir := h.dirs[normalized]; isDir {
+		return fmt.Errorf("cannot write file %s: path is a directory", path)
+	}
+	parent, _ := splitPath(normalized)
+	if _, exists := h.dirs[strings.TrimSuffix(parent, "\\")]; !exists {
+		return fmt.Errorf("cannot write file %s: parent directory does not exist", path)
+	}
+	h.files[normalized] = append([]byte{}, contents...)
+	return nil
+}
+
+// removeAll removes the given normalized path and everything within it
+func (h *Host) removeAll(path string) {
+	h.removeAllExcept(path, nil)
+	delete(h.dirs, path)
+	delete(h.files, path)
+}
+
+// removeAllExcept removes everything within the given normalized directory, aside from the given files
+func (h *Host) removeAllExcept(dir string, excluded []string) {
+	keep := make(map[string]struct{})
+	for _, path := range excluded {
+		keep[normalizePath(path)] = struct{}{}
+	}
+	for path := range h.files {
+		if _, excluded := keep[path]; !excluded && strings.HasPrefix(path, dir+"\\") {
+			delete(h.files, path)
+		}
+	}
+	for path := range h.dirs {
+		if !strings.HasPrefix(path, dir+"\\") {
+			continue
+		}
+		// Directories containing an excluded file cannot be removed
+		inUse := false
+		for keptPath := range keep {
+			if strings.HasPrefix(keptPath, path+"\\") {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			delete(h.dirs, path)
+		}
+	}
+}
+
+// splitPath splits the given Windows path into its directory, including the trailing separator, and file name
+func splitPath(path string) (dir string, fileName string) {
+	splitIndex := strings.LastIndexByte(path, '\\') + 1
+	return path[:splitIndex], path[splitIndex:]
+}
+
+// normalizePath returns the given Windows path in lowercase, with forward slashes, repeated separators and any
+// trailing separator removed
+func normalizePath(path string) string {
+	path = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(path), "/", "\\"))
+	path = repeatedSeparatorRegex.ReplaceAllString(path, "\\")
+	return strings.TrimRight(path, "\\")
+}
+
+// unquote removes the double or single quotes surrounding the given value, if any
+func unquote(value string) string {
+	if match := quotedValueRegex.FindStringSubmatch(value); match != nil {
+		return match[1]
+	}
+	return value
+}
+
+// unescapeQuotes returns the contents of a single-quoted PowerShell string, with its escaped quotes unescaped
+func unescapeQuotes(value string) string {
+	return strings.ReplaceAll(value, "''", "'")
+}
+
+// contains returns true if the given slice contains the given value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
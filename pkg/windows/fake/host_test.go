@@ -0,0 +1,220 @@
+package fake_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+	"github.com/openshift/windows-machine-config-operator/pkg/internal/remotehost"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows/fake"
+)
+
+// newTestWindows returns a Windows object interacting with the given Host
+func newTestWindows(t *testing.T, host *fake.Host) windows.Windows {
+	win, err := remotehost.NewWindows("172.30.0.10", &instance.Info{Address: "10.0.0.1", IPv4Address: "10.0.0.1"},
+		host, "")
+	require.NoError(t, err)
+	return win.(windows.Windows)
+}
+
+func TestHostDefaultShell(t *testing.T) {
+	for _, powerShell := range []bool{true, false} {
+		host := fake.NewHost("test-host", powerShell)
+		vm := newTestWindows(t, host)
+
+		// Both cmd and PowerShell commands must run regardless of the default shell
+		_, err := vm.Run("if not exist "+windows.K8sDir+"\\dir mkdir "+windows.K8sDir+"\\dir", false)
+		require.NoError(t, err)
+		out, err := vm.Run("Test-Path "+windows.K8sDir+"\\dir", true)
+		require.NoError(t, err)
+		assert.Equal(t, "True", out[:4])
+		assert.True(t, host.Exists("C:\\k"))
+	}
+}
+
+func TestHostEnsureFileContent(t *testing.T) {
+	host := fake.NewHost("test-host", false)
+	vm := newTestWindows(t, host)
+
+	require.NoError(t, vm.EnsureFileContent([]byte("contents"), "file.txt", windows.K8sDir))
+	contents, exists := host.ReadFile(windows.K8sDir + "\\file.txt")
+	require.True(t, exists)
+	assert.Equal(t, "contents", string(contents))
+	assert.Equal(t, 1, host.Transfers())
+
+	// The file is not transferred again if it has the expected contents
+	require.NoError(t, vm.EnsureFileContent([]byte("contents"), "file.txt", windows.K8sDir))
+	assert.Equal(t, 1, host.Transfers())
+
+	require.NoError(t, vm.EnsureFileContent([]byte("new contents"), "file.txt", windows.K8sDir))
+	contents, _ = host.ReadFile(windows.K8sDir + "\\file.txt")
+	assert.Equal(t, "new contents", string(contents))
+	assert.Equal(t, 2, host.Transfers())
+}
+
+func TestHostEnsureFile(t *testing.T) {
+	host := fake.NewHost("test-host", true)
+	vm := newTestWindows(t, host)
+	localPath := filepath.Join(t.TempDir(), "kubelet.exe")
+	require.NoError(t, os.WriteFile(localPath, []byte("binary"), 0644))
+	fileInfo, err := payload.NewFileInfo(localPath)
+	require.NoError(t, err)
+
+	require.NoError(t, vm.EnsureFile(fileInfo, windows.K8sDir))
+	contents, exists := host.ReadFile(windows.KubeletPath)
+	require.True(t, exists)
+	assert.Equal(t, "binary", string(contents))
+
+	require.NoError(t, vm.EnsureFile(fileInfo, windows.K8sDir))
+	assert.Equal(t, 1, host.Transfers())
+}
+
+func TestHostTransferConnectionDropped(t *testing.T) {
+	host := fake.NewHost("test-host", false)
+	vm := newTestWindows(t, host)
+	host.DropConnectionDuringTransfers(0, 1)
+
+	require.Error(t, vm.EnsureFileContent([]byte("contents"), "file.txt", windows.K8sDir))
+	// The interrupted transfer leaves a truncated file, and the connection must be reinitialized
+	contents, exists := host.ReadFile(windows.K8sDir + "\\file.txt")
+	require.True(t, exists)
+	assert.Equal(t, "cont", string(contents))
+	_, err := vm.Run("Get-Help", true)
+	require.Error(t, err)
+
+	vm = newTestWindows(t, host)
+	require.NoError(t, vm.EnsureFileContent([]byte("contents"), "file.txt", windows.K8sDir))
+	contents, _ = host.ReadFile(windows.K8sDir + "\\file.txt")
+	assert.Equal(t, "contents", string(contents))
+}
+
+func TestHostReplaceDir(t *testing.T) {
+	host := fake.NewHost("test-host", true)
+	vm := newTestWindows(t, host)
+	require.NoError(t, host.WriteFile(windows.ContainerdConfigDir+"\\old.io\\hosts.toml", []byte("old")))
+
+	files := map[string][]byte{
+		"new.io\\hosts.toml":   []byte("new"),
+		"other.io\\hosts.toml": []byte("other"),
+	}
+	require.NoError(t, vm.ReplaceDir(files, windows.ContainerdConfigDir))
+
+	assert.False(t, host.Exists(windows.ContainerdConfigDir+"\\old.io"))
+	for path, expected := range files {
+		contents, exists := host.ReadFile(windows.ContainerdConfigDir + "\\" + path)
+		require.True(t, exists)
+		assert.Equal(t, expected, contents)
+	}
+}
+
+func TestHostServices(t *testing.T) {
+	host := fake.NewHost("test-host", false)
+	vm := newTestWindows(t, host)
+	require.NoError(t, host.WriteFile(windows.KubeletPath, []byte("kubelet")))
+	require.NoError(t, host.WriteFile(windows.ContainerdPath, []byte("containerd")))
+
+	for _, cmd := range []string{
+		"sc.exe create " + windows.ContainerdServiceName + " binPath=\"" + windows.ContainerdPath +
+			" --run-service\" start=auto",
+		"sc.exe description " + windows.ContainerdServiceName + " \"containerd service\"",
+		"sc.exe failure " + windows.ContainerdServiceName + " reset= 300 actions= restart/10",
+		"sc.exe create " + windows.KubeletServiceName + " binPath=\"" + windows.KubeletPath +
+			" --windows-service\" start=auto depend=" + windows.ContainerdServiceName,
+		// Starting a service starts the services it depends on
+		"sc.exe start " + windows.KubeletServiceName,
+	} {
+		_, err := vm.Run(cmd, false)
+		require.NoError(t, err, cmd)
+	}
+	svc, exists := host.Service(windows.ContainerdServiceName)
+	require.True(t, exists)
+	assert.Equal(t, fake.Service{Name: windows.ContainerdServiceName,
+		BinaryPath: windows.ContainerdPath + " --run-service", Description: "containerd service",
+		RecoveryActions: "reset= 300 actions= restart/10", Running: true}, svc)
+
+	// A service cannot be stopped while a service depending on it is running
+	_, err := vm.Run("sc.exe stop "+windows.ContainerdServiceName, false)
+	require.Error(t, err)
+	for _, cmd := range []string{
+		"sc.exe stop " + windows.KubeletServiceName,
+		"sc.exe stop " + windows.ContainerdServiceName,
+		"sc.exe delete " + windows.KubeletServiceName,
+		"sc.exe delete " + windows.ContainerdServiceName,
+	} {
+		_, err := vm.Run(cmd, false)
+		require.NoError(t, err, cmd)
+	}
+	assert.Empty(t, host.Services())
+	_, err = vm.Run("sc.exe query "+windows.KubeletServiceName, false)
+	require.Error(t, err)
+}
+
+func TestHostReboot(t *testing.T) {
+	testCases := []struct {
+		name             string
+		cmds             []string
+		expectedHostname string
+		expectedFeature  bool
+	}{
+		{
+			name:             "no pending changes",
+			expectedHostname: "test-host",
+			expectedFeature:  false,
+		},
+		{
+			name: "hostname change and feature installation",
+			cmds: []string{
+				"Rename-Computer -NewName new-host -Force",
+				"Install-WindowsFeature -Name Containers",
+			},
+			expectedHostname: "new-host",
+			expectedFeature:  true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			host := fake.NewHost("test-host", false)
+			vm := newTestWindows(t, host)
+			for _, cmd := range test.cmds {
+				_, err := vm.Run(cmd, true)
+				require.NoError(t, err, cmd)
+			}
+			// Changes only take effect once the host is rebooted
+			assert.Equal(t, "test-host", host.Hostname())
+			assert.False(t, host.FeatureEnabled("Containers"))
+
+			require.NoError(t, vm.RebootAndReinitialize())
+			assert.Equal(t, test.expectedHostname, host.Hostname())
+			assert.Equal(t, test.expectedFeature, host.FeatureEnabled("Containers"))
+			assert.Equal(t, 1, host.Reboots())
+		})
+	}
+}
+
+func TestHostRemoveFilesAndNetworks(t *testing.T) {
+	host := fake.NewHost("test-host", true)
+	vm := newTestWindows(t, host)
+	for _, dir := range windows.RequiredDirectories {
+		_, err := vm.Run("if not exist "+dir+" mkdir "+dir, false)
+		require.NoError(t, err)
+	}
+	require.NoError(t, host.WriteFile(windows.K8sDir+"\\windows-instance-config-daemon.exe", []byte("wicd")))
+	require.NoError(t, host.WriteFile(windows.KubeletPath, []byte("kubelet")))
+	require.NoError(t, host.WriteFile(windows.KubeletLog, []byte("log")))
+	host.AddHNSNetwork(windows.BaseOVNKubeOverlayNetwork)
+	host.AddHNSNetwork(windows.OVNKubeOverlayNetwork)
+	host.AddHNSNetwork("nat")
+
+	require.NoError(t, vm.RemoveFilesAndNetworks())
+	assert.Equal(t, []string{"nat"}, host.HNSNetworks())
+	assert.Empty(t, host.Files())
+	for _, dir := range windows.RequiredDirectories {
+		assert.False(t, host.Exists(dir), dir)
+	}
+}
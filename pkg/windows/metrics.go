@@ -0,0 +1,60 @@
+package windows
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// transferredBytes counts the bytes of files transferred to each instance
+	transferredBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wmco_file_transfer_bytes_total",
+		Help: "Number of bytes of files transferred to Windows instances",
+	}, []string{"address"})
+	// resumedBytes counts the bytes of files which did not need to be transferred to each instance, as they were
+	// transferred by an earlier, interrupted transfer
+	resumedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wmco_file_transfer_resumed_bytes_total",
+		Help: "Number of bytes of files skipped when resuming interrupted transfers to Windows instances",
+	}, []string{"address"})
+	// transferFailures counts the failed attempts to transfer a file to each instance
+	transferFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wmco_file_transfer_failures_total",
+		Help: "Number of failed attempts to transfer a file to Windows instances",
+	}, []string{"address"})
+	// sshDialDuration observes the time taken to establish each successful SSH connection to each instance, including
+	// the connections made through the jump hosts and proxy of the route to the instance
+	sshDialDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wmco_ssh_dial_duration_seconds",
+		Help:    "Time taken to establish SSH connections to Windows instances",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"address"})
+	// sshDialFailures counts the failed attempts to establish an SSH connection to each instance, by reason
+	sshDialFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wmco_ssh_dial_failures_total",
+		Help: "Number of failed attempts to establish SSH connections to Windows instances",
+	}, []string{"address", "reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(transferredBytes, resumedBytes, transferFailures, sshDialDuration, sshDialFailures)
+}
+
+// sshDialFailureReason returns the reason label of the given SSH dial error
+func sshDialFailureReason(err error) string {
+	var mismatchErr *HostKeyMismatchErr
+	var jumpErr *jumpHostErr
+	switch {
+	case errors.As(err, &mismatchErr):
+		return "host_key_mismatch"
+	case errors.As(err, &jumpErr):
+		return "jump_host"
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		return "auth"
+	default:
+		return "connection"
+	}
+}
@@ -0,0 +1,44 @@
+package windows
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSHDialFailureReason(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "host key mismatch",
+			err:      fmt.Errorf("error dialing: %w", &HostKeyMismatchErr{expected: "a", presented: "b"}),
+			expected: "host_key_mismatch",
+		},
+		{
+			name:     "jump host",
+			err:      &jumpHostErr{address: "10.0.0.1:22", err: errors.New("connection refused")},
+			expected: "jump_host",
+		},
+		{
+			name: "authentication",
+			err: errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], " +
+				"no supported methods remain"),
+			expected: "auth",
+		},
+		{
+			name:     "connection",
+			err:      errors.New("dial tcp 10.0.0.2:22: i/o timeout"),
+			expected: "connection",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, sshDialFailureReason(test.err))
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package windows
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+)
+
+// PayloadCacheDir is the remote directory payload files are stored in, named by their SHA256. The directory is not
+// removed when the instance is deconfigured, so that files which are unchanged by an upgrade are not transferred again.
+// WICD restores modified payload files from it.
+const PayloadCacheDir = "C:\\ProgramData\\wmco\\payload-cache"
+
+// ensurePayloadFile ensures the given payload file exists within the remote directory. The file is copied from the
+// payload cache, and is only transferred to the cache if it is not already present there.
+func (vm *windows) ensurePayloadFile(file *payload.FileInfo, remoteDir string) error {
+	remotePath := remoteDir + "\\" + filepath.Base(file.Path)
+	fileExists, err := vm.FileExists(remotePath, file.SHA256)
+	if err != nil {
+		return fmt.Errorf("error checking if file '%s' exists on the Windows VM: %w", remotePath, err)
+	}
+	if fileExists {
+		return nil
+	}
+
+	if err := vm.ensureCached(file); err != nil {
+		return err
+	}
+	cachePath := PayloadCacheDir + "\\" + file.SHA256
+	if out, err := vm.Run(copyFileCmd(cachePath, remotePath), true); err != nil {
+		return fmt.Errorf("unable to copy %s from the payload cache, out: %s: %w", remotePath, out, err)
+	}
+	return nil
+}
+
+// ensureCached ensures the given payload file is present in the payload cache, transferring it if it is missing or its
+// cached copy has been modified
+func (vm *windows) ensureCached(file *payload.FileInfo) error {
+	cached, err := vm.FileExists(PayloadCacheDir+"\\"+file.SHA256, file.SHA256)
+	if err != nil {
+		return fmt.Errorf("error checking if file '%s' is in the payload cache: %w", file.Path, err)
+	}
+	if cached {
+		vm.log.V(1).Info("using cached payload file", "local file", file.Path)
+		return nil
+	}
+	return vm.transferLocalFile(file.Path, file.SHA256, PayloadCacheDir)
+}
+
+// CachePayload ensures every payload file is present in the payload cache with its expected contents, without changing
+// the files in use by the instance
+func (vm *windows) CachePayload() error {
+	vm.log.Info("ensuring payload cache is populated")
+	for file := range vm.filesToTransfer {
+		if err := vm.ensureCached(file); err != nil {
+			return fmt.Errorf("error caching %s: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// prunePayloadCache removes all files from the payload cache which are not part of the current payload
+func (vm *windows) prunePayloadCache() error {
+	var hashes []string
+	for file := range vm.filesToTransfer {
+		hashes = append(hashes, file.SHA256)
+	}
+	if out, err := vm.Run(pruneDirCmd(PayloadCacheDir, hashes), true); err != nil {
+		return fmt.Errorf("unable to prune payload cache, out: %s: %w", out, err)
+	}
+	return nil
+}
+
+// RemovePayloadCache removes the payload cache directory along with all the payload files it holds
+func (vm *windows) RemovePayloadCache() error {
+	vm.log.Info("removing payload cache")
+	if out, err := vm.Run(rmDirCmd(PayloadCacheDir), true); err != nil {
+		return fmt.Errorf("unable to remove directory %s, out: %s: %w", PayloadCacheDir, out, err)
+	}
+	return nil
+}
+
+// copyFileCmd returns the PowerShell command copying the source file to the destination, overwriting it if it exists
+func copyFileCmd(source, destination string) string {
+	return fmt.Sprintf("Copy-Item -Force -LiteralPath %s -Destination %s", quotePowerShellString(source),
+		quotePowerShellString(destination))
+}
+
+// pruneDirCmd returns the PowerShell command removing all files in the given directory, aside from those with the
+// given names
+func pruneDirCmd(dir string, keep []string) string {
+	quoted := make([]string, 0, len(keep))
+	for _, name := range keep {
+		quoted = append(quoted, quotePowerShellString(name))
+	}
+	return fmt.Sprintf("if(Test-Path -LiteralPath %[1]s) {Get-ChildItem -LiteralPath %[1]s -File | "+
+		"Where-Object { @(%[2]s) -notcontains $_.Name } | Remove-Item -Force}", quotePowerShellString(dir),
+		strings.Join(quoted, ","))
+}
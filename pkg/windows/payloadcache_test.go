@@ -0,0 +1,96 @@
+package windows
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+)
+
+// newTestPayloadFile writes the given contents to a local file and returns its payload.FileInfo
+func newTestPayloadFile(t *testing.T, dir, name, contents string) *payload.FileInfo {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+	fileInfo, err := payload.NewFileInfo(path)
+	require.NoError(t, err)
+	return fileInfo
+}
+
+func TestTransferFilesPayloadCache(t *testing.T) {
+	vm, host := newTestWindows(t, true)
+	require.NoError(t, vm.createDirectories())
+	kubelet := newTestPayloadFile(t, t.TempDir(), "kubelet.exe", "kubelet v1")
+	kubeProxy := newTestPayloadFile(t, t.TempDir(), "kube-proxy.exe", "kube-proxy v1")
+	vm.filesToTransfer = map[*payload.FileInfo]string{kubelet: K8sDir, kubeProxy: K8sDir}
+
+	require.NoError(t, vm.transferFiles())
+	assert.Equal(t, 2, host.Transfers())
+	assert.True(t, host.Exists(PayloadCacheDir+"\\"+kubelet.SHA256))
+	assert.True(t, host.Exists(PayloadCacheDir+"\\"+kubeProxy.SHA256))
+
+	// Deconfiguring the instance keeps the payload cache, so an upgrade only transfers the changed file
+	require.NoError(t, vm.RemoveFilesAndNetworks())
+	require.NoError(t, vm.createDirectories())
+	upgradedKubelet := newTestPayloadFile(t, t.TempDir(), "kubelet.exe", "kubelet v2")
+	vm.filesToTransfer = map[*payload.FileInfo]string{upgradedKubelet: K8sDir, kubeProxy: K8sDir}
+
+	require.NoError(t, vm.transferFiles())
+	assert.Equal(t, 3, host.Transfers())
+	for path, expected := range map[string]string{KubeletPath: "kubelet v2", K8sDir + "\\kube-proxy.exe": "kube-proxy v1"} {
+		contents, exists := host.ReadFile(path)
+		require.True(t, exists, path)
+		assert.Equal(t, expected, string(contents))
+	}
+	// The cached file which is no longer part of the payload is pruned
+	assert.False(t, host.Exists(PayloadCacheDir+"\\"+kubelet.SHA256))
+	assert.True(t, host.Exists(PayloadCacheDir+"\\"+upgradedKubelet.SHA256))
+	assert.True(t, host.Exists(PayloadCacheDir+"\\"+kubeProxy.SHA256))
+
+	require.NoError(t, vm.RemovePayloadCache())
+	assert.False(t, host.Exists(PayloadCacheDir))
+}
+
+func TestEnsurePayloadFileCorruptedCache(t *testing.T) {
+	vm, host := newTestWindows(t, false)
+	require.NoError(t, vm.createDirectories())
+	kubelet := newTestPayloadFile(t, t.TempDir(), "kubelet.exe", "kubelet")
+	require.NoError(t, host.WriteFile(PayloadCacheDir+"\\"+kubelet.SHA256, []byte("corrupted")))
+
+	// A cached file which does not match its hash is transferred again
+	require.NoError(t, vm.ensurePayloadFile(kubelet, K8sDir))
+	assert.Equal(t, 1, host.Transfers())
+	contents, exists := host.ReadFile(KubeletPath)
+	require.True(t, exists)
+	assert.Equal(t, "kubelet", string(contents))
+}
+
+func TestCachePayload(t *testing.T) {
+	vm, host := newTestWindows(t, false)
+	require.NoError(t, vm.createDirectories())
+	kubelet := newTestPayloadFile(t, t.TempDir(), "kubelet.exe", "kubelet")
+	kubeProxy := newTestPayloadFile(t, t.TempDir(), "kube-proxy.exe", "kube-proxy")
+	vm.filesToTransfer = map[*payload.FileInfo]string{kubelet: K8sDir, kubeProxy: K8sDir}
+	require.NoError(t, host.WriteFile(PayloadCacheDir+"\\"+kubelet.SHA256, []byte("kubelet")))
+	require.NoError(t, host.WriteFile(KubeletPath, []byte("modified")))
+
+	// Only the missing file is transferred, and the files in use are left as is
+	require.NoError(t, vm.CachePayload())
+	assert.Equal(t, 1, host.Transfers())
+	assert.True(t, host.Exists(PayloadCacheDir+"\\"+kubeProxy.SHA256))
+	assert.False(t, host.Exists(K8sDir+"\\kube-proxy.exe"))
+	contents, exists := host.ReadFile(KubeletPath)
+	require.True(t, exists)
+	assert.Equal(t, "modified", string(contents))
+}
+
+func TestPayloadCacheCommandsQuotePaths(t *testing.T) {
+	dir := PayloadCacheDir + "\\it's a dir"
+	quoted := "'" + PayloadCacheDir + "\\it''s a dir'"
+	assert.Equal(t, "Copy-Item -Force -LiteralPath "+quoted+" -Destination 'C:\\dest'", copyFileCmd(dir, "C:\\dest"))
+	assert.Equal(t, "if(Test-Path -LiteralPath "+quoted+") {Get-ChildItem -LiteralPath "+quoted+" -File | "+
+		"Where-Object { @('a','it''s') -notcontains $_.Name } | Remove-Item -Force}", pruneDirCmd(dir, []string{"a", "it's"}))
+}
@@ -0,0 +1,241 @@
+package windows
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/crypto/ssh"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// sshKeepAliveInterval is how often pooled SSH clients are checked to still be connected
+	sshKeepAliveInterval = 30 * time.Second
+	// sshIdleTimeout is how long a pooled SSH client can go unused before it is closed
+	sshIdleTimeout = 10 * time.Minute
+	// sshKeepAliveTimeout is how long to wait for the reply to a keepalive request before considering the connection
+	// broken
+	sshKeepAliveTimeout = 15 * time.Second
+	// keepAliveRequest is the global request sent to check an SSH connection is alive. The server is not expected to
+	// know it, any reply indicates the connection is alive.
+	keepAliveRequest = "keepalive@openssh.com"
+)
+
+// sshPool shares SSH clients to instances between all controllers, so that each reconcile does not require a new
+// handshake with the instance
+var sshPool = newSSHClientPool(sshKeepAliveInterval, sshIdleTimeout, ctrl.Log.WithName("sshpool"))
+
+// sshPoolKey identifies the clients which can be shared. A client is only shared by connections to the same address,
+// as the same user, authenticated with the same key, which trust the host key the client verified.
+type sshPoolKey struct {
+	address  string
+	username string
+	// keyFingerprint is the fingerprint of the public key used to authenticate
+	keyFingerprint string
+	// route describes the route connections are made through, and is empty for direct connections
+	route string
+	// hostKeyFingerprint is the fingerprint of the host key the client verified. It is set by the pool once the client
+	// has been dialed.
+	hostKeyFingerprint string
+}
+
+// sshPoolEntry holds the pooled client for a key
+type sshPoolEntry struct {
+	key sshPoolKey
+	// The fields below are guarded by the lock of the pool
+	// client is the pooled client, nil if there is no open client
+	client *ssh.Client
+	// hostKey is the host key the client verified
+	hostKey ssh.PublicKey
+	// lastUsed is when the client was last used
+	lastUsed time.Time
+	// active is the number of operations currently using the client
+	active int
+}
+
+// sshClientPool is a set of SSH clients which are kept alive, and closed once they have been idle for too long
+type sshClientPool struct {
+	mu      sync.Mutex
+	entries map[sshPoolKey]*sshPoolEntry
+	// keepAliveInterval is how often pooled clients are checked to still be connected
+	keepAliveInterval time.Duration
+	// idleTimeout is how long a pooled client can go unused before it is closed
+	idleTimeout time.Duration
+	log         logr.Logger
+}
+
+// newSSHClientPool returns a new, empty sshClientPool
+func newSSHClientPool(keepAliveInterval, idleTimeout time.Duration, log logr.Logger) *sshClientPool {
+	return &sshClientPool{
+		entries:           make(map[sshPoolKey]*sshPoolEntry),
+		keepAliveInterval: keepAliveInterval,
+		idleTimeout:       idleTimeout,
+		log:               log,
+	}
+}
+
+// get returns the pooled client for the given key, along with the host key it verified. If there is no live client
+// which verified the given host key, dial is called to create one. A nil host key matches any pooled client. Dialing
+// is done without holding any lock, as it can take as long as the instance takes to become reachable. A client dialed
+// while another caller pooled a client for the same key is closed in favor of the pooled client.
+func (p *sshClientPool) get(key sshPoolKey, hostKey ssh.PublicKey,
+	dial func() (*ssh.Client, ssh.PublicKey, error)) (*sshPoolEntry, *ssh.Client, ssh.PublicKey, error) {
+	p.mu.Lock()
+	entry := p.lookupLocked(key, hostKey)
+	var client *ssh.Client
+	var clientHostKey ssh.PublicKey
+	if entry != nil {
+		client, clientHostKey = entry.client, entry.hostKey
+	}
+	p.mu.Unlock()
+	if client != nil {
+		// Checking the client is alive is far cheaper than a new handshake, and avoids handing out a client whose
+		// instance went away since the last keepalive
+		if err := checkAlive(client, sshKeepAliveTimeout); err == nil {
+			p.markUsed(entry)
+			return entry, client, clientHostKey, nil
+		}
+		p.invalidate(entry, client)
+	}
+
+	client, clientHostKey, err := dial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key.hostKeyFingerprint = ssh.FingerprintSHA256(clientHostKey)
+	p.mu.Lock()
+	if pooled, present := p.entries[key]; present && pooled.client != nil {
+		pooled.lastUsed = time.Now()
+		pooledClient, pooledHostKey := pooled.client, pooled.hostKey
+		p.mu.Unlock()
+		if err := client.Close(); err != nil {
+			p.log.V(1).Info("error closing SSH client", "address", key.address, "error", err)
+		}
+		return pooled, pooledClient, pooledHostKey, nil
+	}
+	entry = &sshPoolEntry{key: key, client: client, hostKey: clientHostKey, lastUsed: time.Now()}
+	p.entries[key] = entry
+	p.mu.Unlock()
+	p.log.V(1).Info("pooled new SSH client", "address", key.address)
+	go p.keepAlive(entry, client)
+	return entry, client, clientHostKey, nil
+}
+
+// lookupLocked returns the entry holding a client for the given key which verified the given host key, or any host
+// key if the given host key is nil. Nil is returned if there is no such entry. The pool's lock must be held.
+func (p *sshClientPool) lookupLocked(key sshPoolKey, hostKey ssh.PublicKey) *sshPoolEntry {
+	if hostKey != nil {
+		key.hostKeyFingerprint = ssh.FingerprintSHA256(hostKey)
+		return p.entries[key]
+	}
+	for entryKey, entry := range p.entries {
+		entryKey.hostKeyFingerprint = ""
+		if entryKey == key {
+			return entry
+		}
+	}
+	return nil
+}
+
+// use marks the client of the given entry as being in use, returning the function which must be called once the client
+// is no longer in use. Clients are not closed for being idle while in use.
+func (p *sshClientPool) use(entry *sshPoolEntry) func() {
+	if entry == nil {
+		return func() {}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry.active++
+	entry.lastUsed = time.Now()
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		entry.active--
+		entry.lastUsed = time.Now()
+	}
+}
+
+// markUsed records that the client of the given entry was just used
+func (p *sshClientPool) markUsed(entry *sshPoolEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry.lastUsed = time.Now()
+}
+
+// invalidate closes the given client, if it is still the pooled client of the given entry. Clients which have already
+// been replaced are not affected, so that a connection believed to be broken by one caller does not close the new
+// client dialed by another.
+func (p *sshClientPool) invalidate(entry *sshPoolEntry, client *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry.client == client {
+		p.closeLocked(entry, "invalidated")
+	}
+}
+
+// invalidateAddress closes all pooled clients to the given address
+func (p *sshClientPool) invalidateAddress(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, entry := range p.entries {
+		if key.address == address && entry.client != nil {
+			p.closeLocked(entry, "invalidated")
+		}
+	}
+}
+
+// closeLocked closes the client of the given entry. The pool's lock must be held.
+func (p *sshClientPool) closeLocked(entry *sshPoolEntry, reason string) {
+	if err := entry.client.Close(); err != nil {
+		p.log.V(1).Info("error closing SSH client", "address", entry.key.address, "error", err)
+	}
+	p.log.V(1).Info("closed pooled SSH client", "address", entry.key.address, "reason", reason)
+	entry.client, entry.hostKey = nil, nil
+	if p.entries[entry.key] == entry {
+		delete(p.entries, entry.key)
+	}
+}
+
+// keepAlive periodically checks the given client of the given entry is alive, until the client is closed. The client
+// is closed if the check fails, or if it has been idle for longer than the idle timeout.
+func (p *sshClientPool) keepAlive(entry *sshPoolEntry, client *ssh.Client) {
+	ticker := time.NewTicker(p.keepAliveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mu.Lock()
+		if entry.client != client {
+			// The client was closed or replaced
+			p.mu.Unlock()
+			return
+		}
+		if entry.active == 0 && time.Since(entry.lastUsed) > p.idleTimeout {
+			p.closeLocked(entry, "idle")
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+		if err := checkAlive(client, sshKeepAliveTimeout); err != nil {
+			p.log.V(1).Info("SSH keepalive failed", "address", entry.key.address, "error", err)
+			p.invalidate(entry, client)
+			return
+		}
+	}
+}
+
+// checkAlive returns an error if the given client does not reply to a keepalive request within the given timeout
+func checkAlive(client *ssh.Client, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest(keepAliveRequest, true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		// The request is unblocked once the client is closed
+		return fmt.Errorf("no reply to keepalive request within %s", timeout)
+	}
+}
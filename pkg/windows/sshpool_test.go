@@ -0,0 +1,187 @@
+package windows
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is a local SSH server which replies to global requests
+type testSSHServer struct {
+	// hostSigner is the host key of the server
+	hostSigner ssh.Signer
+	hostKey    ssh.PublicKey
+	// dials is the number of clients which connected to the server
+	dials int
+	// conns are the server side of each connection
+	conns []net.Conn
+}
+
+// newTestSSHServer returns a testSSHServer with a new host key
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(private)
+	require.NoError(t, err)
+	return &testSSHServer{hostSigner: signer, hostKey: signer.PublicKey()}
+}
+
+// dial returns a new client connected to the server
+func (s *testSSHServer) dial(t *testing.T) (*ssh.Client, ssh.PublicKey, error) {
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(s.hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- serverConn
+		_, channels, requests, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(requests)
+		for channel := range channels {
+			_ = channel.Reject(ssh.Prohibited, "")
+		}
+	}()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	if serverConn, ok := <-accepted; ok {
+		s.conns = append(s.conns, serverConn)
+	}
+	conn, channels, requests, err := ssh.NewClientConn(clientConn, "test", &ssh.ClientConfig{User: "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		return nil, nil, err
+	}
+	s.dials++
+	return ssh.NewClient(conn, channels, requests), s.hostKey, nil
+}
+
+// disconnect closes all connections to the server
+func (s *testSSHServer) disconnect() {
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func TestSSHClientPoolGet(t *testing.T) {
+	pool := newSSHClientPool(time.Hour, time.Hour, logr.Discard())
+	server := newTestSSHServer(t)
+	dial := func() (*ssh.Client, ssh.PublicKey, error) { return server.dial(t) }
+	key := sshPoolKey{address: "10.0.0.1", username: "Administrator", keyFingerprint: "SHA256:test"}
+
+	entry, client, hostKey, err := pool.get(key, nil, dial)
+	require.NoError(t, err)
+	assert.Equal(t, server.hostKey, hostKey)
+	assert.Equal(t, 1, server.dials)
+
+	// The pooled client is shared by connections which trust the key it verified
+	_, sharedClient, _, err := pool.get(key, nil, dial)
+	require.NoError(t, err)
+	assert.Same(t, client, sharedClient)
+	_, sharedClient, _, err = pool.get(key, server.hostKey, dial)
+	require.NoError(t, err)
+	assert.Same(t, client, sharedClient)
+	assert.Equal(t, 1, server.dials)
+
+	// A connection expecting another host key must verify it through a new client, without affecting the client
+	// pooled for the key the instance presented
+	otherHostKey := newTestHostKey(t)
+	_, _, _, err = pool.get(key, otherHostKey, func() (*ssh.Client, ssh.PublicKey, error) {
+		return nil, nil, &HostKeyMismatchErr{expected: ssh.FingerprintSHA256(otherHostKey),
+			presented: ssh.FingerprintSHA256(server.hostKey)}
+	})
+	require.Error(t, err)
+	_, sharedClient, _, err = pool.get(key, nil, dial)
+	require.NoError(t, err)
+	assert.Same(t, client, sharedClient)
+	assert.Equal(t, 1, server.dials)
+
+	// Clients which verified different host keys are pooled separately
+	otherServer := newTestSSHServer(t)
+	_, otherClient, _, err := pool.get(key, otherServer.hostKey,
+		func() (*ssh.Client, ssh.PublicKey, error) { return otherServer.dial(t) })
+	require.NoError(t, err)
+	assert.NotSame(t, client, otherClient)
+	_, sharedClient, _, err = pool.get(key, server.hostKey, dial)
+	require.NoError(t, err)
+	assert.Same(t, client, sharedClient)
+	pool.invalidateAddress("10.0.0.1")
+
+	// Dialing does not hold up other callers. A client dialed while another caller pooled a client for the same key is
+	// closed in favor of the pooled client.
+	var pooledClient *ssh.Client
+	entry, client, _, err = pool.get(key, nil, func() (*ssh.Client, ssh.PublicKey, error) {
+		var err error
+		_, pooledClient, _, err = pool.get(key, nil, dial)
+		require.NoError(t, err)
+		return server.dial(t)
+	})
+	require.NoError(t, err)
+	assert.Same(t, pooledClient, client)
+	assert.Equal(t, 3, server.dials)
+
+	// Invalidating a client which was already replaced has no effect
+	pool.invalidate(entry, client)
+	_, client, _, err = pool.get(key, nil, dial)
+	require.NoError(t, err)
+	assert.Equal(t, 4, server.dials)
+	pool.invalidate(entry, pooledClient)
+	_, sharedClient, _, err = pool.get(key, nil, dial)
+	require.NoError(t, err)
+	assert.Same(t, client, sharedClient)
+	assert.Equal(t, 4, server.dials)
+
+	// A client whose connection was lost is replaced
+	server.disconnect()
+	_, _, _, err = pool.get(key, nil, dial)
+	require.NoError(t, err)
+	assert.Equal(t, 5, server.dials)
+
+	// Clients authenticated with other keys are closed along with the address
+	otherKey := sshPoolKey{address: "10.0.0.1", username: "Administrator", keyFingerprint: "SHA256:other"}
+	otherEntry, _, _, err := pool.get(otherKey, nil, dial)
+	require.NoError(t, err)
+	pool.invalidateAddress("10.0.0.1")
+	assert.Nil(t, otherEntry.client)
+	assert.Empty(t, pool.entries)
+}
+
+func TestSSHClientPoolIdleTimeout(t *testing.T) {
+	pool := newSSHClientPool(10*time.Millisecond, 50*time.Millisecond, logr.Discard())
+	server := newTestSSHServer(t)
+	key := sshPoolKey{address: "10.0.0.1", username: "Administrator", keyFingerprint: "SHA256:test"}
+	entry, client, _, err := pool.get(key, nil, func() (*ssh.Client, ssh.PublicKey, error) { return server.dial(t) })
+	require.NoError(t, err)
+
+	// A client is not closed while it is in use, however long the operation takes
+	release := pool.use(entry)
+	time.Sleep(200 * time.Millisecond)
+	pool.mu.Lock()
+	assert.Same(t, client, entry.client)
+	pool.mu.Unlock()
+
+	release()
+	assert.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return entry.client == nil && len(pool.entries) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	_, _, err = client.SendRequest(keepAliveRequest, true, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,325 @@
+package windows
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+)
+
+// routeDialTimeout is how long establishing a TCP connection to a proxy, a jump host or an instance can take
+const routeDialTimeout = 30 * time.Second
+
+// SSHRoute is the route SSH connections to an instance are made through, when the instance cannot be reached directly
+type SSHRoute struct {
+	// ProxyURL is the URL of the HTTP CONNECT or SOCKS5 proxy connections are made through. Connections are made
+	// without a proxy if empty.
+	ProxyURL string
+	// ProxyCredentials are used to authenticate against the proxy. The proxy is used without authentication if nil.
+	ProxyCredentials *url.Userinfo
+	// JumpHosts are the SSH hosts connections are tunneled through, in order
+	JumpHosts []JumpHost
+}
+
+// JumpHost is an SSH host connections to an instance are tunneled through
+type JumpHost struct {
+	// Address is the address of the jump host, optionally followed by a port. Port 22 is used if no port is given.
+	Address string
+	// Username is the name of the user used to access the jump host
+	Username string
+	// Signer is used for authenticating against the jump host
+	Signer ssh.Signer
+	// SSHHostKey is the host key the jump host must present, in authorized_keys format
+	SSHHostKey string
+}
+
+// NewSSHRoute returns the route SSH connections to the given instance should be made through. The instance's own route
+// is used if it specifies one, otherwise the given default route is used. Jump hosts with a private key secret are
+// accessed with the signer jumpHostSigner returns for the secret's name, and all others with the given instance
+// signer. The proxy is authenticated against with the credentials proxyCredentials returns for the name of the
+// route's proxy credentials secret, if any. Nil is returned if connections should be made directly.
+func NewSSHRoute(instanceInfo *instance.Info, defaultRoute instance.SSHRoute, instanceSigner ssh.Signer,
+	jumpHostSigner func(secretName string) (ssh.Signer, error),
+	proxyCredentials func(secretName string) (*url.Userinfo, error)) (*SSHRoute, error) {
+	route := defaultRoute
+	if instanceInfo != nil && !instanceInfo.SSHRoute.IsDirect() {
+		route = instanceInfo.SSHRoute
+	}
+	if route.IsDirect() {
+		return nil, nil
+	}
+	sshRoute := &SSHRoute{ProxyURL: route.ProxyURL}
+	if route.ProxyCredentialsSecret != "" {
+		var err error
+		if sshRoute.ProxyCredentials, err = proxyCredentials(route.ProxyCredentialsSecret); err != nil {
+			return nil, fmt.Errorf("unable to get proxy credentials: %w", err)
+		}
+	}
+	for _, jumpHost := range route.JumpHosts {
+		signer := instanceSigner
+		if jumpHost.PrivateKeySecret != "" {
+			var err error
+			if signer, err = jumpHostSigner(jumpHost.PrivateKeySecret); err != nil {
+				return nil, fmt.Errorf("unable to create signer for jump host %s: %w", jumpHost.Address, err)
+			}
+		}
+		sshRoute.JumpHosts = append(sshRoute.JumpHosts, JumpHost{Address: jumpHost.Address,
+			Username: jumpHost.Username, Signer: signer, SSHHostKey: jumpHost.SSHHostKey})
+	}
+	return sshRoute, nil
+}
+
+// proxyAuthErr occurs when the proxy connections are made through rejects the credentials used to authenticate
+type proxyAuthErr struct {
+	err error
+}
+
+func (e *proxyAuthErr) Error() string {
+	return fmt.Sprintf("proxy authentication failed: %v", e.err)
+}
+
+func (e *proxyAuthErr) Unwrap() error {
+	return e.err
+}
+
+// jumpHostErr occurs when a connection cannot be made to a jump host
+type jumpHostErr struct {
+	address string
+	err     error
+}
+
+func (e *jumpHostErr) Error() string {
+	return fmt.Sprintf("unable to connect through jump host %s: %v", e.address, e.err)
+}
+
+func (e *jumpHostErr) Unwrap() error {
+	return e.err
+}
+
+// isRouteAuthErr returns true if the given error occurred because a proxy or a jump host of the route rejected the
+// credentials used to authenticate against it, which retrying cannot resolve
+func isRouteAuthErr(err error) bool {
+	var proxyErr *proxyAuthErr
+	if errors.As(err, &proxyErr) {
+		return true
+	}
+	var jumpErr *jumpHostErr
+	return errors.As(err, &jumpErr) && strings.Contains(jumpErr.err.Error(), "unable to authenticate")
+}
+
+// dialer creates network connections
+type dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// String returns a description of the route which does not contain any credentials
+func (r *SSHRoute) String() string {
+	if r == nil {
+		return ""
+	}
+	var hops []string
+	if r.ProxyURL != "" {
+		if parsed, err := url.Parse(r.ProxyURL); err == nil {
+			hops = append(hops, parsed.Redacted())
+		}
+	}
+	for _, jumpHost := range r.JumpHosts {
+		hops = append(hops, jumpHost.Username+"@"+withDefaultPort(jumpHost.Address, sshPort))
+	}
+	return strings.Join(hops, ",")
+}
+
+// dial returns a connection to the given address made through the route. The returned function must be called to
+// close the connections to the jump hosts once the connection is no longer needed.
+func (r *SSHRoute) dial(address string) (net.Conn, func(), error) {
+	var d dialer = &net.Dialer{Timeout: routeDialTimeout}
+	if r == nil {
+		conn, err := d.Dial("tcp", address)
+		return conn, func() {}, err
+	}
+	if r.ProxyURL != "" {
+		var err error
+		if d, err = proxyDialer(r.ProxyURL, r.ProxyCredentials); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var jumpClients []*ssh.Client
+	closeJumpClients := func() {
+		// Each jump host is reached through the previous one, so they are closed in reverse order
+		for i := len(jumpClients) - 1; i >= 0; i-- {
+			_ = jumpClients[i].Close()
+		}
+	}
+	for _, jumpHost := range r.JumpHosts {
+		jumpClient, err := jumpHost.connect(d)
+		if err != nil {
+			closeJumpClients()
+			return nil, nil, &jumpHostErr{address: jumpHost.Address, err: err}
+		}
+		jumpClients = append(jumpClients, jumpClient)
+		d = jumpClient
+	}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		closeJumpClients()
+		return nil, nil, err
+	}
+	return conn, closeJumpClients, nil
+}
+
+// connect returns an SSH client connected to the jump host through the given dialer
+func (j *JumpHost) connect(d dialer) (*ssh.Client, error) {
+	address := withDefaultPort(j.Address, sshPort)
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(j.SSHHostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH host key: %w", err)
+	}
+	config := &ssh.ClientConfig{
+		User:              j.Username,
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(j.Signer)},
+		HostKeyCallback:   verifyJumpHostKey(hostKey),
+		HostKeyAlgorithms: hostKeyAlgorithms(hostKey),
+	}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, channels, requests), nil
+}
+
+// verifyJumpHostKey returns the callback checking that the host key presented by a jump host during the SSH handshake
+// is the expected one
+func verifyJumpHostKey(expected ssh.PublicKey) ssh.HostKeyCallback {
+	return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		if !bytes.Equal(key.Marshal(), expected.Marshal()) {
+			return &HostKeyMismatchErr{expected: ssh.FingerprintSHA256(expected),
+				presented: ssh.FingerprintSHA256(key)}
+		}
+		return nil
+	}
+}
+
+// proxyDialer returns a dialer making connections through the proxy with the given URL, authenticating with the given
+// credentials if not nil
+func proxyDialer(proxyURL string, credentials *url.Userinfo) (dialer, error) {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		// The parsing error is not included, as it would contain any credentials within the URL
+		return nil, fmt.Errorf("proxy URL could not be parsed")
+	}
+	parsed.User = credentials
+	forward := &net.Dialer{Timeout: routeDialTimeout}
+	switch parsed.Scheme {
+	case "socks5":
+		d, err := proxy.FromURL(parsed, forward)
+		if err != nil {
+			return nil, err
+		}
+		return &socksDialer{forward: d}, nil
+	case "http":
+		d := &httpConnectDialer{proxyAddress: withDefaultPort(parsed.Host, "80"), forward: forward}
+		if parsed.User != nil {
+			password, _ := parsed.User.Password()
+			d.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(parsed.User.Username()+":"+
+				password))
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, must be http or socks5", parsed.Scheme)
+	}
+}
+
+// httpConnectDialer makes connections through an HTTP proxy, using the CONNECT method
+type httpConnectDialer struct {
+	// proxyAddress is the address of the proxy
+	proxyAddress string
+	// authorization is the value of the Proxy-Authorization header, if any
+	authorization string
+	// forward is used to connect to the proxy
+	forward dialer
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, d.proxyAddress)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to proxy %s: %w", d.proxyAddress, err)
+	}
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: address}, Host: address,
+		Header: make(http.Header)}
+	if d.authorization != "" {
+		req.Header.Set("Proxy-Authorization", d.authorization)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error sending CONNECT request to proxy %s: %w", d.proxyAddress, err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error reading CONNECT response from proxy %s: %w", d.proxyAddress, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		err := fmt.Errorf("proxy %s refused to connect to %s: %s", d.proxyAddress, address, resp.Status)
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			return nil, &proxyAuthErr{err: err}
+		}
+		return nil, err
+	}
+	if reader.Buffered() > 0 {
+		// The proxy may have sent data from the tunnel along with the response
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// socksDialer makes connections through a SOCKS5 proxy, reporting rejected credentials as a proxyAuthErr
+type socksDialer struct {
+	// forward makes connections through the proxy
+	forward dialer
+}
+
+func (d *socksDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, address)
+	if err != nil && strings.Contains(err.Error(), "username/password authentication failed") {
+		return nil, &proxyAuthErr{err: err}
+	}
+	return conn, err
+}
+
+// bufferedConn is a connection whose reads are served from a reader buffering the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// withDefaultPort returns the given address, with the given port appended if the address has no port
+func withDefaultPort(address, port string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, port)
+}
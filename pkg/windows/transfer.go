@@ -0,0 +1,195 @@
+package windows
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// fileChunkSize is the size of the chunks that files larger than a single chunk are transferred in. Chunks which
+	// were fully transferred before an interruption are not sent again.
+	fileChunkSize = 8 * 1024 * 1024
+	// partialFileSuffix is appended to the name of a file while it is being transferred in chunks
+	partialFileSuffix = ".partial"
+	// maxTransferAttempts is the number of times a chunked transfer is attempted within a single call, reinitializing
+	// the connection to the instance between attempts
+	maxTransferAttempts = 3
+)
+
+// transferFile transfers the given content to the file in the remote directory, creating the directory if needed.
+// Files larger than a single chunk are written chunk by chunk to a partial file, which replaces the destination file
+// once the hash of every chunk has been verified. A chunked transfer which is interrupted is resumed from the first
+// chunk that does not match, both by retries within this call and by later calls.
+func (vm *windows) transferFile(content io.ReaderAt, size int64, filename, remoteDir string) error {
+	address := vm.instance.Address
+	if size <= fileChunkSize {
+		if err := vm.interact.transfer(vm.throttle(io.NewSectionReader(content, 0, size)), filename,
+			remoteDir); err != nil {
+			transferFailures.WithLabelValues(address).Inc()
+			return err
+		}
+		transferredBytes.WithLabelValues(address).Add(float64(size))
+		return nil
+	}
+
+	localHashes, err := chunkHashes(content, size)
+	if err != nil {
+		return fmt.Errorf("error hashing %s: %w", filename, err)
+	}
+	for attempt := 1; attempt <= maxTransferAttempts; attempt++ {
+		if attempt > 1 {
+			if err := vm.reinitialize(); err != nil {
+				return fmt.Errorf("error reinitializing connection to resume transfer of %s: %w", filename, err)
+			}
+		}
+		if err = vm.transferChunks(content, size, localHashes, filename, remoteDir); err == nil {
+			return nil
+		}
+		transferFailures.WithLabelValues(address).Inc()
+		vm.log.Info("chunked transfer interrupted", "file", filename, "remote dir", remoteDir, "attempt", attempt,
+			"error", err)
+	}
+	return err
+}
+
+// transferChunks writes the chunks of the given content which are not already present in the partial remote file,
+// verifies the hashes of all chunks, and then replaces the destination file with the partial file
+func (vm *windows) transferChunks(content io.ReaderAt, size int64, localHashes []string, filename,
+	remoteDir string) error {
+	address := vm.instance.Address
+	remotePath := remoteDir + "\\" + filename
+	partialPath := remotePath + partialFileSuffix
+	remoteHashes, err := vm.remoteChunkHashes(partialPath)
+	if err != nil {
+		return err
+	}
+	resumeFrom := resumeChunk(localHashes, remoteHashes)
+	if resumeFrom > 0 {
+		skipped := int64(resumeFrom) * fileChunkSize
+		vm.log.Info("resuming transfer", "file", remotePath, "transferred bytes", skipped, "total bytes", size)
+		resumedBytes.WithLabelValues(address).Add(float64(skipped))
+	}
+
+	for chunk := resumeFrom; chunk < len(localHashes); chunk++ {
+		offset := int64(chunk) * fileChunkSize
+		length := min(fileChunkSize, size-offset)
+		reader := vm.throttle(io.NewSectionReader(content, offset, length))
+		if err := vm.interact.writeChunk(reader, partialPath, offset); err != nil {
+			return fmt.Errorf("error transferring chunk %d of %s: %w", chunk, remotePath, err)
+		}
+		transferredBytes.WithLabelValues(address).Add(float64(length))
+		vm.log.V(1).Info("transfer progress", "file", remotePath, "transferred bytes", offset+length,
+			"total bytes", size)
+	}
+
+	remoteHashes, err = vm.remoteChunkHashes(partialPath)
+	if err != nil {
+		return err
+	}
+	if mismatch := resumeChunk(localHashes, remoteHashes); mismatch != len(localHashes) {
+		return fmt.Errorf("chunk %d of %s does not have the expected hash after being transferred", mismatch,
+			partialPath)
+	}
+	if out, err := vm.Run(moveFileCmd(partialPath, remotePath), true); err != nil {
+		return fmt.Errorf("unable to replace %s with transferred file, out: %s: %w", remotePath, out, err)
+	}
+	return nil
+}
+
+// remoteChunkHashes returns the hash of each chunk of the given remote file. No hashes are returned if the file does
+// not exist.
+func (vm *windows) remoteChunkHashes(path string) ([]string, error) {
+	out, err := vm.Run(chunkHashesCmd(path), true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting chunk hashes of %s, out: %s: %w", path, out, err)
+	}
+	var hashes []string
+	for _, line := range strings.Split(out, "\n") {
+		if hash := strings.ToLower(strings.TrimSpace(line)); hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// throttle returns a reader which reads from the given reader at a rate no higher than the transfer bandwidth limit
+func (vm *windows) throttle(reader io.Reader) io.Reader {
+	if vm.transferBandwidthLimit <= 0 {
+		return reader
+	}
+	return &throttledReader{reader: reader, bytesPerSecond: vm.transferBandwidthLimit}
+}
+
+// throttledReader limits the rate at which data is read from the wrapped reader
+type throttledReader struct {
+	reader io.Reader
+	// bytesPerSecond is the maximum rate data is read at
+	bytesPerSecond int64
+	// start is the time of the first read
+	start time.Time
+	// read is the total number of bytes read
+	read int64
+}
+
+// Read reads from the wrapped reader, sleeping as needed to keep the average rate of reads under the limit
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	// Reading at most a tenth of a second's worth of data at a time keeps the rate smooth
+	if maxRead := r.bytesPerSecond/10 + 1; int64(len(p)) > maxRead {
+		p = p[:maxRead]
+	}
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	expected := time.Duration(float64(r.read) / float64(r.bytesPerSecond) * float64(time.Second))
+	if wait := expected - time.Since(r.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// chunkHashes returns the hash of each fileChunkSize chunk of the given content
+func chunkHashes(content io.ReaderAt, size int64) ([]string, error) {
+	var hashes []string
+	for offset := int64(0); offset < size; offset += fileChunkSize {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(content, offset, min(fileChunkSize, size-offset))); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, fmt.Sprintf("%x", hash.Sum(nil)))
+	}
+	return hashes, nil
+}
+
+// resumeChunk returns the index of the first local chunk which must be written for the remote file to match the
+// local file. The number of local chunks is returned if the files already match.
+func resumeChunk(localHashes, remoteHashes []string) int {
+	matching := 0
+	for matching < len(localHashes) && matching < len(remoteHashes) && localHashes[matching] == remoteHashes[matching] {
+		matching++
+	}
+	// The remote file has extra content beyond the local file, which is removed by rewriting the last chunk
+	if matching == len(localHashes) && len(remoteHashes) > len(localHashes) {
+		return len(localHashes) - 1
+	}
+	return matching
+}
+
+// chunkHashesCmd returns the PowerShell command printing the SHA256 hash of each fileChunkSize chunk of the given
+// file, one per line, if the file exists
+func chunkHashesCmd(path string) string {
+	return fmt.Sprintf("if(Test-Path -LiteralPath %[1]s) {$f = [IO.File]::OpenRead(%[1]s); "+
+		"$sha = [Security.Cryptography.SHA256]::Create(); $buf = New-Object byte[] %[2]d; "+
+		"while (($n = $f.Read($buf, 0, %[2]d)) -gt 0) {[BitConverter]::ToString($sha.ComputeHash($buf, 0, $n))"+
+		".Replace('-', '')}; $f.Close()}", quotePowerShellString(path), fileChunkSize)
+}
+
+// moveFileCmd returns the PowerShell command replacing the destination file with the source file
+func moveFileCmd(source, destination string) string {
+	return fmt.Sprintf("Move-Item -Force -LiteralPath %s -Destination %s", quotePowerShellString(source),
+		quotePowerShellString(destination))
+}
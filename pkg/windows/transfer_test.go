@@ -0,0 +1,147 @@
+package windows
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testContent returns content spanning the given number of chunks, with each chunk having distinct content
+func testContent(chunks float64) []byte {
+	content := make([]byte, int(chunks*fileChunkSize))
+	for i := range content {
+		content[i] = byte(i / fileChunkSize)
+	}
+	return content
+}
+
+func TestTransferFileResumed(t *testing.T) {
+	testCases := []struct {
+		name string
+		// skip and drop are the number of chunk transfers which succeed and then lose the connection
+		skip              int
+		drop              int
+		expectedErr       bool
+		expectedTransfers int
+	}{
+		{
+			name:              "no interruption",
+			skip:              0,
+			drop:              0,
+			expectedErr:       false,
+			expectedTransfers: 3,
+		},
+		{
+			name:              "interrupted transfer resumed from the interrupted chunk",
+			skip:              1,
+			drop:              1,
+			expectedErr:       false,
+			expectedTransfers: 4,
+		},
+		{
+			name:              "interrupted on every attempt",
+			skip:              1,
+			drop:              maxTransferAttempts,
+			expectedErr:       true,
+			expectedTransfers: 1 + maxTransferAttempts,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			vm, host := newTestWindows(t, false)
+			host.DropConnectionDuringTransfers(test.skip, test.drop)
+			content := testContent(2.5)
+
+			err := vm.EnsureFileContent(content, "kubelet.exe", K8sDir)
+			assert.Equal(t, test.expectedTransfers, host.Transfers())
+			if test.expectedErr {
+				require.Error(t, err)
+				_, exists := host.ReadFile(KubeletPath)
+				assert.False(t, exists)
+				// The chunks transferred before the interruption are kept, so a later call only sends the rest
+				require.NoError(t, vm.reinitialize())
+				require.NoError(t, vm.EnsureFileContent(content, "kubelet.exe", K8sDir))
+				assert.Equal(t, test.expectedTransfers+2, host.Transfers())
+			} else {
+				require.NoError(t, err)
+			}
+			transferred, exists := host.ReadFile(KubeletPath)
+			require.True(t, exists)
+			assert.True(t, bytes.Equal(content, transferred))
+			assert.False(t, host.Exists(KubeletPath+partialFileSuffix))
+		})
+	}
+}
+
+func TestTransferFileReplacesLargerPartialFile(t *testing.T) {
+	vm, host := newTestWindows(t, true)
+	content := testContent(1.5)
+	// A partial file left by a transfer of a larger file must be truncated
+	require.NoError(t, host.WriteFile(KubeletPath+partialFileSuffix, append(content, 0xFF)))
+
+	require.NoError(t, vm.EnsureFileContent(content, "kubelet.exe", K8sDir))
+	transferred, _ := host.ReadFile(KubeletPath)
+	assert.True(t, bytes.Equal(content, transferred))
+	assert.Equal(t, 1, host.Transfers())
+}
+
+func TestResumeChunk(t *testing.T) {
+	testCases := []struct {
+		name     string
+		local    []string
+		remote   []string
+		expected int
+	}{
+		{
+			name:     "no remote file",
+			local:    []string{"a", "b"},
+			remote:   nil,
+			expected: 0,
+		},
+		{
+			name:     "partially transferred",
+			local:    []string{"a", "b", "c"},
+			remote:   []string{"a", "x"},
+			expected: 1,
+		},
+		{
+			name:     "fully transferred",
+			local:    []string{"a", "b"},
+			remote:   []string{"a", "b"},
+			expected: 2,
+		},
+		{
+			name:     "remote file larger",
+			local:    []string{"a", "b"},
+			remote:   []string{"a", "b", "c"},
+			expected: 1,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, resumeChunk(test.local, test.remote))
+		})
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	reader := &throttledReader{reader: bytes.NewReader(make([]byte, 2000)), bytesPerSecond: 10000}
+	start := time.Now()
+	read, err := io.Copy(io.Discard, reader)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2000), read)
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+}
+
+func TestChunkCommandsQuotePaths(t *testing.T) {
+	path := K8sDir + "\\it's a dir\\kubelet.exe"
+	quoted := "'" + K8sDir + "\\it''s a dir\\kubelet.exe'"
+	assert.Contains(t, chunkHashesCmd(path), "Test-Path -LiteralPath "+quoted+")")
+	assert.Contains(t, chunkHashesCmd(path), "OpenRead("+quoted+")")
+	assert.Equal(t, "Move-Item -Force -LiteralPath "+quoted+" -Destination 'C:\\dest'",
+		moveFileCmd(path, "C:\\dest"))
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/go-logr/logr"
@@ -16,8 +18,10 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+	"github.com/openshift/windows-machine-config-operator/pkg/internal/remotehost"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 	"github.com/openshift/windows-machine-config-operator/pkg/retry"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 )
 
 const (
@@ -76,6 +80,9 @@ const (
 	wicdPath = K8sDir + "\\windows-instance-config-daemon.exe"
 	// WindowsExporterPath is the location of the windows_exporter.exe
 	WindowsExporterPath = K8sDir + "\\windows_exporter.exe"
+	// WindowsExporterTextfileDir is the directory the windows_exporter textfile collector reads .prom files from. The
+	// directory is not removed when the instance is deconfigured, as it holds files provided by the user.
+	WindowsExporterTextfileDir = "C:\\ProgramData\\wmco\\windows_exporter\\textfile_inputs"
 	// NetworkConfScriptPath is the location of the network configuration script
 	NetworkConfScriptPath = remoteDir + "\\network-conf.ps1"
 	// AzureCloudNodeManagerPath is the location of the azure-cloud-node-manager.exe
@@ -105,6 +112,8 @@ const (
 	csiProxyLogDir = logDir + "\\csi-proxy"
 	// CSIProxyLog is the location of the csi-proxy log file
 	CSIProxyLog = csiProxyLogDir + "\\csi-proxy.log"
+	// CSIProxyServiceName is the name of the csi-proxy Windows service
+	CSIProxyServiceName = "csi-proxy"
 	// HybridOverlayPath is the location of the hybrid-overlay-node exe
 	HybridOverlayPath = K8sDir + "\\hybrid-overlay-node.exe"
 	// HybridOverlayServiceName is the name of the hybrid-overlay-node Windows service
@@ -195,6 +204,24 @@ func createPayload(platform *config.PlatformType) (map[*payload.FileInfo]string,
 	return files, nil
 }
 
+// FileManifest returns the path and SHA256 of each payload file, once copied to a Windows instance of the given
+// platform, sorted by path. This does not include the WICD binary, which is replaced by WMCO while WICD is stopped.
+func FileManifest(platform *config.PlatformType) ([]servicescm.FileInfo, error) {
+	files, err := createPayload(platform)
+	if err != nil {
+		return nil, err
+	}
+	manifest := make([]servicescm.FileInfo, 0, len(files))
+	for file, remoteDir := range files {
+		manifest = append(manifest, servicescm.FileInfo{Path: remoteDir + "\\" + filepath.Base(file.Path),
+			Checksum: file.SHA256})
+	}
+	sort.Slice(manifest, func(i, j int) bool {
+		return manifest[i].Path < manifest[j].Path
+	})
+	return manifest, nil
+}
+
 // getFilesToTransfer returns the properly populated filesToTransfer map. Note this does not include the WICD binary.
 func getFilesToTransfer(platform *config.PlatformType) map[string]string {
 	srcDestPairs := map[string]string{
@@ -240,6 +267,14 @@ type Windows interface {
 	GetIPv4Address() string
 	// GetHostname returns the FQDN of the associated instance including the domain name, if any
 	GetHostname() (string, error)
+	// SSHHostKey returns the host key presented by the instance in authorized_keys format, or an empty string if the
+	// instance is not accessed over SSH
+	SSHHostKey() string
+	// AuthorizeKey adds the given public key to the keys which can be used to access the instance as an administrator,
+	// if it is not already authorized
+	AuthorizeKey(ssh.PublicKey) error
+	// RevokeKey removes the given public key from the keys which can be used to access the instance as an administrator
+	RevokeKey(ssh.PublicKey) error
 	// EnsureFile ensures the given file exists within the specified directory on the Windows VM. The file will be copied
 	// to the Windows VM if it is not present or if it has the incorrect contents. The remote directory is created if it
 	// does not exist.
@@ -265,8 +300,14 @@ type Windows interface {
 	Bootstrap(string, string, string) error
 	// ConfigureWICD ensures that the Windows Instance Config Daemon is running on the node
 	ConfigureWICD(string, string) error
-	// RemoveFilesAndNetworks removes all files and networks created by WMCO
+	// RemoveFilesAndNetworks removes all files and networks created by WMCO, aside from the payload cache
 	RemoveFilesAndNetworks() error
+	// RemovePayloadCache removes the payload cache, which holds the payload files transferred to the instance. It is
+	// kept when the instance is deconfigured so that an upgrade only transfers the files which changed.
+	RemovePayloadCache() error
+	// CachePayload ensures every payload file is present in the payload cache with its expected contents, without
+	// changing the files in use by the instance
+	CachePayload() error
 	// RunWICDCleanup ensures the WICD service is stopped and runs the cleanup command that ensures all WICD-managed
 	// services are also stopped
 	RunWICDCleanup(string, string) error
@@ -284,38 +325,87 @@ type windows struct {
 	// A valid instance is configured with a network address that either is an IPv4 address or resolves to one.
 	instance *instance.Info
 	log      logr.Logger
-	// defaultShellPowerShell indicates if the default SSH shell is PowerShell
+	// defaultShellPowerShell indicates if the default remote shell is PowerShell
 	defaultShellPowerShell bool
 	// filesToTransfer is the map of files needed for the windows VM
 	filesToTransfer map[*payload.FileInfo]string
+	// transferBandwidthLimit is the maximum rate, in bytes per second, files are transferred to the VM at. Zero is
+	// unlimited.
+	transferBandwidthLimit int64
+	// wicdPayloadPath is the local path of the WICD executable copied to the VM
+	wicdPayloadPath string
 }
 
-// New returns a new Windows instance constructed from the given WindowsVM
-func New(clusterDNS string, instanceInfo *instance.Info, signer ssh.Signer, platform *config.PlatformType) (Windows, error) {
+// New returns a new Windows instance constructed from the given WindowsVM. The instance is accessed over SSH using the
+// given signer through the given route, or over WinRM using the given TLS configuration if the instance's transport is
+// WinRM. SSH connections are made directly if route is nil. Files are transferred at no more than
+// transferBandwidthLimit bytes per second, unless it is zero.
+func New(clusterDNS string, instanceInfo *instance.Info, signer ssh.Signer, route *SSHRoute, winrmTLSConfig *tls.Config,
+	platform *config.PlatformType, transferBandwidthLimit int64) (Windows, error) {
 	log := ctrl.Log.WithName(fmt.Sprintf("wc %s", instanceInfo.Address))
-	log.V(1).Info("initializing SSH connection")
-	conn, err := newSshConnectivity(instanceInfo.Username, instanceInfo.Address, signer, log)
-	if err != nil {
-		return nil, fmt.Errorf("unable to setup VM %s sshConnectivity: %w", instanceInfo.Address, err)
+	var conn connectivity
+	var err error
+	if instanceInfo.UsesWinRM() {
+		log.V(1).Info("initializing WinRM connection")
+		conn, err = newWinRMConnectivity(instanceInfo.Address, winrmTLSConfig, log)
+		if err != nil {
+			return nil, fmt.Errorf("unable to setup VM %s winrmConnectivity: %w", instanceInfo.Address, err)
+		}
+	} else {
+		log.V(1).Info("initializing SSH connection")
+		conn, err = newSshConnectivity(instanceInfo.Username, instanceInfo.Address, signer, instanceInfo.SSHHostKey,
+			route, log)
+		if err != nil {
+			return nil, fmt.Errorf("unable to setup VM %s sshConnectivity: %w", instanceInfo.Address, err)
+		}
 	}
 
 	files, err := createPayload(platform)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create payload: %w", err)
 	}
+	win := newWindows(clusterDNS, instanceInfo, conn, files, log)
+	win.transferBandwidthLimit = transferBandwidthLimit
+	return win, nil
+}
 
+// newWindows returns a windows object which interacts with the instance through the given connectivity
+func newWindows(clusterDNS string, instanceInfo *instance.Info, conn connectivity,
+	files map[*payload.FileInfo]string, log logr.Logger) *windows {
 	return &windows{
-			interact:               conn,
-			clusterDNS:             clusterDNS,
-			instance:               instanceInfo,
-			log:                    log,
-			defaultShellPowerShell: defaultShellPowershell(conn),
-			filesToTransfer:        files,
-		},
-		nil
+		interact:               conn,
+		clusterDNS:             clusterDNS,
+		instance:               instanceInfo,
+		log:                    log,
+		defaultShellPowerShell: defaultShellPowershell(conn),
+		filesToTransfer:        files,
+		wicdPayloadPath:        payload.WICDPath,
+	}
+}
+
+func init() {
+	remotehost.NewWindows = func(clusterDNS string, instanceInfo *instance.Info, host remotehost.Host,
+		wicdPayloadPath string) (interface{}, error) {
+		return newForRemoteHost(clusterDNS, instanceInfo, host, wicdPayloadPath)
+	}
 }
 
-// defaultShellPowershell returns true if the default SSH shell of the connected VM is PowerShell.
+// newForRemoteHost returns a Windows object which interacts with the given remote host. The WICD executable is copied
+// from the given local path, no other payload files are transferred when bootstrapping the instance.
+func newForRemoteHost(clusterDNS string, instanceInfo *instance.Info, host remotehost.Host,
+	wicdPayloadPath string) (*windows, error) {
+	conn := &remoteHostConnectivity{host: host}
+	if err := conn.init(); err != nil {
+		return nil, fmt.Errorf("unable to connect to host %s: %w", instanceInfo.Address, err)
+	}
+	log := ctrl.Log.WithName(fmt.Sprintf("wc %s", instanceInfo.Address))
+	win := newWindows(clusterDNS, instanceInfo, conn, map[*payload.FileInfo]string{}, log)
+	win.wicdPayloadPath = wicdPayloadPath
+	return win, nil
+}
+
+// defaultShellPowershell returns true if the default shell of the connected VM is PowerShell. The WinRM shell is
+// always cmd.exe.
 func defaultShellPowershell(conn connectivity) bool {
 	// Get-Help is a basic command that is expected to work on PowerShell and not through cmd.exe.
 	// If this command succeed, it is safe to assume that the shell is PowerShell.
@@ -334,6 +424,13 @@ func (vm *windows) GetIPv4Address() string {
 	return vm.instance.IPv4Address
 }
 
+func (vm *windows) SSHHostKey() string {
+	if conn, ok := vm.interact.(*sshConnectivity); ok {
+		return conn.authorizedHostKey()
+	}
+	return ""
+}
+
 func (vm *windows) GetHostname() (string, error) {
 	hostName, err := vm.Run(GetHostnameFQDNCommand, true)
 	if err != nil {
@@ -358,17 +455,7 @@ func (vm *windows) EnsureFileContent(contents []byte, filename string, remoteDir
 	}
 	vm.log.V(1).Info("copy", "file content", filename, "remote dir", remoteDir)
 
-	c, err := vm.interact.createSFTPClient()
-	if err != nil {
-		return fmt.Errorf("failed to create SFTP client: %w", err)
-	}
-	defer func() {
-		if err := c.Close(); err != nil {
-			vm.log.Error(err, "error closing SFTP connection")
-		}
-	}()
-
-	if err := vm.interact.transfer(c, bytes.NewReader(contents), filename, remoteDir); err != nil {
+	if err := vm.transferFile(bytes.NewReader(contents), int64(len(contents)), filename, remoteDir); err != nil {
 		return fmt.Errorf("unable to copy %s content to remote dir %s: %w", filename, remoteDir, err)
 	}
 	return nil
@@ -385,29 +472,28 @@ func (vm *windows) EnsureFile(file *payload.FileInfo, remoteDir string) error {
 		// The file already exists with the expected content, do nothing
 		return nil
 	}
-	f, err := os.Open(file.Path)
+	return vm.transferLocalFile(file.Path, filepath.Base(file.Path), remoteDir)
+}
+
+// transferLocalFile transfers the local file at the given path to the file with the given name in the remote directory
+func (vm *windows) transferLocalFile(localPath, filename, remoteDir string) error {
+	f, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("error opening %s file to be transferred: %w", file.Path, err)
+		return fmt.Errorf("error opening %s file to be transferred: %w", localPath, err)
 	}
 	defer func() {
 		if err := f.Close(); err != nil {
-			vm.log.Error(err, "error closing local file", "file", file.Path)
+			vm.log.Error(err, "error closing local file", "file", localPath)
 		}
 	}()
-	vm.log.V(1).Info("copy", "local file", file.Path, "remote dir", remoteDir)
-
-	c, err := vm.interact.createSFTPClient()
+	info, err := f.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to create SFTP client: %w", err)
+		return fmt.Errorf("error getting size of %s file to be transferred: %w", localPath, err)
 	}
-	defer func() {
-		if err := c.Close(); err != nil {
-			vm.log.Error(err, "error closing SFTP connection")
-		}
-	}()
+	vm.log.V(1).Info("copy", "local file", localPath, "remote dir", remoteDir, "bytes", info.Size())
 
-	if err := vm.interact.transfer(c, f, filepath.Base(file.Path), remoteDir); err != nil {
-		return fmt.Errorf("unable to transfer %s to remote dir %s: %w", file.Path, remoteDir, err)
+	if err := vm.transferFile(f, info.Size(), filename, remoteDir); err != nil {
+		return fmt.Errorf("unable to transfer %s to remote dir %s: %w", localPath, remoteDir, err)
 	}
 	return nil
 }
@@ -445,20 +531,7 @@ func (vm *windows) ReplaceDir(files map[string][]byte, remoteDir string) error {
 		return fmt.Errorf("unable to create remote directory %s, out: %s: %w", remoteDir, out, err)
 	}
 
-	sftpClient, err := vm.interact.createSFTPClient()
-	if err != nil {
-		return fmt.Errorf("failed to create SFTP client: %w", err)
-	}
-	defer func() {
-		if err := sftpClient.Close(); err != nil {
-			vm.log.Error(err, "error closing SFTP connection")
-		}
-	}()
-
-	if err = vm.interact.transferFiles(sftpClient, files, remoteDir); err != nil {
-		return err
-	}
-	return nil
+	return vm.interact.transferFiles(files, remoteDir)
 }
 
 func (vm *windows) Run(cmd string, psCmd bool) (string, error) {
@@ -602,9 +675,9 @@ func (vm *windows) ensureWICDFilesExist(wicdKubeconfig string) error {
 	if _, err := vm.Run(mkdirCmd(K8sDir), false); err != nil {
 		return fmt.Errorf("unable to create remote directory %s: %w", K8sDir, err)
 	}
-	wicdFileInfo, err := payload.NewFileInfo(payload.WICDPath)
+	wicdFileInfo, err := payload.NewFileInfo(vm.wicdPayloadPath)
 	if err != nil {
-		return fmt.Errorf("could not create FileInfo object for file %s: %w", payload.WICDPath, err)
+		return fmt.Errorf("could not create FileInfo object for file %s: %w", vm.wicdPayloadPath, err)
 	}
 	if err := vm.EnsureFile(wicdFileInfo, K8sDir); err != nil {
 		return fmt.Errorf("error copying %s to %s: %w", wicdFileInfo.Path, K8sDir, err)
@@ -672,7 +745,7 @@ func (vm *windows) changeHostName() error {
 
 // createDirectories creates directories required for configuring the Windows node on the VM
 func (vm *windows) createDirectories() error {
-	for _, dir := range RequiredDirectories {
+	for _, dir := range append(RequiredDirectories, WindowsExporterTextfileDir) {
 		if _, err := vm.Run(mkdirCmd(dir), false); err != nil {
 			return fmt.Errorf("unable to create remote directory %s: %w", dir, err)
 		}
@@ -705,15 +778,16 @@ func (vm *windows) removeDirectories() error {
 	return nil
 }
 
-// transferFiles copies various files required for configuring the Windows node, to the VM.
+// transferFiles copies various files required for configuring the Windows node, to the VM, through the payload cache.
+// Files in the cache which are no longer part of the payload are then removed.
 func (vm *windows) transferFiles() error {
 	vm.log.Info("transferring files")
 	for src, dest := range vm.filesToTransfer {
-		if err := vm.EnsureFile(src, dest); err != nil {
+		if err := vm.ensurePayloadFile(src, dest); err != nil {
 			return fmt.Errorf("error copying %s to %s: %w", src.Path, dest, err)
 		}
 	}
-	return nil
+	return vm.prunePayloadCache()
 }
 
 // ensureServiceIsRunning ensures a Windows service is running on the VM, creating and starting it if not already so
@@ -5,10 +5,22 @@ import (
 
 	config "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows/fake"
 )
 
+// newTestWindows returns a windows object interacting with a new fake.Host
+func newTestWindows(t *testing.T, defaultShellPowerShell bool) (*windows, *fake.Host) {
+	host := fake.NewHost("test-host", defaultShellPowerShell)
+	win, err := newForRemoteHost("172.30.0.10", &instance.Info{Address: "10.0.0.1", IPv4Address: "10.0.0.1"}, host,
+		"")
+	require.NoError(t, err)
+	return win, host
+}
+
 func TestGetFilesToTransfer(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -0,0 +1,466 @@
+package windows
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/retry"
+)
+
+const (
+	// winrmPort is the default port of the WinRM HTTPS listener
+	winrmPort = "5986"
+	// shellNamespace is the XML namespace of the WinRM remote shell protocol
+	shellNamespace = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell"
+	// cmdShellURI is the resource URI of the cmd.exe remote shell
+	cmdShellURI = shellNamespace + "/cmd"
+	// WS-Management actions used to manage remote shells and the commands run within them
+	actionCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	actionDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	actionCommand = shellNamespace + "/Command"
+	actionSend    = shellNamespace + "/Send"
+	actionReceive = shellNamespace + "/Receive"
+	actionSignal  = shellNamespace + "/Signal"
+	// commandStateDone is the state of a command which has exited
+	commandStateDone = shellNamespace + "/CommandState/Done"
+	// signalTerminate is the signal used to terminate a command
+	signalTerminate = shellNamespace + "/signal/terminate"
+	// certificateAuthHeader is the Authorization header value requesting authentication by client certificate
+	certificateAuthHeader = "http://schemas.dmtf.org/wbem/wsman/1/wsman/secprofile/https/mutual"
+	// operationTimeout is the time the WinRM service waits for output before responding to a Receive request
+	operationTimeout = 60 * time.Second
+	// operationTimeoutFaultCode is the WS-Management fault code returned when no output was produced by a command
+	// within the operation timeout. The request should be retried.
+	operationTimeoutFaultCode = "2150858793"
+	// transferChunkSize is the number of bytes of a file sent to the instance within a single message. Each chunk is
+	// base64 encoded twice, once to be read as a line by the receiving script and once within the message, and must
+	// fit within the default maximum envelope size of 500KB.
+	transferChunkSize = 96 * 1024
+)
+
+// wsmanFault is an error returned by the WinRM service
+type wsmanFault struct {
+	// code is the WS-Management fault code
+	code string
+	// message describes the fault
+	message string
+}
+
+// Error returns a description of the fault
+func (f *wsmanFault) Error() string {
+	return fmt.Sprintf("WinRM fault %s: %s", f.code, f.message)
+}
+
+// wsmanResponse holds the parts of a WS-Management response used by the WinRM remote shell protocol
+type wsmanResponse struct {
+	Body struct {
+		// ShellID and Selectors are set in response to a Create request. The shell ID is given as a selector of the
+		// created resource, and within the shell description by later versions of the WinRM service.
+		ShellID   string `xml:"Shell>ShellId"`
+		Selectors []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"ResourceCreated>ReferenceParameters>SelectorSet>Selector"`
+		// CommandID is set in response to a Command request
+		CommandID string `xml:"CommandResponse>CommandId"`
+		// Streams and CommandState are set in response to a Receive request
+		Streams []struct {
+			Name string `xml:"Name,attr"`
+			Data string `xml:",chardata"`
+		} `xml:"ReceiveResponse>Stream"`
+		CommandState struct {
+			State    string `xml:"State,attr"`
+			ExitCode int    `xml:"ExitCode"`
+		} `xml:"ReceiveResponse>CommandState"`
+		Fault *struct {
+			Reason string `xml:"Reason>Text"`
+			Detail struct {
+				WSManFault struct {
+					Code    string `xml:"Code,attr"`
+					Message string `xml:"Message"`
+				} `xml:"WSManFault"`
+			} `xml:"Detail"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// winrmOption is a WinRM shell or command option
+type winrmOption struct {
+	name  string
+	value string
+}
+
+// winrmConnectivity encapsulates the information needed to connect to the Windows VM over WinRM using HTTPS. Commands
+// are run within a cmd.exe remote shell, and the client is authenticated by certificate.
+type winrmConnectivity struct {
+	// ipAddress is the VM's IP address
+	ipAddress string
+	// tlsConfig holds the client certificate used for authenticating against the VM
+	tlsConfig *tls.Config
+	// httpClient is the client used to send requests to the WinRM service of the VM
+	httpClient *http.Client
+	// endpoint is the URL of the WinRM service of the VM
+	endpoint string
+	// commandTimeout is how long a command can run without exiting before waiting for it is abandoned
+	commandTimeout time.Duration
+	log            logr.Logger
+}
+
+// newWinRMConnectivity returns an instance of winrmConnectivity
+func newWinRMConnectivity(ipAddress string, tlsConfig *tls.Config, logger logr.Logger) (connectivity, error) {
+	c := &winrmConnectivity{
+		ipAddress:      ipAddress,
+		tlsConfig:      tlsConfig,
+		commandTimeout: retry.Timeout,
+		log:            logger,
+	}
+	if err := c.init(); err != nil {
+		return nil, fmt.Errorf("error instantiating WinRM client: %w", err)
+	}
+	return c, nil
+}
+
+// init initialises the certificate authenticated WinRM client, and ensures the WinRM service of the VM is reachable
+func (c *winrmConnectivity) init() error {
+	if c.ipAddress == "" || c.tlsConfig == nil || len(c.tlsConfig.Certificates) == 0 {
+		return fmt.Errorf("incomplete winrmConnectivity information for address %s", c.ipAddress)
+	}
+	c.endpoint = "https://" + net.JoinHostPort(c.ipAddress, winrmPort) + "/wsman"
+	c.httpClient = &http.Client{
+		// Proxy settings of the operator are not applied, as the VM is accessed directly, as it is over SSH
+		Transport: &http.Transport{TLSClientConfig: c.tlsConfig},
+		Timeout:   2 * operationTimeout,
+	}
+	// Retry if we are unable to open a shell as the VM could still be executing the steps in its user data
+	err := wait.PollImmediate(time.Minute, retry.Timeout, func() (bool, error) {
+		shellID, err := c.createShell()
+		if err == nil {
+			c.deleteShell(shellID)
+			return true, nil
+		}
+		c.log.V(1).Info("WinRM connect", "IP Address", c.ipAddress, "error", err)
+		var authErr *AuthErr
+		if errors.As(err, &authErr) {
+			// Authentication failure is a special case that must be handled differently
+			return false, authErr
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to connect to Windows VM %s: %w", c.ipAddress, err)
+	}
+	return nil
+}
+
+// run runs the command on the VM within a new remote shell and returns the combined stdout and stderr output
+func (c *winrmConnectivity) run(cmd string) (string, error) {
+	return c.runWithInput(cmd, nil)
+}
+
+// runWithInput runs the command on the VM within a new remote shell, writing the contents of the given reader to the
+// command's stdin as base64 encoded lines, and returns the combined stdout and stderr output. A nil reader results in
+// no input being written.
+func (c *winrmConnectivity) runWithInput(cmd string, input io.Reader) (string, error) {
+	if c.httpClient == nil {
+		return "", fmt.Errorf("run cannot be called with nil WinRM client")
+	}
+	shellID, err := c.createShell()
+	if err != nil {
+		return "", err
+	}
+	defer c.deleteShell(shellID)
+
+	commandID, err := c.startCommand(shellID, cmd, input != nil)
+	if err != nil {
+		return "", err
+	}
+	defer c.terminateCommand(shellID, commandID)
+
+	if input != nil {
+		if err := c.sendInput(shellID, commandID, input); err != nil {
+			return "", err
+		}
+	}
+	out, exitCode, err := c.receiveOutput(shellID, commandID)
+	if err != nil {
+		return out, err
+	}
+	if exitCode != 0 {
+		return out, fmt.Errorf("command exited with status %d", exitCode)
+	}
+	return out, nil
+}
+
+// transfer reads from reader and creates a file in the remote VM directory, creating the remote directory if needed.
+// The file is streamed to a script on the VM, which writes it to a temporary file that replaces the destination file
+// once fully transferred.
+func (c *winrmConnectivity) transfer(reader io.Reader, filename, remoteDir string) error {
+	remoteFile := remoteDir + "\\" + filename
+	script := fmt.Sprintf("$ErrorActionPreference = 'Stop'\n"+
+		"New-Item -ItemType Directory -Force -Path %[1]s | Out-Null\n"+
+		"$file = [IO.File]::Create(%[2]s)\n"+
+		"try {\n"+
+		"  while (($line = [Console]::In.ReadLine()) -ne $null) {\n"+
+		"    if ($line.Length -gt 0) { $bytes = [Convert]::FromBase64String($line); $file.Write($bytes, 0, $bytes.Length) }\n"+
+		"  }\n"+
+		"} finally { $file.Close() }\n"+
+		"Move-Item -Force -Path %[2]s -Destination %[3]s",
+		quotePowerShellString(remoteDir), quotePowerShellString(remoteFile+".tmp"), quotePowerShellString(remoteFile))
+	if out, err := c.runWithInput(encodedPowerShellCommand(script), reader); err != nil {
+		return fmt.Errorf("error copying %s to the Windows VM, out: %s: %w", filename, out, err)
+	}
+	return nil
+}
+
+// writeChunk writes the data read from reader to the remote file at the given offset, using a script on the VM which
+// reads the data streamed to it in the same way as transfer
+func (c *winrmConnectivity) writeChunk(reader io.Reader, remotePath string, offset int64) error {
+	remoteDir, _ := SplitPath(remotePath)
+	script := fmt.Sprintf("$ErrorActionPreference = 'Stop'\n"+
+		"New-Item -ItemType Directory -Force -Path %[1]s | Out-Null\n"+
+		"$file = [IO.File]::Open(%[2]s, [IO.FileMode]::OpenOrCreate, [IO.FileAccess]::Write)\n"+
+		"try {\n"+
+		"  $file.SetLength(%[3]d)\n"+
+		"  $file.Seek(%[3]d, [IO.SeekOrigin]::Begin) | Out-Null\n"+
+		"  while (($line = [Console]::In.ReadLine()) -ne $null) {\n"+
+		"    if ($line.Length -gt 0) { $bytes = [Convert]::FromBase64String($line); $file.Write($bytes, 0, $bytes.Length) }\n"+
+		"  }\n"+
+		"} finally { $file.Close() }",
+		quotePowerShellString(remoteDir), quotePowerShellString(remotePath), offset)
+	if out, err := c.runWithInput(encodedPowerShellCommand(script), reader); err != nil {
+		return fmt.Errorf("error writing to %s at offset %d on the Windows VM, out: %s: %w", remotePath, offset, out,
+			err)
+	}
+	return nil
+}
+
+// transferFiles transfers the given files to a given remote directory
+func (c *winrmConnectivity) transferFiles(files map[string][]byte, remoteDir string) error {
+	return forEachFile(files, remoteDir, c.transfer)
+}
+
+// createShell opens a cmd.exe remote shell on the VM, returning the ID of the shell
+func (c *winrmConnectivity) createShell() (string, error) {
+	body := "<rsp:Shell><rsp:InputStreams>stdin</rsp:InputStreams>" +
+		"<rsp:OutputStreams>stdout stderr</rsp:OutputStreams></rsp:Shell>"
+	resp, err := c.send(actionCreate, "", []winrmOption{{name: "WINRS_NOPROFILE", value: "FALSE"},
+		{name: "WINRS_CODEPAGE", value: "65001"}}, body)
+	if err != nil {
+		return "", fmt.Errorf("error creating remote shell: %w", err)
+	}
+	if resp.Body.ShellID != "" {
+		return resp.Body.ShellID, nil
+	}
+	for _, selector := range resp.Body.Selectors {
+		if selector.Name == "ShellId" && selector.Value != "" {
+			return selector.Value, nil
+		}
+	}
+	return "", fmt.Errorf("no shell ID in response to remote shell creation")
+}
+
+// deleteShell closes the given remote shell, logging any error
+func (c *winrmConnectivity) deleteShell(shellID string) {
+	if _, err := c.send(actionDelete, shellID, nil, ""); err != nil {
+		c.log.V(1).Info("error deleting remote shell", "shell", shellID, "error", err)
+	}
+}
+
+// startCommand starts the given command within the given remote shell, returning the ID of the command. If
+// pipeInput is true, the command's stdin is a pipe rather than a console.
+func (c *winrmConnectivity) startCommand(shellID, cmd string, pipeInput bool) (string, error) {
+	consoleStdin := "TRUE"
+	if pipeInput {
+		consoleStdin = "FALSE"
+	}
+	var escaped bytes.Buffer
+	if err := xml.EscapeText(&escaped, []byte(cmd)); err != nil {
+		return "", err
+	}
+	body := "<rsp:CommandLine><rsp:Command>" + escaped.String() + "</rsp:Command></rsp:CommandLine>"
+	resp, err := c.send(actionCommand, shellID, []winrmOption{{name: "WINRS_CONSOLEMODE_STDIN", value: consoleStdin},
+		{name: "WINRS_SKIP_CMD_SHELL", value: "FALSE"}}, body)
+	if err != nil {
+		return "", fmt.Errorf("error starting command: %w", err)
+	}
+	if resp.Body.CommandID == "" {
+		return "", fmt.Errorf("no command ID in response to starting command")
+	}
+	return resp.Body.CommandID, nil
+}
+
+// sendInput writes the contents of the given reader to the stdin of the given command, as base64 encoded lines of at
+// most transferChunkSize bytes, and then closes stdin
+func (c *winrmConnectivity) sendInput(shellID, commandID string, input io.Reader) error {
+	chunk := make([]byte, transferChunkSize)
+	for {
+		n, readErr := io.ReadFull(input, chunk)
+		end := errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF)
+		if readErr != nil && !end {
+			return fmt.Errorf("error reading input: %w", readErr)
+		}
+		line := base64.StdEncoding.EncodeToString(chunk[:n]) + "\n"
+		endAttr := ""
+		if end {
+			endAttr = ` End="true"`
+		}
+		body := fmt.Sprintf(`<rsp:Send><rsp:Stream Name="stdin" CommandId="%s"%s>%s</rsp:Stream></rsp:Send>`,
+			commandID, endAttr, base64.StdEncoding.EncodeToString([]byte(line)))
+		if _, err := c.send(actionSend, shellID, nil, body); err != nil {
+			return fmt.Errorf("error sending input: %w", err)
+		}
+		if end {
+			return nil
+		}
+	}
+}
+
+// receiveOutput waits for the given command to exit, returning its combined stdout and stderr output and exit code. An
+// error is returned if the command does not exit within the command timeout.
+func (c *winrmConnectivity) receiveOutput(shellID, commandID string) (string, int, error) {
+	body := fmt.Sprintf(`<rsp:Receive><rsp:DesiredStream CommandId="%s">stdout stderr</rsp:DesiredStream>`+
+		`</rsp:Receive>`, commandID)
+	deadline := time.Now().Add(c.commandTimeout)
+	var out strings.Builder
+	for {
+		resp, err := c.send(actionReceive, shellID, nil, body)
+		if err != nil {
+			var fault *wsmanFault
+			if errors.As(err, &fault) && fault.code == operationTimeoutFaultCode {
+				// The command has not produced output yet
+				if time.Now().After(deadline) {
+					return out.String(), 0, fmt.Errorf("command did not exit within %s", c.commandTimeout)
+				}
+				continue
+			}
+			return out.String(), 0, fmt.Errorf("error receiving command output: %w", err)
+		}
+		for _, stream := range resp.Body.Streams {
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Data))
+			if err != nil {
+				return out.String(), 0, fmt.Errorf("error decoding command output: %w", err)
+			}
+			out.Write(data)
+		}
+		if resp.Body.CommandState.State == commandStateDone {
+			return out.String(), resp.Body.CommandState.ExitCode, nil
+		}
+	}
+}
+
+// terminateCommand signals the given command to terminate, logging any error. Terminating a command which has exited
+// releases the resources held for it by the WinRM service.
+func (c *winrmConnectivity) terminateCommand(shellID, commandID string) {
+	body := fmt.Sprintf(`<rsp:Signal CommandId="%s"><rsp:Code>%s</rsp:Code></rsp:Signal>`, commandID,
+		signalTerminate)
+	if _, err := c.send(actionSignal, shellID, nil, body); err != nil {
+		c.log.V(1).Info("error terminating command", "command", commandID, "error", err)
+	}
+}
+
+// send sends a WS-Management request with the given action, targeting the given shell if not empty, and returns the
+// parsed response. A wsmanFault is returned if the WinRM service responds with a fault, and an AuthErr is returned if
+// the client certificate is rejected.
+func (c *winrmConnectivity) send(action, shellID string, options []winrmOption, body string) (*wsmanResponse,
+	error) {
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, strings.NewReader(c.envelope(action, shellID, options,
+		body)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	req.Header.Set("Authorization", certificateAuthHeader)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, newAuthErr(fmt.Errorf("WinRM service responded with %s", resp.Status))
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	parsed := &wsmanResponse{}
+	if len(respBody) > 0 {
+		if err := xml.Unmarshal(respBody, parsed); err != nil {
+			return nil, fmt.Errorf("error parsing response with status %s: %w", resp.Status, err)
+		}
+	}
+	if fault := parsed.Body.Fault; fault != nil {
+		message := strings.TrimSpace(fault.Detail.WSManFault.Message)
+		if message == "" {
+			message = strings.TrimSpace(fault.Reason)
+		}
+		return nil, &wsmanFault{code: fault.Detail.WSManFault.Code, message: message}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("WinRM service responded with %s", resp.Status)
+	}
+	return parsed, nil
+}
+
+// envelope returns a SOAP envelope holding a WS-Management request with the given action and body, targeting the
+// given shell if not empty
+func (c *winrmConnectivity) envelope(action, shellID string, options []winrmOption, body string) string {
+	var header strings.Builder
+	header.WriteString("<a:To>" + c.endpoint + "</a:To>")
+	header.WriteString(`<a:ReplyTo><a:Address s:mustUnderstand="true">` +
+		"http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>")
+	header.WriteString(`<w:MaxEnvelopeSize s:mustUnderstand="true">512000</w:MaxEnvelopeSize>`)
+	header.WriteString("<a:MessageID>uuid:" + string(uuid.NewUUID()) + "</a:MessageID>")
+	header.WriteString(`<w:Locale xml:lang="en-US" s:mustUnderstand="false"/>`)
+	header.WriteString(fmt.Sprintf("<w:OperationTimeout>PT%dS</w:OperationTimeout>",
+		int(operationTimeout.Seconds())))
+	header.WriteString(`<w:ResourceURI s:mustUnderstand="true">` + cmdShellURI + "</w:ResourceURI>")
+	header.WriteString(`<a:Action s:mustUnderstand="true">` + action + "</a:Action>")
+	if shellID != "" {
+		header.WriteString(`<w:SelectorSet><w:Selector Name="ShellId">` + shellID + "</w:Selector></w:SelectorSet>")
+	}
+	if len(options) > 0 {
+		header.WriteString("<w:OptionSet>")
+		for _, option := range options {
+			header.WriteString(fmt.Sprintf(`<w:Option Name="%s">%s</w:Option>`, option.name, option.value))
+		}
+		header.WriteString("</w:OptionSet>")
+	}
+	return `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" ` +
+		`xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" ` +
+		`xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" ` +
+		`xmlns:rsp="` + shellNamespace + `">` +
+		"<s:Header>" + header.String() + "</s:Header>" +
+		"<s:Body>" + body + "</s:Body></s:Envelope>"
+}
+
+// quotePowerShellString returns the given value as a single quoted PowerShell string
+func quotePowerShellString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// encodedPowerShellCommand returns a command running the given PowerShell script, passed as an encoded command to
+// avoid any quoting issues
+func encodedPowerShellCommand(script string) string {
+	encoded := utf16.Encode([]rune(script))
+	scriptBytes := make([]byte, 2*len(encoded))
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(scriptBytes[2*i:], r)
+	}
+	return "powershell.exe -NonInteractive -NoProfile -ExecutionPolicy Bypass -EncodedCommand " +
+		base64.StdEncoding.EncodeToString(scriptBytes)
+}
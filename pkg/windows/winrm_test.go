@@ -0,0 +1,194 @@
+package windows
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWinRMService is a minimal WinRM service, running each command by returning a fixed output and exit code
+type fakeWinRMService struct {
+	lock sync.Mutex
+	// output and exitCode are the result of each command
+	output   string
+	exitCode int
+	// status, if set, is returned in response to all requests
+	status int
+	// commands are the commands which were run
+	commands []string
+	// stdin is the input sent to the commands
+	stdin bytes.Buffer
+	// timeouts is the number of Receive requests responded to with an operation timeout fault before the output
+	timeouts int
+	// shellsOpen is the number of shells created and not deleted
+	shellsOpen int
+}
+
+// fakeRequest holds the parts of a WS-Management request used by fakeWinRMService
+type fakeRequest struct {
+	Action string `xml:"Header>Action"`
+	Body   struct {
+		Command string `xml:"CommandLine>Command"`
+		Stream  struct {
+			End  bool   `xml:"End,attr"`
+			Data string `xml:",chardata"`
+		} `xml:"Send>Stream"`
+	} `xml:"Body"`
+}
+
+// ServeHTTP responds to a WS-Management request
+func (f *fakeWinRMService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		return
+	}
+	if r.Header.Get("Authorization") != certificateAuthHeader {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	req := fakeRequest{}
+	if err := xml.Unmarshal(data, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body := ""
+	switch req.Action {
+	case actionCreate:
+		f.shellsOpen++
+		body = "<rsp:Shell><rsp:ShellId>shell-1</rsp:ShellId></rsp:Shell>"
+	case actionDelete:
+		f.shellsOpen--
+	case actionCommand:
+		f.commands = append(f.commands, req.Body.Command)
+		body = "<rsp:CommandResponse><rsp:CommandId>command-1</rsp:CommandId></rsp:CommandResponse>"
+	case actionSend:
+		decoded, _ := base64.StdEncoding.DecodeString(req.Body.Stream.Data)
+		f.stdin.Write(decoded)
+	case actionReceive:
+		if f.timeouts > 0 {
+			f.timeouts--
+			w.WriteHeader(http.StatusInternalServerError)
+			body = fmt.Sprintf(`<s:Fault><s:Reason><s:Text>timed out</s:Text></s:Reason><s:Detail>`+
+				`<f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="%s">`+
+				`<f:Message>operation timed out</f:Message></f:WSManFault></s:Detail></s:Fault>`,
+				operationTimeoutFaultCode)
+			break
+		}
+		body = fmt.Sprintf(`<rsp:ReceiveResponse><rsp:Stream Name="stdout" CommandId="command-1">%s</rsp:Stream>`+
+			`<rsp:CommandState CommandId="command-1" State="%s"><rsp:ExitCode>%d</rsp:ExitCode></rsp:CommandState>`+
+			`</rsp:ReceiveResponse>`, base64.StdEncoding.EncodeToString([]byte(f.output)), commandStateDone,
+			f.exitCode)
+	}
+	fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="%s"><s:Body>%s`+
+		`</s:Body></s:Envelope>`, shellNamespace, body)
+}
+
+// newTestWinRMConnectivity returns a winrmConnectivity using the given test server
+func newTestWinRMConnectivity(server *httptest.Server) *winrmConnectivity {
+	return &winrmConnectivity{ipAddress: "127.0.0.1", httpClient: server.Client(), endpoint: server.URL + "/wsman",
+		commandTimeout: time.Second, log: logr.Discard()}
+}
+
+func TestWinRMRun(t *testing.T) {
+	testCases := []struct {
+		name        string
+		service     *fakeWinRMService
+		expectedOut string
+		expectedErr bool
+		expectAuth  bool
+	}{
+		{
+			name:        "command succeeds",
+			service:     &fakeWinRMService{output: "hostname"},
+			expectedOut: "hostname",
+			expectedErr: false,
+		},
+		{
+			name:        "output received after operation timeouts",
+			service:     &fakeWinRMService{output: "hostname", timeouts: 2},
+			expectedOut: "hostname",
+			expectedErr: false,
+		},
+		{
+			name:        "command does not exit within the command timeout",
+			service:     &fakeWinRMService{output: "hostname", timeouts: math.MaxInt},
+			expectedOut: "",
+			expectedErr: true,
+		},
+		{
+			name:        "command fails",
+			service:     &fakeWinRMService{output: "not found", exitCode: 1},
+			expectedOut: "not found",
+			expectedErr: true,
+		},
+		{
+			name:        "certificate rejected",
+			service:     &fakeWinRMService{status: http.StatusUnauthorized},
+			expectedErr: true,
+			expectAuth:  true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(test.service)
+			defer server.Close()
+			c := newTestWinRMConnectivity(server)
+
+			out, err := c.run("hostname & echo <done>")
+			assert.Equal(t, test.expectedOut, out)
+			if test.expectedErr {
+				require.Error(t, err)
+				var authErr *AuthErr
+				assert.Equal(t, test.expectAuth, errors.As(err, &authErr))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []string{"hostname & echo <done>"}, test.service.commands)
+			assert.Zero(t, test.service.shellsOpen)
+		})
+	}
+}
+
+func TestWinRMTransfer(t *testing.T) {
+	service := &fakeWinRMService{}
+	server := httptest.NewTLSServer(service)
+	defer server.Close()
+	c := newTestWinRMConnectivity(server)
+
+	// The content spans multiple chunks
+	content := bytes.Repeat([]byte("0123456789"), transferChunkSize/4)
+	require.NoError(t, c.transfer(bytes.NewReader(content), "file.exe", "C:\\k"))
+
+	require.Len(t, service.commands, 1)
+	assert.True(t, strings.HasPrefix(service.commands[0], "powershell.exe"))
+	// Each line sent to stdin is a base64 encoded chunk of the file
+	var received []byte
+	for _, line := range strings.Split(strings.TrimSpace(service.stdin.String()), "\n") {
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		require.NoError(t, err)
+		received = append(received, decoded...)
+	}
+	assert.Equal(t, content, received)
+	assert.Zero(t, service.shellsOpen)
+}
+
+func TestQuotePowerShellString(t *testing.T) {
+	assert.Equal(t, "'C:\\k\\it''s'", quotePowerShellString("C:\\k\\it's"))
+}
@@ -4,21 +4,34 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/url"
 	"strings"
 
+	"golang.org/x/crypto/ssh"
 	core "k8s.io/api/core/v1"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	wmcov1 "github.com/openshift/windows-machine-config-operator/api/v1"
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
 )
 
-// InstanceConfigMap is the name of the ConfigMap where VMs to be configured should be described.
-const InstanceConfigMap = "windows-instances"
+const (
+	// InstanceConfigMap is the name of the ConfigMap where VMs to be configured should be described.
+	InstanceConfigMap = "windows-instances"
+	// ConfigMapManagedLabel is applied to WindowsInstances which were created from an InstanceConfigMap entry. The
+	// lifecycle of these WindowsInstances is tied to their entry. Removing the label from a WindowsInstance detaches it
+	// from the ConfigMap, allowing the entry to be removed without the instance being deconfigured.
+	ConfigMapManagedLabel = "windowsmachineconfig.openshift.io/windows-instances-entry"
+)
 
-// GetInstances returns a list of Windows instances by parsing the Windows instance configMap.
+// GetInstances returns a list of Windows instances by parsing the Windows instance configMap and the WindowsInstance
+// objects within the given namespace. ConfigMap entries are only included if there is no WindowsInstance with the
+// same address, which is the case until the entry has been migrated.
 func GetInstances(c client.Client, namespace string) ([]*instance.Info, error) {
 	configMap := &core.ConfigMap{}
 	err := c.Get(context.TODO(), kubeTypes.NamespacedName{Namespace: namespace,
@@ -37,6 +50,177 @@ func GetInstances(c client.Client, namespace string) ([]*instance.Info, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse instances from ConfigMap %s: %w", configMap.Name, err)
 	}
+
+	windowsInstanceList := &wmcov1.WindowsInstanceList{}
+	if err := c.List(context.TODO(), windowsInstanceList, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("error listing WindowsInstances: %w", err)
+	}
+	fromObjects, err := ParseWindowsInstances(windowsInstanceList.Items, nodes)
+	if err != nil {
+		return nil, err
+	}
+	for _, instanceInfo := range windowsInstances {
+		if !containsAddress(fromObjects, instanceInfo.Address) {
+			fromObjects = append(fromObjects, instanceInfo)
+		}
+	}
+	return fromObjects, nil
+}
+
+// containsAddress returns true if any of the given instances has the given address
+func containsAddress(instances []*instance.Info, address string) bool {
+	for _, instanceInfo := range instances {
+		if instanceInfo.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseWindowsInstances returns the list of instances described by the given WindowsInstances. WindowsInstances
+// which are being deleted are not included. Each instance returned will contain a reference to its associated Node,
+// if it has one in the given NodeList.
+func ParseWindowsInstances(windowsInstances []wmcov1.WindowsInstance, nodes *core.NodeList) ([]*instance.Info,
+	error) {
+	instances := make([]*instance.Info, 0, len(windowsInstances))
+	for i := range windowsInstances {
+		if !windowsInstances[i].GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		if containsAddress(instances, windowsInstances[i].Spec.Address) {
+			return nil, fmt.Errorf("address %s is specified by multiple WindowsInstances",
+				windowsInstances[i].Spec.Address)
+		}
+		instanceInfo, err := FromWindowsInstance(&windowsInstances[i], nodes)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instanceInfo)
+	}
+	return instances, nil
+}
+
+// FromWindowsInstance returns the instance described by the given WindowsInstance, with a reference to its associated
+// Node if it has one in the given NodeList
+func FromWindowsInstance(windowsInstance *wmcov1.WindowsInstance, nodes *core.NodeList) (*instance.Info, error) {
+	if windowsInstance == nil {
+		return nil, fmt.Errorf("WindowsInstance cannot be nil")
+	}
+	if nodes == nil {
+		return nil, fmt.Errorf("nodes cannot be nil")
+	}
+	if windowsInstance.Spec.Username == "" {
+		return nil, fmt.Errorf("WindowsInstance %s is missing a username", windowsInstance.GetName())
+	}
+	ip, err := net.ResolveIPAddr("ip4", windowsInstance.Spec.Address)
+	if err != nil {
+		return nil, fmt.Errorf("WindowsInstance %s has an invalid address: %w", windowsInstance.GetName(), err)
+	}
+	instanceInfo, err := instance.NewInfo(windowsInstance.Spec.Address, windowsInstance.Spec.Username,
+		windowsInstance.Spec.Hostname, false, nodeutil.FindByAddress(ip.String(), nodes))
+	if err != nil {
+		return nil, err
+	}
+	if windowsInstance.Spec.PrivateKeySecretRef != nil {
+		instanceInfo.PrivateKeySecret = windowsInstance.Spec.PrivateKeySecretRef.Name
+	}
+	instanceInfo.Transport, err = instance.ParseTransport(windowsInstance.Spec.Transport)
+	if err != nil {
+		return nil, fmt.Errorf("WindowsInstance %s has an invalid transport: %w", windowsInstance.GetName(), err)
+	}
+	// A host key given by the WindowsInstance takes precedence over the one recorded on the Node, allowing the key to
+	// be replaced after the host keys of the instance are regenerated
+	if windowsInstance.Spec.SSHHostKey != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(windowsInstance.Spec.SSHHostKey)); err != nil {
+			return nil, fmt.Errorf("WindowsInstance %s has an invalid SSH host key: %w", windowsInstance.GetName(),
+				err)
+		}
+		instanceInfo.SSHHostKey = windowsInstance.Spec.SSHHostKey
+	}
+	instanceInfo.SSHRoute, err = ToSSHRoute(windowsInstance.Spec.ProxyURL,
+		SecretName(windowsInstance.Spec.ProxyCredentialsSecretRef), windowsInstance.Spec.JumpHosts)
+	if err != nil {
+		return nil, fmt.Errorf("WindowsInstance %s has an invalid SSH route: %w", windowsInstance.GetName(), err)
+	}
+	return instanceInfo, nil
+}
+
+// ToSSHRoute returns the SSH route through the given proxy and jump hosts, validating them. The proxy is authenticated
+// against with the credentials held by the given secret, if not empty.
+func ToSSHRoute(proxyURL, proxyCredentialsSecret string, jumpHosts []wmcov1.JumpHost) (instance.SSHRoute, error) {
+	route := instance.SSHRoute{ProxyURL: proxyURL, ProxyCredentialsSecret: proxyCredentialsSecret}
+	if proxyURL != "" {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil {
+			// The parsing error is not included, as it would contain any credentials within the URL
+			return route, fmt.Errorf("proxy URL could not be parsed")
+		}
+		if (parsed.Scheme != "http" && parsed.Scheme != "socks5") || parsed.Host == "" {
+			return route, fmt.Errorf("invalid proxy URL %s: must be an http:// or socks5:// URL with a host",
+				parsed.Redacted())
+		}
+		if parsed.User != nil {
+			return route, fmt.Errorf("invalid proxy URL %s: credentials must be given through a secret rather than "+
+				"within the URL", parsed.Redacted())
+		}
+	} else if proxyCredentialsSecret != "" {
+		return route, fmt.Errorf("proxy credentials cannot be given without a proxy URL")
+	}
+	for _, jumpHost := range jumpHosts {
+		if jumpHost.Address == "" || jumpHost.Username == "" {
+			return route, fmt.Errorf("jump hosts must have an address and a username")
+		}
+		if jumpHost.SSHHostKey == "" {
+			return route, fmt.Errorf("jump host %s must have an SSH host key", jumpHost.Address)
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(jumpHost.SSHHostKey)); err != nil {
+			return route, fmt.Errorf("jump host %s has an invalid SSH host key: %w", jumpHost.Address, err)
+		}
+		converted := instance.JumpHost{Address: jumpHost.Address, Username: jumpHost.Username,
+			SSHHostKey: jumpHost.SSHHostKey}
+		if jumpHost.PrivateKeySecretRef != nil {
+			converted.PrivateKeySecret = jumpHost.PrivateKeySecretRef.Name
+		}
+		route.JumpHosts = append(route.JumpHosts, converted)
+	}
+	return route, nil
+}
+
+// SecretName returns the name of the referenced secret, or an empty string if there is no reference
+func SecretName(ref *core.LocalObjectReference) string {
+	if ref == nil {
+		return ""
+	}
+	return ref.Name
+}
+
+// ToWindowsInstances converts the given Windows instances data into WindowsInstance objects within the given
+// namespace. Each object is named after the address of the entry it was created from, and labeled as being managed
+// by the InstanceConfigMap.
+func ToWindowsInstances(instancesData map[string]string, namespace string) ([]wmcov1.WindowsInstance, error) {
+	windowsInstances := make([]wmcov1.WindowsInstance, 0, len(instancesData))
+	for address, data := range instancesData {
+		username, err := extractUsername(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get username for %s: %w", address, err)
+		}
+		name := strings.ToLower(address)
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return nil, fmt.Errorf("address %s cannot be used as a WindowsInstance name: %s", address,
+				strings.Join(errs, ", "))
+		}
+		windowsInstances = append(windowsInstances, wmcov1.WindowsInstance{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{ConfigMapManagedLabel: "true"},
+			},
+			Spec: wmcov1.WindowsInstanceSpec{
+				Address:  address,
+				Username: username,
+			},
+		})
+	}
 	return windowsInstances, nil
 }
 
@@ -74,18 +258,33 @@ func Parse(instancesData map[string]string, nodes *core.NodeList) ([]*instance.I
 	return instances, nil
 }
 
-// GetNodeUsername retrieves the username associated with the given node from the instance ConfigMap data
-func GetNodeUsername(instancesData map[string]string, node *core.Node) (string, error) {
+// GetWindowsInstanceForNode returns the WindowsInstance associated with the given node via address
+func GetWindowsInstanceForNode(windowsInstances []wmcov1.WindowsInstance, node *core.Node) (*wmcov1.WindowsInstance,
+	error) {
 	if node == nil {
-		return "", fmt.Errorf("cannot get username for nil node")
+		return nil, fmt.Errorf("cannot get WindowsInstance for nil node")
 	}
-	// Find entry in ConfigMap that is associated to node via address
 	for _, address := range node.Status.Addresses {
-		if value, found := instancesData[address.Address]; found {
-			return extractUsername(value)
+		for i := range windowsInstances {
+			if windowsInstances[i].Spec.Address == address.Address {
+				return &windowsInstances[i], nil
+			}
 		}
 	}
-	return "", fmt.Errorf("unable to find instance associated with node %s", node.GetName())
+	return nil, fmt.Errorf("unable to find WindowsInstance associated with node %s", node.GetName())
+}
+
+// GetNodeUsername retrieves the username of the WindowsInstance associated with the given node
+func GetNodeUsername(windowsInstances []wmcov1.WindowsInstance, node *core.Node) (string, error) {
+	windowsInstance, err := GetWindowsInstanceForNode(windowsInstances, node)
+	if err != nil {
+		return "", err
+	}
+	if windowsInstance.Spec.Username == "" {
+		return "", fmt.Errorf("WindowsInstance %s associated with node %s has no username", windowsInstance.GetName(),
+			node.GetName())
+	}
+	return windowsInstance.Spec.Username, nil
 }
 
 // extractUsername returns the username string from data in the form username=<username>
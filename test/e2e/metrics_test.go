@@ -17,6 +17,7 @@ import (
 	"github.com/stretchr/testify/require"
 	auth "k8s.io/api/authentication/v1"
 	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -79,45 +80,57 @@ func (tc *testContext) testPrometheus(t *testing.T) {
 		metrics.WindowsMetricsResource, metav1.GetOptions{})
 	require.NoError(t, err, "error getting service monitor")
 
-	// check that endpoints exists
+	// check that the EndpointSlices managed by WMCO hold an endpoint for each Windows node
+	slices, err := tc.client.K8s.DiscoveryV1().EndpointSlices(wmcoNamespace).List(context.TODO(),
+		metav1.ListOptions{LabelSelector: discovery.LabelServiceName + "=" + metrics.WindowsMetricsResource + "," +
+			discovery.LabelManagedBy + "=" + metrics.EndpointSliceManager})
+	require.NoError(t, err)
+
+	// check that the endpoints Prometheus discovers the Windows nodes through exist alongside the EndpointSlices
 	windowsEndpoints, err := tc.client.K8s.CoreV1().Endpoints(wmcoNamespace).Get(context.TODO(),
 		metrics.WindowsMetricsResource, metav1.GetOptions{})
 	require.NoError(t, err)
 
 	if len(gc.allNodes()) == 0 {
-		// check if all entries in subset are deleted when there are no Windows Nodes
+		// check that the EndpointSlices are deleted and all entries in subset are deleted when there are no Windows
+		// Nodes
+		require.Equal(t, 0, len(slices.Items))
 		require.Equal(t, 0, len(windowsEndpoints.Subsets))
-	} else {
-		// Total length of list for subsets is always equal to the list of Windows Nodes.
-		require.Equal(t, len(gc.allNodes()), len(windowsEndpoints.Subsets[0].Addresses))
-
-		// check Nodes in the targetRef of Endpoints are same as the Windows Nodes bootstrapped using WMCO
-		err = checkTargetNodes(windowsEndpoints)
-		require.NoError(t, err)
-
+		return
+	}
+	// Total length of list for subsets is always equal to the list of Windows Nodes.
+	require.Equal(t, len(gc.allNodes()), len(windowsEndpoints.Subsets[0].Addresses))
+	require.NotEmpty(t, slices.Items)
+	for _, slice := range slices.Items {
+		// Each node has an endpoint of each address type it has an internal IP of
+		require.LessOrEqual(t, len(slice.Endpoints), len(gc.allNodes()))
+		require.Len(t, slice.Ports, 1)
 		// check Port name matches
-		require.Equal(t, windowsEndpoints.Subsets[0].Ports[0].Name, metrics.PortName)
+		require.Equal(t, metrics.PortName, *slice.Ports[0].Name)
 		// check Port matches the defined port
-		require.Equal(t, windowsEndpoints.Subsets[0].Ports[0].Port, metrics.Port)
+		require.Equal(t, metrics.Port, *slice.Ports[0].Port)
 	}
-
+	// check Nodes in the targetRef of the endpoints are same as the Windows Nodes bootstrapped using WMCO
+	require.NoError(t, checkTargetNodes(slices.Items))
 }
 
-// checkTargetNodes checks if nodes in the targetRef of Endpoints are same as the Windows Nodes bootstrapped using WMCO
-func checkTargetNodes(windowsEndpoints *core.Endpoints) error {
+// checkTargetNodes checks if nodes in the targetRef of the endpoints of the given EndpointSlices are same as the Windows
+// Nodes bootstrapped using WMCO
+func checkTargetNodes(slices []discovery.EndpointSlice) error {
 	for _, node := range gc.allNodes() {
 		foundNode := false
-		for _, endpointAddress := range windowsEndpoints.Subsets[0].Addresses {
-			if node.Name == endpointAddress.TargetRef.Name {
-				foundNode = true
-				break
+		for _, slice := range slices {
+			for _, endpoint := range slice.Endpoints {
+				if endpoint.TargetRef != nil && node.Name == endpoint.TargetRef.Name {
+					foundNode = true
+					break
+				}
 			}
 		}
 		if !foundNode {
-			return fmt.Errorf("target node not found in Endpoints object")
+			return fmt.Errorf("target node %s not found in EndpointSlices", node.Name)
 		}
 	}
-
 	return nil
 }
 
@@ -41,6 +41,16 @@ func (tc *testContext) getExpectedKeyPair() ([]byte, ssh.PublicKey, error) {
 	return privateKey, signer.PublicKey(), nil
 }
 
+// getUsernameEncryptionKey returns the key the username annotations of nodes are encrypted with
+func (tc *testContext) getUsernameEncryptionKey() ([]byte, error) {
+	keySecret, err := tc.client.K8s.CoreV1().Secrets(wmcoNamespace).Get(context.TODO(),
+		secrets.UsernameEncryptionKeySecret, meta.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve username encryption key secret: %w", err)
+	}
+	return keySecret.Data[secrets.UsernameEncryptionKeySecretKey], nil
+}
+
 // getCloudPrivateKey returns the private key present within the cloud-private-key secret
 func (tc *testContext) getCloudPrivateKey() ([]byte, error) {
 	privateKeySecret, err := tc.client.K8s.CoreV1().Secrets(wmcoNamespace).Get(context.TODO(),
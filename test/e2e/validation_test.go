@@ -817,7 +817,7 @@ func (tc *testContext) testNodeAnnotations(t *testing.T) {
 
 // checkUsernameAnnotation checks that the username annotation value is decipherable and correct
 func (tc *testContext) checkUsernameAnnotation(node *core.Node) (bool, error) {
-	privKey, _, err := tc.getExpectedKeyPair()
+	key, err := tc.getUsernameEncryptionKey()
 	if err != nil {
 		return false, err
 	}
@@ -826,7 +826,7 @@ func (tc *testContext) checkUsernameAnnotation(node *core.Node) (bool, error) {
 	if !present {
 		return false, nil
 	}
-	username, err := crypto.DecryptFromJSONString(usernameValue, privKey)
+	username, err := crypto.DecryptFromJSONString(usernameValue, key)
 	if err != nil {
 		return false, err
 	}